@@ -1,14 +1,55 @@
 package main
 
 import (
+	"context"
 	"github.com/LandGAA/authh2/internal/app"
+	"github.com/LandGAA/authh2/pkg/config"
 	"github.com/LandGAA/authh2/pkg/grpc/server"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"github.com/LandGAA/authh2/pkg/tracing"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	logger.LoggerRun()
+	config.Init()
+	if err := tracing.Init(config.Cfg.Tracing); err != nil {
+		logger.Logger.Fatal("Ошибка настройки трассировки", zap.Error(err))
+	}
+	app.Init()
+	if config.Cfg.Metrics.Enabled {
+		server.ObserveRPC = metrics.ObserveRPC
+	}
 	go app.Run()
-	go server.Run(app.GlobalUseCase)
-	select {}
+	go server.Run(app.GlobalUseCase, app.GlobalTokenUseCase, app.GlobalWebAuthnUseCase, app.GlobalInviteUseCase, app.GlobalUserEventUseCase)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	shutdown(sig)
+}
+
+// shutdown выводит сервис из балансировки и останавливает его: сначала /readyz и
+// grpc.health.v1 сообщают о неготовности, через DrainDelay закрываются порты,
+// текущие запросы дожидаются не дольше ShutdownTimeout.
+func shutdown(sig os.Signal) {
+	cfg := config.Cfg.Health
+	logger.Logger.Info("Получен сигнал остановки, сервис выводится из балансировки",
+		zap.String("signal", sig.String()),
+		zap.Duration("drain_delay", cfg.DrainDelay))
+	app.Drain()
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	app.Shutdown(ctx)
+	if err := tracing.Shutdown(ctx); err != nil {
+		logger.Logger.Warn("Ошибка отправки трассировки при остановке", zap.Error(err))
+	}
+	logger.Logger.Info("Сервис остановлен")
 }
@@ -1,23 +1,252 @@
 package app
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"github.com/LandGAA/authh2/internal/delivery"
 	"github.com/LandGAA/authh2/internal/repository"
 	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/broker"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/connector"
 	"github.com/LandGAA/authh2/pkg/database"
+	"github.com/LandGAA/authh2/pkg/grpc/server"
+	"github.com/LandGAA/authh2/pkg/health"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/ldapauth"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/mailer"
+	"github.com/LandGAA/authh2/pkg/samlauth"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
 )
 
-var GlobalUseCase usecase.UseCase
+var (
+	GlobalUseCase              usecase.UseCase
+	GlobalClientUseCase        usecase.ClientUseCase
+	GlobalTokenUseCase         usecase.TokenUseCase
+	GlobalWebAuthnUseCase      usecase.WebAuthnUseCase
+	GlobalPasswordlessUseCase  usecase.PasswordlessUseCase
+	GlobalIdentityUseCase      usecase.IdentityUseCase
+	GlobalSAMLUseCase          usecase.SAMLUseCase
+	GlobalOrganizationUseCase  usecase.OrganizationUseCase
+	GlobalGroupUseCase         usecase.GroupUseCase
+	GlobalInviteUseCase        usecase.InviteUseCase
+	GlobalAuditUseCase         usecase.AuditUseCase
+	GlobalImpersonationUseCase usecase.ImpersonationUseCase
+	GlobalUserEventUseCase     usecase.UserEventUseCase
+	GlobalWebhookUseCase       usecase.WebhookUseCase
+	GlobalOutboxUseCase        usecase.OutboxUseCase
+	GlobalSCIMUseCase          usecase.SCIMUseCase
+)
 
-func Run() {
-	db := database.Connect()
+// Health — проверки готовности для /readyz; Drain переводит его в режим остановки.
+var Health *health.Checker
+
+var db *sql.DB
+
+var httpServer = &http.Server{Addr: ":8081"}
+
+// Фоновые задачи (relay outbox, вебхуки, очистка событий, синхронизация LDAP) работают
+// с базой, поэтому Shutdown останавливает их и дожидается до ее закрытия.
+var (
+	workersCtx, stopWorkers = context.WithCancel(context.Background())
+	workers                 sync.WaitGroup
+)
+
+// startWorker запускает фоновую задачу, которую остановит Shutdown.
+func startWorker(run func(ctx context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run(workersCtx)
+	}()
+}
+
+// Init подключает базу и собирает use case'ы. Вызывается до запуска HTTP и gRPC серверов,
+// чтобы оба получили уже готовые зависимости.
+func Init() {
+	db = database.Connect()
 	rep := repository.NewRep(db)
-	u := usecase.NewUserUseCase(&rep)
-	GlobalUseCase = u
-	router := delivery.SetupRouters(u)
-	err := router.Run(":8081")
-	defer db.Close()
+	organizationRep := repository.NewOrganizationRep(db)
+	GlobalOrganizationUseCase = usecase.NewOrganizationUseCase(config.Cfg.Tenancy, &organizationRep)
+	groupRep := repository.NewGroupRep(db)
+	GlobalGroupUseCase = usecase.NewGroupUseCase(config.Cfg.Groups, &groupRep, &organizationRep)
+	jwt.GroupClaims = GlobalGroupUseCase.Claims
+	auditRep := repository.NewAuditRep(db)
+	GlobalAuditUseCase = usecase.NewAuditUseCase(&auditRep)
+	GlobalImpersonationUseCase = usecase.NewImpersonationUseCase(config.Cfg.Impersonation, GlobalGroupUseCase, GlobalAuditUseCase)
+	identityRep := repository.NewIdentityRep(db)
+	GlobalUseCase = usecase.NewUserUseCase(&rep, authBackends(&rep, &identityRep)...)
+	saRep := repository.NewServiceAccountRep(db)
+	GlobalClientUseCase = usecase.NewClientUseCase(&saRep, config.Cfg.TokenExchange, config.Cfg.Groups)
+	userEventRep := repository.NewUserEventRep(db)
+	GlobalUserEventUseCase = usecase.NewUserEventUseCase(config.Cfg.Events, &userEventRep)
+	startWorker(GlobalUserEventUseCase.RunRetention)
+	webhookRep := repository.NewWebhookRep(db)
+	GlobalWebhookUseCase = usecase.NewWebhookUseCase(config.Cfg.Webhooks, &webhookRep)
+	startWorker(GlobalWebhookUseCase.Run)
+	outboxRep := repository.NewOutboxRep(db)
+	var err error
+	GlobalOutboxUseCase, err = usecase.NewOutboxUseCase(config.Cfg.Outbox, &outboxRep, outboxSinks()...)
+	if err != nil {
+		logger.Logger.Fatal("Ошибка настройки outbox", zap.Error(err))
+	}
+	startWorker(GlobalOutboxUseCase.Run)
+	tokenRep := repository.NewTokenRep(db)
+	GlobalTokenUseCase = usecase.NewTokenUseCase(&tokenRep, &outboxRep, config.Cfg.Groups, GlobalUseCase)
+	jwt.RevocationCheck = GlobalTokenUseCase.IsRevoked
+	scimRep := repository.NewSCIMRep(db)
+	GlobalSCIMUseCase = usecase.NewSCIMUseCase(config.Cfg.SCIM, GlobalUseCase, &rep, GlobalGroupUseCase, &scimRep)
+	// отключенные через SCIM пользователи не входят, даже если SCIM потом выключен в конфиге
+	jwt.UserDisabled = GlobalSCIMUseCase.IsUserDisabled
+	webAuthnRep := repository.NewWebAuthnRep(db)
+	GlobalWebAuthnUseCase, err = usecase.NewWebAuthnUseCase(config.Cfg.WebAuthn, &webAuthnRep, &rep)
+	if err != nil {
+		logger.Logger.Fatal("Ошибка инициализации WebAuthn", zap.Error(err))
+	}
+	mail := mailer.New(config.Cfg.Mailer)
+	passwordlessRep := repository.NewPasswordlessRep(db)
+	GlobalPasswordlessUseCase = usecase.NewPasswordlessUseCase(config.Cfg.Passwordless, &passwordlessRep, &rep, mail)
+	inviteRep := repository.NewInviteRep(db)
+	GlobalInviteUseCase = usecase.NewInviteUseCase(config.Cfg.Registration, &inviteRep, GlobalUseCase, mail)
+
+	connectors := make([]connector.Connector, 0, len(config.Cfg.Connectors))
+	for _, cfg := range config.Cfg.Connectors {
+		c, err := connector.New(cfg)
+		if err != nil {
+			logger.Logger.Fatal("Ошибка настройки провайдера входа", zap.Error(err))
+		}
+		connectors = append(connectors, c)
+	}
+	GlobalIdentityUseCase = usecase.NewIdentityUseCase(connectors, &identityRep, GlobalUseCase)
+	samlRep := repository.NewSAMLRep(db)
+	GlobalSAMLUseCase = usecase.NewSAMLUseCase(samlProviders(), &identityRep, &samlRep, &rep, GlobalIdentityUseCase, GlobalOrganizationUseCase)
+	Health = healthChecks()
+}
+
+// healthChecks собирает проверки готовности: база, версия схемы, ключ подписи и gRPC сервер.
+func healthChecks() *health.Checker {
+	checker := health.New(config.Cfg.Health.CheckTimeout)
+	checker.Register("database", func(ctx context.Context) (map[string]any, error) {
+		stats := db.Stats()
+		details := map[string]any{"open_connections": stats.OpenConnections, "in_use": stats.InUse}
+		return details, db.PingContext(ctx)
+	})
+	checker.Register("migrations", func(ctx context.Context) (map[string]any, error) {
+		state, err := database.CheckMigrations(ctx, db)
+		return map[string]any{"version": state.Version, "dirty": state.Dirty, "latest": state.Latest}, err
+	})
+	checker.Register("signing_key", func(ctx context.Context) (map[string]any, error) {
+		alg, err := jwt.CheckSigningKey()
+		return map[string]any{"alg": alg}, err
+	})
+	checker.Register("grpc", func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"addr": config.Cfg.GRPC.Addr}, server.Status()
+	})
+	return checker
+}
+
+// outboxSinks собирает приемники outbox: вебхуки, ленту gRPC и брокеры из конфигурации.
+func outboxSinks() []usecase.OutboxSink {
+	sinks := []usecase.OutboxSink{GlobalWebhookUseCase, GlobalUserEventUseCase}
+	for _, cfg := range config.Cfg.Outbox.Sinks {
+		publisher, err := broker.New(cfg)
+		if err != nil {
+			logger.Logger.Fatal("Ошибка настройки приемника outbox", zap.Error(err))
+		}
+		sinks = append(sinks, usecase.NewBrokerSink(publisher))
+	}
+	return sinks
+}
+
+// samlProviders создает сервис-провайдеры SAML для тенантов из конфигурации.
+func samlProviders() []*samlauth.Provider {
+	if len(config.Cfg.SAML.IdPs) == 0 {
+		return nil
+	}
+
+	key, cert, ephemeral, err := samlauth.LoadKeyPair(config.Cfg.SAML)
 	if err != nil {
-		return
+		logger.Logger.Fatal("Ошибка настройки SAML", zap.Error(err))
+	}
+	if ephemeral {
+		logger.Logger.Warn("Не заданы cert_file и key_file SAML, используется временный ключ: после перезапуска метаданные нужно заново импортировать в IdP")
+	}
+
+	providers := make([]*samlauth.Provider, 0, len(config.Cfg.SAML.IdPs))
+	for _, cfg := range config.Cfg.SAML.IdPs {
+		p, err := samlauth.New(config.Cfg.SAML, cfg, key, cert)
+		if err != nil {
+			logger.Logger.Fatal("Ошибка настройки SAML IdP", zap.Error(err))
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// authBackends собирает бэкенды проверки пароля: LDAP (если включен), затем локальный.
+func authBackends(rep repository.Repository, identityRep repository.IdentityRepository) []usecase.AuthBackend {
+	if !config.Cfg.LDAP.Enabled {
+		return []usecase.AuthBackend{usecase.NewLocalBackend(rep, nil)}
+	}
+
+	client, err := ldapauth.New(config.Cfg.LDAP)
+	if err != nil {
+		logger.Logger.Fatal("Ошибка настройки LDAP", zap.Error(err))
+	}
+	ldapBackend := usecase.NewLDAPBackend(client, rep, identityRep, config.Cfg.LDAP.CreateUsers)
+	if config.Cfg.LDAP.SyncInterval > 0 {
+		startWorker(func(ctx context.Context) { ldapBackend.RunSync(ctx, config.Cfg.LDAP.SyncInterval) })
+	}
+	logger.Logger.Info("Включена проверка паролей в LDAP", zap.String("url", config.Cfg.LDAP.URL))
+	return []usecase.AuthBackend{ldapBackend, usecase.NewLocalBackend(rep, identityRep)}
+}
+
+func Run() {
+	httpServer.Handler = delivery.SetupRouters(GlobalUseCase, GlobalClientUseCase, GlobalTokenUseCase, GlobalWebAuthnUseCase, GlobalPasswordlessUseCase, GlobalIdentityUseCase, GlobalSAMLUseCase, GlobalOrganizationUseCase, GlobalGroupUseCase, GlobalInviteUseCase, GlobalAuditUseCase, GlobalImpersonationUseCase, GlobalWebhookUseCase, GlobalOutboxUseCase, GlobalSCIMUseCase, Health)
+	logger.Logger.Info("HTTP сервер запущен!", zap.String("addr", httpServer.Addr))
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Logger.Fatal("Ошибка запуска HTTP сервера!", zap.Error(err))
+	}
+}
+
+// Drain переводит сервис в режим остановки: /readyz отвечает 503, а gRPC сервисы —
+// NOT_SERVING. Запросы при этом продолжают обслуживаться.
+func Drain() {
+	Health.Drain()
+	server.Drain()
+}
+
+// Shutdown останавливает HTTP и gRPC серверы, дожидаясь текущих запросов не дольше ctx,
+// затем фоновые задачи и закрывает базу.
+func Shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.Stop(ctx)
+	}()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Logger.Warn("HTTP запросы не завершились до остановки", zap.Error(err))
+	}
+	wg.Wait()
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Logger.Warn("Фоновые задачи не завершились до остановки", zap.Error(ctx.Err()))
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Logger.Error("Ошибка закрытия базы данных", zap.Error(err))
 	}
 }
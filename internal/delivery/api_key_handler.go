@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type APIKeyHandler struct {
+	t usecase.TokenUseCase
+}
+
+func NewAPIKeyHandler(tokenUseCase usecase.TokenUseCase) *APIKeyHandler {
+	return &APIKeyHandler{t: tokenUseCase}
+}
+
+type createAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int64    `json:"expires_in"`
+}
+
+type createAPIKeyResponse struct {
+	entity.APIKey
+	Key string `json:"key"`
+}
+
+// @Summary Мои API ключи
+// @Description Список API ключей текущего пользователя (без секретов)
+// @Tags api-keys
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.APIKey
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /api-keys [get]
+func (h *APIKeyHandler) GetAll(c *gin.Context) {
+	keys, err := h.t.GetAPIKeys(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary Создать API ключ
+// @Description Создает API ключ текущего пользователя, ключ возвращается только один раз. expires_in в секундах, 0 — бессрочный. scopes должны входить в разрешения ролей пользователя (groups.permissions)
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} createAPIKeyResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /api-keys [post]
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	key, secret, err := h.t.CreateAPIKey(requestClaims(c), req.Name, req.Scopes, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		if errors.Is(err, usecase.ErrorInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Запрошенный scope не входит в разрешения пользователя"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Logger.Info("Создан API ключ",
+		zap.Int("user_id", key.UserID),
+		zap.String("prefix", key.Prefix))
+	c.JSON(http.StatusOK, createAPIKeyResponse{APIKey: key, Key: secret})
+}
+
+// @Summary Отозвать API ключ
+// @Description Отзыв API ключа текущего пользователя по ID
+// @Tags api-keys
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID API ключа"
+// @Success 200 {string} string "API ключ отозван"
+// @Failure 400 {string} string "Неправильный параметр"
+// @Failure 404 {string} string "API ключ не найден"
+// @Router /api-keys/{id} [delete]
+func (h *APIKeyHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+
+	if err := h.t.RevokeAPIKey(c.GetInt("id"), id); err != nil {
+		if errors.Is(err, usecase.ErrorAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("API ключ с ID = %d отозван", id))
+}
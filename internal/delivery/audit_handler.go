@@ -0,0 +1,54 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type AuditHandler struct {
+	a usecase.AuditUseCase
+}
+
+func NewAuditHandler(a usecase.AuditUseCase) *AuditHandler {
+	return &AuditHandler{a: a}
+}
+
+// @Summary Журнал аудита
+// @Description Последние записи, новые первыми. Администратор организации видит только ее записи
+// @Tags audit
+// @Produce json
+// @Security BearerAuth
+// @Param user_id query int false "От чьего имени"
+// @Param actor_id query int false "Кто действовал"
+// @Param limit query int false "Количество записей (до 1000, по умолчанию 100)"
+// @Success 200 {array} entity.AuditEvent
+// @Router /audit [get]
+func (h *AuditHandler) GetEvents(c *gin.Context) {
+	var filter entity.AuditFilter
+	var err1, err2, err3 error
+	if v := c.Query("user_id"); v != "" {
+		filter.UserID, err1 = strconv.Atoi(v)
+	}
+	if v := c.Query("actor_id"); v != "" {
+		filter.ActorID, err2 = strconv.Atoi(v)
+	}
+	if v := c.Query("limit"); v != "" {
+		filter.Limit, err3 = strconv.Atoi(v)
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	filter.Tenant = c.GetString("tenant")
+	filter.AllTenants = filter.Tenant == ""
+
+	events, err := h.a.GetEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
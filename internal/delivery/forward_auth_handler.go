@@ -0,0 +1,144 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type ForwardAuthHandler struct {
+	cfg config.ForwardAuth
+}
+
+func NewForwardAuthHandler(cfg config.ForwardAuth) *ForwardAuthHandler {
+	return &ForwardAuthHandler{cfg: cfg}
+}
+
+// @Summary Forward-auth для обратных прокси
+// @Description Проверка запроса для nginx auth_request и Traefik ForwardAuth. Исходный адрес берется только из заголовков прокси, указанного в forward_auth.proxy: X-Forwarded-Host/X-Forwarded-Uri для traefik, X-Original-URL для nginx. Путь нормализуется, пути с ".." и закодированными слешами отклоняются с 400. При успехе возвращает 200 и заголовки X-User-Id, X-User-Email, X-User-Role X-User-Groups (если группы есть в токене) и X-Impersonated-By при входе от имени пользователя. Без аутентификации — 401 либо 302 на страницу входа для браузера (отключается ?redirect=false, нужно для nginx)
+// @Tags forward-auth
+// @Produce json
+// @Param redirect query bool false "Разрешить 302 на страницу входа"
+// @Success 200 {string} string ""
+// @Failure 302 {string} string "Редирект на страницу входа"
+// @Failure 400 {string} string "Некорректный путь запроса"
+// @Failure 401 {string} string "Не аутентифицирован"
+// @Failure 403 {string} string "Недостаточно прав"
+// @Router /forward-auth [get]
+func (h *ForwardAuthHandler) Check(c *gin.Context) {
+	host, method, path, original, ok := forwardedRequest(c, h.cfg.Proxy)
+	if !ok {
+		logger.Logger.Warn("Forward-auth: некорректный путь исходного запроса",
+			zap.String("host", host),
+			zap.String("original", original))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Некорректный путь запроса"})
+		return
+	}
+	rule, matched := config.MatchRule(h.cfg.Rules, host, method, path)
+	if matched && rule.Deny {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Доступ запрещен"})
+		return
+	}
+
+	claims, _, err := authenticateRequest(c, h.cfg.CookieName)
+	if err != nil {
+		if matched && rule.AllowAnonymous {
+			c.Status(http.StatusOK)
+			return
+		}
+		h.unauthenticated(c, original)
+		return
+	}
+
+	if matched && !rule.AllowsRole(claims.EffectiveRoles()...) {
+		logger.Logger.Warn("Forward-auth: недостаточно прав",
+			zap.String("host", host),
+			zap.String("path", path),
+			zap.String("email", claims.Email),
+			zap.String("role", claims.Role))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return
+	}
+
+	c.Header("X-User-Id", strconv.Itoa(claims.ID))
+	c.Header("X-User-Email", claims.Email)
+	c.Header("X-User-Role", claims.Role)
+	if len(claims.Groups) > 0 {
+		c.Header("X-User-Groups", strings.Join(claims.Groups, ","))
+	}
+	if claims.Act != nil {
+		c.Header("X-Impersonated-By", claims.Act.Email)
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *ForwardAuthHandler) unauthenticated(c *gin.Context, original string) {
+	wantsHTML := strings.Contains(c.GetHeader("Accept"), "text/html")
+	if h.cfg.LoginURL != "" && wantsHTML && c.Query("redirect") != "false" {
+		location := h.cfg.LoginURL
+		if original != "" {
+			sep := "?"
+			if strings.Contains(location, "?") {
+				sep = "&"
+			}
+			location += sep + "rd=" + url.QueryEscape(original)
+		}
+		c.Redirect(http.StatusFound, location)
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Не аутентифицирован"})
+}
+
+// forwardedRequest восстанавливает хост, метод, путь и полный адрес исходного запроса
+// из заголовков прокси, выбранного в forward_auth.proxy: Traefik (X-Forwarded-*) или
+// nginx (X-Original-URL / X-Original-Method). Путь нормализуется config.CleanPath;
+// ok=false — путь не удалось однозначно нормализовать.
+func forwardedRequest(c *gin.Context, proxy string) (host string, method string, path string, original string, ok bool) {
+	if proxy == config.ProxyNginx {
+		method = c.GetHeader("X-Original-Method")
+		if method == "" {
+			method = c.Request.Method
+		}
+		raw := c.GetHeader("X-Original-URL")
+		u, err := url.Parse(raw)
+		if raw == "" || err != nil {
+			return "", "", "", "", false
+		}
+		path, ok = config.CleanPath(u.EscapedPath())
+		return u.Host, method, path, raw, ok
+	}
+
+	method = c.GetHeader("X-Forwarded-Method")
+	if method == "" {
+		method = c.Request.Method
+	}
+	host = c.GetHeader("X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	uri := c.GetHeader("X-Forwarded-Uri")
+	if uri == "" {
+		uri = "/"
+	}
+	proto := c.GetHeader("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "http"
+	}
+	path, ok = config.CleanPath(uri)
+	return host, method, path, proto + "://" + host + uri, ok
+}
+
+func containsRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuthRules(t *testing.T) {
+	rules := []config.AccessRule{
+		{PathPrefix: "/health", AllowAnonymous: true},
+		{PathPrefix: "/admin", Roles: []string{"admin"}},
+	}
+	user, _, err := jwt.GenerateAccessToken(entity.User{ID: 2, Email: "user@a.com", Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxy   string
+		headers map[string]string
+		token   string
+		want    int
+	}{
+		{"traefik: анонимный health", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health"}, "", http.StatusOK},
+		{"traefik: невалидный токен на анонимном пути", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health"}, "x.y.z", http.StatusOK},
+		{"traefik: невалидный токен", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/private"}, "x.y.z", http.StatusUnauthorized},
+		{"traefik: health с query", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health?x=/admin"}, "", http.StatusOK},
+		{"traefik: выход из health через ..", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health/../admin"}, "", http.StatusBadRequest},
+		{"traefik: закодированный ..", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health/%2e%2e/admin"}, "", http.StatusBadRequest},
+		{"traefik: закодированный слеш", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health%2f..%2fadmin"}, "", http.StatusBadRequest},
+		{"traefik: путь с префиксом health не анонимный", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/health-anything"}, "", http.StatusUnauthorized},
+		{"traefik: /administrator не под правилом /admin", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/administrator"}, user, http.StatusOK},
+		{"traefik: /admin требует роль", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/admin/users"}, user, http.StatusForbidden},
+		{"traefik: двойной слеш нормализуется", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "//admin"}, user, http.StatusForbidden},
+		{"traefik: X-Original-URL игнорируется", config.ProxyTraefik,
+			map[string]string{"X-Forwarded-Uri": "/admin", "X-Original-URL": "http://app/health"}, "", http.StatusUnauthorized},
+		{"nginx: анонимный health", config.ProxyNginx,
+			map[string]string{"X-Original-URL": "https://app.example.com/health"}, "", http.StatusOK},
+		{"nginx: выход из health через ..", config.ProxyNginx,
+			map[string]string{"X-Original-URL": "https://app.example.com/health/../admin"}, "", http.StatusBadRequest},
+		{"nginx: X-Forwarded-Uri игнорируется", config.ProxyNginx,
+			map[string]string{"X-Original-URL": "https://app.example.com/admin", "X-Forwarded-Uri": "/health"}, "", http.StatusUnauthorized},
+		{"nginx: без X-Original-URL", config.ProxyNginx,
+			map[string]string{"X-Forwarded-Uri": "/health"}, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewForwardAuthHandler(config.ForwardAuth{Proxy: tt.proxy, Rules: rules})
+			r := gin.New()
+			r.GET("/forward-auth", h.Check)
+
+			req := httptest.NewRequest(http.MethodGet, "/forward-auth", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type GroupHandler struct {
+	g usecase.GroupUseCase
+	u usecase.UseCase
+}
+
+func NewGroupHandler(g usecase.GroupUseCase, u usecase.UseCase) *GroupHandler {
+	return &GroupHandler{g: g, u: u}
+}
+
+type setGroupRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// @Summary Список групп
+// @Description Группы организации запроса или глобальные группы. Только для admin
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.Group
+// @Router /groups [get]
+func (h *GroupHandler) GetAll(c *gin.Context) {
+	groups, err := tenantGroups(c, h.g).GetGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// @Summary Получить группу
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Success 200 {object} entity.Group
+// @Failure 404 {string} string "Не найдена"
+// @Router /groups/{id} [get]
+func (h *GroupHandler) GetByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	group, err := tenantGroups(c, h.g).GetGroup(id)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// @Summary Создать группу
+// @Description parent_id — родительская группа: ее роли наследуются участниками новой группы
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} entity.Group
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 409 {string} string "Группа уже существует"
+// @Router /groups [post]
+func (h *GroupHandler) Create(c *gin.Context) {
+	var group entity.Group
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	created, err := tenantGroups(c, h.g).CreateGroup(group)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// @Summary Изменить группу
+// @Description Меняет название, описание и родителя группы
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Success 200 {object} entity.Group
+// @Failure 400 {string} string "Циклическая вложенность"
+// @Failure 404 {string} string "Не найдена"
+// @Router /groups/{id} [put]
+func (h *GroupHandler) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var group entity.Group
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+	group.ID = id
+
+	updated, err := tenantGroups(c, h.g).UpdateGroup(group)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// @Summary Удалить группу
+// @Description Подгруппы переходят к родителю удаляемой группы
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Success 200 {string} string "Группа удалена"
+// @Failure 404 {string} string "Не найдена"
+// @Router /groups/{id} [delete]
+func (h *GroupHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := tenantGroups(c, h.g).DeleteGroup(id); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Группа с ID = %d удалена", id))
+}
+
+// @Summary Назначить роли группе
+// @Description Заменяет роли группы
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Success 200 {string} string "Роли сохранены"
+// @Failure 404 {string} string "Не найдена"
+// @Router /groups/{id}/roles [put]
+func (h *GroupHandler) SetRoles(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var req setGroupRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	if err := tenantGroups(c, h.g).SetRoles(id, req.Roles); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Роли группы с ID = %d сохранены", id))
+}
+
+// @Summary Участники группы
+// @Description Только непосредственные участники, без участников подгрупп
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Success 200 {array} entity.GroupMember
+// @Failure 404 {string} string "Не найдена"
+// @Router /groups/{id}/members [get]
+func (h *GroupHandler) GetMembers(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	members, err := tenantGroups(c, h.g).GetMembers(id)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// @Summary Добавить участника группы
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {string} string "Участник добавлен"
+// @Failure 404 {string} string "Группа или пользователь не найдены"
+// @Router /groups/{id}/members/{user_id} [put]
+func (h *GroupHandler) AddMember(c *gin.Context) {
+	id, err1 := strconv.Atoi(c.Param("id"))
+	userID, err2 := strconv.Atoi(c.Param("user_id"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := tenantGroups(c, h.g).AddMember(id, userID); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Пользователь с ID = %d добавлен в группу", userID))
+}
+
+// @Summary Исключить участника группы
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID группы"
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {string} string "Участник исключен"
+// @Failure 404 {string} string "Не состоит в группе"
+// @Router /groups/{id}/members/{user_id} [delete]
+func (h *GroupHandler) RemoveMember(c *gin.Context) {
+	id, err1 := strconv.Atoi(c.Param("id"))
+	userID, err2 := strconv.Atoi(c.Param("user_id"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := tenantGroups(c, h.g).RemoveMember(id, userID); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Пользователь с ID = %d исключен из группы", userID))
+}
+
+// @Summary Эффективные права текущего пользователя
+// @Description Собственная роль, роли групп (включая родительские) и разрешения этих ролей
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} entity.Access
+// @Router /access [get]
+func (h *GroupHandler) MyAccess(c *gin.Context) {
+	h.access(c, c.GetInt("id"))
+}
+
+// @Summary Эффективные права пользователя
+// @Tags groups
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} entity.Access
+// @Failure 404 {string} string "Не найден"
+// @Router /users/{id}/access [get]
+func (h *GroupHandler) UserAccess(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	h.access(c, id)
+}
+
+func (h *GroupHandler) access(c *gin.Context, userID int) {
+	user, err := tenantUsers(c, h.u).GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	access, err := tenantGroups(c, h.g).Access(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, access)
+}
+
+func (h *GroupHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorGroupNotFound), errors.Is(err, usecase.ErrorGroupMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorGroupCycle), errors.Is(err, usecase.ErrorGroupRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorGroupExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,42 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/pkg/health"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+type HealthHandler struct {
+	h       *health.Checker
+	started time.Time
+}
+
+func NewHealthHandler(h *health.Checker) *HealthHandler {
+	return &HealthHandler{h: h, started: time.Now()}
+}
+
+// Live отвечает, пока процесс обрабатывает запросы. Зависимости не проверяются:
+// недоступная база не повод перезапускать процесс, это задача /readyz.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":         health.StatusOK,
+		"uptime_seconds": int64(time.Since(h.started).Seconds()),
+	})
+}
+
+// Ready отвечает 200, если все зависимости доступны, иначе 503 с результатом каждой
+// проверки. Во время остановки отвечает 503 со статусом draining.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	report := h.h.Ready(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,177 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type IdentityHandler struct {
+	i       usecase.IdentityUseCase
+	w       usecase.WebAuthnUseCase
+	session *SessionHandler
+}
+
+func NewIdentityHandler(i usecase.IdentityUseCase, w usecase.WebAuthnUseCase, session *SessionHandler) *IdentityHandler {
+	return &IdentityHandler{i: i, w: w, session: session}
+}
+
+type linkResponse struct {
+	AuthURL string `json:"auth_url"`
+}
+
+// @Summary Список внешних провайдеров входа
+// @Tags connectors
+// @Produce json
+// @Success 200 {array} entity.Connector
+// @Router /connectors [get]
+func (h *IdentityHandler) GetConnectors(c *gin.Context) {
+	c.JSON(http.StatusOK, h.i.Connectors())
+}
+
+// @Summary Вход через внешний провайдер
+// @Description Перенаправляет браузер к провайдеру и ставит cookie со state. С ?session=true после входа ставится cookie-сессия
+// @Tags connectors
+// @Param id path string true "ID провайдера"
+// @Param session query bool false "Вход в режиме cookie-сессии"
+// @Success 302
+// @Failure 404 {string} string "Провайдер не найден"
+// @Router /connectors/{id}/login [get]
+func (h *IdentityHandler) Login(c *gin.Context) {
+	authURL, state, err := tenantIdentities(c, h.i).BeginLogin(c.Request.Context(), c.Param("id"), 0, c.Query("session") == "true")
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.setStateCookie(c, state, usecase.ExternalLoginStateTTL)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// @Summary Привязать учетную запись внешнего провайдера
+// @Description Возвращает адрес провайдера; после входа у провайдера его учетная запись привязывается к текущему пользователю
+// @Tags connectors
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID провайдера"
+// @Success 200 {object} linkResponse
+// @Failure 404 {string} string "Провайдер не найден"
+// @Router /connectors/{id}/link [post]
+func (h *IdentityHandler) Link(c *gin.Context) {
+	authURL, state, err := tenantIdentities(c, h.i).BeginLogin(c.Request.Context(), c.Param("id"), c.GetInt("id"), false)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.setStateCookie(c, state, usecase.ExternalLoginStateTTL)
+	c.JSON(http.StatusOK, linkResponse{AuthURL: authURL})
+}
+
+// @Summary Возврат от внешнего провайдера
+// @Description Завершает вход или привязку. Вход возвращает токены (или cookie-сессию, если вход начат с ?session=true).
+// @Description state должен совпадать с cookie, поставленной при начале входа в том же браузере и в той же организации
+// @Tags connectors
+// @Produce json
+// @Param id path string true "ID провайдера"
+// @Param state query string true "state"
+// @Param code query string true "Код авторизации"
+// @Success 200 {object} jwt.TokenResponse
+// @Failure 400 {string} string "Ошибка провайдера или устаревший state"
+// @Failure 403 {string} string "Учетная запись не привязана"
+// @Router /connectors/{id}/callback [get]
+func (h *IdentityHandler) Callback(c *gin.Context) {
+	browserState, _ := c.Cookie(h.session.cfg.StateCookie)
+	h.setStateCookie(c, "", -time.Second)
+
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Провайдер отклонил вход", "details": errCode})
+		return
+	}
+
+	user, state, err := tenantIdentities(c, h.i).Finish(c.Request.Context(), c.Param("id"), c.Query("state"), browserState, c.Query("code"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	if state.UserID != 0 {
+		c.JSON(http.StatusOK, fmt.Sprintf("Учетная запись %s привязана", c.Param("id")))
+		return
+	}
+	if requireSecondFactor(c, h.w, user) {
+		return
+	}
+	issueTokens(c, h.session, user, state.Session, h.session.cfg.PostLoginURL)
+}
+
+// @Summary Мои внешние учетные записи
+// @Tags connectors
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.UserIdentity
+// @Router /identities [get]
+func (h *IdentityHandler) GetIdentities(c *gin.Context) {
+	identities, err := h.i.GetIdentities(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary Отвязать внешнюю учетную запись
+// @Tags connectors
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID привязки"
+// @Success 200 {string} string "Учетная запись отвязана"
+// @Failure 404 {string} string "Не найдена"
+// @Failure 409 {string} string "Единственный способ входа"
+// @Router /identities/{id} [delete]
+func (h *IdentityHandler) Unlink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+
+	if err := h.i.Unlink(c.GetInt("id"), id); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Учетная запись с ID = %d отвязана", id))
+}
+
+// setStateCookie привязывает state к браузеру. SameSite=Lax всегда: со Strict браузер
+// не пришлет cookie при возврате от провайдера, это переход с другого сайта. Путь
+// включает префикс организации /t/{slug}, если вход начат через него.
+func (h *IdentityHandler) setStateCookie(c *gin.Context, state string, ttl time.Duration) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     h.session.cfg.StateCookie,
+		Value:    state,
+		Path:     tenantPathPrefix(c) + "/v1/connectors",
+		Domain:   h.session.cfg.Domain,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   h.session.cfg.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *IdentityHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorConnectorNotFound), errors.Is(err, usecase.ErrorIdentityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorExternalState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorIdentityNotLinked), errors.Is(err, usecase.ErrorIdentityTaken):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorIdentityLastLogin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Ошибка внешнего провайдера входа", "details": err.Error()})
+	}
+}
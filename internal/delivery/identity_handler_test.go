@@ -0,0 +1,218 @@
+package delivery
+
+import (
+	"context"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubIdentities — вход через провайдер без провайдера: state фиксированный,
+// Finish сверяет его с cookie так же, как IdentityUseCaseImpl.
+type stubIdentities struct {
+	usecase.IdentityUseCase
+	state        string
+	browserState string
+	// session — вход начат в режиме cookie-сессии
+	session bool
+	// tenant — организация, в контексте которой вызван последний метод
+	tenant string
+}
+
+func (s *stubIdentities) WithTenant(org entity.Organization) usecase.IdentityUseCase {
+	return &tenantIdentitiesStub{stubIdentities: s, slug: org.Slug}
+}
+
+type tenantIdentitiesStub struct {
+	*stubIdentities
+	slug string
+}
+
+func (s *tenantIdentitiesStub) BeginLogin(ctx context.Context, id string, userID int, session bool) (string, string, error) {
+	s.tenant = s.slug
+	return s.stubIdentities.BeginLogin(ctx, id, userID, session)
+}
+
+func (s *tenantIdentitiesStub) Finish(ctx context.Context, id string, state string, browserState string, code string) (entity.User, entity.ExternalLoginState, error) {
+	s.tenant = s.slug
+	return s.stubIdentities.Finish(ctx, id, state, browserState, code)
+}
+
+// memberUsers — участники организации для issueTokens: пользователь 5 состоит в любой.
+type memberUsers struct {
+	usecase.UseCase
+}
+
+func (m memberUsers) WithTenant(org entity.Organization) usecase.UseCase { return m }
+
+func (memberUsers) GetUserByID(id int) (entity.User, error) {
+	return entity.User{ID: id, Email: "oidc@a.com", Role: "user", Tenant: "acme"}, nil
+}
+
+type stubOrgs struct {
+	usecase.OrganizationUseCase
+}
+
+func (stubOrgs) Resolve(slug string) (entity.Organization, error) {
+	if slug != "acme" {
+		return entity.Organization{}, usecase.ErrorConnectorNotFound
+	}
+	return entity.Organization{ID: 2, Slug: "acme"}, nil
+}
+
+func (stubOrgs) ResolveHost(string) (entity.Organization, error) {
+	return entity.Organization{}, usecase.ErrorConnectorNotFound
+}
+
+func (s *stubIdentities) BeginLogin(_ context.Context, _ string, _ int, _ bool) (string, string, error) {
+	return "https://idp.example.com/authorize?state=" + s.state, s.state, nil
+}
+
+func (s *stubIdentities) Finish(_ context.Context, _ string, state string, browserState string, _ string) (entity.User, entity.ExternalLoginState, error) {
+	s.browserState = browserState
+	if state != s.state || browserState != s.state {
+		return entity.User{}, entity.ExternalLoginState{}, usecase.ErrorExternalState
+	}
+	return entity.User{ID: 5, Email: "oidc@a.com", Role: "user"}, entity.ExternalLoginState{State: state, Session: s.session}, nil
+}
+
+type noPasskeys struct {
+	usecase.WebAuthnUseCase
+}
+
+func (noPasskeys) PasskeyRequired(int) bool { return false }
+
+func newIdentityRouter(identities *stubIdentities) *gin.Engine {
+	session := NewSessionHandler(memberUsers{}, nil, noPasskeys{}, config.Session{StateCookie: "oauth_state", AccessCookie: "access_token", RefreshCookie: "refresh_token", CSRFCookie: "csrf_token", Secure: true, SameSite: "strict"})
+	h := NewIdentityHandler(identities, noPasskeys{}, session)
+	r := gin.New()
+	r.Any("/t/:tenant/*path", tenantPathHandler(r))
+	api := r.Group("v1", TenantMiddleware(stubOrgs{}, config.Tenancy{Enabled: true, Resolvers: []string{TenantResolverPath}}))
+	api.GET("/connectors/:id/login", h.Login)
+	api.GET("/connectors/:id/callback", h.Callback)
+	return r
+}
+
+func TestIdentityLoginSetsStateCookie(t *testing.T) {
+	r := newIdentityRouter(&stubIdentities{state: "abc123"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/connectors/mock/login", nil))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("статус %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookie: %v", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Name != "oauth_state" || cookie.Value != "abc123" || !cookie.HttpOnly || !cookie.Secure ||
+		cookie.Path != "/v1/connectors" || cookie.MaxAge <= 0 {
+		t.Fatalf("cookie state: %+v", cookie)
+	}
+	// Strict из настроек сессии не применяется: иначе cookie не вернется от провайдера
+	if cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("SameSite = %v", cookie.SameSite)
+	}
+}
+
+func TestIdentityCallbackChecksStateCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		want   int
+	}{
+		{"без cookie", "", http.StatusBadRequest},
+		{"чужой state", "other", http.StatusBadRequest},
+		{"тот же браузер", "abc123", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identities := &stubIdentities{state: "abc123"}
+			r := newIdentityRouter(identities)
+			req := httptest.NewRequest(http.MethodGet, "/v1/connectors/mock/callback?state=abc123&code=c", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "oauth_state", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if identities.browserState != tt.cookie {
+				t.Fatalf("в Finish передан state %q", identities.browserState)
+			}
+			// cookie удаляется при любом исходе
+			cleared := false
+			for _, c := range w.Result().Cookies() {
+				cleared = cleared || (c.Name == "oauth_state" && c.MaxAge < 0)
+			}
+			if !cleared {
+				t.Fatal("cookie state не удалена")
+			}
+		})
+	}
+}
+
+// Вход через /t/{slug}/... идет в контексте организации, а cookie state ставится на путь
+// с префиксом: иначе браузер не пришлет ее на /t/{slug}/v1/connectors/{id}/callback.
+func TestIdentityTenantPathLogin(t *testing.T) {
+	identities := &stubIdentities{state: "abc123"}
+	r := newIdentityRouter(identities)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t/acme/v1/connectors/mock/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("статус %d: %s", w.Code, w.Body.String())
+	}
+	if identities.tenant != "acme" {
+		t.Fatalf("вход начат в организации %q", identities.tenant)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/t/acme/v1/connectors" {
+		t.Fatalf("cookie: %+v", cookies)
+	}
+
+	identities.tenant = ""
+	req := httptest.NewRequest(http.MethodGet, "/t/acme/v1/connectors/mock/callback?state=abc123&code=c", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("статус %d: %s", w.Code, w.Body.String())
+	}
+	if identities.tenant != "acme" {
+		t.Fatalf("вход завершен в организации %q", identities.tenant)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "oauth_state" && c.Path != "/t/acme/v1/connectors" {
+			t.Fatalf("cookie удаляется с пути %q", c.Path)
+		}
+	}
+}
+
+// refresh cookie сессии, начатой под /t/{slug}, ставится на путь сессии этой организации.
+func TestIdentityTenantPathSessionCookies(t *testing.T) {
+	identities := &stubIdentities{state: "abc123", session: true}
+	r := newIdentityRouter(identities)
+
+	req := httptest.NewRequest(http.MethodGet, "/t/acme/v1/connectors/mock/callback?state=abc123&code=c", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "abc123"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("статус %d: %s", w.Code, w.Body.String())
+	}
+	paths := map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		paths[c.Name] = c.Path
+	}
+	if paths["refresh_token"] != "/t/acme/v1/session" {
+		t.Fatalf("пути cookie: %v", paths)
+	}
+}
@@ -0,0 +1,72 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type ImpersonationHandler struct {
+	i usecase.ImpersonationUseCase
+	u usecase.UseCase
+}
+
+func NewImpersonationHandler(i usecase.ImpersonationUseCase, u usecase.UseCase) *ImpersonationHandler {
+	return &ImpersonationHandler{i: i, u: u}
+}
+
+type impersonateRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// @Summary Войти от имени пользователя
+// @Description Выдает короткий access токен пользователя с claim act (настоящий пользователь). Refresh токен не выдается, смена пароля и факторов входа недоступна, все запросы пишутся в журнал аудита
+// @Tags impersonation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} jwt.TokenResponse
+// @Failure 403 {string} string "Подмена запрещена"
+// @Failure 404 {string} string "Пользователь не найден"
+// @Router /users/{id}/impersonate [post]
+func (h *ImpersonationHandler) Impersonate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var req impersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	target, err := tenantUsers(c, h.u).GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	token, expiresIn, err := tenantImpersonation(c, h.i).Impersonate(requestClaims(c), target, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrorImpersonateSelf), errors.Is(err, usecase.ErrorImpersonateProtected),
+			errors.Is(err, usecase.ErrorImpersonateChain):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, jwt.TokenResponse{
+		AccessToken: token,
+		ExpiresIn:   expiresIn,
+		UserID:      target.ID,
+		Role:        target.Role,
+	})
+}
@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type InviteHandler struct {
+	i usecase.InviteUseCase
+	u usecase.UseCase
+}
+
+func NewInviteHandler(i usecase.InviteUseCase, u usecase.UseCase) *InviteHandler {
+	return &InviteHandler{i: i, u: u}
+}
+
+// @Summary Список приглашений
+// @Description Приглашения организации запроса или глобальные. Только для admin
+// @Tags invites
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.Invite
+// @Router /invites [get]
+func (h *InviteHandler) GetAll(c *gin.Context) {
+	invites, err := tenantInvites(c, h.i).GetInvites()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+// @Summary Пригласить пользователя
+// @Description Отправляет на email ссылку с подписанным токеном приглашения. Прежние непринятые приглашения на этот email отзываются
+// @Tags invites
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} entity.Invite
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 409 {string} string "Пользователь уже зарегистрирован"
+// @Router /invites [post]
+func (h *InviteHandler) Create(c *gin.Context) {
+	var invite entity.Invite
+	if err := c.ShouldBindJSON(&invite); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+	invite.InvitedBy = c.GetInt("id")
+
+	created, err := tenantInvites(c, h.i).CreateInvite(invite)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// @Summary Отозвать приглашение
+// @Tags invites
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID приглашения"
+// @Success 200 {string} string "Приглашение отозвано"
+// @Failure 404 {string} string "Не найдено или уже не действует"
+// @Router /invites/{id} [delete]
+func (h *InviteHandler) Revoke(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := tenantInvites(c, h.i).RevokeInvite(id); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Приглашение с ID = %d отозвано", id))
+}
+
+// @Summary Принять приглашение
+// @Description Создает учетную запись с ролью из приглашения. Если учетная запись уже есть, а организация не изолирует email, пользователь становится участником без пароля
+// @Tags invites
+// @Accept json
+// @Produce json
+// @Success 200 {object} entity.DTOUser
+// @Failure 400 {string} string "Неверное или просроченное приглашение"
+// @Failure 409 {string} string "Пользователь уже зарегистрирован"
+// @Router /invites/accept [post]
+func (h *InviteHandler) Accept(c *gin.Context) {
+	var accept entity.AcceptInvite
+	if err := c.ShouldBindJSON(&accept); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	user, err := tenantInvites(c, h.i).Accept(accept)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.u.ToDTO([]entity.User{user})[0])
+}
+
+func (h *InviteHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorInviteInvalid), errors.Is(err, usecase.ErrorInvitePassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorInviteAccountExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
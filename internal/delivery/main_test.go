@@ -0,0 +1,17 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	jwt.SECRET_KEY = []byte("test-secret")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
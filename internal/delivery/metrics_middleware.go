@@ -0,0 +1,35 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// tenantPathRoute — внешний маршрут /t/:tenant/*path; запрос учитывается вложенным маршрутом.
+const tenantPathRoute = "/t/:tenant/*path"
+
+// Metrics учитывает запросы по шаблону маршрута gin. Запросы без маршрута
+// объединяются в route="unmatched".
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == tenantPathRoute {
+			c.Next()
+			return
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+
+		start := time.Now()
+		c.Next()
+		metrics.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// countOutcome учитывает исход операции по коду ответа обработчика; вызывается через defer.
+func countOutcome(c *gin.Context, counter *prometheus.CounterVec) {
+	counter.WithLabelValues(metrics.HTTPOutcome(c.Writer.Status())).Inc()
+}
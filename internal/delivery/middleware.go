@@ -1,34 +1,194 @@
 package delivery
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
 	"github.com/LandGAA/authh2/pkg/jwt"
 	"github.com/LandGAA/authh2/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+var (
+	errEmptyCredentials = fmt.Errorf("Пустой херер")
+	errInvalidToken     = fmt.Errorf("Невалидный токен")
+)
+
+// AuthMiddleware принимает access токен из заголовка Authorization или из cookie-сессии.
+// Для cookie на изменяющих запросах дополнительно проверяется CSRF токен.
 func AuthMiddleware() gin.HandlerFunc {
+	session := config.Cfg.Session
 	return func(c *gin.Context) {
-		header := c.GetHeader("Authorization")
-		if header == "" {
-			logger.Logger.Error("Пустой хеддер",
-				zap.String("middleware", "пустой хедер"))
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Пустой херер"})
+		claims, fromCookie, err := authenticateRequest(c, session.AccessCookie)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !sameTenant(c, claims) {
+			logger.Logger.Warn("Токен выдан для другой организации",
+				zap.String("email", claims.Email),
+				zap.String("token_tenant", claims.Tenant),
+				zap.String("tenant", c.GetString("tenant")))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Токен выдан для другой организации"})
+			return
+		}
+		if fromCookie && !validCSRF(c, session) {
+			logger.Logger.Warn("Неверный CSRF токен",
+				zap.String("email", claims.Email),
+				zap.String("path", c.FullPath()))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Неверный CSRF токен"})
 			return
 		}
+		setClaims(c, claims)
+		c.Next()
+	}
+}
 
-		token := strings.TrimPrefix(header, "Bearer ")
-		claims, err := jwt.ValidateToken(token)
-		if err != nil {
-			logger.Logger.Error(fmt.Sprintf("Невалидный токен %s", token),
-				zap.Error(err))
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Невалидный токен"})
+// authenticateRequest достает токен из заголовка Authorization, а если его нет
+// и задано имя cookie — из cookie, и проверяет его. Второе значение сообщает, что токен взят из cookie.
+func authenticateRequest(c *gin.Context, cookieName string) (*jwt.Claims, bool, error) {
+	fromCookie := false
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" && cookieName != "" {
+		token, _ = c.Cookie(cookieName)
+		fromCookie = true
+	}
+	if token == "" {
+		logger.Logger.Error("Пустой хеддер",
+			zap.String("middleware", "пустой хедер"))
+		return nil, false, errEmptyCredentials
+	}
+
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		logger.Logger.Error(fmt.Sprintf("Невалидный токен %s", token),
+			zap.Error(err))
+		return nil, false, errInvalidToken
+	}
+	if !claims.IsUserAccess() {
+		logger.Logger.Error("Токен не является access токеном пользователя",
+			zap.Int("id", claims.ID),
+			zap.String("token_use", claims.TokenUse))
+		return nil, false, errInvalidToken
+	}
+	return claims, fromCookie, nil
+}
+
+// validCSRF реализует double-submit: значение заголовка должно совпадать с CSRF cookie.
+// Безопасные методы не проверяются.
+func validCSRF(c *gin.Context, session config.Session) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := c.Cookie(session.CSRFCookie)
+	header := c.GetHeader(session.CSRFHeader)
+	if err != nil || cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+func setClaims(c *gin.Context, claims *jwt.Claims) {
+	c.Set("email", claims.Email)
+	c.Set("id", claims.ID)
+	c.Set("role", claims.Role)
+	c.Set("roles", claims.EffectiveRoles())
+	c.Set("claims", claims)
+	if claims.Act != nil {
+		// фронтенд по заголовку показывает, что сессия подменена
+		c.Set("actor_id", claims.ActorID())
+		c.Header("X-Impersonated-By", claims.Act.Email)
+	}
+}
+
+// DenyImpersonated запрещает действие в подмененной сессии: администратор не может
+// сменить пароль или факторы входа пользователя, действуя от его имени.
+func DenyImpersonated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := requestClaims(c)
+		if claims == nil || claims.Act == nil {
+			c.Next()
 			return
 		}
-		c.Set("email", claims.Email)
+		c.Set("impersonation_denied", true)
+		logger.Logger.Warn("Действие запрещено в подмененной сессии",
+			zap.Int("user_id", claims.ID),
+			zap.Int("actor_id", claims.ActorID()),
+			zap.String("path", c.FullPath()))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Действие недоступно при входе от имени пользователя"})
+	}
+}
+
+// AuditImpersonation пишет в журнал каждый запрос подмененной сессии от имени обоих пользователей.
+func AuditImpersonation(audit usecase.AuditUseCase) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		c.Next()
+		claims := requestClaims(c)
+		if claims == nil || claims.Act == nil {
+			return
+		}
+		action := entity.AuditImpersonatedRequest
+		if c.GetBool("impersonation_denied") {
+			action = entity.AuditImpersonationDenied
+		}
+		audit.Record(impersonationEvent(c, claims, action, c.Writer.Status()))
+	}
+}
+
+func requestClaims(c *gin.Context) *jwt.Claims {
+	value, _ := c.Get("claims")
+	claims, _ := value.(*jwt.Claims)
+	return claims
+}
+
+func impersonationEvent(c *gin.Context, claims *jwt.Claims, action string, status int) entity.AuditEvent {
+	return entity.AuditEvent{
+		Action:     action,
+		ActorID:    claims.ActorID(),
+		ActorEmail: claims.Act.Email,
+		UserID:     claims.ID,
+		UserEmail:  claims.Email,
+		Tenant:     claims.Tenant,
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		Status:     status,
+	}
+}
+
+// RequireSelfOrRole пропускает запрос к собственной учетной записи (параметр param
+// равен ID из токена) или пользователя с одной из ролей.
+func RequireSelfOrRole(param string, roles ...string) gin.HandlerFunc {
+	requireRole := RequireRole(roles...)
+	return func(c *gin.Context) {
+		if id := c.GetInt("id"); id != 0 && c.Param(param) == strconv.Itoa(id) {
+			c.Next()
+			return
+		}
+		requireRole(c)
+	}
+}
+
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, effective := range c.GetStringSlice("roles") {
+			if containsRole(roles, effective) {
+				c.Next()
+				return
+			}
+		}
+		logger.Logger.Warn("Недостаточно прав",
+			zap.String("email", c.GetString("email")),
+			zap.String("role", role),
+			zap.String("path", c.FullPath()))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
 	}
 }
@@ -0,0 +1,144 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testSession = config.Session{
+	AccessCookie: "access_token",
+	CSRFCookie:   "csrf_token",
+	CSRFHeader:   "X-CSRF-Token",
+}
+
+func TestValidCSRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		want   bool
+	}{
+		{"GET без токена", http.MethodGet, "", "", true},
+		{"HEAD без токена", http.MethodHead, "", "", true},
+		{"OPTIONS без токена", http.MethodOptions, "", "", true},
+		{"POST с совпадающим токеном", http.MethodPost, "abc", "abc", true},
+		{"POST без заголовка", http.MethodPost, "abc", "", false},
+		{"POST без cookie", http.MethodPost, "", "abc", false},
+		{"POST без обоих", http.MethodPost, "", "", false},
+		{"POST с другим токеном", http.MethodPost, "abc", "abd", false},
+		{"POST с префиксом токена", http.MethodPost, "abc", "ab", false},
+		{"DELETE с совпадающим токеном", http.MethodDelete, "abc", "abc", true},
+		{"PUT без заголовка", http.MethodPut, "abc", "", false},
+		{"PATCH с другим регистром", http.MethodPatch, "abc", "ABC", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: testSession.CSRFCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				c.Request.Header.Set(testSession.CSRFHeader, tt.header)
+			}
+			if got := validCSRF(c, testSession); got != tt.want {
+				t.Fatalf("validCSRF = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// CSRF проверяется только у токена из cookie: токен в заголовке Authorization
+// браузер сам не подставит.
+func TestAuthMiddlewareCSRF(t *testing.T) {
+	session := config.Cfg.Session
+	config.Cfg.Session = testSession
+	defer func() { config.Cfg.Session = session }()
+
+	access, _, err := jwt.GenerateAccessToken(entity.User{ID: 1, Email: "admin@a.com", Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := jwt.GenerateRefreshToken(entity.User{ID: 1, Email: "admin@a.com", Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Any("/v1/me", AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		method string
+		bearer string
+		cookie string
+		csrf   string
+		want   int
+	}{
+		{"cookie, GET", http.MethodGet, "", access, "", http.StatusOK},
+		{"cookie, POST без CSRF", http.MethodPost, "", access, "", http.StatusForbidden},
+		{"cookie, POST с неверным CSRF", http.MethodPost, "", access, "wrong", http.StatusForbidden},
+		{"cookie, POST с CSRF", http.MethodPost, "", access, "csrf-value", http.StatusOK},
+		{"заголовок, POST без CSRF", http.MethodPost, access, "", "", http.StatusOK},
+		{"refresh токен в cookie", http.MethodGet, "", refresh, "", http.StatusUnauthorized},
+		{"без токена", http.MethodGet, "", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/me", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: testSession.AccessCookie, Value: tt.cookie})
+				req.AddCookie(&http.Cookie{Name: testSession.CSRFCookie, Value: "csrf-value"})
+			}
+			if tt.csrf != "" {
+				req.Header.Set(testSession.CSRFHeader, tt.csrf)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireSelfOrRole(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    int
+		roles []string
+		path  string
+		want  int
+	}{
+		{"своя учетная запись", 2, []string{"user"}, "/users/2", http.StatusOK},
+		{"чужая учетная запись", 2, []string{"user"}, "/users/1", http.StatusForbidden},
+		{"чужая учетная запись администратором", 1, []string{"admin"}, "/users/2", http.StatusOK},
+		{"роль admin из группы", 3, []string{"user", "admin"}, "/users/2", http.StatusOK},
+		{"токен без пользователя", 0, nil, "/users/0", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.DELETE("/users/:id", func(c *gin.Context) {
+				c.Set("id", tt.id)
+				c.Set("roles", tt.roles)
+			}, RequireSelfOrRole("id", "admin"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d", w.Code, tt.want)
+			}
+		})
+	}
+}
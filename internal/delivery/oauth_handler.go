@@ -0,0 +1,263 @@
+package delivery
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type OAuthHandler struct {
+	c usecase.ClientUseCase
+	t usecase.TokenUseCase
+}
+
+func NewOAuthHandler(clientUseCase usecase.ClientUseCase, tokenUseCase usecase.TokenUseCase) *OAuthHandler {
+	return &OAuthHandler{c: clientUseCase, t: tokenUseCase}
+}
+
+const grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	// IssuedTokenType — тип выданного токена, только для token-exchange
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// @Summary Выдача токена OAuth2
+// @Description Grant client_credentials: сервисный аккаунт обменивает client_id и client_secret на access токен.
+// @Description Grant urn:ietf:params:oauth:grant-type:token-exchange (RFC 8693): сервисный аккаунт со scope token:exchange
+// @Description обменивает access токен пользователя на короткий токен для сервиса audience с claim act
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "client_credentials / urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param scope formData string false "Запрашиваемые scope через пробел"
+// @Param subject_token formData string false "Access токен пользователя (token-exchange)"
+// @Param subject_token_type formData string false "urn:ietf:params:oauth:token-type:access_token (token-exchange)"
+// @Param audience formData string false "Сервис, для которого нужен токен (token-exchange)"
+// @Success 200 {object} oauthTokenResponse
+// @Failure 400 {string} string "invalid_request / unsupported_grant_type / invalid_scope / invalid_target / unauthorized_client"
+// @Failure 401 {string} string "invalid_client"
+// @Router /oauth/token [post]
+func (h *OAuthHandler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	switch c.PostForm("grant_type") {
+	case "client_credentials":
+		h.clientCredentials(c)
+	case grantTypeTokenExchange:
+		h.tokenExchange(c)
+	case "":
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Не указан grant_type")
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Неподдерживаемый grant_type")
+	}
+}
+
+func (h *OAuthHandler) clientCredentials(c *gin.Context) {
+	clientID, clientSecret, ok := clientCredentials(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Не переданы учетные данные клиента")
+		return
+	}
+
+	token, expiresIn, scope, err := h.c.IssueClientToken(clientID, clientSecret, c.PostForm("scope"))
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrorInvalidClient):
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			oauthError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+		case errors.Is(err, usecase.ErrorInvalidScope):
+			oauthError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+		default:
+			logger.Logger.Error("Ошибка выдачи токена клиенту",
+				zap.Error(err),
+				zap.String("client_id", clientID))
+			oauthError(c, http.StatusInternalServerError, "server_error", "Ошибка генерации токена")
+		}
+		return
+	}
+
+	logger.Logger.Info("Выдан токен сервисному аккаунту",
+		zap.String("client_id", clientID),
+		zap.String("scope", scope))
+	c.JSON(http.StatusOK, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   expiresIn - time.Now().Unix(),
+		Scope:       scope,
+	})
+}
+
+func (h *OAuthHandler) tokenExchange(c *gin.Context) {
+	account, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	subjectToken := c.PostForm("subject_token")
+	if subjectToken == "" || c.PostForm("subject_token_type") == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Не переданы subject_token и subject_token_type")
+		return
+	}
+	// resource (RFC 8707) принимается как синоним audience
+	audience := c.PostForm("audience")
+	if audience == "" {
+		audience = c.PostForm("resource")
+	}
+
+	token, expiresIn, scope, err := h.c.ExchangeToken(account, subjectToken, c.PostForm("subject_token_type"), audience, c.PostForm("scope"))
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrorUnauthorizedClient):
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", err.Error())
+		case errors.Is(err, usecase.ErrorInvalidSubjectToken):
+			oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		case errors.Is(err, usecase.ErrorInvalidTarget):
+			oauthError(c, http.StatusBadRequest, "invalid_target", err.Error())
+		case errors.Is(err, usecase.ErrorInvalidScope):
+			oauthError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+		default:
+			logger.Logger.Error("Ошибка обмена токена",
+				zap.Error(err),
+				zap.String("client_id", account.ClientID))
+			oauthError(c, http.StatusInternalServerError, "server_error", "Ошибка генерации токена")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, oauthTokenResponse{
+		AccessToken:     token,
+		TokenType:       "Bearer",
+		ExpiresIn:       expiresIn - time.Now().Unix(),
+		Scope:           scope,
+		IssuedTokenType: usecase.TokenTypeAccessToken,
+	})
+}
+
+// @Summary Интроспекция токена (RFC 7662)
+// @Description Проверяет access/refresh токен или API ключ. Клиент аутентифицируется через Basic, требуется scope token:introspect
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Токен или API ключ"
+// @Param token_type_hint formData string false "access_token / refresh_token / api_key"
+// @Success 200 {object} entity.TokenIntrospection
+// @Failure 400 {string} string "invalid_request"
+// @Failure 401 {string} string "invalid_client"
+// @Failure 403 {string} string "insufficient_scope"
+// @Router /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	account, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+	if !jwt.HasScope(account.Scopes, jwt.ScopeTokenIntrospect) {
+		oauthError(c, http.StatusForbidden, "insufficient_scope", "Требуется scope "+jwt.ScopeTokenIntrospect)
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Не передан token")
+		return
+	}
+
+	c.JSON(http.StatusOK, h.t.Introspect(token, c.PostForm("token_type_hint")))
+}
+
+// @Summary Отзыв токена (RFC 7009)
+// @Description Отзывает access/refresh токен или API ключ. Клиент может отозвать свои токены, чужие — только со scope token:revoke
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Токен или API ключ"
+// @Param token_type_hint formData string false "access_token / refresh_token / api_key"
+// @Success 200 {string} string ""
+// @Failure 400 {string} string "invalid_request / unauthorized_client"
+// @Failure 401 {string} string "invalid_client"
+// @Router /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	account, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Не передан token")
+		return
+	}
+
+	if err := h.t.Revoke(token, c.PostForm("token_type_hint"), account); err != nil {
+		if errors.Is(err, usecase.ErrorUnauthorizedClient) {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", err.Error())
+			return
+		}
+		logger.Logger.Error("Ошибка отзыва токена",
+			zap.Error(err),
+			zap.String("client_id", account.ClientID))
+		oauthError(c, http.StatusServiceUnavailable, "server_error", "Ошибка отзыва токена")
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (entity.ServiceAccount, bool) {
+	clientID, clientSecret, ok := clientCredentials(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Не переданы учетные данные клиента")
+		return entity.ServiceAccount{}, false
+	}
+
+	account, err := h.c.AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+		return entity.ServiceAccount{}, false
+	}
+	return account, true
+}
+
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		id, errID := url.QueryUnescape(id)
+		secret, errSecret := url.QueryUnescape(secret)
+		return id, secret, errID == nil && errSecret == nil && id != "" && secret != ""
+	}
+	id, secret := c.PostForm("client_id"), c.PostForm("client_secret")
+	return id, secret, id != "" && secret != ""
+}
+
+func oauthError(c *gin.Context, status int, code string, description string) {
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// @Summary Открытые ключи подписи токенов (JWKS)
+// @Description Ключи для локальной проверки подписи токенов сервисами. Пустой набор, если токены подписываются HS256 (не задан JWT_PRIVATE_KEY_FILE)
+// @Tags oauth
+// @Produce json
+// @Success 200 {object} jwt.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (h *OAuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.JWKS())
+}
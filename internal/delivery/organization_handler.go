@@ -0,0 +1,174 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type OrganizationHandler struct {
+	o usecase.OrganizationUseCase
+}
+
+func NewOrganizationHandler(o usecase.OrganizationUseCase) *OrganizationHandler {
+	return &OrganizationHandler{o: o}
+}
+
+type setMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// @Summary Список организаций
+// @Description Только для admin вне контекста организации
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.Organization
+// @Router /orgs [get]
+func (h *OrganizationHandler) GetAll(c *gin.Context) {
+	orgs, err := h.o.GetOrganizations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// @Summary Создать организацию
+// @Description isolated_emails — учетные записи, созданные в организации, принадлежат ей, email уникален в ее пределах
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} entity.Organization
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 409 {string} string "Организация уже существует"
+// @Router /orgs [post]
+func (h *OrganizationHandler) Create(c *gin.Context) {
+	var org entity.Organization
+	if err := c.ShouldBindJSON(&org); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	created, err := h.o.CreateOrganization(org)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// @Summary Удалить организацию
+// @Description Удаляет организацию, членства и принадлежащие ей учетные записи
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID организации"
+// @Success 200 {string} string "Организация удалена"
+// @Failure 404 {string} string "Не найдена"
+// @Router /orgs/{id} [delete]
+func (h *OrganizationHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := h.o.DeleteOrganization(id); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Организация с ID = %d удалена", id))
+}
+
+// @Summary Участники организации
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID организации"
+// @Success 200 {array} entity.Membership
+// @Failure 404 {string} string "Не найдена"
+// @Router /orgs/{id}/members [get]
+func (h *OrganizationHandler) GetMembers(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	members, err := h.o.GetMembers(id)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// @Summary Добавить участника или изменить его роль
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID организации"
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {string} string "Участник сохранен"
+// @Failure 404 {string} string "Организация или пользователь не найдены"
+// @Router /orgs/{id}/members/{user_id} [put]
+func (h *OrganizationHandler) SetMember(c *gin.Context) {
+	id, err1 := strconv.Atoi(c.Param("id"))
+	userID, err2 := strconv.Atoi(c.Param("user_id"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var req setMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	if err := h.o.SetMember(id, userID, req.Role); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Пользователь с ID = %d добавлен в организацию", userID))
+}
+
+// @Summary Исключить участника
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID организации"
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {string} string "Участник исключен"
+// @Failure 404 {string} string "Не состоит в организации"
+// @Router /orgs/{id}/members/{user_id} [delete]
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	id, err1 := strconv.Atoi(c.Param("id"))
+	userID, err2 := strconv.Atoi(c.Param("user_id"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := h.o.RemoveMember(id, userID); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Пользователь с ID = %d исключен из организации", userID))
+}
+
+func (h *OrganizationHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorOrganizationNotFound), errors.Is(err, usecase.ErrorMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorOrganizationSlug):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorOrganizationExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
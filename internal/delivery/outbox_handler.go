@@ -0,0 +1,31 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type OutboxHandler struct {
+	o usecase.OutboxUseCase
+}
+
+func NewOutboxHandler(o usecase.OutboxUseCase) *OutboxHandler {
+	return &OutboxHandler{o: o}
+}
+
+// @Summary Состояние outbox
+// @Description Для каждого приемника (webhooks, stream, брокеры): позиция, число недоставленных событий, отставание в секундах и последняя ошибка. Только для admin
+// @Tags outbox
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.OutboxSinkStats
+// @Router /outbox [get]
+func (h *OutboxHandler) Stats(c *gin.Context) {
+	stats, err := h.o.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
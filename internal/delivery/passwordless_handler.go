@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type PasswordlessHandler struct {
+	p       usecase.PasswordlessUseCase
+	w       usecase.WebAuthnUseCase
+	session *SessionHandler
+}
+
+func NewPasswordlessHandler(p usecase.PasswordlessUseCase, w usecase.WebAuthnUseCase, session *SessionHandler) *PasswordlessHandler {
+	return &PasswordlessHandler{p: p, w: w, session: session}
+}
+
+type passwordlessStartRequest struct {
+	Email  string `json:"email" binding:"required,email"`
+	Method string `json:"method" binding:"required"`
+}
+
+type passwordlessVerifyRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// @Summary Запросить вход без пароля
+// @Description method = link — письмо с одноразовой ссылкой, method = code — письмо с 6-значным кодом. Ответ не зависит от того, существует ли пользователь и превышен ли лимит писем
+// @Tags passwordless
+// @Accept json
+// @Produce json
+// @Success 202 {string} string "Письмо отправлено"
+// @Failure 400 {string} string "Некоректные данные"
+// @Router /passwordless/start [post]
+func (h *PasswordlessHandler) Start(c *gin.Context) {
+	var req passwordlessStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tenantPasswordless(c, h.p).Start(req.Email, req.Method); err != nil {
+		if errors.Is(err, usecase.ErrorPasswordlessMethod) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отправки письма"})
+		return
+	}
+	c.JSON(http.StatusAccepted, "Если вход без пароля включен, письмо отправлено")
+}
+
+// @Summary Войти по ссылке или коду из письма
+// @Description token — из ссылки, либо email + code. Код и ссылка одноразовые. С ?session=true ставит cookie-сессию
+// @Tags passwordless
+// @Accept json
+// @Produce json
+// @Param session query bool false "Вход в режиме cookie-сессии"
+// @Success 200 {object} jwt.TokenResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 401 {string} string "Неверный или просроченный код"
+// @Router /passwordless/verify [post]
+func (h *PasswordlessHandler) Verify(c *gin.Context) {
+	var req passwordlessVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var (
+		user entity.User
+		err  error
+	)
+	switch {
+	case req.Token != "":
+		user, err = tenantPasswordless(c, h.p).RedeemLink(req.Token)
+	case req.Email != "" && req.Code != "":
+		user, err = tenantPasswordless(c, h.p).RedeemCode(req.Email, req.Code)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нужен token или email и code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if requireSecondFactor(c, h.w, user) {
+		return
+	}
+	issueTokens(c, h.session, user, c.Query("session") == "true", "")
+}
+
+// @Summary Включить/выключить вход без пароля
+// @Tags passwordless
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {string} string "Настройки сохранены"
+// @Router /passwordless [put]
+func (h *PasswordlessHandler) SetEnabled(c *gin.Context) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.p.SetEnabled(c.GetInt("id"), req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, "Настройки сохранены")
+}
@@ -3,6 +3,9 @@ package delivery
 import (
 	_ "github.com/LandGAA/authh2/docs"
 	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/health"
+	"github.com/LandGAA/authh2/pkg/metrics"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -17,21 +20,88 @@ import (
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
-func SetupRouters(u usecase.UseCase) *gin.Engine {
+func SetupRouters(u usecase.UseCase, cu usecase.ClientUseCase, tu usecase.TokenUseCase, wu usecase.WebAuthnUseCase, pu usecase.PasswordlessUseCase, iu usecase.IdentityUseCase, su usecase.SAMLUseCase, ou usecase.OrganizationUseCase, gu usecase.GroupUseCase, inv usecase.InviteUseCase, au usecase.AuditUseCase, imu usecase.ImpersonationUseCase, whu usecase.WebhookUseCase, obu usecase.OutboxUseCase, scu usecase.SCIMUseCase, hc *health.Checker) *gin.Engine {
 	r := gin.Default()
+	if config.Cfg.Tracing.Enabled {
+		r.Use(Tracing(config.Cfg.Tracing.ServiceName, config.Cfg.Metrics.Path, livenessPath, readinessPath))
+	}
+	if config.Cfg.Metrics.Enabled {
+		r.Use(Metrics())
+		r.GET(config.Cfg.Metrics.Path, gin.WrapH(metrics.Handler()))
+	}
+	healthHandler := NewHealthHandler(hc)
+	r.GET(livenessPath, healthHandler.Live)
+	r.GET(readinessPath, healthHandler.Ready)
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", config.Cfg.Session.CSRFHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	handler := NewUserHandler(u)
+	handler := NewUserHandler(u, tu, wu, inv)
+	oauthHandler := NewOAuthHandler(cu, tu)
+	serviceAccountHandler := NewServiceAccountHandler(cu)
+	apiKeyHandler := NewAPIKeyHandler(tu)
+	forwardAuthHandler := NewForwardAuthHandler(config.Cfg.ForwardAuth)
+	sessionHandler := NewSessionHandler(u, tu, wu, config.Cfg.Session)
+	webAuthnHandler := NewWebAuthnHandler(u, wu, sessionHandler)
+	passwordlessHandler := NewPasswordlessHandler(pu, wu, sessionHandler)
+	identityHandler := NewIdentityHandler(iu, wu, sessionHandler)
+	samlHandler := NewSAMLHandler(su, wu, sessionHandler)
+	organizationHandler := NewOrganizationHandler(ou)
+	groupHandler := NewGroupHandler(gu, u)
+	inviteHandler := NewInviteHandler(inv, u)
+	auditHandler := NewAuditHandler(au)
+	impersonationHandler := NewImpersonationHandler(imu, u)
+	webhookHandler := NewWebhookHandler(whu)
+	outboxHandler := NewOutboxHandler(obu)
+	scimHandler := NewSCIMHandler(scu, config.Cfg.SCIM)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	api := r.Group("v1")
+	r.GET("/.well-known/jwks.json", oauthHandler.JWKS)
+
+	oauth := r.Group("oauth")
+	{
+		oauth.POST("/token", oauthHandler.Token)
+		oauth.POST("/introspect", oauthHandler.Introspect)
+		oauth.POST("/revoke", oauthHandler.Revoke)
+	}
+
+	// /t/{slug}/v1/... — те же маршруты в контексте организации slug
+	if config.Cfg.Tenancy.Enabled {
+		r.Any("/t/:tenant/*path", tenantPathHandler(r))
+	}
+
+	// /scim/v2 — провижининг из IdP; авторизация токеном провижининга, а не пользователя
+	if config.Cfg.SCIM.Enabled {
+		scimAPI := r.Group("scim/v2", TenantMiddleware(ou, config.Cfg.Tenancy), SCIMAuth(scu, ou))
+		{
+			scimAPI.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+			scimAPI.GET("/ResourceTypes", scimHandler.ResourceTypes)
+			scimAPI.GET("/ResourceTypes/:id", scimHandler.ResourceTypes)
+			scimAPI.GET("/Schemas", scimHandler.Schemas)
+			scimAPI.GET("/Schemas/:id", scimHandler.Schemas)
+
+			scimAPI.GET("/Users", scimHandler.ListUsers)
+			scimAPI.POST("/Users", scimHandler.CreateUser)
+			scimAPI.GET("/Users/:id", scimHandler.GetUser)
+			scimAPI.PUT("/Users/:id", scimHandler.ReplaceUser)
+			scimAPI.PATCH("/Users/:id", scimHandler.PatchUser)
+			scimAPI.DELETE("/Users/:id", scimHandler.DeleteUser)
+
+			scimAPI.GET("/Groups", scimHandler.ListGroups)
+			scimAPI.POST("/Groups", scimHandler.CreateGroup)
+			scimAPI.GET("/Groups/:id", scimHandler.GetGroup)
+			scimAPI.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+			scimAPI.PATCH("/Groups/:id", scimHandler.PatchGroup)
+			scimAPI.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+		}
+	}
+
+	api := r.Group("v1", TenantMiddleware(ou, config.Cfg.Tenancy))
 	{
 		api.GET("/users", handler.GetAll)
 		api.GET("/users/:id", handler.GetByID)
@@ -40,11 +110,114 @@ func SetupRouters(u usecase.UseCase) *gin.Engine {
 		api.POST("/login", handler.Login)
 		api.POST("/register", handler.Register)
 
-		auth := api.Use(AuthMiddleware())
+		api.POST("/invites/accept", inviteHandler.Accept)
+
+		api.Any("/forward-auth", forwardAuthHandler.Check)
+
+		session := api.Group("session")
+		{
+			session.POST("/login", sessionHandler.Login)
+			session.POST("/refresh", sessionHandler.Refresh)
+			session.POST("/logout", sessionHandler.Logout)
+		}
+
+		api.POST("/webauthn/login/begin", webAuthnHandler.BeginLogin)
+		api.POST("/webauthn/login/finish", webAuthnHandler.FinishLogin)
+
+		api.POST("/passwordless/start", passwordlessHandler.Start)
+		api.POST("/passwordless/verify", passwordlessHandler.Verify)
+
+		api.GET("/connectors", identityHandler.GetConnectors)
+		api.GET("/connectors/:id/login", identityHandler.Login)
+		api.GET("/connectors/:id/callback", identityHandler.Callback)
+
+		api.GET("/saml/:tenant/metadata", samlHandler.Metadata)
+		api.GET("/saml/:tenant/login", samlHandler.Login)
+		api.POST("/saml/:tenant/acs", samlHandler.ACS)
+
+		auth := api.Group("", AuthMiddleware(), AuditImpersonation(au))
 		{
-			auth.DELETE("/users/:id", handler.Delete)
-			auth.PATCH("/users/:id", handler.UpdatePassword)
 			auth.POST("/refresh", handler.Refresh)
+
+			auth.GET("/api-keys", apiKeyHandler.GetAll)
+			auth.GET("/webauthn/credentials", webAuthnHandler.GetCredentials)
+			auth.GET("/identities", identityHandler.GetIdentities)
+
+			// пароль, ключи и факторы входа нельзя менять, действуя от имени пользователя
+			sensitive := auth.Group("", DenyImpersonated())
+			{
+				// свою учетную запись меняет сам пользователь, чужую — администратор
+				sensitive.DELETE("/users/:id", RequireSelfOrRole("id", "admin"), handler.Delete)
+				sensitive.PATCH("/users/:id", RequireSelfOrRole("id", "admin"), handler.UpdatePassword)
+
+				sensitive.POST("/api-keys", apiKeyHandler.Create)
+				sensitive.DELETE("/api-keys/:id", apiKeyHandler.Delete)
+
+				sensitive.POST("/webauthn/register/begin", webAuthnHandler.BeginRegistration)
+				sensitive.POST("/webauthn/register/finish", webAuthnHandler.FinishRegistration)
+				sensitive.DELETE("/webauthn/credentials/:id", webAuthnHandler.DeleteCredential)
+				sensitive.PUT("/webauthn/mfa", webAuthnHandler.SetMFA)
+
+				sensitive.PUT("/passwordless", passwordlessHandler.SetEnabled)
+
+				sensitive.POST("/connectors/:id/link", identityHandler.Link)
+				sensitive.DELETE("/identities/:id", identityHandler.Unlink)
+			}
+
+			auth.GET("/access", groupHandler.MyAccess)
+
+			// группы, приглашения и журнал аудита доступны и администраторам организации, каждому — свои
+			tenantAdmin := auth.Group("", RequireRole("admin"))
+			{
+				tenantAdmin.GET("/users/:id/access", groupHandler.UserAccess)
+				tenantAdmin.GET("/groups", groupHandler.GetAll)
+				tenantAdmin.POST("/groups", groupHandler.Create)
+				tenantAdmin.GET("/groups/:id", groupHandler.GetByID)
+				tenantAdmin.PUT("/groups/:id", groupHandler.Update)
+				tenantAdmin.DELETE("/groups/:id", groupHandler.Delete)
+				tenantAdmin.PUT("/groups/:id/roles", groupHandler.SetRoles)
+				tenantAdmin.GET("/groups/:id/members", groupHandler.GetMembers)
+				tenantAdmin.PUT("/groups/:id/members/:user_id", groupHandler.AddMember)
+				tenantAdmin.DELETE("/groups/:id/members/:user_id", groupHandler.RemoveMember)
+
+				tenantAdmin.GET("/invites", inviteHandler.GetAll)
+				tenantAdmin.POST("/invites", inviteHandler.Create)
+				tenantAdmin.DELETE("/invites/:id", inviteHandler.Revoke)
+
+				tenantAdmin.GET("/audit", auditHandler.GetEvents)
+
+				tenantAdmin.GET("/scim/tokens", scimHandler.GetTokens)
+				tenantAdmin.POST("/scim/tokens", scimHandler.CreateToken)
+				tenantAdmin.DELETE("/scim/tokens/:id", scimHandler.RevokeToken)
+			}
+
+			if config.Cfg.Impersonation.Enabled {
+				auth.POST("/users/:id/impersonate", RequireRole(config.Cfg.Impersonation.Roles...), impersonationHandler.Impersonate)
+			}
+
+			admin := auth.Group("", RequireRole("admin"), RequireGlobal())
+			{
+				admin.GET("/service-accounts", serviceAccountHandler.GetAll)
+				admin.POST("/service-accounts", serviceAccountHandler.Create)
+				admin.DELETE("/service-accounts/:id", serviceAccountHandler.Delete)
+
+				admin.GET("/orgs", organizationHandler.GetAll)
+				admin.POST("/orgs", organizationHandler.Create)
+				admin.DELETE("/orgs/:id", organizationHandler.Delete)
+				admin.GET("/orgs/:id/members", organizationHandler.GetMembers)
+				admin.PUT("/orgs/:id/members/:user_id", organizationHandler.SetMember)
+				admin.DELETE("/orgs/:id/members/:user_id", organizationHandler.RemoveMember)
+
+				admin.GET("/webhooks", webhookHandler.GetAll)
+				admin.POST("/webhooks", webhookHandler.Create)
+				admin.PUT("/webhooks/:id", webhookHandler.Update)
+				admin.DELETE("/webhooks/:id", webhookHandler.Delete)
+				admin.GET("/webhooks/:id/deliveries", webhookHandler.GetDeliveries)
+				admin.GET("/webhooks/:id/deliveries/:delivery_id", webhookHandler.GetDelivery)
+				admin.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", webhookHandler.Redeliver)
+
+				admin.GET("/outbox", outboxHandler.Stats)
+			}
 		}
 	}
 	return r
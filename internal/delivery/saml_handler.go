@@ -0,0 +1,96 @@
+package delivery
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type SAMLHandler struct {
+	s       usecase.SAMLUseCase
+	w       usecase.WebAuthnUseCase
+	session *SessionHandler
+}
+
+func NewSAMLHandler(s usecase.SAMLUseCase, w usecase.WebAuthnUseCase, session *SessionHandler) *SAMLHandler {
+	return &SAMLHandler{s: s, w: w, session: session}
+}
+
+// @Summary Метаданные SAML сервис-провайдера
+// @Description Импортируются в IdP тенанта: entity ID, ACS и сертификат подписи запросов
+// @Tags saml
+// @Produce xml
+// @Param tenant path string true "Тенант"
+// @Success 200 {string} string "EntityDescriptor"
+// @Failure 404 {string} string "IdP не настроен"
+// @Router /saml/{tenant}/metadata [get]
+func (h *SAMLHandler) Metadata(c *gin.Context) {
+	metadata, err := h.s.Metadata(c.Param("tenant"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// @Summary Вход через SAML IdP тенанта
+// @Description Перенаправляет браузер к IdP с AuthnRequest. С ?session=true после входа ставится cookie-сессия
+// @Tags saml
+// @Param tenant path string true "Тенант"
+// @Param session query bool false "Вход в режиме cookie-сессии"
+// @Success 302
+// @Failure 404 {string} string "IdP не настроен"
+// @Router /saml/{tenant}/login [get]
+func (h *SAMLHandler) Login(c *gin.Context) {
+	authURL, err := h.s.BeginLogin(c.Request.Context(), c.Param("tenant"), c.Query("session") == "true")
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// @Summary Assertion Consumer Service
+// @Description Принимает ответ IdP (HTTP-POST binding). Вход, начатый на стороне IdP, разрешается настройкой allow_idp_initiated и завершается cookie-сессией
+// @Tags saml
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param tenant path string true "Тенант"
+// @Param SAMLResponse formData string true "Ответ IdP (base64)"
+// @Param RelayState formData string false "RelayState"
+// @Success 200 {object} jwt.TokenResponse
+// @Failure 400 {string} string "Ответ не прошел проверку"
+// @Failure 403 {string} string "Учетная запись не привязана"
+// @Router /saml/{tenant}/acs [post]
+func (h *SAMLHandler) ACS(c *gin.Context) {
+	response, err := base64.StdEncoding.DecodeString(c.PostForm("SAMLResponse"))
+	if err != nil || len(response) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный SAMLResponse"})
+		return
+	}
+
+	user, state, err := h.s.Finish(c.Request.Context(), c.Param("tenant"), c.PostForm("RelayState"), response)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	if requireSecondFactor(c, h.w, user) {
+		return
+	}
+	issueTokens(c, h.session, user, state.Session, h.session.cfg.PostLoginURL)
+}
+
+func (h *SAMLHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorSAMLTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorSAMLResponse), errors.Is(err, usecase.ErrorSAMLReplay), errors.Is(err, usecase.ErrorExternalState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorIdentityNotLinked):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Ошибка SAML IdP", "details": err.Error()})
+	}
+}
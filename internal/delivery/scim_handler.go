@@ -0,0 +1,517 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/scim"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SCIMHandler — SCIM 2.0 (RFC 7643, 7644) для провижининга из IdP и управление
+// токенами провижининга. Ответы протокола — application/scim+json.
+type SCIMHandler struct {
+	s   usecase.SCIMUseCase
+	cfg config.SCIM
+}
+
+func NewSCIMHandler(s usecase.SCIMUseCase, cfg config.SCIM) *SCIMHandler {
+	return &SCIMHandler{s: s, cfg: cfg}
+}
+
+// SCIMAuth пропускает токен провижининга (scim_...) или access токен сервисного аккаунта
+// со scope scim:provision. Токен организации работает только в ней; запрос без организации
+// с таким токеном выполняется в его организации. Сервисные аккаунты глобальные, поэтому
+// их токены принимаются только вне организаций.
+func SCIMAuth(s usecase.SCIMUseCase, orgs usecase.OrganizationUseCase) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			scimError(c, scim.NewError(http.StatusUnauthorized, "", "Требуется токен провижининга"))
+			return
+		}
+
+		if !strings.HasPrefix(token, "scim_") {
+			claims, err := jwt.ValidateToken(token)
+			if err != nil || claims.TokenUse != jwt.TokenUseClient || !claims.HasScope(jwt.ScopeSCIMProvision) {
+				logger.Logger.Warn("Отклонен токен SCIM",
+					zap.Error(err),
+					zap.String("path", c.Request.URL.Path))
+				scimError(c, scim.NewError(http.StatusUnauthorized, "", "Невалидный токен провижининга"))
+				return
+			}
+			if c.GetString("tenant") != "" {
+				logger.Logger.Warn("Отклонен токен сервисного аккаунта в контексте организации",
+					zap.String("client_id", claims.ClientID),
+					zap.String("tenant", c.GetString("tenant")))
+				scimError(c, scim.NewError(http.StatusForbidden, "", "Токен сервисного аккаунта не действует в организации, используйте токен провижининга организации"))
+				return
+			}
+			c.Set("client_id", claims.ClientID)
+			c.Next()
+			return
+		}
+
+		found, err := s.Authenticate(token)
+		if err != nil {
+			logger.Logger.Warn("Отклонен токен провижининга",
+				zap.String("path", c.Request.URL.Path))
+			scimError(c, scim.NewError(http.StatusUnauthorized, "", err.Error()))
+			return
+		}
+		if found.TenantID != 0 {
+			tenant := c.GetString("tenant")
+			if tenant != "" && tenant != found.Tenant {
+				scimError(c, scim.NewError(http.StatusUnauthorized, "", "Токен выдан для другой организации"))
+				return
+			}
+			if tenant == "" {
+				org, err := orgs.Resolve(found.Tenant)
+				if err != nil {
+					scimError(c, scim.NewError(http.StatusUnauthorized, "", err.Error()))
+					return
+				}
+				c.Set("tenant", org.Slug)
+				c.Set("tenant_org", org)
+			}
+		}
+		c.Set("scim_token_id", found.ID)
+		c.Next()
+	}
+}
+
+func scimError(c *gin.Context, err *scim.Error) {
+	c.Abort()
+	scimJSON(c, err.Status, err)
+}
+
+func scimJSON(c *gin.Context, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(status, scim.ContentType, data)
+}
+
+// fail переводит ошибку use case в ответ SCIM.
+func (h *SCIMHandler) fail(c *gin.Context, err error) {
+	var protocol *scim.Error
+	switch {
+	case errors.As(err, &protocol):
+		scimError(c, protocol)
+	case errors.Is(err, usecase.ErrorSCIMNotFound):
+		scimError(c, scim.NewError(http.StatusNotFound, "", err.Error()))
+	case errors.Is(err, usecase.ErrorSCIMUserExists), errors.Is(err, usecase.ErrorGroupExists):
+		scimError(c, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, err.Error()))
+	case errors.Is(err, usecase.ErrorSCIMUserName), errors.Is(err, usecase.ErrorSCIMGroupName),
+		errors.Is(err, usecase.ErrorGroupMemberNotFound), errors.Is(err, usecase.ErrorGroupRole), errors.Is(err, usecase.ErrorSCIMRole):
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	case errors.Is(err, usecase.ErrorSCIMEmailImmutable), errors.Is(err, usecase.ErrorSCIMPasswordImmutable):
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorMutability, err.Error()))
+	default:
+		logger.Logger.Error("Ошибка обработки запроса SCIM",
+			zap.Error(err),
+			zap.String("path", c.Request.URL.Path))
+		scimError(c, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+	}
+}
+
+// baseURL — адрес /scim/v2 для meta.location с учетом организации из пути.
+func (h *SCIMHandler) baseURL(c *gin.Context) string {
+	base := strings.TrimSuffix(h.cfg.BaseURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	if slug, ok := c.Request.Context().Value(tenantPathKey{}).(string); ok {
+		base += "/t/" + slug
+	}
+	return base + "/scim/v2"
+}
+
+// listParams читает filter, startIndex и count; count ограничен max_results.
+func (h *SCIMHandler) listParams(c *gin.Context) (scim.Filter, int, int, error) {
+	var filter scim.Filter
+	if expression := c.Query("filter"); expression != "" {
+		parsed, err := scim.ParseFilter(expression)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		filter = parsed
+	}
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		return nil, 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Некорректный startIndex")
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(h.cfg.MaxResults)))
+	if err != nil || count < 0 {
+		return nil, 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Некорректный count")
+	}
+	return filter, startIndex, min(count, h.cfg.MaxResults), nil
+}
+
+func bindSCIM(c *gin.Context, body any) error {
+	if err := json.NewDecoder(c.Request.Body).Decode(body); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, fmt.Sprintf("Некорректное тело запроса: %v", err))
+	}
+	return nil
+}
+
+func resourceID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, usecase.ErrorSCIMNotFound
+	}
+	return id, nil
+}
+
+func (h *SCIMHandler) userLocation(c *gin.Context, user *scim.User) {
+	user.Meta.Location = h.baseURL(c) + "/Users/" + user.ID
+}
+
+func (h *SCIMHandler) groupLocation(c *gin.Context, group *scim.Group) {
+	base := h.baseURL(c)
+	group.Meta.Location = base + "/Groups/" + group.ID
+	for i := range group.Members {
+		group.Members[i].Ref = base + "/Users/" + group.Members[i].Value
+	}
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, scim.ServiceProviderConfig(h.baseURL(c), h.cfg.MaxResults))
+}
+
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	h.discovery(c, scim.ResourceTypes(h.baseURL(c)))
+}
+
+func (h *SCIMHandler) Schemas(c *gin.Context) {
+	h.discovery(c, scim.Schemas(h.baseURL(c)))
+}
+
+// discovery отдает весь список или один документ по :id.
+func (h *SCIMHandler) discovery(c *gin.Context, documents []any) {
+	id := c.Param("id")
+	if id == "" {
+		scimJSON(c, http.StatusOK, scim.Page(documents, 1, len(documents)))
+		return
+	}
+	for _, document := range documents {
+		if document.(map[string]any)["id"] == id {
+			scimJSON(c, http.StatusOK, document)
+			return
+		}
+	}
+	scimError(c, scim.NewError(http.StatusNotFound, "", fmt.Sprintf("Ресурс %s не найден", id)))
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	filter, startIndex, count, err := h.listParams(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	users, err := tenantSCIM(c, h.s).ListUsers(filter)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	resources := make([]any, len(users))
+	for i := range users {
+		resources[i] = &users[i]
+	}
+	page := scim.Page(resources, startIndex, count)
+	for _, resource := range page.Resources {
+		h.userLocation(c, resource.(*scim.User))
+	}
+	scimJSON(c, http.StatusOK, page)
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	user, err := tenantSCIM(c, h.s).GetUser(id)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.userLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var req scim.User
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	user, err := tenantSCIM(c, h.s).CreateUser(req)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.userLocation(c, &user)
+	c.Header("Location", user.Meta.Location)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	var req scim.User
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	user, err := tenantSCIM(c, h.s).ReplaceUser(id, req)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.userLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	var req scim.PatchRequest
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	user, err := tenantSCIM(c, h.s).PatchUser(id, req.Operations)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.userLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	if err := tenantSCIM(c, h.s).DeleteUser(id); err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	filter, startIndex, count, err := h.listParams(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	// IdP запрашивают группы без участников, чтобы не тянуть большие списки
+	withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+	groups, err := tenantSCIM(c, h.s).ListGroups(filter, withMembers)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	resources := make([]any, len(groups))
+	for i := range groups {
+		resources[i] = &groups[i]
+	}
+	page := scim.Page(resources, startIndex, count)
+	for _, resource := range page.Resources {
+		h.groupLocation(c, resource.(*scim.Group))
+	}
+	scimJSON(c, http.StatusOK, page)
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	group, err := tenantSCIM(c, h.s).GetGroup(id)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.groupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var req scim.Group
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	group, err := tenantSCIM(c, h.s).CreateGroup(req)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.groupLocation(c, &group)
+	c.Header("Location", group.Meta.Location)
+	scimJSON(c, http.StatusCreated, group)
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	var req scim.Group
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	group, err := tenantSCIM(c, h.s).ReplaceGroup(id, req)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.groupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	var req scim.PatchRequest
+	if err := bindSCIM(c, &req); err != nil {
+		h.fail(c, err)
+		return
+	}
+	group, err := tenantSCIM(c, h.s).PatchGroup(id, req.Operations)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.groupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	id, err := resourceID(c)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+	if err := tenantSCIM(c, h.s).DeleteGroup(id); err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type createSCIMTokenRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type createSCIMTokenResponse struct {
+	entity.SCIMToken
+	Token string `json:"token"`
+}
+
+// @Summary Токены провижининга
+// @Description Токены SCIM организации запроса (или глобальные) без секретов
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.SCIMToken
+// @Failure 403 {string} string "Недостаточно прав"
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /scim/tokens [get]
+func (h *SCIMHandler) GetTokens(c *gin.Context) {
+	tokens, err := tenantSCIM(c, h.s).GetTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary Создать токен провижининга
+// @Description Создает токен SCIM для IdP, токен возвращается только один раз. Токен организации работает только с ее пользователями и группами
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body createSCIMTokenRequest true "Название токена"
+// @Success 200 {object} createSCIMTokenResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 403 {string} string "Недостаточно прав"
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /scim/tokens [post]
+func (h *SCIMHandler) CreateToken(c *gin.Context) {
+	var req createSCIMTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	token, secret, err := tenantSCIM(c, h.s).CreateToken(req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, createSCIMTokenResponse{SCIMToken: token, Token: secret})
+}
+
+// @Summary Отозвать токен провижининга
+// @Description Отзыв токена SCIM организации запроса по ID
+// @Tags scim
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID токена"
+// @Success 200 {string} string "Токен отозван"
+// @Failure 400 {string} string "Неправильный параметр"
+// @Failure 404 {string} string "Токен не найден"
+// @Router /scim/tokens/{id} [delete]
+func (h *SCIMHandler) RevokeToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+
+	if err := tenantSCIM(c, h.s).RevokeToken(id); err != nil {
+		if errors.Is(err, usecase.ErrorSCIMTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Токен провижининга с ID = %d отозван", id))
+}
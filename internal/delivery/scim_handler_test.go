@@ -0,0 +1,52 @@
+package delivery
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSCIMAuthClientToken(t *testing.T) {
+	provision, _, err := jwt.GenerateClientToken("idp", []string{jwt.ScopeSCIMProvision})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := jwt.GenerateClientToken("idp", []string{"users:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		tenant string
+		want   int
+	}{
+		{"глобальный запрос", provision, "", http.StatusOK},
+		{"запрос организации", provision, "acme", http.StatusForbidden},
+		{"без scope", other, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tt.tenant != "" {
+					c.Set("tenant", tt.tenant)
+					c.Set("tenant_org", entity.Organization{ID: 1, Slug: tt.tenant})
+				}
+			})
+			r.GET("/scim/v2/Users", SCIMAuth(nil, nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package delivery
+
+import (
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type ServiceAccountHandler struct {
+	c usecase.ClientUseCase
+}
+
+func NewServiceAccountHandler(clientUseCase usecase.ClientUseCase) *ServiceAccountHandler {
+	return &ServiceAccountHandler{c: clientUseCase}
+}
+
+type createServiceAccountRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes"`
+}
+
+type createServiceAccountResponse struct {
+	entity.ServiceAccount
+	ClientSecret string `json:"client_secret"`
+}
+
+// @Summary Получить сервисные аккаунты
+// @Description Список сервисных аккаунтов (без секретов). Только для admin
+// @Tags service-accounts
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.ServiceAccount
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /service-accounts [get]
+func (h *ServiceAccountHandler) GetAll(c *gin.Context) {
+	accounts, err := h.c.GetAllServiceAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// @Summary Создать сервисный аккаунт
+// @Description Создает сервисный аккаунт, client_secret возвращается только один раз. Только для admin
+// @Tags service-accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} createServiceAccountResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /service-accounts [post]
+func (h *ServiceAccountHandler) Create(c *gin.Context) {
+	var req createServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	account, secret, err := h.c.CreateServiceAccount(req.Name, req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Logger.Info("Создан сервисный аккаунт",
+		zap.String("client_id", account.ClientID),
+		zap.String("by", c.GetString("email")))
+	c.JSON(http.StatusOK, createServiceAccountResponse{
+		ServiceAccount: account,
+		ClientSecret:   secret,
+	})
+}
+
+// @Summary Удалить сервисный аккаунт
+// @Description Удаление сервисного аккаунта по ID. Только для admin
+// @Tags service-accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID сервисного аккаунта"
+// @Success 200 {string} string "Сервисный аккаунт удален"
+// @Failure 400 {string} string "Неправильный параметр"
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /service-accounts/{id} [delete]
+func (h *ServiceAccountHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+
+	if err := h.c.DeleteServiceAccount(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Сервисный аккаунт с ID = %d удален", id))
+}
@@ -0,0 +1,208 @@
+package delivery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessCookieTTL  = 15 * time.Minute
+	refreshCookieTTL = 7 * 24 * time.Hour
+)
+
+type SessionHandler struct {
+	u   usecase.UseCase
+	t   usecase.TokenUseCase
+	w   usecase.WebAuthnUseCase
+	cfg config.Session
+}
+
+func NewSessionHandler(u usecase.UseCase, t usecase.TokenUseCase, w usecase.WebAuthnUseCase, cfg config.Session) *SessionHandler {
+	return &SessionHandler{u: u, t: t, w: w, cfg: cfg}
+}
+
+type sessionResponse struct {
+	UserID    int    `json:"user_id"`
+	Role      string `json:"role"`
+	ExpiresIn int64  `json:"expires_in"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// @Summary Вход с cookie-сессией
+// @Description Вход email + пароль. Токены ставятся в HttpOnly cookie, CSRF токен — в читаемую cookie и в ответ; его нужно передавать в заголовке X-CSRF-Token на изменяющих запросах
+// @Tags session
+// @Accept json
+// @Produce json
+// @Success 200 {object} sessionResponse
+// @Failure 400 {string} string "Неправильные данные"
+// @Failure 401 {string} string "Неверный email или пароль"
+// @Failure 500 {string} string "Ошибка создания токенов"
+// @Router /session/login [post]
+func (h *SessionHandler) Login(c *gin.Context) {
+	var req jwt.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessToken, refreshToken, expiresIn, err := tenantUsers(c, h.u).Authenticate(req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, usecase.ErrorWrongPassword) || errors.Is(err, usecase.ErrorUnknownUser) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "Ошибка аутентификации",
+				"details": "Неверный email или пароль",
+			})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Ошибка аутентификации"})
+		return
+	}
+
+	user, err := tenantUsers(c, h.u).GetUserByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// второй фактор завершается через /v1/webauthn/login/finish?session=true
+	if requireSecondFactor(c, h.w, user) {
+		return
+	}
+
+	h.startSession(c, user, accessToken, refreshToken, expiresIn)
+	logger.Logger.Info("Начата cookie-сессия", zap.Int("user_id", user.ID))
+}
+
+// @Summary Обновление cookie-сессии
+// @Description Ротирует access и refresh cookie по refresh cookie; старый refresh токен отзывается. Требует X-CSRF-Token
+// @Tags session
+// @Produce json
+// @Success 200 {object} sessionResponse
+// @Failure 401 {string} string "Невалидный refresh токен"
+// @Failure 403 {string} string "Неверный CSRF токен"
+// @Router /session/refresh [post]
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	defer countOutcome(c, metrics.Refreshes)
+	if !validCSRF(c, h.cfg) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Неверный CSRF токен"})
+		return
+	}
+
+	refresh, err := c.Cookie(h.cfg.RefreshCookie)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Нет refresh cookie"})
+		return
+	}
+
+	user, accessToken, refreshToken, expiresIn, err := tenantTokens(c, h.t).Refresh(refresh)
+	switch {
+	case errors.Is(err, usecase.ErrorInvalidRefresh):
+		h.clearCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecase.ErrorUnknownUser):
+		h.clearCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не найден"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		return
+	}
+	h.startSession(c, user, accessToken, refreshToken, expiresIn)
+}
+
+// @Summary Выход из cookie-сессии
+// @Description Отзывает токены сессии и удаляет cookie. Требует X-CSRF-Token
+// @Tags session
+// @Produce json
+// @Success 200 {string} string "Сессия завершена"
+// @Failure 403 {string} string "Неверный CSRF токен"
+// @Router /session/logout [post]
+func (h *SessionHandler) Logout(c *gin.Context) {
+	if !validCSRF(c, h.cfg) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Неверный CSRF токен"})
+		return
+	}
+
+	var tokens []string
+	for _, name := range []string{h.cfg.AccessCookie, h.cfg.RefreshCookie} {
+		if token, err := c.Cookie(name); err == nil && token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if err := h.t.Logout(tokens...); err != nil {
+		logger.Logger.Warn("Не удалось отозвать токен сессии", zap.Error(err))
+	}
+	h.clearCookies(c)
+	c.JSON(http.StatusOK, "Сессия завершена")
+}
+
+func (h *SessionHandler) startSession(c *gin.Context, user entity.User, accessToken string, refreshToken string, expiresIn int64) {
+	csrfToken, err := h.setSessionCookies(c, accessToken, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации CSRF токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionResponse{
+		UserID:    user.ID,
+		Role:      user.Role,
+		ExpiresIn: expiresIn,
+		CSRFToken: csrfToken,
+	})
+}
+
+func (h *SessionHandler) setSessionCookies(c *gin.Context, accessToken string, refreshToken string) (string, error) {
+	csrf := make([]byte, 32)
+	if _, err := rand.Read(csrf); err != nil {
+		return "", err
+	}
+	csrfToken := hex.EncodeToString(csrf)
+
+	h.setCookie(c, h.cfg.AccessCookie, accessToken, "/", accessCookieTTL, true)
+	h.setCookie(c, h.cfg.RefreshCookie, refreshToken, tenantPathPrefix(c)+"/v1/session", refreshCookieTTL, true)
+	h.setCookie(c, h.cfg.CSRFCookie, csrfToken, "/", refreshCookieTTL, false)
+	return csrfToken, nil
+}
+
+func (h *SessionHandler) clearCookies(c *gin.Context) {
+	h.setCookie(c, h.cfg.AccessCookie, "", "/", -time.Second, true)
+	h.setCookie(c, h.cfg.RefreshCookie, "", tenantPathPrefix(c)+"/v1/session", -time.Second, true)
+	h.setCookie(c, h.cfg.CSRFCookie, "", "/", -time.Second, false)
+}
+
+func (h *SessionHandler) setCookie(c *gin.Context, name string, value string, path string, ttl time.Duration, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   h.cfg.Domain,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   h.cfg.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSiteMode(h.cfg.SameSite),
+	})
+}
+
+func sameSiteMode(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,178 @@
+package delivery
+
+import (
+	"context"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	TenantResolverPath   = "path"
+	TenantResolverHeader = "header"
+	TenantResolverHost   = "host"
+)
+
+type tenantPathKey struct{}
+
+// TenantMiddleware определяет организацию запроса резолверами из настроек и кладет
+// в контекст ее slug ("tenant") и саму организацию ("tenant_org"). Запрос без
+// организации обрабатывается в глобальном контексте, если тенант не обязателен.
+func TenantMiddleware(orgs usecase.OrganizationUseCase, cfg config.Tenancy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.Enabled {
+			c.Next()
+			return
+		}
+
+		org, found, err := resolveTenant(c, orgs, cfg)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if !found {
+			if cfg.Required {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Не указана организация"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Set("tenant", org.Slug)
+		c.Set("tenant_org", org)
+		c.Next()
+	}
+}
+
+func resolveTenant(c *gin.Context, orgs usecase.OrganizationUseCase, cfg config.Tenancy) (entity.Organization, bool, error) {
+	for _, resolver := range cfg.Resolvers {
+		switch resolver {
+		case TenantResolverPath:
+			if slug, ok := c.Request.Context().Value(tenantPathKey{}).(string); ok {
+				org, err := orgs.Resolve(slug)
+				return org, true, err
+			}
+		case TenantResolverHeader:
+			if slug := c.GetHeader(cfg.Header); slug != "" {
+				org, err := orgs.Resolve(slug)
+				return org, true, err
+			}
+		case TenantResolverHost:
+			// хост без организации — основной домен сервиса, пробуем следующий резолвер
+			if org, err := orgs.ResolveHost(c.Request.Host); err == nil {
+				return org, true, nil
+			}
+		}
+	}
+	return entity.Organization{}, false, nil
+}
+
+// tenantPathHandler обслуживает /t/{slug}/...: запоминает slug и передает запрос
+// обычным маршрутам без префикса.
+func tenantPathHandler(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), tenantPathKey{}, c.Param("tenant"))
+		c.Request = c.Request.WithContext(ctx)
+		c.Request.URL.Path = c.Param("path")
+		r.HandleContext(c)
+		// HandleContext восстанавливает индекс внешнего обработчика: без Abort
+		// цепочка вложенного маршрута выполнилась бы повторно
+		c.Abort()
+	}
+}
+
+// RequireGlobal пропускает только запросы вне организации: управлять организациями
+// могут администраторы всего развертывания, а не администраторы тенанта.
+func RequireGlobal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("tenant") != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недоступно в контексте организации"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// tenantUsers возвращает use case пользователей, ограниченный организацией запроса
+// и привязанный к его трассировке.
+func tenantUsers(c *gin.Context, u usecase.UseCase) usecase.UseCase {
+	u = u.WithContext(c.Request.Context())
+	if org, ok := c.Get("tenant_org"); ok {
+		return u.WithTenant(org.(entity.Organization))
+	}
+	return u
+}
+
+// tenantGroups возвращает use case групп организации запроса (или глобальных групп).
+func tenantGroups(c *gin.Context, g usecase.GroupUseCase) usecase.GroupUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return g.WithTenant(org.(entity.Organization))
+	}
+	return g
+}
+
+// tenantInvites возвращает use case приглашений организации запроса (или глобальных).
+func tenantInvites(c *gin.Context, i usecase.InviteUseCase) usecase.InviteUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return i.WithTenant(org.(entity.Organization))
+	}
+	return i
+}
+
+// tenantSCIM возвращает use case SCIM организации запроса (или глобальный).
+func tenantSCIM(c *gin.Context, s usecase.SCIMUseCase) usecase.SCIMUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return s.WithTenant(org.(entity.Organization))
+	}
+	return s
+}
+
+// tenantIdentities возвращает use case внешних входов организации запроса (или глобальный).
+func tenantIdentities(c *gin.Context, i usecase.IdentityUseCase) usecase.IdentityUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return i.WithTenant(org.(entity.Organization))
+	}
+	return i
+}
+
+// tenantTokens возвращает use case токенов организации запроса (или глобальный).
+func tenantTokens(c *gin.Context, t usecase.TokenUseCase) usecase.TokenUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return t.WithTenant(org.(entity.Organization))
+	}
+	return t
+}
+
+// tenantImpersonation возвращает use case подмены, проверяющий группы организации запроса.
+func tenantImpersonation(c *gin.Context, i usecase.ImpersonationUseCase) usecase.ImpersonationUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return i.WithTenant(org.(entity.Organization))
+	}
+	return i
+}
+
+// tenantPasswordless возвращает use case входа без пароля организации запроса (или глобальный).
+func tenantPasswordless(c *gin.Context, p usecase.PasswordlessUseCase) usecase.PasswordlessUseCase {
+	if org, ok := c.Get("tenant_org"); ok {
+		return p.WithTenant(org.(entity.Organization))
+	}
+	return p
+}
+
+// tenantPathPrefix — префикс /t/{slug}, под которым пришел запрос, или пустая строка.
+// Маршруты видят путь без префикса, а браузер — с ним: от него строятся пути cookie.
+func tenantPathPrefix(c *gin.Context) string {
+	if slug, ok := c.Request.Context().Value(tenantPathKey{}).(string); ok {
+		return "/t/" + slug
+	}
+	return ""
+}
+
+// sameTenant сообщает, что токен выдан для организации текущего запроса.
+func sameTenant(c *gin.Context, claims *jwt.Claims) bool {
+	return claims.Tenant == c.GetString("tenant")
+}
@@ -0,0 +1,19 @@
+package delivery
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// Tracing начинает спан запроса с именем по шаблону маршрута gin и продолжает трассировку
+// из заголовка traceparent. Внешний маршрут /t/:tenant/*path не трассируется — спан
+// получит вложенный маршрут; запросы к skipPaths (метрики, проверки здоровья) тоже.
+func Tracing(service string, skipPaths ...string) gin.HandlerFunc {
+	skip := map[string]bool{tenantPathRoute: true}
+	for _, path := range skipPaths {
+		skip[path] = true
+	}
+	return otelgin.Middleware(service, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return !skip[c.FullPath()]
+	}))
+}
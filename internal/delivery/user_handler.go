@@ -1,22 +1,29 @@
 package delivery
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
 	"github.com/LandGAA/authh2/internal/usecase"
 	"github.com/LandGAA/authh2/pkg/jwt"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 	"net/http"
 	"strconv"
 )
 
 type UserHandler struct {
 	u usecase.UseCase
+	t usecase.TokenUseCase
+	w usecase.WebAuthnUseCase
+	i usecase.InviteUseCase
 }
 
-func NewUserHandler(usecase usecase.UseCase) *UserHandler {
-	return &UserHandler{u: usecase}
+func NewUserHandler(usecase usecase.UseCase, tokens usecase.TokenUseCase, webAuthn usecase.WebAuthnUseCase, invites usecase.InviteUseCase) *UserHandler {
+	return &UserHandler{u: usecase, t: tokens, w: webAuthn, i: invites}
 }
 
 // @Summary Получить всех пользователей
@@ -28,7 +35,7 @@ func NewUserHandler(usecase usecase.UseCase) *UserHandler {
 // @Failure 404 {string} string "Пустая база данных"
 // @Router /users [get]
 func (h *UserHandler) GetAll(c *gin.Context) {
-	users, err := h.u.GetAllUsers()
+	users, err := tenantUsers(c, h.u).GetAllUsers()
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -58,9 +65,9 @@ func (h *UserHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	user, err := h.u.GetUserByID(id)
+	user, err := tenantUsers(c, h.u).GetUserByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, fmt.Sprintf("Пользователь не найден: %w", err))
+		c.JSON(http.StatusNotFound, fmt.Sprintf("Пользователь не найден: %v", err))
 		return
 	}
 
@@ -77,7 +84,7 @@ func (h *UserHandler) GetByID(c *gin.Context) {
 // @Router /users/email/{email} [get]
 func (h *UserHandler) GetByEmail(c *gin.Context) {
 	email := c.Param("email")
-	user, err := h.u.GetUserByEmail(email)
+	user, err := tenantUsers(c, h.u).GetUserByEmail(email)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -86,12 +93,13 @@ func (h *UserHandler) GetByEmail(c *gin.Context) {
 }
 
 // @Summary Удалить пользователя по ID
-// @Description Удаление пользователя по его ID
+// @Description Удаление пользователя по его ID. Свою учетную запись удаляет сам пользователь, чужую — администратор
 // @Accept json
 // @Produce json
 // @Param id path int true "ID пользователя"
 // @Success 200 {string} string "Пользователь удален"
 // @Failure 400 {string} string "Неправильные параметры"
+// @Failure 403 {string} string "Недостаточно прав"
 // @Failure 500 {string} string "Ошибка сервера"
 // @Router /users/{id} [delete]
 func (h *UserHandler) Delete(c *gin.Context) {
@@ -101,7 +109,7 @@ func (h *UserHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	err = h.u.DeleteUser(id)
+	err = tenantUsers(c, h.u).DeleteUser(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -110,38 +118,58 @@ func (h *UserHandler) Delete(c *gin.Context) {
 	c.JSON(http.StatusOK, fmt.Sprintf("Пользователь с ID = %d удален", id))
 }
 
+// registerRequest — данные самостоятельной регистрации. Роли в запросе нет: новый
+// пользователь всегда получает роль user.
+type registerRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
 // @Summary Регистрация пользователя
-// @Description Введите данные пользователя
+// @Description Введите данные пользователя. В зависимости от registration.mode регистрация может быть закрыта или ограничена доменами email. Роль всегда user
 // @Accept json
 // @Produce json
-// @Success 200 {object} entity.User
+// @Param request body registerRequest true "Имя, email и пароль"
+// @Success 200 {object} entity.DTOUser
 // @Failure 400 {string} string "Некоректные данные"
+// @Failure 403 {string} string "Регистрация только по приглашению"
 // @Failure 500 {string} string "Пользователь уже создан или ошибка сервера"
 // @Router /register [post]
 func (h *UserHandler) Register(c *gin.Context) {
-	var user entity.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   fmt.Sprintf("Введены некоректные данные: %w", err),
-			"details": user,
-		})
+	defer countOutcome(c, metrics.Registrations)
+	var req registerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
 		return
 	}
 
-	if err := h.u.CreateUser(user); err != nil {
+	if err := tenantInvites(c, h.i).CheckRegistration(req.Email); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	users := tenantUsers(c, h.u)
+	if err := users.CreateUser(entity.User{Name: req.Name, Email: req.Email, Password: req.Password, Role: "user"}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	logger.Logger.Info("Пользователь зарегистрировался")
-	c.JSON(http.StatusOK, user)
+	user, err := users.GetUserByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Logger.Info("Пользователь зарегистрировался", zap.Int("user_id", user.ID))
+	c.JSON(http.StatusOK, users.ToDTO([]entity.User{user})[0])
 }
 
 // @Summary Обновление пароля
-// @Description Введите новый пароль
+// @Description Введите новый пароль. Свой пароль меняет сам пользователь, чужой — администратор. Выданные ранее токены пользователя отзываются
 // @Accept json
 // @Produce json
 // @Success 200 {object} entity.User
 // @Failure 400 {string} string "Некоректные данные"
+// @Failure 403 {string} string "Глобальную учетную запись нельзя изменить в организации"
 // @Failure 404 {string} string "Пользователь не найден"
 // @Failure 500 {string} string "Пользователь уже создан или ошибка сервера"
 // @Router /register [post]
@@ -161,14 +189,18 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 		return
 	}
 
-	user, err := h.u.GetUserByID(id)
+	user, err := tenantUsers(c, h.u).GetUserByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
 	user.Password = ResponsePassword.Password
-	err = h.u.UpdatePassword(user)
+	err = tenantUsers(c, h.u).UpdatePassword(user)
+	if errors.Is(err, repository.ErrGlobalAccount) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -193,32 +225,34 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.u.GetUserByEmail(req.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	fmt.Println(h.u.CheckHashPassword(req.Password, user))
-
-	if !h.u.CheckHashPassword(req.Password, user) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный пароль"})
-		return
-	}
-
-	accessToken, refreshToken, expiresIn, err := h.u.Authenticate(req.Email, req.Password)
+	accessToken, refreshToken, expiresIn, err := tenantUsers(c, h.u).Authenticate(req.Email, req.Password)
 	if err != nil {
-		if err == usecase.ErrorWrongPassword {
+		if errors.Is(err, usecase.ErrorWrongPassword) || errors.Is(err, usecase.ErrorUnknownUser) {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":   "Ошибка аутентификации",
 				"details": "Неверный email или пароль",
 			})
 			return
 		}
+		if errors.Is(err, jwt.ErrorUserDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": jwt.ErrorUserDisabled.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// пользователь из LDAP к этому моменту уже создан локально
+	user, err := tenantUsers(c, h.u).GetUserByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if requireSecondFactor(c, h.w, user) {
+		return
+	}
+
 	c.JSON(http.StatusOK, jwt.TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -229,36 +263,26 @@ func (h *UserHandler) Login(c *gin.Context) {
 }
 
 func (h *UserHandler) Refresh(c *gin.Context) {
+	defer countOutcome(c, metrics.Refreshes)
 	var req jwt.RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	claims, err := jwt.ValidateToken(req.RefreshToken)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Невалидный refresh токен"})
+	_, accessToken, refreshToken, expiresIn, err := tenantTokens(c, h.t).Refresh(req.RefreshToken)
+	switch {
+	case errors.Is(err, usecase.ErrorInvalidRefresh):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
-	}
-
-	user, err := h.u.GetUserByEmail(claims.Email)
-	if err != nil {
+	case errors.Is(err, usecase.ErrorUnknownUser):
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не найден"})
 		return
-	}
-
-	accessToken, expiresIn, err := jwt.GenerateAccessToken(user)
-	if err != nil {
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
 		return
 	}
 
-	refreshToken, err := jwt.GenerateRefreshToken(user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации refresh токена"})
-		return
-	}
-
 	c.JSON(http.StatusOK, jwt.TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
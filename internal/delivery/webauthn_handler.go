@@ -0,0 +1,306 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type WebAuthnHandler struct {
+	u       usecase.UseCase
+	w       usecase.WebAuthnUseCase
+	session *SessionHandler
+}
+
+func NewWebAuthnHandler(u usecase.UseCase, w usecase.WebAuthnUseCase, session *SessionHandler) *WebAuthnHandler {
+	return &WebAuthnHandler{u: u, w: w, session: session}
+}
+
+type webAuthnBeginResponse struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+type webAuthnLoginRequest struct {
+	Email    string `json:"email"`
+	MFAToken string `json:"mfa_token"`
+}
+
+type mfaRequiredResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+// @Summary Начать регистрацию ключа WebAuthn
+// @Description Возвращает PublicKeyCredentialCreationOptions для navigator.credentials.create и session_id
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} webAuthnBeginResponse
+// @Failure 500 {string} string "Ошибка сервера"
+// @Router /webauthn/register/begin [post]
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	user, err := tenantUsers(c, h.u).GetUserByID(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, sessionID, err := h.w.BeginRegistration(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webAuthnBeginResponse{SessionID: sessionID, Options: options})
+}
+
+// @Summary Завершить регистрацию ключа WebAuthn
+// @Description Тело — ответ navigator.credentials.create. Поддерживаются аттестации none и packed
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param session_id query string true "session_id из begin"
+// @Param name query string false "Название ключа"
+// @Success 200 {object} entity.WebAuthnCredential
+// @Failure 400 {string} string "Ошибка проверки ключа"
+// @Router /webauthn/register/finish [post]
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	user, err := tenantUsers(c, h.u).GetUserByID(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Некоректный ответ аутентификатора: %v", err)})
+		return
+	}
+
+	cred, err := h.w.FinishRegistration(user, c.Query("session_id"), c.Query("name"), response)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cred)
+}
+
+// @Summary Начать вход по ключу WebAuthn
+// @Description Пустое тело — вход по passkey без пароля (discoverable). email — вход по ключам пользователя. mfa_token — второй фактор после пароля
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Success 200 {object} webAuthnBeginResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 401 {string} string "Невалидный mfa_token"
+// @Router /webauthn/login/begin [post]
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	var req webAuthnLoginRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var user *entity.User
+	switch {
+	case req.MFAToken != "":
+		claims, err := jwt.ValidateToken(req.MFAToken)
+		if err != nil || claims.TokenUse != jwt.TokenUseMFA || !sameTenant(c, claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Невалидный mfa_token"})
+			return
+		}
+		u, err := tenantUsers(c, h.u).GetUserByID(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не найден"})
+			return
+		}
+		user = &u
+	case req.Email != "":
+		u, err := tenantUsers(c, h.u).GetUserByEmail(req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Вход по ключу недоступен"})
+			return
+		}
+		user = &u
+	}
+
+	options, sessionID, err := h.w.BeginLogin(user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Вход по ключу недоступен"})
+		return
+	}
+	c.JSON(http.StatusOK, webAuthnBeginResponse{SessionID: sessionID, Options: options})
+}
+
+// @Summary Завершить вход по ключу WebAuthn
+// @Description Тело — ответ navigator.credentials.get. Возвращает токены, с ?session=true — ставит cookie-сессию
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Param session_id query string true "session_id из begin"
+// @Param session query bool false "Вход в режиме cookie-сессии"
+// @Success 200 {object} jwt.TokenResponse
+// @Failure 400 {string} string "Некоректный ответ аутентификатора"
+// @Failure 401 {string} string "Ошибка проверки ключа"
+// @Router /webauthn/login/finish [post]
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	response, err := protocol.ParseCredentialRequestResponseBody(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Некоректный ответ аутентификатора: %v", err)})
+		return
+	}
+
+	user, err := h.w.FinishLogin(c.Query("session_id"), response)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Logger.Info("Вход по ключу WebAuthn", zap.Int("user_id", user.ID))
+	issueTokens(c, h.session, user, c.Query("session") == "true", "")
+}
+
+// @Summary Мои ключи WebAuthn
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.WebAuthnCredential
+// @Router /webauthn/credentials [get]
+func (h *WebAuthnHandler) GetCredentials(c *gin.Context) {
+	creds, err := h.w.GetCredentials(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, creds)
+}
+
+// @Summary Удалить ключ WebAuthn
+// @Tags webauthn
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID ключа"
+// @Success 200 {string} string "Ключ удален"
+// @Failure 404 {string} string "Ключ не найден"
+// @Router /webauthn/credentials/{id} [delete]
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+
+	if err := h.w.DeleteCredential(c.GetInt("id"), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Ключ с ID = %d удален", id))
+}
+
+// @Summary Включить/выключить ключ как второй фактор
+// @Description При passkey_required = true вход по паролю требует подтверждения ключом WebAuthn
+// @Tags webauthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {string} string "Настройки MFA сохранены"
+// @Failure 400 {string} string "Нет зарегистрированных ключей"
+// @Router /webauthn/mfa [put]
+func (h *WebAuthnHandler) SetMFA(c *gin.Context) {
+	var req struct {
+		PasskeyRequired bool `json:"passkey_required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.w.SetPasskeyRequired(c.GetInt("id"), req.PasskeyRequired); err != nil {
+		if errors.Is(err, usecase.ErrorWebAuthnNoPasskeys) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, "Настройки MFA сохранены")
+}
+
+// requireSecondFactor отвечает mfa_required, если пользователь включил ключ как второй фактор.
+func requireSecondFactor(c *gin.Context, w usecase.WebAuthnUseCase, user entity.User) bool {
+	if !w.PasskeyRequired(user.ID) {
+		return false
+	}
+
+	token, err := jwt.GenerateMFAToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		return true
+	}
+	c.JSON(http.StatusOK, mfaRequiredResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     []string{"webauthn"},
+	})
+	return true
+}
+
+// issueTokens выдает токены пользователю, прошедшему вход без пароля: в теле ответа
+// или, при cookie = true, в cookie-сессии. Непустой redirect в режиме cookie
+// перенаправляет браузер вместо ответа JSON.
+//
+// В контексте организации пользователь перечитывается в ней: токены получает только
+// ее участник, с ролью из членства.
+func issueTokens(c *gin.Context, session *SessionHandler, user entity.User, cookie bool, redirect string) {
+	if org, ok := c.Get("tenant_org"); ok {
+		member, err := session.u.WithTenant(org.(entity.Organization)).GetUserByID(user.ID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Пользователь не состоит в организации"})
+			return
+		}
+		user = member
+	}
+
+	accessToken, expiresIn, err := jwt.GenerateAccessToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		return
+	}
+	refreshToken, err := jwt.GenerateRefreshToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации refresh токена"})
+		return
+	}
+
+	if cookie && redirect != "" {
+		if _, err := session.setSessionCookies(c, accessToken, refreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации CSRF токена"})
+			return
+		}
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	if cookie {
+		session.startSession(c, user, accessToken, refreshToken, expiresIn)
+		return
+	}
+	c.JSON(http.StatusOK, jwt.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresIn,
+		UserID:       user.ID,
+		Role:         user.Role,
+	})
+}
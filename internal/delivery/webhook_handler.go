@@ -0,0 +1,245 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type WebhookHandler struct {
+	w usecase.WebhookUseCase
+}
+
+func NewWebhookHandler(w usecase.WebhookUseCase) *WebhookHandler {
+	return &WebhookHandler{w: w}
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type createWebhookResponse struct {
+	entity.Webhook
+	Secret string `json:"secret"`
+}
+
+type updateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+type webhookDeliveryResponse struct {
+	entity.WebhookDelivery
+	Attempts []entity.WebhookAttempt `json:"log"`
+}
+
+// @Summary Список вебхуков
+// @Description Подписки на события пользователей (без секретов). Только для admin
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} entity.Webhook
+// @Router /webhooks [get]
+func (h *WebhookHandler) GetAll(c *gin.Context) {
+	webhooks, err := h.w.GetWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// @Summary Создать вебхук
+// @Description Подписывает URL на события (пустой events — на все). Тело запроса подписывается: заголовок X-Webhook-Signature: v1=hex(HMAC-SHA256(secret, X-Webhook-Timestamp + "." + тело)). Секрет возвращается только один раз; если он не передан, генерируется. Только для admin
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} createWebhookResponse
+// @Failure 400 {string} string "Некоректные данные"
+// @Router /webhooks [post]
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var req createWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	webhook, secret, err := h.w.CreateWebhook(req.URL, req.Events, req.Secret)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	logger.Logger.Info("Создан вебхук",
+		zap.Int("webhook_id", webhook.ID),
+		zap.String("url", webhook.URL),
+		zap.String("by", c.GetString("email")))
+	c.JSON(http.StatusOK, createWebhookResponse{Webhook: webhook, Secret: secret})
+}
+
+// @Summary Изменить вебхук
+// @Description Меняет URL, типы событий и активность. Отключенной подписке новые события не ставятся в очередь, а уже ожидающие доставки ждут ее включения. Только для admin
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID вебхука"
+// @Success 200 {object} entity.Webhook
+// @Failure 400 {string} string "Некоректные данные"
+// @Failure 404 {string} string "Вебхук не найден"
+// @Router /webhooks/{id} [put]
+func (h *WebhookHandler) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var req updateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Введены некоректные данные: %v", err)})
+		return
+	}
+
+	webhook, err := h.w.UpdateWebhook(id, req.URL, req.Events, req.Active)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// @Summary Удалить вебхук
+// @Description Удаляет подписку вместе с очередью и журналом доставок. Только для admin
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID вебхука"
+// @Success 200 {string} string "Вебхук удален"
+// @Failure 404 {string} string "Вебхук не найден"
+// @Router /webhooks/{id} [delete]
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	if err := h.w.DeleteWebhook(id); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fmt.Sprintf("Вебхук с ID = %d удален", id))
+}
+
+// @Summary Журнал доставок вебхука
+// @Description Последние доставки, новые первыми. Только для admin
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID вебхука"
+// @Param status query string false "pending, delivered или dead"
+// @Param limit query int false "Количество записей (до 1000, по умолчанию 100)"
+// @Success 200 {array} entity.WebhookDelivery
+// @Failure 404 {string} string "Вебхук не найден"
+// @Router /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return
+	}
+	var limit int
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+			return
+		}
+	}
+
+	deliveries, err := h.w.GetDeliveries(id, c.Query("status"), limit)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// @Summary Доставка вебхука
+// @Description Доставка с журналом всех попыток: код ответа, ошибка, длительность. Только для admin
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID вебхука"
+// @Param delivery_id path int true "ID доставки"
+// @Success 200 {object} webhookDeliveryResponse
+// @Failure 404 {string} string "Доставка не найдена"
+// @Router /webhooks/{id}/deliveries/{delivery_id} [get]
+func (h *WebhookHandler) GetDelivery(c *gin.Context) {
+	id, deliveryID, ok := deliveryParams(c)
+	if !ok {
+		return
+	}
+	delivery, attempts, err := h.w.GetDelivery(id, deliveryID)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhookDeliveryResponse{WebhookDelivery: delivery, Attempts: attempts})
+}
+
+// @Summary Повторить доставку
+// @Description Возвращает доставку (в том числе dead) в очередь с обнуленным счетчиком попыток. Только для admin
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID вебхука"
+// @Param delivery_id path int true "ID доставки"
+// @Success 200 {string} string "Доставка поставлена в очередь"
+// @Failure 404 {string} string "Доставка не найдена"
+// @Router /webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	id, deliveryID, ok := deliveryParams(c)
+	if !ok {
+		return
+	}
+	if err := h.w.Redeliver(id, deliveryID); err != nil {
+		h.error(c, err)
+		return
+	}
+	logger.Logger.Info("Доставка вебхука поставлена в очередь повторно",
+		zap.Int("webhook_id", id),
+		zap.Int64("delivery_id", deliveryID),
+		zap.String("by", c.GetString("email")))
+	c.JSON(http.StatusOK, fmt.Sprintf("Доставка с ID = %d поставлена в очередь", deliveryID))
+}
+
+func deliveryParams(c *gin.Context) (int, int64, bool) {
+	id, err1 := strconv.Atoi(c.Param("id"))
+	deliveryID, err2 := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неправильный параметр"})
+		return 0, 0, false
+	}
+	return id, deliveryID, true
+}
+
+func (h *WebhookHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrorWebhookNotFound), errors.Is(err, usecase.ErrorDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrorWebhookURL), errors.Is(err, usecase.ErrorWebhookAddress), errors.Is(err, usecase.ErrorWebhookEvent),
+		errors.Is(err, usecase.ErrorWebhookSecret), errors.Is(err, usecase.ErrorDeliveryStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
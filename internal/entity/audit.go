@@ -0,0 +1,34 @@
+package entity
+
+const (
+	AuditImpersonationStart  = "impersonation.start"
+	AuditImpersonatedRequest = "impersonation.request"
+	AuditImpersonationDenied = "impersonation.denied"
+)
+
+// AuditEvent — запись журнала аудита. Actor — тот, кто фактически действовал,
+// User — от чьего имени; при обычных действиях они совпадают.
+type AuditEvent struct {
+	ID         int    `json:"id"`
+	Action     string `json:"action"`
+	ActorID    int    `json:"actor_id"`
+	ActorEmail string `json:"actor_email"`
+	UserID     int    `json:"user_id"`
+	UserEmail  string `json:"user_email"`
+	Tenant     string `json:"tenant,omitempty"`
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	Details    string `json:"details,omitempty"`
+	CreateAt   string `json:"create_at"`
+}
+
+// AuditFilter — отбор записей журнала; нулевые поля не ограничивают выборку.
+type AuditFilter struct {
+	UserID  int
+	ActorID int
+	Tenant  string
+	// AllTenants — не ограничивать выборку организацией (глобальный администратор)
+	AllTenants bool
+	Limit      int
+}
@@ -0,0 +1,29 @@
+package entity
+
+// Group — группа пользователей. Участники вложенной группы входят и во все ее
+// родительские группы, поэтому роли родителя наследуются подгруппами.
+type Group struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	ParentID    int      `json:"parent_id"`
+	Roles       []string `json:"roles"`
+	CreateAt    string   `json:"create_at"`
+}
+
+type GroupMember struct {
+	GroupID  int    `json:"group_id"`
+	UserID   int    `json:"user_id"`
+	Email    string `json:"email"`
+	CreateAt string `json:"create_at"`
+}
+
+// Access — эффективные права пользователя: собственная роль, роли его групп
+// (с учетом родительских) и разрешения всех этих ролей.
+type Access struct {
+	UserID      int      `json:"user_id"`
+	Role        string   `json:"role"`
+	Roles       []string `json:"roles"`
+	Groups      []string `json:"groups"`
+	Permissions []string `json:"permissions"`
+}
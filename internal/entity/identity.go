@@ -0,0 +1,32 @@
+package entity
+
+// UserIdentity — учетная запись внешнего провайдера, привязанная к пользователю.
+// Provisioned = true у всех записей пользователя, созданного при первом входе через провайдер.
+type UserIdentity struct {
+	ID          int    `json:"id"`
+	UserID      int    `json:"user_id"`
+	Connector   string `json:"connector"`
+	Subject     string `json:"subject"`
+	Email       string `json:"email"`
+	Provisioned bool   `json:"provisioned"`
+	CreateAt    string `json:"create_at"`
+}
+
+// ExternalLoginState — незавершенный вход или привязка через внешний провайдер.
+// UserID != 0 означает привязку к уже вошедшему пользователю.
+type ExternalLoginState struct {
+	State     string
+	Connector string
+	UserID    int
+	Nonce     string
+	Verifier  string
+	Session   bool
+	// Tenant — slug организации, в которой начат вход; пустой в глобальном контексте
+	Tenant    string
+	ExpiresAt int64
+}
+
+type Connector struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
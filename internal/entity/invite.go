@@ -0,0 +1,20 @@
+package entity
+
+// Invite — приглашение зарегистрироваться с заранее назначенной ролью. В контексте
+// организации приглашенный становится ее участником.
+type Invite struct {
+	ID         int    `json:"id"`
+	Email      string `json:"email" binding:"required,email"`
+	Role       string `json:"role"`
+	InvitedBy  int    `json:"invited_by"`
+	ExpiresAt  int64  `json:"expires_at"`
+	AcceptedAt int64  `json:"accepted_at"`
+	RevokedAt  int64  `json:"revoked_at"`
+	CreateAt   string `json:"create_at"`
+}
+
+type AcceptInvite struct {
+	Token    string `json:"token" binding:"required"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,13 @@
+package entity
+
+// LoginCode — одноразовая ссылка (kind = link) или код (kind = code) для входа без пароля.
+type LoginCode struct {
+	ID        int
+	UserID    int
+	Kind      string
+	CodeHash  string
+	Attempts  int
+	IssuedAt  int64
+	ExpiresAt int64
+	UsedAt    int64
+}
@@ -0,0 +1,21 @@
+package entity
+
+// Organization — тенант. С IsolatedEmails учетные записи, созданные в организации,
+// принадлежат ей: тот же email может быть зарегистрирован в другой организации.
+type Organization struct {
+	ID             int    `json:"id"`
+	Slug           string `json:"slug" binding:"required"`
+	Name           string `json:"name" binding:"required"`
+	Domain         string `json:"domain"`
+	IsolatedEmails bool   `json:"isolated_emails"`
+	CreateAt       string `json:"create_at"`
+}
+
+// Membership — участие пользователя в организации и его роль в ней.
+type Membership struct {
+	OrgID    int    `json:"org_id"`
+	UserID   int    `json:"user_id"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	CreateAt string `json:"create_at"`
+}
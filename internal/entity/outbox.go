@@ -0,0 +1,16 @@
+package entity
+
+// OutboxSinkStats — состояние доставки outbox в один приемник.
+type OutboxSinkStats struct {
+	Sink   string `json:"sink"`
+	Cursor int64  `json:"cursor"`
+	// Pending — событий outbox после Cursor, еще не доставленных в приемник
+	Pending int64 `json:"pending"`
+	// LagSeconds — возраст самого старого недоставленного события
+	LagSeconds float64 `json:"lag_seconds"`
+	Published  int64   `json:"published"`
+	Failures   int64   `json:"failures"`
+	LastError  string  `json:"last_error,omitempty"`
+	// LastPublishedAt — unix время последней успешной доставки
+	LastPublishedAt int64 `json:"last_published_at"`
+}
@@ -0,0 +1,33 @@
+package entity
+
+const (
+	SCIMResourceUser  = "User"
+	SCIMResourceGroup = "Group"
+)
+
+// SCIMResource — атрибуты пользователя или группы из SCIM, которых нет в основных
+// таблицах. ResourceID — ID пользователя или группы, TenantID — организация (0 — глобально).
+type SCIMResource struct {
+	Type       string
+	ResourceID int
+	TenantID   int
+	ExternalID string
+	// Active — только у пользователей; отключенный пользователь не может войти
+	Active   bool
+	CreateAt int64
+	UpdateAt int64
+}
+
+// SCIMToken — токен провижининга для IdP. Токен организации работает только с ее
+// пользователями и группами, глобальный — с любыми.
+type SCIMToken struct {
+	ID         int    `json:"id"`
+	TenantID   int    `json:"tenant_id"`
+	Tenant     string `json:"tenant,omitempty"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	TokenHash  string `json:"-"`
+	Revoked    bool   `json:"revoked"`
+	LastUsedAt int64  `json:"last_used_at"`
+	CreateAt   int64  `json:"create_at"`
+}
@@ -0,0 +1,10 @@
+package entity
+
+type ServiceAccount struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"-"`
+	Scopes       string `json:"scopes"`
+	CreateAt     string `json:"create_at"`
+}
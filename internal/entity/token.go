@@ -0,0 +1,37 @@
+package entity
+
+type APIKey struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix"`
+	KeyHash   string `json:"-"`
+	Scopes    string `json:"scopes"`
+	ExpiresAt int64  `json:"expires_at"`
+	Revoked   bool   `json:"revoked"`
+	CreateAt  string `json:"create_at"`
+}
+
+// TokenIntrospection — ответ RFC 7662.
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Role      string `json:"role,omitempty"`
+	// Aud — сервисы, для которых выдан токен (обмен токена RFC 8693)
+	Aud []string `json:"aud,omitempty"`
+	// Act — кто действует от имени пользователя: администратор или сервис, обменявший токен
+	Act *TokenActor `json:"act,omitempty"`
+}
+
+// TokenActor — claim act (RFC 8693): цепочка действующих от имени пользователя.
+type TokenActor struct {
+	Sub string      `json:"sub"`
+	Act *TokenActor `json:"act,omitempty"`
+}
@@ -7,6 +7,8 @@ type User struct {
 	Password string `json:"password"`
 	Role     string `json:"role"`
 	CreateAt string `json:"create_at"`
+	// Tenant — slug организации, в контексте которой загружен пользователь
+	Tenant string `json:"tenant,omitempty"`
 }
 
 type DTOUser struct {
@@ -15,4 +17,5 @@ type DTOUser struct {
 	Email    string `json:"email"`
 	Role     string `json:"role"`
 	CreateAt string `json:"create_at"`
+	Tenant   string `json:"tenant,omitempty"`
 }
@@ -0,0 +1,52 @@
+package entity
+
+const (
+	UserEventCreated     = "user.created"
+	UserEventUpdated     = "user.updated"
+	UserEventDeleted     = "user.deleted"
+	UserEventRoleChanged = "user.role_changed"
+	UserEventLoggedOut   = "user.logged_out"
+	// отключение и включение учетной записи через SCIM (active)
+	UserEventDeactivated = "user.deactivated"
+	UserEventActivated   = "user.activated"
+
+	// события безопасности
+	UserEventPasswordChanged = "user.password_changed"
+	UserEventPasskeyAdded    = "user.passkey_added"
+	UserEventPasskeyRemoved  = "user.passkey_removed"
+	UserEventAPIKeyCreated   = "user.api_key_created"
+	UserEventAPIKeyRevoked   = "user.api_key_revoked"
+)
+
+// UserEventTypes — все типы событий; по ним проверяются фильтры потоков и подписок.
+var UserEventTypes = []string{
+	UserEventCreated,
+	UserEventUpdated,
+	UserEventDeleted,
+	UserEventRoleChanged,
+	UserEventLoggedOut,
+	UserEventDeactivated,
+	UserEventActivated,
+	UserEventPasswordChanged,
+	UserEventPasskeyAdded,
+	UserEventPasskeyRemoved,
+	UserEventAPIKeyCreated,
+	UserEventAPIKeyRevoked,
+}
+
+// UserEvent — изменение пользователя для сервисов, хранящих его копию. ID служит
+// курсором: события нумеруются по порядку, и потребитель продолжает с последнего
+// полученного. Tenant задан у событий участника организации, Role — его роль в ней.
+// IdempotencyKey одинаков во всех копиях события (outbox, лента, вебхуки, брокеры):
+// доставка at-least-once, и по нему получатель отбрасывает повторы.
+type UserEvent struct {
+	ID             int64  `json:"id"`
+	IdempotencyKey string `json:"idempotency_key"`
+	Type           string `json:"type"`
+	UserID         int    `json:"user_id"`
+	Email          string `json:"email"`
+	Name           string `json:"name"`
+	Role           string `json:"role"`
+	Tenant         string `json:"tenant,omitempty"`
+	CreateAt       int64  `json:"create_at"`
+}
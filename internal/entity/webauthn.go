@@ -0,0 +1,21 @@
+package entity
+
+type WebAuthnCredential struct {
+	ID              int    `json:"id"`
+	UserID          int    `json:"user_id"`
+	CredentialID    string `json:"credential_id"`
+	Name            string `json:"name"`
+	AttestationType string `json:"attestation_type"`
+	SignCount       uint32 `json:"sign_count"`
+	Data            string `json:"-"`
+	CreateAt        string `json:"create_at"`
+	LastUsedAt      string `json:"last_used_at"`
+}
+
+type WebAuthnSession struct {
+	ID        string
+	UserID    int
+	Purpose   string
+	Data      string
+	ExpiresAt int64
+}
@@ -0,0 +1,45 @@
+package entity
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// WebhookDeliveryDead — попытки исчерпаны, доставка повторяется только вручную
+	WebhookDeliveryDead = "dead"
+)
+
+// Webhook — подписка на события пользователей. Events — типы через пробел,
+// пустая строка — все типы. Secret подписывает тело запроса (HMAC-SHA256).
+type Webhook struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url"`
+	Events   string `json:"events"`
+	Secret   string `json:"-"`
+	Active   bool   `json:"active"`
+	CreateAt int64  `json:"create_at"`
+}
+
+// WebhookDelivery — доставка одного события одной подписке.
+type WebhookDelivery struct {
+	ID            int64  `json:"id"`
+	WebhookID     int    `json:"webhook_id"`
+	EventID       int64  `json:"event_id"`
+	EventType     string `json:"event_type"`
+	Payload       string `json:"payload"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt int64  `json:"next_attempt_at"`
+	LastStatus    int    `json:"last_status"`
+	LastError     string `json:"last_error"`
+	CreateAt      int64  `json:"create_at"`
+	UpdateAt      int64  `json:"update_at"`
+}
+
+// WebhookAttempt — запись журнала доставки: ответ получателя или ошибка соединения.
+type WebhookAttempt struct {
+	ID         int64  `json:"id"`
+	DeliveryID int64  `json:"delivery_id"`
+	Status     int    `json:"status"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"duration_ms"`
+	CreateAt   int64  `json:"create_at"`
+}
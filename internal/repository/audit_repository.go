@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"strings"
+)
+
+type AuditRepository interface {
+	CreateEvent(event entity.AuditEvent) error
+	GetEvents(filter entity.AuditFilter) ([]entity.AuditEvent, error)
+}
+
+type AuditRep struct {
+	db *sql.DB
+}
+
+func NewAuditRep(db *sql.DB) AuditRep {
+	return AuditRep{db: db}
+}
+
+const auditColumns = `id, action, actor_id, actor_email, user_id, user_email, tenant, method, path, status, details, create_at`
+
+func (a *AuditRep) CreateEvent(event entity.AuditEvent) error {
+	query := `INSERT INTO audit_log (action, actor_id, actor_email, user_id, user_email, tenant, method, path, status, details, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := a.db.Exec(query, event.Action, event.ActorID, event.ActorEmail, event.UserID, event.UserEmail,
+		event.Tenant, event.Method, event.Path, event.Status, event.Details, event.CreateAt)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка записи в журнал аудита: %w", err)
+		logger.Logger.Error("Ошибка записи в журнал аудита",
+			zap.Error(msg),
+			zap.String("action", event.Action),
+			zap.String("rep", "CreateEvent"))
+		return msg
+	}
+	return nil
+}
+
+// GetEvents возвращает последние записи журнала, новые первыми.
+func (a *AuditRep) GetEvents(filter entity.AuditFilter) ([]entity.AuditEvent, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.UserID != 0 {
+		add("user_id = $%d", filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if !filter.AllTenants {
+		add("tenant = $%d", filter.Tenant)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения журнала аудита",
+			zap.Error(err),
+			zap.String("rep", "GetEvents"))
+		return nil, fmt.Errorf("Ошибка получения журнала аудита: %w", err)
+	}
+	defer rows.Close()
+
+	events := []entity.AuditEvent{}
+	for rows.Next() {
+		var e entity.AuditEvent
+		err := rows.Scan(&e.ID, &e.Action, &e.ActorID, &e.ActorEmail, &e.UserID, &e.UserEmail,
+			&e.Tenant, &e.Method, &e.Path, &e.Status, &e.Details, &e.CreateAt)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения записи журнала аудита: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+	"time"
+)
+
+// ErrGroupExists — в организации уже есть группа с таким названием.
+var ErrGroupExists = errors.New("Группа с таким названием уже существует")
+
+type GroupRepository interface {
+	GetGroups() ([]entity.Group, error)
+	GetGroup(id int) (entity.Group, error)
+	CreateGroup(group entity.Group) (entity.Group, error)
+	UpdateGroup(group entity.Group) error
+	DeleteGroup(id int) error
+	GetAncestors(id int) ([]int, error)
+	SetRoles(id int, roles []string) error
+	GetMembers(id int) ([]entity.GroupMember, error)
+	AddMember(id int, userID int) error
+	DeleteMember(id int, userID int) error
+	GetUserGroups(userID int) ([]entity.Group, error)
+	WithTenant(tenantID int) GroupRepository
+}
+
+// GroupRep без тенанта работает с глобальными группами, иначе — с группами организации.
+type GroupRep struct {
+	db       *sql.DB
+	tenantID int
+}
+
+func NewGroupRep(db *sql.DB) GroupRep {
+	return GroupRep{db: db}
+}
+
+func (g *GroupRep) WithTenant(tenantID int) GroupRepository {
+	return &GroupRep{db: g.db, tenantID: tenantID}
+}
+
+// groupSelect выбирает группы вместе с их ролями (через пробел).
+const groupSelect = `SELECT user_groups.id, user_groups.name, user_groups.description, user_groups.parent_id, user_groups.create_at,
+		COALESCE((SELECT group_concat(role, ' ') FROM group_roles WHERE group_roles.group_id = user_groups.id), '')
+		FROM user_groups`
+
+func scanGroup(row interface{ Scan(dest ...any) error }) (entity.Group, error) {
+	var group entity.Group
+	var roles string
+	err := row.Scan(&group.ID, &group.Name, &group.Description, &group.ParentID, &group.CreateAt, &roles)
+	group.Roles = strings.Fields(roles)
+	return group, err
+}
+
+func (g *GroupRep) queryGroups(query string, args ...any) ([]entity.Group, error) {
+	rows, err := g.db.Query(query, args...)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения групп",
+			zap.Error(err),
+			zap.String("rep", "queryGroups"))
+		return nil, fmt.Errorf("Ошибка получения групп: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []entity.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения группы: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (g *GroupRep) GetGroups() ([]entity.Group, error) {
+	return g.queryGroups(groupSelect+` WHERE user_groups.tenant_id = $1 ORDER BY user_groups.name`, g.tenantID)
+}
+
+func (g *GroupRep) GetGroup(id int) (entity.Group, error) {
+	query := groupSelect + ` WHERE user_groups.id = $1 AND user_groups.tenant_id = $2`
+	group, err := scanGroup(g.db.QueryRow(query, id, g.tenantID))
+	if err != nil {
+		return entity.Group{}, fmt.Errorf("Ошибка получения группы с ID = %d -> %w", id, err)
+	}
+	return group, nil
+}
+
+func (g *GroupRep) CreateGroup(group entity.Group) (entity.Group, error) {
+	query := `INSERT INTO user_groups (tenant_id, name, description, parent_id, create_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id`
+	err := g.db.QueryRow(query, g.tenantID, group.Name, group.Description, group.ParentID, group.CreateAt).Scan(&group.ID)
+	if uniqueViolation(err) {
+		return entity.Group{}, ErrGroupExists
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании группы: %w", err)
+		logger.Logger.Error("Ошибка создания группы",
+			zap.Error(msg),
+			zap.String("name", group.Name),
+			zap.String("rep", "CreateGroup"))
+		return entity.Group{}, msg
+	}
+	return group, nil
+}
+
+func (g *GroupRep) UpdateGroup(group entity.Group) error {
+	query := `UPDATE user_groups SET name = $1, description = $2, parent_id = $3 WHERE id = $4 AND tenant_id = $5`
+	res, err := g.db.Exec(query, group.Name, group.Description, group.ParentID, group.ID, g.tenantID)
+	if uniqueViolation(err) {
+		return ErrGroupExists
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при изменении группы: %w", err)
+		logger.Logger.Error("Ошибка изменения группы",
+			zap.Error(msg),
+			zap.Int("group_id", group.ID),
+			zap.String("rep", "UpdateGroup"))
+		return msg
+	}
+	return expectAffected(res, fmt.Sprintf("Группа с ID = %d не найдена", group.ID))
+}
+
+// DeleteGroup удаляет группу с ее участниками и ролями. Подгруппы переходят к ее родителю.
+func (g *GroupRep) DeleteGroup(id int) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления группы с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	var parentID int
+	err = tx.QueryRow(`SELECT parent_id FROM user_groups WHERE id = $1 AND tenant_id = $2`, id, g.tenantID).Scan(&parentID)
+	if err != nil {
+		return fmt.Errorf("Группа с ID = %d не найдена: %w", id, err)
+	}
+	if _, err := tx.Exec(`UPDATE user_groups SET parent_id = $1 WHERE parent_id = $2`, parentID, id); err != nil {
+		return fmt.Errorf("Ошибка переноса подгрупп группы с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления участников группы с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_roles WHERE group_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления ролей группы с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM scim_resources WHERE type = 'Group' AND resource_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления атрибутов SCIM группы с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_groups WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления группы с ID = %d: %w", id, err)
+	}
+	return tx.Commit()
+}
+
+// GetAncestors возвращает ID группы и всех ее родителей.
+func (g *GroupRep) GetAncestors(id int) ([]int, error) {
+	query := `WITH RECURSIVE ancestors(id) AS (
+				  SELECT id FROM user_groups WHERE id = $1 AND tenant_id = $2
+				  UNION
+				  SELECT user_groups.parent_id FROM user_groups JOIN ancestors ON user_groups.id = ancestors.id
+				  WHERE user_groups.parent_id != 0
+			  )
+			  SELECT id FROM ancestors`
+	rows, err := g.db.Query(query, id, g.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка получения родителей группы с ID = %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var ancestor int
+		if err := rows.Scan(&ancestor); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения родителя группы: %w", err)
+		}
+		ids = append(ids, ancestor)
+	}
+	return ids, nil
+}
+
+// SetRoles заменяет роли группы.
+func (g *GroupRep) SetRoles(id int, roles []string) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка изменения ролей группы с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM group_roles WHERE group_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления ролей группы с ID = %d: %w", id, err)
+	}
+	for _, role := range roles {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO group_roles (group_id, role) VALUES ($1, $2)`, id, role); err != nil {
+			msg := fmt.Errorf("Ошибка назначения роли группе: %w", err)
+			logger.Logger.Error("Ошибка назначения роли группе",
+				zap.Error(msg),
+				zap.Int("group_id", id),
+				zap.String("role", role),
+				zap.String("rep", "SetRoles"))
+			return msg
+		}
+	}
+	return tx.Commit()
+}
+
+func (g *GroupRep) GetMembers(id int) ([]entity.GroupMember, error) {
+	query := `SELECT group_members.group_id, group_members.user_id, users.email, group_members.create_at
+			  FROM group_members JOIN users ON users.id = group_members.user_id
+			  WHERE group_members.group_id = $1 ORDER BY group_members.user_id`
+	rows, err := g.db.Query(query, id)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения участников группы",
+			zap.Error(err),
+			zap.String("rep", "GetMembers"))
+		return nil, fmt.Errorf("Ошибка получения участников группы: %w", err)
+	}
+	defer rows.Close()
+
+	members := []entity.GroupMember{}
+	for rows.Next() {
+		var m entity.GroupMember
+		if err := rows.Scan(&m.GroupID, &m.UserID, &m.Email, &m.CreateAt); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения участника группы: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// AddMember добавляет пользователя в группу. В группу организации можно добавить только
+// ее участника, в глобальную — только глобальную учетную запись.
+func (g *GroupRep) AddMember(id int, userID int) error {
+	eligible := `SELECT 1 FROM users WHERE id = $2 AND tenant_id = 0`
+	if g.tenantID != 0 {
+		eligible = `SELECT 1 FROM memberships WHERE user_id = $2 AND org_id = $3`
+	}
+	query := `INSERT OR IGNORE INTO group_members (group_id, user_id, create_at)
+			  SELECT $1, $2, $4 WHERE EXISTS (` + eligible + `)
+			  AND EXISTS (SELECT 1 FROM user_groups WHERE id = $1 AND tenant_id = $3)`
+	res, err := g.db.Exec(query, id, userID, g.tenantID, time.Now().String())
+	if err != nil {
+		msg := fmt.Errorf("Ошибка добавления участника группы: %w", err)
+		logger.Logger.Error("Ошибка добавления участника группы",
+			zap.Error(msg),
+			zap.Int("group_id", id),
+			zap.Int("user_id", userID),
+			zap.String("rep", "AddMember"))
+		return msg
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при добавлении участника группы: %w", err)
+	}
+	if affected == 0 {
+		// повторное добавление — не ошибка
+		var exists int
+		err := g.db.QueryRow(`SELECT 1 FROM group_members WHERE group_id = $1 AND user_id = $2`, id, userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("Пользователь с ID = %d не может состоять в группе: %w", userID, sql.ErrNoRows)
+		}
+	}
+	return nil
+}
+
+func (g *GroupRep) DeleteMember(id int, userID int) error {
+	query := `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2
+			  AND group_id IN (SELECT id FROM user_groups WHERE tenant_id = $3)`
+	res, err := g.db.Exec(query, id, userID, g.tenantID)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления участника группы: %w", err)
+	}
+	return expectAffected(res, fmt.Sprintf("Пользователь с ID = %d не состоит в группе", userID))
+}
+
+// GetUserGroups возвращает группы пользователя вместе со всеми их родительскими группами.
+func (g *GroupRep) GetUserGroups(userID int) ([]entity.Group, error) {
+	query := `WITH RECURSIVE member_groups(id) AS (
+				  SELECT group_members.group_id FROM group_members
+				  JOIN user_groups ON user_groups.id = group_members.group_id
+				  WHERE group_members.user_id = $1 AND user_groups.tenant_id = $2
+				  UNION
+				  SELECT user_groups.parent_id FROM user_groups JOIN member_groups ON user_groups.id = member_groups.id
+				  WHERE user_groups.parent_id != 0
+			  )
+			  ` + groupSelect + ` WHERE user_groups.id IN (SELECT id FROM member_groups) ORDER BY user_groups.name`
+	return g.queryGroups(query, userID, g.tenantID)
+}
+
+// uniqueViolation сообщает, что запрос нарушил ограничение UNIQUE.
+func uniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func expectAffected(res sql.Result, notFound string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", notFound, sql.ErrNoRows)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type IdentityRepository interface {
+	GetIdentitiesByUser(userID int) ([]entity.UserIdentity, error)
+	GetIdentity(connector string, subject string) (entity.UserIdentity, error)
+	CreateIdentity(identity entity.UserIdentity) (entity.UserIdentity, error)
+	DeleteIdentity(userID int, id int) error
+	SaveState(state entity.ExternalLoginState) error
+	TakeState(state string) (entity.ExternalLoginState, error)
+}
+
+type IdentityRep struct {
+	db *sql.DB
+}
+
+func NewIdentityRep(db *sql.DB) IdentityRep {
+	return IdentityRep{db: db}
+}
+
+const userIdentityColumns = `id, user_id, connector, subject, email, provisioned, create_at`
+
+func scanUserIdentity(row interface{ Scan(dest ...any) error }) (entity.UserIdentity, error) {
+	var identity entity.UserIdentity
+	err := row.Scan(&identity.ID, &identity.UserID, &identity.Connector, &identity.Subject, &identity.Email, &identity.Provisioned, &identity.CreateAt)
+	return identity, err
+}
+
+func (i *IdentityRep) GetIdentitiesByUser(userID int) ([]entity.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE user_id = $1`
+	rows, err := i.db.Query(query, userID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения внешних учетных записей",
+			zap.Error(err),
+			zap.String("rep", "GetIdentitiesByUser"))
+		return nil, fmt.Errorf("Ошибка получения внешних учетных записей: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []entity.UserIdentity{}
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения внешней учетной записи: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+func (i *IdentityRep) GetIdentity(connector string, subject string) (entity.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE connector = $1 AND subject = $2`
+	identity, err := scanUserIdentity(i.db.QueryRow(query, connector, subject))
+	if err != nil {
+		return entity.UserIdentity{}, fmt.Errorf("Ошибка получения внешней учетной записи -> %w", err)
+	}
+	return identity, nil
+}
+
+func (i *IdentityRep) CreateIdentity(identity entity.UserIdentity) (entity.UserIdentity, error) {
+	query := `INSERT INTO user_identities (user_id, connector, subject, email, provisioned, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id`
+	err := i.db.QueryRow(
+		query,
+		identity.UserID,
+		identity.Connector,
+		identity.Subject,
+		identity.Email,
+		identity.Provisioned,
+		identity.CreateAt).Scan(&identity.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при сохранении внешней учетной записи: %w", err)
+		logger.Logger.Error("Ошибка сохранения внешней учетной записи",
+			zap.Error(msg),
+			zap.Int("user_id", identity.UserID),
+			zap.String("connector", identity.Connector),
+			zap.String("rep", "CreateIdentity"))
+		return entity.UserIdentity{}, msg
+	}
+	return identity, nil
+}
+
+func (i *IdentityRep) DeleteIdentity(userID int, id int) error {
+	res, err := i.db.Exec(`DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления внешней учетной записи с ID = %d: %w", id, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при удалении внешней учетной записи: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Внешняя учетная запись с ID = %d не найдена: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (i *IdentityRep) SaveState(state entity.ExternalLoginState) error {
+	if _, err := i.db.Exec(`DELETE FROM external_login_states WHERE expires_at < $1`, time.Now().Unix()); err != nil {
+		logger.Logger.Warn("Ошибка очистки состояний внешнего входа",
+			zap.Error(err),
+			zap.String("rep", "SaveState"))
+	}
+
+	query := `INSERT INTO external_login_states (state, connector, user_id, nonce, verifier, session, tenant, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := i.db.Exec(query, state.State, state.Connector, state.UserID, state.Nonce, state.Verifier, state.Session, state.Tenant, state.ExpiresAt)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка сохранения состояния внешнего входа: %w", err)
+		logger.Logger.Error("Ошибка сохранения состояния внешнего входа",
+			zap.Error(msg),
+			zap.String("rep", "SaveState"))
+		return msg
+	}
+	return nil
+}
+
+// TakeState достает состояние и сразу удаляет его: state одноразовый.
+func (i *IdentityRep) TakeState(state string) (entity.ExternalLoginState, error) {
+	query := `DELETE FROM external_login_states WHERE state = $1 AND expires_at >= $2
+			  RETURNING state, connector, user_id, nonce, verifier, session, tenant, expires_at`
+	var s entity.ExternalLoginState
+	err := i.db.QueryRow(query, state, time.Now().Unix()).Scan(&s.State, &s.Connector, &s.UserID, &s.Nonce, &s.Verifier, &s.Session, &s.Tenant, &s.ExpiresAt)
+	if err != nil {
+		return entity.ExternalLoginState{}, fmt.Errorf("Состояние внешнего входа не найдено или истекло: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type InviteRepository interface {
+	GetInvites() ([]entity.Invite, error)
+	GetInvite(id int) (entity.Invite, error)
+	CreateInvite(invite entity.Invite) (entity.Invite, error)
+	RevokeInvite(id int) error
+	Accept(id int, org entity.Organization, user entity.User) (entity.User, error)
+	WithTenant(tenantID int) InviteRepository
+}
+
+// InviteRep без тенанта работает с глобальными приглашениями, иначе — с приглашениями организации.
+type InviteRep struct {
+	db       *sql.DB
+	tenantID int
+}
+
+func NewInviteRep(db *sql.DB) InviteRep {
+	return InviteRep{db: db}
+}
+
+func (i *InviteRep) WithTenant(tenantID int) InviteRepository {
+	return &InviteRep{db: i.db, tenantID: tenantID}
+}
+
+const inviteColumns = `id, email, role, invited_by, expires_at, accepted_at, revoked_at, create_at`
+
+func scanInvite(row interface{ Scan(dest ...any) error }) (entity.Invite, error) {
+	var invite entity.Invite
+	err := row.Scan(&invite.ID, &invite.Email, &invite.Role, &invite.InvitedBy, &invite.ExpiresAt,
+		&invite.AcceptedAt, &invite.RevokedAt, &invite.CreateAt)
+	return invite, err
+}
+
+func (i *InviteRep) GetInvites() ([]entity.Invite, error) {
+	rows, err := i.db.Query(`SELECT `+inviteColumns+` FROM invites WHERE tenant_id = $1 ORDER BY id DESC`, i.tenantID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения приглашений",
+			zap.Error(err),
+			zap.String("rep", "GetInvites"))
+		return nil, fmt.Errorf("Ошибка получения приглашений: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []entity.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения приглашения: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+func (i *InviteRep) GetInvite(id int) (entity.Invite, error) {
+	query := `SELECT ` + inviteColumns + ` FROM invites WHERE id = $1 AND tenant_id = $2`
+	invite, err := scanInvite(i.db.QueryRow(query, id, i.tenantID))
+	if err != nil {
+		return entity.Invite{}, fmt.Errorf("Ошибка получения приглашения с ID = %d -> %w", id, err)
+	}
+	return invite, nil
+}
+
+// CreateInvite сохраняет приглашение и отзывает прежние непринятые приглашения
+// на тот же email: действует только последнее.
+func (i *InviteRep) CreateInvite(invite entity.Invite) (entity.Invite, error) {
+	now := time.Now().Unix()
+	_, err := i.db.Exec(`UPDATE invites SET revoked_at = $1
+						  WHERE tenant_id = $2 AND email = $3 AND accepted_at = 0 AND revoked_at = 0`,
+		now, i.tenantID, invite.Email)
+	if err != nil {
+		return entity.Invite{}, fmt.Errorf("Ошибка отзыва прежних приглашений: %w", err)
+	}
+
+	query := `INSERT INTO invites (tenant_id, email, role, invited_by, expires_at, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id`
+	err = i.db.QueryRow(query, i.tenantID, invite.Email, invite.Role, invite.InvitedBy, invite.ExpiresAt, invite.CreateAt).
+		Scan(&invite.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании приглашения: %w", err)
+		logger.Logger.Error("Ошибка создания приглашения",
+			zap.Error(msg),
+			zap.String("email", invite.Email),
+			zap.String("rep", "CreateInvite"))
+		return entity.Invite{}, msg
+	}
+	return invite, nil
+}
+
+func (i *InviteRep) RevokeInvite(id int) error {
+	query := `UPDATE invites SET revoked_at = $1 WHERE id = $2 AND tenant_id = $3 AND accepted_at = 0 AND revoked_at = 0`
+	res, err := i.db.Exec(query, time.Now().Unix(), id, i.tenantID)
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва приглашения: %w", err)
+	}
+	return expectAffected(res, fmt.Sprintf("Действующее приглашение с ID = %d не найдено", id))
+}
+
+// Accept гасит приглашение, если оно еще действует, и в той же транзакции создает по нему
+// учетную запись (user.ID == 0) или делает существующую учетную запись участником org с
+// ролью user.Role. Роль того, кто уже состоит в организации, не меняется. Второе принятие
+// того же приглашения возвращает sql.ErrNoRows и ничего не создает.
+func (i *InviteRep) Accept(id int, org entity.Organization, user entity.User) (entity.User, error) {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return entity.User{}, fmt.Errorf("Ошибка принятия приглашения: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	res, err := tx.Exec(`UPDATE invites SET accepted_at = $1
+			  WHERE id = $2 AND tenant_id = $3 AND accepted_at = 0 AND revoked_at = 0 AND expires_at >= $1`,
+		now, id, i.tenantID)
+	if err != nil {
+		return entity.User{}, fmt.Errorf("Ошибка принятия приглашения: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("Действующее приглашение с ID = %d не найдено", id)); err != nil {
+		return entity.User{}, err
+	}
+
+	if user.ID == 0 {
+		user, err = insertUser(tx, org, user)
+		if uniqueViolation(err) {
+			return entity.User{}, ErrUserExists
+		}
+	} else {
+		err = joinInvited(tx, org.ID, user)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка принятия приглашения: %w", err)
+		logger.Logger.Error("Ошибка принятия приглашения",
+			zap.Error(msg),
+			zap.Int("invite_id", id),
+			zap.String("rep", "Accept"))
+		return entity.User{}, msg
+	}
+	return user, nil
+}
+
+// joinInvited добавляет глобальную учетную запись в организацию, если она еще не участник.
+func joinInvited(tx *sql.Tx, orgID int, user entity.User) error {
+	query := `INSERT INTO memberships (org_id, user_id, role, create_at)
+			  SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2 AND tenant_id IN (0, $1))
+			  ON CONFLICT (org_id, user_id) DO NOTHING`
+	res, err := tx.Exec(query, orgID, user.ID, user.Role, time.Now().String())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var member bool
+		err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM memberships WHERE org_id = $1 AND user_id = $2`, orgID, user.ID).Scan(&member)
+		if err == nil && !member {
+			err = fmt.Errorf("Пользователь с ID = %d не найден: %w", user.ID, sql.ErrNoRows)
+		}
+		return err
+	}
+	return writeMemberEvent(tx, entity.UserEventCreated, orgID, user.ID, user.Role)
+}
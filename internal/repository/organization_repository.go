@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ErrOrganizationExists — slug или домен уже заняты другой организацией.
+var ErrOrganizationExists = errors.New("Организация с таким slug или доменом уже существует")
+
+type OrganizationRepository interface {
+	GetOrganizations() ([]entity.Organization, error)
+	GetOrganization(id int) (entity.Organization, error)
+	GetOrganizationBySlug(slug string) (entity.Organization, error)
+	GetOrganizationByDomain(domain string) (entity.Organization, error)
+	CreateOrganization(org entity.Organization) (entity.Organization, error)
+	DeleteOrganization(id int) error
+	GetMembers(orgID int) ([]entity.Membership, error)
+	SetMember(orgID int, userID int, role string) error
+	DeleteMember(orgID int, userID int) error
+}
+
+type OrganizationRep struct {
+	db *sql.DB
+}
+
+func NewOrganizationRep(db *sql.DB) OrganizationRep {
+	return OrganizationRep{db: db}
+}
+
+const organizationColumns = `id, slug, name, domain, isolated_emails, create_at`
+
+func scanOrganization(row interface{ Scan(dest ...any) error }) (entity.Organization, error) {
+	var org entity.Organization
+	err := row.Scan(&org.ID, &org.Slug, &org.Name, &org.Domain, &org.IsolatedEmails, &org.CreateAt)
+	return org, err
+}
+
+func (o *OrganizationRep) GetOrganizations() ([]entity.Organization, error) {
+	rows, err := o.db.Query(`SELECT ` + organizationColumns + ` FROM organizations ORDER BY id`)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения организаций",
+			zap.Error(err),
+			zap.String("rep", "GetOrganizations"))
+		return nil, fmt.Errorf("Ошибка получения организаций: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []entity.Organization{}
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения организации: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, nil
+}
+
+func (o *OrganizationRep) GetOrganization(id int) (entity.Organization, error) {
+	org, err := scanOrganization(o.db.QueryRow(`SELECT `+organizationColumns+` FROM organizations WHERE id = $1`, id))
+	if err != nil {
+		return entity.Organization{}, fmt.Errorf("Ошибка получения организации с ID = %d -> %w", id, err)
+	}
+	return org, nil
+}
+
+func (o *OrganizationRep) GetOrganizationBySlug(slug string) (entity.Organization, error) {
+	org, err := scanOrganization(o.db.QueryRow(`SELECT `+organizationColumns+` FROM organizations WHERE slug = $1`, slug))
+	if err != nil {
+		return entity.Organization{}, fmt.Errorf("Ошибка получения организации %s -> %w", slug, err)
+	}
+	return org, nil
+}
+
+func (o *OrganizationRep) GetOrganizationByDomain(domain string) (entity.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations WHERE domain != '' AND domain = $1`
+	org, err := scanOrganization(o.db.QueryRow(query, domain))
+	if err != nil {
+		return entity.Organization{}, fmt.Errorf("Ошибка получения организации по домену %s -> %w", domain, err)
+	}
+	return org, nil
+}
+
+func (o *OrganizationRep) CreateOrganization(org entity.Organization) (entity.Organization, error) {
+	query := `INSERT INTO organizations (slug, name, domain, isolated_emails, create_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id`
+	err := o.db.QueryRow(query, org.Slug, org.Name, org.Domain, org.IsolatedEmails, org.CreateAt).Scan(&org.ID)
+	if uniqueViolation(err) {
+		return entity.Organization{}, ErrOrganizationExists
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании организации: %w", err)
+		logger.Logger.Error("Ошибка создания организации",
+			zap.Error(msg),
+			zap.String("slug", org.Slug),
+			zap.String("rep", "CreateOrganization"))
+		return entity.Organization{}, msg
+	}
+	return org, nil
+}
+
+// DeleteOrganization удаляет организацию вместе с членствами, группами и ее собственными учетными записями.
+func (o *OrganizationRep) DeleteOrganization(id int) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления организации с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	// участники получают событие удаления раньше, чем исчезнут членства и организация
+	_, err = tx.Exec(`INSERT INTO outbox (type, user_id, email, name, role, tenant, create_at)
+					  SELECT $1, users.id, users.email, users.name, memberships.role, organizations.slug, $2
+					  FROM memberships
+					  JOIN users ON users.id = memberships.user_id
+					  JOIN organizations ON organizations.id = memberships.org_id
+					  WHERE memberships.org_id = $3`, entity.UserEventDeleted, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("Ошибка записи событий участников организации с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM memberships WHERE org_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления участников организации с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id IN (SELECT id FROM user_groups WHERE tenant_id = $1)`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления участников групп организации с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_roles WHERE group_id IN (SELECT id FROM user_groups WHERE tenant_id = $1)`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления ролей групп организации с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_groups WHERE tenant_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления групп организации с ID = %d: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE tenant_id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка удаления пользователей организации с ID = %d: %w", id, err)
+	}
+	res, err := tx.Exec(`DELETE FROM organizations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления организации с ID = %d: %w", id, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при удалении организации: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Организация с ID = %d не найдена: %w", id, sql.ErrNoRows)
+	}
+	return tx.Commit()
+}
+
+func (o *OrganizationRep) GetMembers(orgID int) ([]entity.Membership, error) {
+	query := `SELECT memberships.org_id, memberships.user_id, users.email, memberships.role, memberships.create_at
+			  FROM memberships JOIN users ON users.id = memberships.user_id
+			  WHERE memberships.org_id = $1 ORDER BY memberships.user_id`
+	rows, err := o.db.Query(query, orgID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения участников организации",
+			zap.Error(err),
+			zap.String("rep", "GetMembers"))
+		return nil, fmt.Errorf("Ошибка получения участников организации: %w", err)
+	}
+	defer rows.Close()
+
+	members := []entity.Membership{}
+	for rows.Next() {
+		var m entity.Membership
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreateAt); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения участника организации: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// SetMember добавляет пользователя в организацию или меняет его роль в ней.
+// Учетную запись, принадлежащую другой организации, добавить нельзя.
+func (o *OrganizationRep) SetMember(orgID int, userID int, role string) error {
+	previous, wasMember := o.memberRole(orgID, userID)
+
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка сохранения участника организации: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO memberships (org_id, user_id, role, create_at)
+			  SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2 AND tenant_id IN (0, $1))
+			  ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role`
+	res, err := tx.Exec(query, orgID, userID, role, time.Now().String())
+	if err != nil {
+		msg := fmt.Errorf("Ошибка сохранения участника организации: %w", err)
+		logger.Logger.Error("Ошибка сохранения участника организации",
+			zap.Error(msg),
+			zap.Int("org_id", orgID),
+			zap.Int("user_id", userID),
+			zap.String("rep", "SetMember"))
+		return msg
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при сохранении участника организации: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Пользователь с ID = %d не найден: %w", userID, sql.ErrNoRows)
+	}
+
+	switch {
+	case !wasMember:
+		err = writeMemberEvent(tx, entity.UserEventCreated, orgID, userID, role)
+	case previous != role:
+		err = writeMemberEvent(tx, entity.UserEventRoleChanged, orgID, userID, role)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (o *OrganizationRep) DeleteMember(orgID int, userID int) error {
+	previous, _ := o.memberRole(orgID, userID)
+
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления участника организации: %w", err)
+	}
+	defer tx.Rollback()
+
+	// событие пишется первым, пока пользователь еще состоит в организации
+	if err := writeMemberEvent(tx, entity.UserEventDeleted, orgID, userID, previous); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM memberships WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления участника организации: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при удалении участника организации: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Пользователь с ID = %d не состоит в организации: %w", userID, sql.ErrNoRows)
+	}
+
+	query := `DELETE FROM group_members WHERE user_id = $1 AND group_id IN (SELECT id FROM user_groups WHERE tenant_id = $2)`
+	if _, err := tx.Exec(query, userID, orgID); err != nil {
+		return fmt.Errorf("Ошибка исключения участника из групп организации: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (o *OrganizationRep) memberRole(orgID int, userID int) (string, bool) {
+	var role string
+	err := o.db.QueryRow(`SELECT role FROM memberships WHERE org_id = $1 AND user_id = $2`, orgID, userID).Scan(&role)
+	return role, err == nil
+}
+
+// writeMemberEvent пишет в outbox событие участника организации, беря email, имя и slug из базы.
+func writeMemberEvent(tx execer, eventType string, orgID int, userID int, role string) error {
+	query := `INSERT INTO outbox (type, user_id, email, name, role, tenant, create_at)
+			  SELECT $1, users.id, users.email, users.name, $2, organizations.slug, $3
+			  FROM users, organizations WHERE users.id = $4 AND organizations.id = $5`
+	if _, err := tx.Exec(query, eventType, role, time.Now().Unix(), userID, orgID); err != nil {
+		logger.Logger.Error("Ошибка записи события участника организации",
+			zap.Error(err),
+			zap.String("type", eventType),
+			zap.Int("org_id", orgID),
+			zap.Int("user_id", userID),
+			zap.String("rep", "writeMemberEvent"))
+		return fmt.Errorf("Ошибка записи события %s в outbox: %w", eventType, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+// OutboxRepository — события, записанные вместе с изменениями пользователей, и
+// позиции приемников, в которые их доставляет relay.
+type OutboxRepository interface {
+	// CreateEvent пишет событие, не связанное с изменением в базе (например, выход)
+	CreateEvent(event entity.UserEvent) error
+	GetEventsAfter(cursor int64, limit int) ([]entity.UserEvent, error)
+	LatestCursor() (int64, error)
+	// GetCursor возвращает позицию приемника; ok == false, если приемник еще не запускался
+	GetCursor(sink string) (cursor int64, ok bool, err error)
+	SetCursor(sink string, cursor int64) error
+	// Pending возвращает число событий после cursor и время (unix) самого старого из них
+	Pending(cursor int64) (count int64, oldest int64, err error)
+	// DeleteEventsUpTo удаляет события, уже доставленные во все приемники
+	DeleteEventsUpTo(cursor int64) (int64, error)
+}
+
+type OutboxRep struct {
+	db *sql.DB
+}
+
+func NewOutboxRep(db *sql.DB) OutboxRep {
+	return OutboxRep{db: db}
+}
+
+// execer — *sql.DB или *sql.Tx: событие outbox пишется в той же транзакции,
+// что и изменение, и без него изменение не сохраняется.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// writeOutbox пишет событие в outbox. Ключ идемпотентности генерирует база.
+func writeOutbox(tx execer, event entity.UserEvent) error {
+	if event.CreateAt == 0 {
+		event.CreateAt = time.Now().Unix()
+	}
+	query := `INSERT INTO outbox (type, user_id, email, name, role, tenant, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.Exec(query, event.Type, event.UserID, event.Email, event.Name, event.Role, event.Tenant, event.CreateAt)
+	if err != nil {
+		return fmt.Errorf("Ошибка записи события %s в outbox: %w", event.Type, err)
+	}
+	return nil
+}
+
+// writeAccountEvent пишет в outbox событие глобального пользователя, беря email,
+// имя и роль из users.
+func writeAccountEvent(tx execer, eventType string, userID int) error {
+	query := `INSERT INTO outbox (type, user_id, email, name, role, tenant, create_at)
+			  SELECT $1, id, email, name, role, '', $2 FROM users WHERE id = $3`
+	if _, err := tx.Exec(query, eventType, time.Now().Unix(), userID); err != nil {
+		return fmt.Errorf("Ошибка записи события %s в outbox: %w", eventType, err)
+	}
+	return nil
+}
+
+func (o *OutboxRep) CreateEvent(event entity.UserEvent) error {
+	if err := writeOutbox(o.db, event); err != nil {
+		logger.Logger.Error("Ошибка записи события в outbox",
+			zap.Error(err),
+			zap.Int("user_id", event.UserID),
+			zap.String("rep", "CreateEvent"))
+		return err
+	}
+	return nil
+}
+
+func (o *OutboxRep) GetEventsAfter(cursor int64, limit int) ([]entity.UserEvent, error) {
+	query := `SELECT id, idempotency_key, type, user_id, email, name, role, tenant, create_at
+			  FROM outbox WHERE id > $1 ORDER BY id LIMIT $2`
+	return queryUserEvents(o.db, query, cursor, limit)
+}
+
+func (o *OutboxRep) LatestCursor() (int64, error) {
+	// после очистки outbox пуст, поэтому последний выданный ID берется из sqlite_sequence
+	var id int64
+	err := o.db.QueryRow(`SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'outbox'), 0)`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка получения позиции outbox: %w", err)
+	}
+	return id, nil
+}
+
+func (o *OutboxRep) GetCursor(sink string) (int64, bool, error) {
+	var cursor int64
+	err := o.db.QueryRow(`SELECT cursor FROM outbox_cursors WHERE sink = $1`, sink).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("Ошибка получения позиции приемника %s: %w", sink, err)
+	}
+	return cursor, true, nil
+}
+
+func (o *OutboxRep) SetCursor(sink string, cursor int64) error {
+	query := `INSERT INTO outbox_cursors (sink, cursor, update_at) VALUES ($1, $2, $3)
+			  ON CONFLICT (sink) DO UPDATE SET cursor = excluded.cursor, update_at = excluded.update_at`
+	if _, err := o.db.Exec(query, sink, cursor, time.Now().Unix()); err != nil {
+		msg := fmt.Errorf("Ошибка сохранения позиции приемника %s: %w", sink, err)
+		logger.Logger.Error("Ошибка сохранения позиции приемника outbox",
+			zap.Error(msg),
+			zap.Int64("cursor", cursor),
+			zap.String("rep", "SetCursor"))
+		return msg
+	}
+	return nil
+}
+
+func (o *OutboxRep) Pending(cursor int64) (int64, int64, error) {
+	var count, oldest int64
+	err := o.db.QueryRow(`SELECT COUNT(*), COALESCE(MIN(create_at), 0) FROM outbox WHERE id > $1`, cursor).Scan(&count, &oldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Ошибка получения очереди outbox: %w", err)
+	}
+	return count, oldest, nil
+}
+
+func (o *OutboxRep) DeleteEventsUpTo(cursor int64) (int64, error) {
+	res, err := o.db.Exec(`DELETE FROM outbox WHERE id <= $1`, cursor)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка очистки outbox: %w", err)
+	}
+	return res.RowsAffected()
+}
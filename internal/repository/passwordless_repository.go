@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PasswordlessRepository interface {
+	CreateCode(code entity.LoginCode) error
+	CountIssuedSince(userID int, since int64) (int, error)
+	GetActiveCode(userID int, kind string) (entity.LoginCode, error)
+	GetActiveCodeByHash(kind string, hash string) (entity.LoginCode, error)
+	IncrementAttempts(id int) error
+	MarkUsed(id int) error
+	GetEnabled(userID int) (bool, error)
+	SetEnabled(userID int, enabled bool) error
+}
+
+type PasswordlessRep struct {
+	db *sql.DB
+}
+
+func NewPasswordlessRep(db *sql.DB) PasswordlessRep {
+	return PasswordlessRep{db: db}
+}
+
+const loginCodeColumns = `id, user_id, kind, code_hash, attempts, issued_at, expires_at, used_at`
+
+func scanLoginCode(row interface{ Scan(dest ...any) error }) (entity.LoginCode, error) {
+	var code entity.LoginCode
+	err := row.Scan(&code.ID, &code.UserID, &code.Kind, &code.CodeHash, &code.Attempts, &code.IssuedAt, &code.ExpiresAt, &code.UsedAt)
+	return code, err
+}
+
+// CreateCode сохраняет новый код и гасит прежние неиспользованные коды того же вида:
+// действует только последний отправленный.
+func (p *PasswordlessRep) CreateCode(code entity.LoginCode) error {
+	now := time.Now().Unix()
+	if _, err := p.db.Exec(`DELETE FROM login_codes WHERE expires_at < $1`, now-int64(24*time.Hour/time.Second)); err != nil {
+		logger.Logger.Warn("Ошибка очистки кодов входа",
+			zap.Error(err),
+			zap.String("rep", "CreateCode"))
+	}
+
+	_, err := p.db.Exec(`UPDATE login_codes SET used_at = $3 WHERE user_id = $1 AND kind = $2 AND used_at = 0`,
+		code.UserID, code.Kind, now)
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва прежних кодов входа: %w", err)
+	}
+
+	query := `INSERT INTO login_codes (user_id, kind, code_hash, issued_at, expires_at)
+			  VALUES ($1, $2, $3, $4, $5)`
+	if _, err := p.db.Exec(query, code.UserID, code.Kind, code.CodeHash, code.IssuedAt, code.ExpiresAt); err != nil {
+		msg := fmt.Errorf("Ошибка сохранения кода входа: %w", err)
+		logger.Logger.Error("Ошибка сохранения кода входа",
+			zap.Error(msg),
+			zap.Int("user_id", code.UserID),
+			zap.String("rep", "CreateCode"))
+		return msg
+	}
+	return nil
+}
+
+func (p *PasswordlessRep) CountIssuedSince(userID int, since int64) (int, error) {
+	var count int
+	err := p.db.QueryRow(`SELECT COUNT(*) FROM login_codes WHERE user_id = $1 AND issued_at >= $2`, userID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка подсчета кодов входа: %w", err)
+	}
+	return count, nil
+}
+
+func (p *PasswordlessRep) GetActiveCode(userID int, kind string) (entity.LoginCode, error) {
+	query := `SELECT ` + loginCodeColumns + ` FROM login_codes
+			  WHERE user_id = $1 AND kind = $2 AND used_at = 0 AND expires_at >= $3
+			  ORDER BY id DESC LIMIT 1`
+	code, err := scanLoginCode(p.db.QueryRow(query, userID, kind, time.Now().Unix()))
+	if err != nil {
+		return entity.LoginCode{}, fmt.Errorf("Код входа не найден -> %w", err)
+	}
+	return code, nil
+}
+
+func (p *PasswordlessRep) GetActiveCodeByHash(kind string, hash string) (entity.LoginCode, error) {
+	query := `SELECT ` + loginCodeColumns + ` FROM login_codes
+			  WHERE kind = $1 AND code_hash = $2 AND used_at = 0 AND expires_at >= $3`
+	code, err := scanLoginCode(p.db.QueryRow(query, kind, hash, time.Now().Unix()))
+	if err != nil {
+		return entity.LoginCode{}, fmt.Errorf("Код входа не найден -> %w", err)
+	}
+	return code, nil
+}
+
+func (p *PasswordlessRep) IncrementAttempts(id int) error {
+	if _, err := p.db.Exec(`UPDATE login_codes SET attempts = attempts + 1 WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("Ошибка обновления кода входа: %w", err)
+	}
+	return nil
+}
+
+// MarkUsed гасит код. Возвращает sql.ErrNoRows, если код уже использован параллельным запросом.
+func (p *PasswordlessRep) MarkUsed(id int) error {
+	res, err := p.db.Exec(`UPDATE login_codes SET used_at = $2 WHERE id = $1 AND used_at = 0`, id, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Ошибка обновления кода входа: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при обновлении кода входа: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Код входа уже использован: %w", sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (p *PasswordlessRep) GetEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := p.db.QueryRow(`SELECT enabled FROM user_passwordless WHERE user_id = $1`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Ошибка получения настроек входа без пароля: %w", err)
+	}
+	return enabled, nil
+}
+
+func (p *PasswordlessRep) SetEnabled(userID int, enabled bool) error {
+	query := `INSERT INTO user_passwordless (user_id, enabled) VALUES ($1, $2)
+			  ON CONFLICT (user_id) DO UPDATE SET enabled = excluded.enabled`
+	if _, err := p.db.Exec(query, userID, enabled); err != nil {
+		msg := fmt.Errorf("Ошибка сохранения настроек входа без пароля: %w", err)
+		logger.Logger.Error("Ошибка сохранения настроек входа без пароля",
+			zap.Error(msg),
+			zap.String("rep", "SetEnabled"))
+		return msg
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+var ErrAssertionReplayed = errors.New("SAML утверждение уже использовано")
+
+type SAMLRepository interface {
+	UseAssertion(id string, tenant string, expiresAt int64) error
+}
+
+type SAMLRep struct {
+	db *sql.DB
+}
+
+func NewSAMLRep(db *sql.DB) SAMLRep {
+	return SAMLRep{db: db}
+}
+
+// UseAssertion запоминает ID утверждения до конца его срока действия.
+// Повторное предъявление того же утверждения возвращает ErrAssertionReplayed.
+func (s *SAMLRep) UseAssertion(id string, tenant string, expiresAt int64) error {
+	if _, err := s.db.Exec(`DELETE FROM saml_assertions WHERE expires_at < $1`, time.Now().Unix()); err != nil {
+		logger.Logger.Warn("Ошибка очистки использованных SAML утверждений",
+			zap.Error(err),
+			zap.String("rep", "UseAssertion"))
+	}
+
+	res, err := s.db.Exec(`INSERT INTO saml_assertions (id, tenant, expires_at) VALUES ($1, $2, $3)
+			  ON CONFLICT (id) DO NOTHING`, id, tenant, expiresAt)
+	if err != nil {
+		return fmt.Errorf("Ошибка сохранения SAML утверждения: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при сохранении SAML утверждения: %w", err)
+	}
+	if affected == 0 {
+		return ErrAssertionReplayed
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SCIMRepository interface {
+	// GetResources возвращает атрибуты SCIM ресурсов типа resourceType в организации по ID ресурса
+	GetResources(resourceType string, tenantID int) (map[int]entity.SCIMResource, error)
+	// GetResource возвращает атрибуты ресурса; ok == false, если их еще не сохраняли
+	GetResource(resourceType string, tenantID int, id int) (resource entity.SCIMResource, ok bool, err error)
+	// SaveResource сохраняет атрибуты; отключение и включение пользователя пишется в outbox
+	SaveResource(resource entity.SCIMResource) error
+	// IsUserDisabled сообщает, отключен ли пользователь глобально или в организации tenant (slug)
+	IsUserDisabled(userID int, tenant string) (bool, error)
+
+	GetTokens(tenantID int) ([]entity.SCIMToken, error)
+	CreateToken(token entity.SCIMToken) (entity.SCIMToken, error)
+	GetTokenByPrefix(prefix string) (entity.SCIMToken, error)
+	RevokeToken(tenantID int, id int) error
+	TouchToken(id int) error
+}
+
+type SCIMRep struct {
+	db *sql.DB
+}
+
+func NewSCIMRep(db *sql.DB) SCIMRep {
+	return SCIMRep{db: db}
+}
+
+const scimResourceColumns = `type, resource_id, tenant_id, external_id, active, create_at, update_at`
+
+func scanSCIMResource(row interface{ Scan(dest ...any) error }) (entity.SCIMResource, error) {
+	var r entity.SCIMResource
+	err := row.Scan(&r.Type, &r.ResourceID, &r.TenantID, &r.ExternalID, &r.Active, &r.CreateAt, &r.UpdateAt)
+	return r, err
+}
+
+func (s *SCIMRep) GetResources(resourceType string, tenantID int) (map[int]entity.SCIMResource, error) {
+	query := `SELECT ` + scimResourceColumns + ` FROM scim_resources WHERE type = $1 AND tenant_id = $2`
+	rows, err := s.db.Query(query, resourceType, tenantID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения атрибутов SCIM",
+			zap.Error(err),
+			zap.String("type", resourceType),
+			zap.String("rep", "GetResources"))
+		return nil, fmt.Errorf("Ошибка получения атрибутов SCIM: %w", err)
+	}
+	defer rows.Close()
+
+	resources := map[int]entity.SCIMResource{}
+	for rows.Next() {
+		r, err := scanSCIMResource(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения атрибутов SCIM: %w", err)
+		}
+		resources[r.ResourceID] = r
+	}
+	return resources, rows.Err()
+}
+
+func (s *SCIMRep) GetResource(resourceType string, tenantID int, id int) (entity.SCIMResource, bool, error) {
+	query := `SELECT ` + scimResourceColumns + ` FROM scim_resources WHERE type = $1 AND tenant_id = $2 AND resource_id = $3`
+	r, err := scanSCIMResource(s.db.QueryRow(query, resourceType, tenantID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.SCIMResource{}, false, nil
+	}
+	if err != nil {
+		return entity.SCIMResource{}, false, fmt.Errorf("Ошибка получения атрибутов SCIM: %w", err)
+	}
+	return r, true, nil
+}
+
+func (s *SCIMRep) SaveResource(resource entity.SCIMResource) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка сохранения атрибутов SCIM: %w", err)
+	}
+	defer tx.Rollback()
+
+	wasActive := true
+	err = tx.QueryRow(`SELECT active FROM scim_resources WHERE type = $1 AND tenant_id = $2 AND resource_id = $3`,
+		resource.Type, resource.TenantID, resource.ResourceID).Scan(&wasActive)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Ошибка сохранения атрибутов SCIM: %w", err)
+	}
+
+	query := `INSERT INTO scim_resources (` + scimResourceColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $6)
+			  ON CONFLICT (type, tenant_id, resource_id) DO UPDATE
+			  SET external_id = excluded.external_id, active = excluded.active, update_at = excluded.update_at`
+	_, err = tx.Exec(query, resource.Type, resource.ResourceID, resource.TenantID, resource.ExternalID, resource.Active, time.Now().Unix())
+	if err == nil && resource.Type == entity.SCIMResourceUser && wasActive != resource.Active {
+		eventType := entity.UserEventDeactivated
+		if resource.Active {
+			eventType = entity.UserEventActivated
+		}
+		if resource.TenantID == 0 {
+			err = writeAccountEvent(tx, eventType, resource.ResourceID)
+		} else {
+			err = writeStatusEvent(tx, eventType, resource.TenantID, resource.ResourceID)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка сохранения атрибутов SCIM: %w", err)
+		logger.Logger.Error("Ошибка сохранения атрибутов SCIM",
+			zap.Error(msg),
+			zap.String("type", resource.Type),
+			zap.Int("id", resource.ResourceID),
+			zap.Int("tenant_id", resource.TenantID),
+			zap.String("rep", "SaveResource"))
+		return msg
+	}
+	return nil
+}
+
+// writeStatusEvent пишет в outbox событие участника организации с его ролью в ней.
+func writeStatusEvent(tx execer, eventType string, orgID int, userID int) error {
+	query := `INSERT INTO outbox (type, user_id, email, name, role, tenant, create_at)
+			  SELECT $1, users.id, users.email, users.name, memberships.role, organizations.slug, $2
+			  FROM users
+			  JOIN memberships ON memberships.user_id = users.id AND memberships.org_id = $3
+			  JOIN organizations ON organizations.id = $3
+			  WHERE users.id = $4`
+	if _, err := tx.Exec(query, eventType, time.Now().Unix(), orgID, userID); err != nil {
+		return fmt.Errorf("Ошибка записи события %s в outbox: %w", eventType, err)
+	}
+	return nil
+}
+
+func (s *SCIMRep) IsUserDisabled(userID int, tenant string) (bool, error) {
+	query := `SELECT COUNT(*) FROM scim_resources
+			  LEFT JOIN organizations ON organizations.id = scim_resources.tenant_id
+			  WHERE scim_resources.type = 'User' AND scim_resources.resource_id = $1 AND scim_resources.active = 0
+			  AND (scim_resources.tenant_id = 0 OR organizations.slug = $2)`
+	var count int
+	if err := s.db.QueryRow(query, userID, tenant).Scan(&count); err != nil {
+		return false, fmt.Errorf("Ошибка проверки статуса пользователя: %w", err)
+	}
+	return count > 0, nil
+}
+
+const scimTokenColumns = `scim_tokens.id, scim_tokens.tenant_id, COALESCE(organizations.slug, ''), scim_tokens.name,
+			  scim_tokens.prefix, scim_tokens.token_hash, scim_tokens.revoked, scim_tokens.last_used_at, scim_tokens.create_at`
+
+const scimTokenFrom = ` FROM scim_tokens LEFT JOIN organizations ON organizations.id = scim_tokens.tenant_id`
+
+func scanSCIMToken(row interface{ Scan(dest ...any) error }) (entity.SCIMToken, error) {
+	var t entity.SCIMToken
+	err := row.Scan(&t.ID, &t.TenantID, &t.Tenant, &t.Name, &t.Prefix, &t.TokenHash, &t.Revoked, &t.LastUsedAt, &t.CreateAt)
+	return t, err
+}
+
+func (s *SCIMRep) GetTokens(tenantID int) ([]entity.SCIMToken, error) {
+	query := `SELECT ` + scimTokenColumns + scimTokenFrom + ` WHERE scim_tokens.tenant_id = $1 ORDER BY scim_tokens.id`
+	rows, err := s.db.Query(query, tenantID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения токенов провижининга",
+			zap.Error(err),
+			zap.String("rep", "GetTokens"))
+		return nil, fmt.Errorf("Ошибка получения токенов провижининга: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []entity.SCIMToken{}
+	for rows.Next() {
+		t, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения токена провижининга: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SCIMRep) CreateToken(token entity.SCIMToken) (entity.SCIMToken, error) {
+	query := `INSERT INTO scim_tokens (tenant_id, name, prefix, token_hash, create_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id`
+	err := s.db.QueryRow(query, token.TenantID, token.Name, token.Prefix, token.TokenHash, token.CreateAt).Scan(&token.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка создания токена провижининга: %w", err)
+		logger.Logger.Error("Ошибка создания токена провижининга",
+			zap.Error(msg),
+			zap.Int("tenant_id", token.TenantID),
+			zap.String("rep", "CreateToken"))
+		return entity.SCIMToken{}, msg
+	}
+	return token, nil
+}
+
+func (s *SCIMRep) GetTokenByPrefix(prefix string) (entity.SCIMToken, error) {
+	query := `SELECT ` + scimTokenColumns + scimTokenFrom + ` WHERE scim_tokens.prefix = $1`
+	token, err := scanSCIMToken(s.db.QueryRow(query, prefix))
+	if err != nil {
+		return entity.SCIMToken{}, fmt.Errorf("Токен провижининга %s не найден: %w", prefix, err)
+	}
+	return token, nil
+}
+
+func (s *SCIMRep) RevokeToken(tenantID int, id int) error {
+	res, err := s.db.Exec(`UPDATE scim_tokens SET revoked = 1 WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва токена провижининга с ID = %d: %w", id, err)
+	}
+	return expectAffected(res, fmt.Sprintf("Токен провижининга с ID = %d не найден", id))
+}
+
+func (s *SCIMRep) TouchToken(id int) error {
+	_, err := s.db.Exec(`UPDATE scim_tokens SET last_used_at = $1 WHERE id = $2`, time.Now().Unix(), id)
+	return err
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type ServiceAccountRepository interface {
+	GetAll() ([]entity.ServiceAccount, error)
+	GetByClientID(clientID string) (entity.ServiceAccount, error)
+	Create(account entity.ServiceAccount) (entity.ServiceAccount, error)
+	Delete(id int) error
+}
+
+type ServiceAccountRep struct {
+	db *sql.DB
+}
+
+func NewServiceAccountRep(db *sql.DB) ServiceAccountRep {
+	return ServiceAccountRep{db: db}
+}
+
+func (s *ServiceAccountRep) GetAll() ([]entity.ServiceAccount, error) {
+	query := `SELECT id, name, client_id, client_secret, scopes, create_at FROM service_accounts`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения сервисных аккаунтов",
+			zap.Error(err),
+			zap.String("rep", "ServiceAccount.GetAll"))
+		return nil, fmt.Errorf("Ошибка получения сервисных аккаунтов: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []entity.ServiceAccount
+	for rows.Next() {
+		var account entity.ServiceAccount
+		if err := rows.Scan(&account.ID, &account.Name, &account.ClientID, &account.ClientSecret, &account.Scopes, &account.CreateAt); err != nil {
+			msg := fmt.Errorf("Ошибка чтения сервисного аккаунта: %w", err)
+			logger.Logger.Error("Ошибка чтения сервисного аккаунта",
+				zap.Error(msg),
+				zap.String("rep", "ServiceAccount.GetAll"))
+			return nil, msg
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+func (s *ServiceAccountRep) GetByClientID(clientID string) (entity.ServiceAccount, error) {
+	query := `SELECT id, name, client_id, client_secret, scopes, create_at FROM service_accounts WHERE client_id = $1`
+	row := s.db.QueryRow(query, clientID)
+
+	var account entity.ServiceAccount
+	if err := row.Scan(&account.ID, &account.Name, &account.ClientID, &account.ClientSecret, &account.Scopes, &account.CreateAt); err != nil {
+		msg := fmt.Errorf("Ошибка получения сервисного аккаунта client_id = %s -> %w", clientID, err)
+		logger.Logger.Error("Ошибка поиска сервисного аккаунта",
+			zap.Error(msg),
+			zap.String("rep", "ServiceAccount.GetByClientID"))
+		return entity.ServiceAccount{}, msg
+	}
+	return account, nil
+}
+
+func (s *ServiceAccountRep) Create(account entity.ServiceAccount) (entity.ServiceAccount, error) {
+	query := `INSERT INTO service_accounts (name, client_id, client_secret, scopes, create_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id`
+	err := s.db.QueryRow(
+		query,
+		account.Name,
+		account.ClientID,
+		account.ClientSecret,
+		account.Scopes,
+		account.CreateAt).Scan(&account.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании сервисного аккаунта: %w", err)
+		logger.Logger.Error("Ошибка создания сервисного аккаунта",
+			zap.Error(msg),
+			zap.String("client_id", account.ClientID),
+			zap.String("rep", "ServiceAccount.Create"))
+		return entity.ServiceAccount{}, msg
+	}
+	return account, nil
+}
+
+func (s *ServiceAccountRep) Delete(id int) error {
+	query := `DELETE FROM service_accounts WHERE id = $1`
+	res, err := s.db.Exec(query, id)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка запроса на удаление сервисного аккаунта с ID = %d: %w", id, err)
+		logger.Logger.Error("Ошибка запроса на удаление",
+			zap.Error(msg),
+			zap.String("rep", "ServiceAccount.Delete"))
+		return msg
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при удалении сервисного аккаунта с ID = %d: %w", id, err)
+	}
+	if affected == 0 {
+		msg := fmt.Errorf("Сервисный аккаунт с ID = %d не существует", id)
+		logger.Logger.Error("Ошибка удаления сервисного аккаунта",
+			zap.Error(msg),
+			zap.String("rep", "ServiceAccount.Delete"))
+		return msg
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type TokenRepository interface {
+	GetAPIKeysByUser(userID int) ([]entity.APIKey, error)
+	GetAPIKeyByPrefix(prefix string) (entity.APIKey, error)
+	CreateAPIKey(key entity.APIKey) (entity.APIKey, error)
+	RevokeAPIKey(id int) error
+	RevokeToken(jti string, expiresAt int64) error
+	// ConsumeToken отзывает токен и сообщает, был ли он до этого действующим:
+	// из двух одновременных вызовов с одним jti true получит только один
+	ConsumeToken(jti string, expiresAt int64) (bool, error)
+	// IsRevoked сообщает, отозван ли токен по jti или выдан пользователю userID
+	// раньше смены его пароля
+	IsRevoked(jti string, userID int, issuedAt int64) (bool, error)
+}
+
+type TokenRep struct {
+	db *sql.DB
+}
+
+func NewTokenRep(db *sql.DB) TokenRep {
+	return TokenRep{db: db}
+}
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (entity.APIKey, error) {
+	var key entity.APIKey
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &key.Scopes, &key.ExpiresAt, &key.Revoked, &key.CreateAt)
+	return key, err
+}
+
+func (t *TokenRep) GetAPIKeysByUser(userID int) ([]entity.APIKey, error) {
+	query := `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, revoked, create_at
+			  FROM api_keys WHERE user_id = $1`
+	rows, err := t.db.Query(query, userID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения API ключей",
+			zap.Error(err),
+			zap.String("rep", "GetAPIKeysByUser"))
+		return nil, fmt.Errorf("Ошибка получения API ключей: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []entity.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения API ключа: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (t *TokenRep) GetAPIKeyByPrefix(prefix string) (entity.APIKey, error) {
+	// ключ удаленного пользователя не находится, даже если строка осталась в api_keys
+	query := `SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.expires_at, k.revoked, k.create_at
+			  FROM api_keys k
+			  JOIN users u ON u.id = k.user_id
+			  WHERE k.prefix = $1`
+	key, err := scanAPIKey(t.db.QueryRow(query, prefix))
+	if err != nil {
+		return entity.APIKey{}, fmt.Errorf("Ошибка получения API ключа prefix = %s -> %w", prefix, err)
+	}
+	return key, nil
+}
+
+func (t *TokenRep) CreateAPIKey(key entity.APIKey) (entity.APIKey, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return entity.APIKey{}, fmt.Errorf("Ошибка при создании API ключа: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  RETURNING id`
+	err = tx.QueryRow(
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+		key.ExpiresAt,
+		key.CreateAt).Scan(&key.ID)
+	if err == nil {
+		err = writeAccountEvent(tx, entity.UserEventAPIKeyCreated, key.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании API ключа: %w", err)
+		logger.Logger.Error("Ошибка создания API ключа",
+			zap.Error(msg),
+			zap.Int("user_id", key.UserID),
+			zap.String("rep", "CreateAPIKey"))
+		return entity.APIKey{}, msg
+	}
+	return key, nil
+}
+
+func (t *TokenRep) RevokeAPIKey(id int) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва API ключа с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE api_keys SET revoked = 1 WHERE id = $1 RETURNING user_id`
+	var userID int
+	err = tx.QueryRow(query, id).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("API ключ с ID = %d не существует", id)
+	}
+	if err == nil {
+		err = writeAccountEvent(tx, entity.UserEventAPIKeyRevoked, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка отзыва API ключа с ID = %d: %w", id, err)
+		logger.Logger.Error("Ошибка отзыва API ключа",
+			zap.Error(msg),
+			zap.String("rep", "RevokeAPIKey"))
+		return msg
+	}
+	return nil
+}
+
+func (t *TokenRep) RevokeToken(jti string, expiresAt int64) error {
+	_, err := t.ConsumeToken(jti, expiresAt)
+	return err
+}
+
+func (t *TokenRep) ConsumeToken(jti string, expiresAt int64) (bool, error) {
+	if _, err := t.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now().Unix()); err != nil {
+		logger.Logger.Warn("Ошибка очистки отозванных токенов",
+			zap.Error(err),
+			zap.String("rep", "RevokeToken"))
+	}
+
+	query := `INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)`
+	result, err := t.db.Exec(query, jti, expiresAt)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка отзыва токена: %w", err)
+		logger.Logger.Error("Ошибка отзыва токена",
+			zap.Error(msg),
+			zap.String("rep", "RevokeToken"))
+		return false, msg
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Ошибка отзыва токена: %w", err)
+	}
+	return affected == 1, nil
+}
+
+func (t *TokenRep) IsRevoked(jti string, userID int, issuedAt int64) (bool, error) {
+	var revoked bool
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			  OR EXISTS (SELECT 1 FROM users WHERE id = $2 AND tokens_valid_after > $3)`
+	if err := t.db.QueryRow(query, jti, userID, issuedAt).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("Ошибка проверки отзыва токена: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// UserEventRepository — лента событий для gRPC WatchUserEvents. Ее пополняет
+// relay outbox, сами изменения пользователей пишут события в outbox.
+type UserEventRepository interface {
+	// AppendEvents добавляет события в ленту; уже добавленные (по IdempotencyKey) пропускаются
+	AppendEvents(events []entity.UserEvent) error
+	// GetEventsAfter возвращает события с ID больше cursor по возрастанию ID
+	GetEventsAfter(cursor int64, limit int) ([]entity.UserEvent, error)
+	// OldestCursor возвращает ID самого старого сохраненного события (0, если событий нет)
+	OldestCursor() (int64, error)
+	LatestCursor() (int64, error)
+	DeleteEventsBefore(createAt int64) (int64, error)
+}
+
+type UserEventRep struct {
+	db *sql.DB
+}
+
+func NewUserEventRep(db *sql.DB) UserEventRep {
+	return UserEventRep{db: db}
+}
+
+const userEventColumns = `id, COALESCE(idempotency_key, ''), type, user_id, email, name, role, tenant, create_at`
+
+func scanUserEvent(row interface{ Scan(dest ...any) error }) (entity.UserEvent, error) {
+	var e entity.UserEvent
+	err := row.Scan(&e.ID, &e.IdempotencyKey, &e.Type, &e.UserID, &e.Email, &e.Name, &e.Role, &e.Tenant, &e.CreateAt)
+	return e, err
+}
+
+func (u *UserEventRep) AppendEvents(events []entity.UserEvent) error {
+	tx, err := u.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка записи событий пользователей: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT OR IGNORE INTO user_events (idempotency_key, type, user_id, email, name, role, tenant, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, e := range events {
+		if _, err = tx.Exec(query, e.IdempotencyKey, e.Type, e.UserID, e.Email, e.Name, e.Role, e.Tenant, e.CreateAt); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка записи событий пользователей: %w", err)
+		logger.Logger.Error("Ошибка записи событий пользователей",
+			zap.Error(msg),
+			zap.String("rep", "AppendEvents"))
+		return msg
+	}
+	return nil
+}
+
+func (u *UserEventRep) GetEventsAfter(cursor int64, limit int) ([]entity.UserEvent, error) {
+	return queryUserEvents(u.db, `SELECT `+userEventColumns+` FROM user_events WHERE id > $1 ORDER BY id LIMIT $2`, cursor, limit)
+}
+
+func queryUserEvents(db *sql.DB, query string, args ...any) ([]entity.UserEvent, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения событий пользователей",
+			zap.Error(err),
+			zap.String("rep", "queryUserEvents"))
+		return nil, fmt.Errorf("Ошибка получения событий пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	events := []entity.UserEvent{}
+	for rows.Next() {
+		e, err := scanUserEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения события пользователя: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (u *UserEventRep) OldestCursor() (int64, error) {
+	var id int64
+	err := u.db.QueryRow(`SELECT COALESCE(MIN(id), 0) FROM user_events`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка получения курсора событий: %w", err)
+	}
+	return id, nil
+}
+
+func (u *UserEventRep) LatestCursor() (int64, error) {
+	var id int64
+	err := u.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM user_events`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка получения курсора событий: %w", err)
+	}
+	return id, nil
+}
+
+func (u *UserEventRep) DeleteEventsBefore(createAt int64) (int64, error) {
+	res, err := u.db.Exec(`DELETE FROM user_events WHERE create_at < $1`, createAt)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка удаления старых событий пользователей: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -1,14 +1,23 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/LandGAA/authh2/internal/entity"
 	"github.com/LandGAA/authh2/pkg/logger"
 	"go.uber.org/zap"
+	"time"
 )
 
+// ErrGlobalAccount — изменение в контексте организации учетной записи, общей для всех
+// организаций (tenant_id = 0). Ее пароль, имя и email меняются только глобально.
+var ErrGlobalAccount = errors.New("Учетную запись, общую для всех организаций, нельзя изменить в организации")
+
+// ErrUserExists — email уже занят учетной записью, видимой в этом контексте.
+var ErrUserExists = errors.New("Пользователь с таким email уже зарегистрирован")
+
 type Repository interface {
 	GetAll() ([]entity.User, error)
 	GetByID(id int) (entity.User, error)
@@ -16,20 +25,67 @@ type Repository interface {
 	Delete(id int) error
 	Create(user entity.User) error
 	UpdatePassword(user entity.User) error
+	UpdateProfile(user entity.User) error
+	UpdateEmail(id int, email string) error
+	// Owned сообщает, можно ли менять пароль, имя и email пользователя в этом контексте:
+	// глобально — любого, в организации — только ее собственной учетной записи
+	Owned(id int) (bool, error)
+	WithTenant(org entity.Organization) Repository
+	// WithContext возвращает репозиторий, выполняющий запросы в контексте ctx
+	WithContext(ctx context.Context) Repository
 }
 
+// UserRepository без тенанта работает со всеми пользователями (глобальный контекст).
+// В контексте организации видны только ее участники, а роль берется из членства.
 type UserRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	tenant entity.Organization
+	ctx    context.Context
 }
 
 func NewRep(db *sql.DB) UserRepository {
 	return UserRepository{db: db}
 }
 
+// WithTenant возвращает репозиторий, ограниченный участниками организации.
+func (u *UserRepository) WithTenant(org entity.Organization) Repository {
+	return &UserRepository{db: u.db, tenant: org, ctx: u.ctx}
+}
+
+// WithContext возвращает репозиторий, запросы которого попадают в трассировку ctx.
+func (u *UserRepository) WithContext(ctx context.Context) Repository {
+	return &UserRepository{db: u.db, tenant: u.tenant, ctx: ctx}
+}
+
+func (u *UserRepository) context() context.Context {
+	if u.ctx == nil {
+		return context.Background()
+	}
+	return u.ctx
+}
+
+// selectUsers строит SELECT пользователей с условием where (колонки с префиксом users.).
+func (u *UserRepository) selectUsers(where string) string {
+	if u.tenant.ID == 0 {
+		return `SELECT users.id, users.name, users.email, users.password, users.role, users.create_at
+				FROM users WHERE ` + where
+	}
+	return fmt.Sprintf(`SELECT users.id, users.name, users.email, users.password, memberships.role, users.create_at
+				FROM users JOIN memberships ON memberships.user_id = users.id AND memberships.org_id = %d
+				WHERE `, u.tenant.ID) + where
+}
+
+func (u *UserRepository) scanUser(row interface{ Scan(dest ...any) error }) (entity.User, error) {
+	var user entity.User
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Role, &user.CreateAt)
+	user.Tenant = u.tenant.Slug
+	return user, err
+}
+
 func (u *UserRepository) GetAll() ([]entity.User, error) {
-	query := `SELECT * FROM users`
+	query := u.selectUsers(`1 = 1`)
 	logger.Logger.Debug("Получение всех пользователей")
-	rows, err := u.db.Query(query)
+	rows, err := u.db.QueryContext(u.context(), query)
 	if err != nil {
 		logger.Logger.Error("Ошибка получения пользователей",
 			zap.Error(err),
@@ -37,10 +93,12 @@ func (u *UserRepository) GetAll() ([]entity.User, error) {
 		return nil, fmt.Errorf("Ошибка получения пользователей: %w", err)
 	}
 
+	defer rows.Close()
+
 	var users []entity.User
 	for rows.Next() {
-		var user entity.User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Role, &user.CreateAt); err != nil {
+		user, err := u.scanUser(rows)
+		if err != nil {
 			msg := fmt.Errorf("Ошибка поиска пользователей: %w", err)
 
 			if errors.Is(err, sql.ErrNoRows) {
@@ -62,11 +120,9 @@ func (u *UserRepository) GetAll() ([]entity.User, error) {
 }
 
 func (u *UserRepository) GetByID(id int) (entity.User, error) {
-	query := `SELECT * FROM users WHERE id = $1`
-	row := u.db.QueryRow(query, id)
-
-	var user entity.User
-	if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Role, &user.CreateAt); err != nil {
+	query := u.selectUsers(`users.id = $1`)
+	user, err := u.scanUser(u.db.QueryRowContext(u.context(), query, id))
+	if err != nil {
 		msg := fmt.Errorf("Ошибка получения пользователя по ID = %d -> %w", id, err)
 
 		logger.Logger.Error("Ошибка поиска пользователя",
@@ -77,12 +133,12 @@ func (u *UserRepository) GetByID(id int) (entity.User, error) {
 	return user, nil
 }
 
+// GetByEmail ищет глобальную учетную запись, а в контексте организации — также
+// принадлежащую ей; собственная учетная запись организации важнее глобальной.
 func (u *UserRepository) GetByEmail(email string) (entity.User, error) {
-	query := `SELECT * FROM users WHERE email = $1`
-	row := u.db.QueryRow(query, email)
-
-	var user entity.User
-	if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Role, &user.CreateAt); err != nil {
+	query := u.selectUsers(`users.email = $1 AND users.tenant_id IN (0, $2) ORDER BY users.tenant_id DESC LIMIT 1`)
+	user, err := u.scanUser(u.db.QueryRowContext(u.context(), query, email, u.tenant.ID))
+	if err != nil {
 		msg := fmt.Errorf("Ошибка получения пользователя по email = %s -> %w", email, err)
 		logger.Logger.Error("Ошибка поиска пользователя",
 			zap.Error(msg),
@@ -92,9 +148,28 @@ func (u *UserRepository) GetByEmail(email string) (entity.User, error) {
 	return user, nil
 }
 
+// Delete в контексте организации удаляет ее собственную учетную запись,
+// а у глобальной — только членство в организации.
 func (u *UserRepository) Delete(id int) error {
+	// данные для события читаются до удаления; без них событие все равно пишется
+	deleted, _ := u.GetByID(id)
+	deleted.ID = id
+
+	tx, err := u.db.BeginTx(u.context(), nil)
+	if err != nil {
+		return fmt.Errorf("Ошибка запроса на удаление пользователя с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	// в организации сначала удаляется членство: собственная учетная запись организации
+	// удаляется после него, иначе каскад удалит членство раньше и проверка строк не пройдет
 	query := `DELETE FROM users WHERE id = $1`
-	rowEd, err := u.db.Exec(query, id)
+	args := []any{id}
+	if u.tenant.ID != 0 {
+		query = `DELETE FROM memberships WHERE user_id = $1 AND org_id = $2`
+		args = append(args, u.tenant.ID)
+	}
+	rowEd, err := tx.Exec(query, args...)
 	if err != nil {
 		msg := fmt.Errorf("Ошибка запроса на удаление пользователя с ID = %d", id)
 		logger.Logger.Error("Ошибка запроса на удаление",
@@ -119,40 +194,173 @@ func (u *UserRepository) Delete(id int) error {
 			zap.String("rep", "Delete"))
 		return msg
 	}
+	if u.tenant.ID != 0 {
+		if _, err := tx.Exec(`DELETE FROM users WHERE id = $1 AND tenant_id = $2`, id, u.tenant.ID); err != nil {
+			msg := fmt.Errorf("Ошибка запроса на удаление пользователя с ID = %d", id)
+			logger.Logger.Error("Ошибка запроса на удаление",
+				zap.Error(msg),
+				zap.String("rep", "Delete"))
+			return msg
+		}
+	}
+
+	// пользователь выходит из групп того контекста, из которого удален
+	groups := `DELETE FROM group_members WHERE user_id = $1`
+	if u.tenant.ID != 0 {
+		groups += ` AND group_id IN (SELECT id FROM user_groups WHERE tenant_id = $2)`
+	}
+	if _, err := tx.Exec(groups, args...); err != nil {
+		logger.Logger.Warn("Не удалось исключить удаленного пользователя из групп",
+			zap.Error(err),
+			zap.Int("user_id", id))
+	}
+	// атрибуты SCIM удаляются вместе с пользователем, иначе новый пользователь с тем же ID
+	// унаследует externalId и статус
+	scim := `DELETE FROM scim_resources WHERE type = 'User' AND resource_id = $1`
+	if u.tenant.ID != 0 {
+		scim += ` AND tenant_id = $2`
+	}
+	if _, err := tx.Exec(scim, args...); err != nil {
+		return fmt.Errorf("Ошибка удаления атрибутов SCIM пользователя с ID = %d: %w", id, err)
+	}
+	if err := u.recordEvent(tx, entity.UserEventDeleted, deleted); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка удаления пользователя с ID = %d: %w", id, err)
+	}
 	return nil
 }
 
+// Create в контексте организации делает пользователя ее участником с ролью user.Role;
+// глобальная роль такого пользователя — user.
 func (u *UserRepository) Create(user entity.User) error {
-	query := `INSERT INTO users (name, email, password, role, create_at)
-			  VALUES ($1, $2, $3, $4, $5)
-			  RETURNING id`
-	err := u.db.QueryRow(
-		query,
-		user.Name,
-		user.Email,
-		user.Password,
-		user.Role,
-		user.CreateAt).Scan(&user.ID)
+	tx, err := u.db.BeginTx(u.context(), nil)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании пользователя: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = insertUser(tx, u.tenant, user)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if uniqueViolation(err) {
+		return ErrUserExists
+	}
 	if err != nil {
 		msg := fmt.Errorf("Ошибка при создании пользователя: %w", err)
 		logger.Logger.Error("Ошибка создания пользователя",
 			zap.Error(msg),
-			zap.Any("user", user),
+			zap.String("email", user.Email),
+			zap.String("tenant", u.tenant.Slug),
 			zap.String("rep", "Create"))
 		return msg
 	}
 	return nil
 }
 
+// insertUser создает пользователя и событие о нем в транзакции tx. В организации tenant
+// пользователь становится ее участником с ролью user.Role, а его учетная запись —
+// собственной учетной записью организации, если у нее изолированные email.
+func insertUser(tx *sql.Tx, tenant entity.Organization, user entity.User) (entity.User, error) {
+	tenantID, globalRole := 0, user.Role
+	if tenant.ID != 0 {
+		globalRole = "user"
+		if tenant.IsolatedEmails {
+			tenantID = tenant.ID
+		}
+	}
+
+	err := tx.QueryRow(`INSERT INTO users (name, email, password, role, create_at, tenant_id)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id`,
+		user.Name,
+		user.Email,
+		user.Password,
+		globalRole,
+		user.CreateAt,
+		tenantID).Scan(&user.ID)
+	if err == nil && tenant.ID != 0 {
+		_, err = tx.Exec(`INSERT INTO memberships (org_id, user_id, role, create_at) VALUES ($1, $2, $3, $4)`,
+			tenant.ID, user.ID, user.Role, user.CreateAt)
+	}
+	if err == nil {
+		err = writeOutbox(tx, entity.UserEvent{
+			Type:   entity.UserEventCreated,
+			UserID: user.ID,
+			Email:  user.Email,
+			Name:   user.Name,
+			Role:   user.Role,
+			Tenant: tenant.Slug,
+		})
+	}
+	return user, err
+}
+
+// recordEvent пишет в outbox событие об изменении пользователя в контексте репозитория.
+// Вызывается в транзакции изменения: без события изменение не сохраняется.
+func (u *UserRepository) recordEvent(tx execer, eventType string, user entity.User) error {
+	err := writeOutbox(tx, entity.UserEvent{
+		Type:   eventType,
+		UserID: user.ID,
+		Email:  user.Email,
+		Name:   user.Name,
+		Role:   user.Role,
+		Tenant: u.tenant.Slug,
+	})
+	if err != nil {
+		logger.Logger.Error("Ошибка записи события пользователя",
+			zap.Error(err),
+			zap.Int("user_id", user.ID),
+			zap.String("rep", "recordEvent"))
+	}
+	return err
+}
+
+// ownedFilter ограничивает UPDATE учетными записями, принадлежащими организации.
+// Участник с глобальной учетной записью под него не попадает: ее данные общие
+// для всех организаций, где он состоит.
+func (u *UserRepository) ownedFilter() string {
+	if u.tenant.ID == 0 {
+		return ""
+	}
+	return fmt.Sprintf(` AND tenant_id = %d`, u.tenant.ID)
+}
+
+func (u *UserRepository) Owned(id int) (bool, error) {
+	var owned bool
+	err := u.db.QueryRowContext(u.context(), `SELECT COUNT(*) > 0 FROM users WHERE id = $1`+u.ownedFilter(), id).Scan(&owned)
+	if err != nil {
+		return false, fmt.Errorf("Ошибка проверки учетной записи пользователя с ID = %d: %w", id, err)
+	}
+	return owned, nil
+}
+
+// UpdatePassword в контексте организации меняет пароль только ее собственной учетной
+// записи, для глобальной возвращает ErrGlobalAccount.
 func (u *UserRepository) UpdatePassword(user entity.User) error {
+	changed, err := u.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := u.db.BeginTx(u.context(), nil)
+	if err != nil {
+		return fmt.Errorf("Ошибка отправки запроса на обновления данных, %w", err)
+	}
+	defer tx.Rollback()
+
+	// токены, выданные со старым паролем, отзываются вместе с его сменой
 	query := `UPDATE users
-			  SET password = $2
-		      WHERE id = $1`
+			  SET password = $2, tokens_valid_after = $3
+		      WHERE id = $1` + u.ownedFilter()
 
-	exec, err := u.db.Exec(
+	exec, err := tx.Exec(
 		query,
 		user.ID,
-		user.Password)
+		user.Password,
+		time.Now().Unix())
 
 	if err != nil {
 		msg := fmt.Errorf("Ошибка отправки запроса на обновления данных, %w", err)
@@ -171,11 +379,118 @@ func (u *UserRepository) UpdatePassword(user entity.User) error {
 		return msg
 	}
 
+	if affected == 0 && u.tenant.ID != 0 {
+		logger.Logger.Warn("Отказ в смене пароля глобальной учетной записи в организации",
+			zap.Int("user_id", user.ID),
+			zap.String("tenant", u.tenant.Slug),
+			zap.String("rep", "UpdatePassword"))
+		return ErrGlobalAccount
+	}
 	if affected == 0 {
 		msg := fmt.Errorf("0 измененных строк")
 		logger.Logger.Error("0 измененных строк",
 			zap.String("rep", "UpdatePassword"))
 		return msg
 	}
-	return nil
+	if err := u.recordEvent(tx, entity.UserEventPasswordChanged, changed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateProfile в контексте организации меняет роль в ней, а не глобальную. Имя там
+// меняется только у собственной учетной записи организации, для глобальной — ErrGlobalAccount.
+func (u *UserRepository) UpdateProfile(user entity.User) error {
+	before, err := u.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := u.db.BeginTx(u.context(), nil)
+	if err != nil {
+		return fmt.Errorf("Ошибка обновления профиля пользователя с ID = %d: %w", user.ID, err)
+	}
+	defer tx.Rollback()
+
+	if u.tenant.ID == 0 {
+		_, err = tx.Exec(`UPDATE users SET name = $2, role = $3 WHERE id = $1`, user.ID, user.Name, user.Role)
+	} else if before.Name != user.Name {
+		var res sql.Result
+		res, err = tx.Exec(`UPDATE users SET name = $2 WHERE id = $1`+u.ownedFilter(), user.ID, user.Name)
+		if err == nil {
+			if affected, _ := res.RowsAffected(); affected == 0 {
+				logger.Logger.Warn("Отказ в смене имени глобальной учетной записи в организации",
+					zap.Int("user_id", user.ID),
+					zap.String("tenant", u.tenant.Slug),
+					zap.String("rep", "UpdateProfile"))
+				return ErrGlobalAccount
+			}
+		}
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка обновления профиля пользователя с ID = %d: %w", user.ID, err)
+		logger.Logger.Error("Ошибка обновления профиля пользователя",
+			zap.Error(msg),
+			zap.String("rep", "UpdateProfile"))
+		return msg
+	}
+	if u.tenant.ID != 0 {
+		_, err := tx.Exec(`UPDATE memberships SET role = $3 WHERE org_id = $1 AND user_id = $2`,
+			u.tenant.ID, user.ID, user.Role)
+		if err != nil {
+			return fmt.Errorf("Ошибка обновления роли пользователя с ID = %d в организации: %w", user.ID, err)
+		}
+	}
+
+	user.Email = before.Email
+	if before.Name != user.Name {
+		if err := u.recordEvent(tx, entity.UserEventUpdated, user); err != nil {
+			return err
+		}
+	}
+	if before.Role != user.Role {
+		if err := u.recordEvent(tx, entity.UserEventRoleChanged, user); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateEmail в контексте организации меняет email только ее собственной учетной записи:
+// глобальные учетные записи общие для всех организаций.
+func (u *UserRepository) UpdateEmail(id int, email string) error {
+	before, err := u.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := u.db.BeginTx(u.context(), nil)
+	if err != nil {
+		return fmt.Errorf("Ошибка обновления email пользователя с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET email = $2 WHERE id = $1`+u.ownedFilter(), id, email)
+	if uniqueViolation(err) {
+		return ErrUserExists
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка обновления email пользователя с ID = %d: %w", id, err)
+		logger.Logger.Error("Ошибка обновления email пользователя",
+			zap.Error(msg),
+			zap.String("rep", "UpdateEmail"))
+		return msg
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 && u.tenant.ID != 0 {
+		return ErrGlobalAccount
+	}
+	if err := expectAffected(res, fmt.Sprintf("Пользователь с ID = %d не найден", id)); err != nil {
+		return err
+	}
+
+	before.Email = email
+	if err := u.recordEvent(tx, entity.UserEventUpdated, before); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
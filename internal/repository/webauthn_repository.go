@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type WebAuthnRepository interface {
+	GetCredentialsByUser(userID int) ([]entity.WebAuthnCredential, error)
+	GetCredentialByCredentialID(credentialID string) (entity.WebAuthnCredential, error)
+	CreateCredential(cred entity.WebAuthnCredential) (entity.WebAuthnCredential, error)
+	UpdateCredentialUsage(cred entity.WebAuthnCredential) error
+	DeleteCredential(userID int, id int) error
+	SaveSession(session entity.WebAuthnSession) error
+	TakeSession(id string) (entity.WebAuthnSession, error)
+	GetPasskeyRequired(userID int) (bool, error)
+	SetPasskeyRequired(userID int, required bool) error
+}
+
+type WebAuthnRep struct {
+	db *sql.DB
+}
+
+func NewWebAuthnRep(db *sql.DB) WebAuthnRep {
+	return WebAuthnRep{db: db}
+}
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, name, attestation_type, sign_count, data, create_at, COALESCE(last_used_at, '')`
+
+func scanWebAuthnCredential(row interface{ Scan(dest ...any) error }) (entity.WebAuthnCredential, error) {
+	var cred entity.WebAuthnCredential
+	err := row.Scan(&cred.ID, &cred.UserID, &cred.CredentialID, &cred.Name, &cred.AttestationType, &cred.SignCount, &cred.Data, &cred.CreateAt, &cred.LastUsedAt)
+	return cred, err
+}
+
+func (w *WebAuthnRep) GetCredentialsByUser(userID int) ([]entity.WebAuthnCredential, error) {
+	query := `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE user_id = $1`
+	rows, err := w.db.Query(query, userID)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения ключей WebAuthn",
+			zap.Error(err),
+			zap.String("rep", "GetCredentialsByUser"))
+		return nil, fmt.Errorf("Ошибка получения ключей WebAuthn: %w", err)
+	}
+	defer rows.Close()
+
+	creds := []entity.WebAuthnCredential{}
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения ключа WebAuthn: %w", err)
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+func (w *WebAuthnRep) GetCredentialByCredentialID(credentialID string) (entity.WebAuthnCredential, error) {
+	query := `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE credential_id = $1`
+	cred, err := scanWebAuthnCredential(w.db.QueryRow(query, credentialID))
+	if err != nil {
+		return entity.WebAuthnCredential{}, fmt.Errorf("Ошибка получения ключа WebAuthn -> %w", err)
+	}
+	return cred, nil
+}
+
+func (w *WebAuthnRep) CreateCredential(cred entity.WebAuthnCredential) (entity.WebAuthnCredential, error) {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return entity.WebAuthnCredential{}, fmt.Errorf("Ошибка при сохранении ключа WebAuthn: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO webauthn_credentials (user_id, credential_id, name, attestation_type, sign_count, data, create_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  RETURNING id`
+	err = tx.QueryRow(
+		query,
+		cred.UserID,
+		cred.CredentialID,
+		cred.Name,
+		cred.AttestationType,
+		cred.SignCount,
+		cred.Data,
+		cred.CreateAt).Scan(&cred.ID)
+	if err == nil {
+		err = writeAccountEvent(tx, entity.UserEventPasskeyAdded, cred.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при сохранении ключа WebAuthn: %w", err)
+		logger.Logger.Error("Ошибка сохранения ключа WebAuthn",
+			zap.Error(msg),
+			zap.Int("user_id", cred.UserID),
+			zap.String("rep", "CreateCredential"))
+		return entity.WebAuthnCredential{}, msg
+	}
+	return cred, nil
+}
+
+func (w *WebAuthnRep) UpdateCredentialUsage(cred entity.WebAuthnCredential) error {
+	query := `UPDATE webauthn_credentials
+			  SET sign_count = $2, data = $3, last_used_at = $4
+			  WHERE id = $1`
+	if _, err := w.db.Exec(query, cred.ID, cred.SignCount, cred.Data, time.Now().String()); err != nil {
+		msg := fmt.Errorf("Ошибка обновления ключа WebAuthn: %w", err)
+		logger.Logger.Error("Ошибка обновления ключа WebAuthn",
+			zap.Error(msg),
+			zap.String("rep", "UpdateCredentialUsage"))
+		return msg
+	}
+	return nil
+}
+
+func (w *WebAuthnRep) DeleteCredential(userID int, id int) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления ключа WebAuthn с ID = %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`
+	res, err := tx.Exec(query, id, userID)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления ключа WebAuthn с ID = %d: %w", id, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Ошибка получения измененных строк при удалении ключа WebAuthn: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("Ключ WebAuthn с ID = %d не найден: %w", id, sql.ErrNoRows)
+	}
+	if err := writeAccountEvent(tx, entity.UserEventPasskeyRemoved, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (w *WebAuthnRep) SaveSession(session entity.WebAuthnSession) error {
+	if _, err := w.db.Exec(`DELETE FROM webauthn_sessions WHERE expires_at < $1`, time.Now().Unix()); err != nil {
+		logger.Logger.Warn("Ошибка очистки сессий WebAuthn",
+			zap.Error(err),
+			zap.String("rep", "SaveSession"))
+	}
+
+	query := `INSERT INTO webauthn_sessions (id, user_id, purpose, data, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := w.db.Exec(query, session.ID, session.UserID, session.Purpose, session.Data, session.ExpiresAt); err != nil {
+		msg := fmt.Errorf("Ошибка сохранения сессии WebAuthn: %w", err)
+		logger.Logger.Error("Ошибка сохранения сессии WebAuthn",
+			zap.Error(msg),
+			zap.String("rep", "SaveSession"))
+		return msg
+	}
+	return nil
+}
+
+// TakeSession достает сессию и сразу удаляет ее: challenge одноразовый.
+func (w *WebAuthnRep) TakeSession(id string) (entity.WebAuthnSession, error) {
+	query := `DELETE FROM webauthn_sessions WHERE id = $1 AND expires_at >= $2
+			  RETURNING id, user_id, purpose, data, expires_at`
+	var session entity.WebAuthnSession
+	err := w.db.QueryRow(query, id, time.Now().Unix()).Scan(&session.ID, &session.UserID, &session.Purpose, &session.Data, &session.ExpiresAt)
+	if err != nil {
+		return entity.WebAuthnSession{}, fmt.Errorf("Сессия WebAuthn не найдена или истекла: %w", err)
+	}
+	return session, nil
+}
+
+func (w *WebAuthnRep) GetPasskeyRequired(userID int) (bool, error) {
+	var required bool
+	err := w.db.QueryRow(`SELECT passkey_required FROM user_mfa WHERE user_id = $1`, userID).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Ошибка получения настроек MFA: %w", err)
+	}
+	return required, nil
+}
+
+func (w *WebAuthnRep) SetPasskeyRequired(userID int, required bool) error {
+	query := `INSERT INTO user_mfa (user_id, passkey_required) VALUES ($1, $2)
+			  ON CONFLICT (user_id) DO UPDATE SET passkey_required = excluded.passkey_required`
+	if _, err := w.db.Exec(query, userID, required); err != nil {
+		msg := fmt.Errorf("Ошибка сохранения настроек MFA: %w", err)
+		logger.Logger.Error("Ошибка сохранения настроек MFA",
+			zap.Error(msg),
+			zap.String("rep", "SetPasskeyRequired"))
+		return msg
+	}
+	return nil
+}
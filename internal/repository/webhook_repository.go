@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+type WebhookRepository interface {
+	GetWebhooks() ([]entity.Webhook, error)
+	GetWebhook(id int) (entity.Webhook, error)
+	CreateWebhook(webhook entity.Webhook) (entity.Webhook, error)
+	UpdateWebhook(webhook entity.Webhook) error
+	// DeleteWebhook удаляет подписку вместе с ее доставками и журналом
+	DeleteWebhook(id int) error
+
+	// EnqueueDeliveries ставит доставки в очередь; доставка того же события той же подписке пропускается
+	EnqueueDeliveries(deliveries []entity.WebhookDelivery) error
+	// GetDueDeliveries возвращает ожидающие доставки активных подписок, время попытки которых наступило
+	GetDueDeliveries(now int64, limit int) ([]entity.WebhookDelivery, error)
+	// RecordAttempt пишет попытку в журнал и сохраняет новое состояние доставки
+	RecordAttempt(delivery entity.WebhookDelivery, attempt entity.WebhookAttempt) error
+
+	GetDeliveries(webhookID int, status string, limit int) ([]entity.WebhookDelivery, error)
+	GetDelivery(webhookID int, id int64) (entity.WebhookDelivery, error)
+	GetAttempts(deliveryID int64) ([]entity.WebhookAttempt, error)
+	// Redeliver возвращает доставку в очередь с обнуленным счетчиком попыток
+	Redeliver(webhookID int, id int64) error
+}
+
+type WebhookRep struct {
+	db *sql.DB
+}
+
+func NewWebhookRep(db *sql.DB) WebhookRep {
+	return WebhookRep{db: db}
+}
+
+const webhookColumns = `id, url, events, secret, active, create_at`
+
+const webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status, last_error, create_at, update_at`
+
+func scanWebhook(row interface{ Scan(dest ...any) error }) (entity.Webhook, error) {
+	var w entity.Webhook
+	err := row.Scan(&w.ID, &w.URL, &w.Events, &w.Secret, &w.Active, &w.CreateAt)
+	return w, err
+}
+
+func scanWebhookDelivery(row interface{ Scan(dest ...any) error }) (entity.WebhookDelivery, error) {
+	var d entity.WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.CreateAt, &d.UpdateAt)
+	return d, err
+}
+
+func (w *WebhookRep) GetWebhooks() ([]entity.Webhook, error) {
+	rows, err := w.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения вебхуков",
+			zap.Error(err),
+			zap.String("rep", "GetWebhooks"))
+		return nil, fmt.Errorf("Ошибка получения вебхуков: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []entity.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения вебхука: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (w *WebhookRep) GetWebhook(id int) (entity.Webhook, error) {
+	webhook, err := scanWebhook(w.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err != nil {
+		return entity.Webhook{}, fmt.Errorf("Ошибка получения вебхука с ID = %d -> %w", id, err)
+	}
+	return webhook, nil
+}
+
+func (w *WebhookRep) CreateWebhook(webhook entity.Webhook) (entity.Webhook, error) {
+	query := `INSERT INTO webhooks (url, events, secret, active, create_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id`
+	err := w.db.QueryRow(query, webhook.URL, webhook.Events, webhook.Secret, webhook.Active, webhook.CreateAt).Scan(&webhook.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при создании вебхука: %w", err)
+		logger.Logger.Error("Ошибка создания вебхука",
+			zap.Error(msg),
+			zap.String("url", webhook.URL),
+			zap.String("rep", "CreateWebhook"))
+		return entity.Webhook{}, msg
+	}
+	return webhook, nil
+}
+
+func (w *WebhookRep) UpdateWebhook(webhook entity.Webhook) error {
+	query := `UPDATE webhooks SET url = $1, events = $2, active = $3 WHERE id = $4`
+	res, err := w.db.Exec(query, webhook.URL, webhook.Events, webhook.Active, webhook.ID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка при изменении вебхука: %w", err)
+		logger.Logger.Error("Ошибка изменения вебхука",
+			zap.Error(msg),
+			zap.Int("webhook_id", webhook.ID),
+			zap.String("rep", "UpdateWebhook"))
+		return msg
+	}
+	return expectAffected(res, fmt.Sprintf("Вебхук с ID = %d не найден", webhook.ID))
+}
+
+func (w *WebhookRep) DeleteWebhook(id int) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления вебхука: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err == nil {
+		err = expectAffected(res, fmt.Sprintf("Вебхук с ID = %d не найден", id))
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = $1)`, id)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		msg := fmt.Errorf("Ошибка удаления вебхука с ID = %d: %w", id, err)
+		logger.Logger.Error("Ошибка удаления вебхука",
+			zap.Error(msg),
+			zap.String("rep", "DeleteWebhook"))
+		return msg
+	}
+	return nil
+}
+
+func (w *WebhookRep) EnqueueDeliveries(deliveries []entity.WebhookDelivery) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка постановки доставок в очередь: %w", err)
+	}
+	defer tx.Rollback()
+
+	// повторная постановка того же события той же подписке игнорируется
+	query := `INSERT OR IGNORE INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, create_at, update_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`
+	for _, d := range deliveries {
+		if _, err = tx.Exec(query, d.WebhookID, d.EventID, d.EventType, d.Payload, entity.WebhookDeliveryPending, d.NextAttemptAt, d.CreateAt); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка постановки доставок в очередь: %w", err)
+		logger.Logger.Error("Ошибка постановки доставок вебхуков в очередь",
+			zap.Error(msg),
+			zap.Int("count", len(deliveries)),
+			zap.String("rep", "EnqueueDeliveries"))
+		return msg
+	}
+	return nil
+}
+
+func (w *WebhookRep) queryDeliveries(query string, args ...any) ([]entity.WebhookDelivery, error) {
+	rows, err := w.db.Query(query, args...)
+	if err != nil {
+		logger.Logger.Error("Ошибка получения доставок вебхуков",
+			zap.Error(err),
+			zap.String("rep", "queryDeliveries"))
+		return nil, fmt.Errorf("Ошибка получения доставок вебхуков: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []entity.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения доставки вебхука: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (w *WebhookRep) GetDueDeliveries(now int64, limit int) ([]entity.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+			  WHERE status = $1 AND next_attempt_at <= $2
+			    AND webhook_id IN (SELECT id FROM webhooks WHERE active = 1)
+			  ORDER BY next_attempt_at, id LIMIT $3`
+	return w.queryDeliveries(query, entity.WebhookDeliveryPending, now, limit)
+}
+
+func (w *WebhookRep) RecordAttempt(delivery entity.WebhookDelivery, attempt entity.WebhookAttempt) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка записи попытки доставки: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO webhook_attempts (delivery_id, status, error, duration_ms, create_at)
+			  VALUES ($1, $2, $3, $4, $5)`,
+		delivery.ID, attempt.Status, attempt.Error, attempt.DurationMs, attempt.CreateAt)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE webhook_deliveries
+				  SET status = $1, attempts = $2, next_attempt_at = $3, last_status = $4, last_error = $5, update_at = $6
+				  WHERE id = $7`,
+			delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatus, delivery.LastError, attempt.CreateAt, delivery.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg := fmt.Errorf("Ошибка записи попытки доставки: %w", err)
+		logger.Logger.Error("Ошибка записи попытки доставки вебхука",
+			zap.Error(msg),
+			zap.Int64("delivery_id", delivery.ID),
+			zap.String("rep", "RecordAttempt"))
+		return msg
+	}
+	return nil
+}
+
+// GetDeliveries отдает последние доставки подписки; пустой status — в любом состоянии.
+func (w *WebhookRep) GetDeliveries(webhookID int, status string, limit int) ([]entity.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+			  WHERE webhook_id = $1 AND ($2 = '' OR status = $2) ORDER BY id DESC LIMIT $3`
+	return w.queryDeliveries(query, webhookID, status, limit)
+}
+
+func (w *WebhookRep) GetDelivery(webhookID int, id int64) (entity.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2`
+	d, err := scanWebhookDelivery(w.db.QueryRow(query, id, webhookID))
+	if err != nil {
+		return entity.WebhookDelivery{}, fmt.Errorf("Ошибка получения доставки с ID = %d -> %w", id, err)
+	}
+	return d, nil
+}
+
+func (w *WebhookRep) GetAttempts(deliveryID int64) ([]entity.WebhookAttempt, error) {
+	query := `SELECT id, delivery_id, status, error, duration_ms, create_at
+			  FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id`
+	rows, err := w.db.Query(query, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка получения журнала доставки: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []entity.WebhookAttempt{}
+	for rows.Next() {
+		var a entity.WebhookAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.Status, &a.Error, &a.DurationMs, &a.CreateAt); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения журнала доставки: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func (w *WebhookRep) Redeliver(webhookID int, id int64) error {
+	now := time.Now().Unix()
+	query := `UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = $2, update_at = $2
+			  WHERE id = $3 AND webhook_id = $4`
+	res, err := w.db.Exec(query, entity.WebhookDeliveryPending, now, id, webhookID)
+	if err != nil {
+		msg := fmt.Errorf("Ошибка повторной отправки доставки с ID = %d: %w", id, err)
+		logger.Logger.Error("Ошибка повторной отправки доставки вебхука",
+			zap.Error(msg),
+			zap.String("rep", "Redeliver"))
+		return msg
+	}
+	return expectAffected(res, fmt.Sprintf("Доставка с ID = %d не найдена", id))
+}
@@ -0,0 +1,35 @@
+package usecase
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"time"
+)
+
+const auditDefaultLimit = 100
+
+type AuditUseCase interface {
+	// Record пишет событие в журнал. Ошибка записи не прерывает действие, а только логируется
+	Record(event entity.AuditEvent)
+	GetEvents(filter entity.AuditFilter) ([]entity.AuditEvent, error)
+}
+
+type AuditUseCaseImpl struct {
+	repo repository.AuditRepository
+}
+
+func NewAuditUseCase(repo repository.AuditRepository) AuditUseCase {
+	return &AuditUseCaseImpl{repo: repo}
+}
+
+func (a *AuditUseCaseImpl) Record(event entity.AuditEvent) {
+	event.CreateAt = time.Now().String()
+	_ = a.repo.CreateEvent(event)
+}
+
+func (a *AuditUseCaseImpl) GetEvents(filter entity.AuditFilter) ([]entity.AuditEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = auditDefaultLimit
+	}
+	return a.repo.GetEvents(filter)
+}
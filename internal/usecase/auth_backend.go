@@ -0,0 +1,273 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/ldapauth"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// ConnectorLDAP — имя коннектора в user_identities для пользователей из LDAP, subject — DN.
+const ConnectorLDAP = "ldap"
+
+var ErrorUnknownUser = fmt.Errorf("Пользователь не найден")
+
+// AuthBackend проверяет пароль пользователя. UserUseCase.Authenticate опрашивает бэкенды
+// по порядку до первого успешного.
+type AuthBackend interface {
+	Name() string
+	Authenticate(email string, password string) (entity.User, error)
+	// WithTenant возвращает бэкенд, работающий с пользователями организации
+	WithTenant(org entity.Organization) AuthBackend
+	// WithContext возвращает бэкенд, запросы которого к базе попадают в трассировку ctx
+	WithContext(ctx context.Context) AuthBackend
+}
+
+// LocalBackend проверяет bcrypt-хеш пароля из таблицы users. Если передан identities,
+// пользователи из LDAP локальным паролем не входят.
+type LocalBackend struct {
+	repo       repository.Repository
+	identities repository.IdentityRepository
+}
+
+func NewLocalBackend(repo repository.Repository, identities repository.IdentityRepository) AuthBackend {
+	return &LocalBackend{repo: repo, identities: identities}
+}
+
+func (b *LocalBackend) Name() string { return "local" }
+
+func (b *LocalBackend) WithTenant(org entity.Organization) AuthBackend {
+	return &LocalBackend{repo: b.repo.WithTenant(org), identities: b.identities}
+}
+
+func (b *LocalBackend) WithContext(ctx context.Context) AuthBackend {
+	return &LocalBackend{repo: b.repo.WithContext(ctx), identities: b.identities}
+}
+
+func (b *LocalBackend) Authenticate(email string, password string) (entity.User, error) {
+	user, err := b.repo.GetByEmail(email)
+	if err != nil {
+		return entity.User{}, ErrorUnknownUser
+	}
+
+	if b.identities != nil {
+		linked, err := b.identities.GetIdentitiesByUser(user.ID)
+		if err != nil {
+			return entity.User{}, err
+		}
+		for _, identity := range linked {
+			if identity.Connector == ConnectorLDAP {
+				return entity.User{}, ErrorUnknownUser
+			}
+		}
+	}
+
+	start := time.Now()
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	metrics.ObserveBcrypt("compare", start)
+	if err != nil {
+		return entity.User{}, ErrorWrongPassword
+	}
+	return user, nil
+}
+
+// LDAPBackend проверяет пароль в LDAP и поддерживает локальную копию пользователя:
+// создает ее при первом входе и обновляет имя, а у созданных из LDAP — и роль по группам.
+type LDAPBackend struct {
+	client      *ldapauth.Client
+	users       repository.Repository
+	identities  repository.IdentityRepository
+	createUsers bool
+}
+
+func NewLDAPBackend(client *ldapauth.Client, users repository.Repository, identities repository.IdentityRepository, createUsers bool) *LDAPBackend {
+	return &LDAPBackend{client: client, users: users, identities: identities, createUsers: createUsers}
+}
+
+func (b *LDAPBackend) Name() string { return ConnectorLDAP }
+
+// WithTenant ограничивает LDAP участниками организации; пользователь, созданный
+// при первом входе, становится ее участником.
+func (b *LDAPBackend) WithTenant(org entity.Organization) AuthBackend {
+	return &LDAPBackend{client: b.client, users: b.users.WithTenant(org), identities: b.identities, createUsers: b.createUsers}
+}
+
+func (b *LDAPBackend) WithContext(ctx context.Context) AuthBackend {
+	return &LDAPBackend{client: b.client, users: b.users.WithContext(ctx), identities: b.identities, createUsers: b.createUsers}
+}
+
+func (b *LDAPBackend) Authenticate(email string, password string) (entity.User, error) {
+	entry, err := b.client.Authenticate(email, password)
+	switch {
+	case errors.Is(err, ldapauth.ErrorInvalidCredentials):
+		return entity.User{}, ErrorWrongPassword
+	case errors.Is(err, ldapauth.ErrorUserNotFound):
+		return entity.User{}, ErrorUnknownUser
+	case err != nil:
+		return entity.User{}, err
+	}
+	return b.upsert(entry, b.createUsers)
+}
+
+// upsert находит локальную копию записи каталога по DN или создает ее. Локальный
+// пользователь с тем же email, но без привязки к LDAP, не привязывается: иначе запись
+// каталога с чужим email получила бы его учетную запись, в том числе администратора.
+// Такой пользователь продолжает входить локальным паролем.
+func (b *LDAPBackend) upsert(entry ldapauth.Entry, create bool) (entity.User, error) {
+	if entry.Email == "" {
+		return entity.User{}, fmt.Errorf("у записи LDAP %s нет email", entry.DN)
+	}
+	role := b.client.Role(entry)
+
+	if identity, err := b.identities.GetIdentity(ConnectorLDAP, entry.DN); err == nil {
+		// в контексте организации пользователь не найден, если он не ее участник
+		user, err := b.users.GetByID(identity.UserID)
+		if err != nil {
+			return entity.User{}, ErrorUnknownUser
+		}
+		return b.updateProfile(user, entry, role, identity.Provisioned)
+	}
+
+	if user, err := b.users.GetByEmail(entry.Email); err == nil {
+		logger.Logger.Warn("Локальный пользователь с email из LDAP не привязан к каталогу",
+			zap.Int("user_id", user.ID),
+			zap.String("dn", entry.DN))
+		return entity.User{}, ErrorUnknownUser
+	}
+
+	if !create {
+		return entity.User{}, ErrorUnknownUser
+	}
+	password, err := randomHex(32)
+	if err != nil {
+		return entity.User{}, err
+	}
+	start := time.Now()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	metrics.ObserveBcrypt("hash", start)
+	if err != nil {
+		return entity.User{}, err
+	}
+	name := entry.Name
+	if name == "" {
+		name = entry.Email
+	}
+
+	err = b.users.Create(entity.User{Name: name, Email: entry.Email, Password: string(hash), Role: role, CreateAt: time.Now().String()})
+	if err != nil {
+		return entity.User{}, err
+	}
+	user, err := b.users.GetByEmail(entry.Email)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if err := b.link(user.ID, entry); err != nil {
+		return entity.User{}, err
+	}
+	logger.Logger.Info("Создан пользователь из LDAP",
+		zap.Int("user_id", user.ID),
+		zap.String("dn", entry.DN))
+	return user, nil
+}
+
+func (b *LDAPBackend) link(userID int, entry ldapauth.Entry) error {
+	_, err := b.identities.CreateIdentity(entity.UserIdentity{
+		UserID:      userID,
+		Connector:   ConnectorLDAP,
+		Subject:     entry.DN,
+		Email:       entry.Email,
+		Provisioned: true,
+		CreateAt:    time.Now().String(),
+	})
+	return err
+}
+
+// updateProfile обновляет имя по каталогу. Роль по группам меняется только у пользователей,
+// созданных из LDAP (managed): роль, выданная локально, каталогом не повышается и не понижается.
+func (b *LDAPBackend) updateProfile(user entity.User, entry ldapauth.Entry, role string, managed bool) (entity.User, error) {
+	name := entry.Name
+	if name == "" {
+		name = user.Name
+	}
+	if !managed {
+		if user.Role != role {
+			logger.Logger.Info("Роль из групп LDAP не применена: пользователь создан не из LDAP",
+				zap.Int("user_id", user.ID),
+				zap.String("role", user.Role),
+				zap.String("ldap_role", role))
+		}
+		role = user.Role
+	}
+	if user.Name == name && user.Role == role {
+		return user, nil
+	}
+
+	if user.Role != role {
+		logger.Logger.Warn("Роль пользователя изменена по группам LDAP",
+			zap.Int("user_id", user.ID),
+			zap.String("old_role", user.Role),
+			zap.String("role", role))
+	}
+	oldName := user.Name
+	user.Name, user.Role = name, role
+	err := b.users.UpdateProfile(user)
+	if errors.Is(err, repository.ErrGlobalAccount) {
+		// в организации имя глобальной учетной записи не меняется, роль в ней — меняется
+		user.Name = oldName
+		err = b.users.UpdateProfile(user)
+	}
+	if err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
+}
+
+// Sync обновляет имена и роли пользователей по каталогу. Новые пользователи создаются,
+// если включен create_users.
+func (b *LDAPBackend) Sync() error {
+	entries, err := b.client.Search()
+	if err != nil {
+		return err
+	}
+
+	synced := 0
+	for _, entry := range entries {
+		if _, err := b.upsert(entry, b.createUsers); err != nil {
+			if !errors.Is(err, ErrorUnknownUser) {
+				logger.Logger.Warn("Ошибка синхронизации пользователя LDAP",
+					zap.Error(err),
+					zap.String("dn", entry.DN))
+			}
+			continue
+		}
+		synced++
+	}
+	logger.Logger.Info("Синхронизация LDAP завершена",
+		zap.Int("entries", len(entries)),
+		zap.Int("synced", synced))
+	return nil
+}
+
+// RunSync запускает Sync каждые interval, пока не отменен ctx. Блокирует, вызывается
+// в отдельной горутине.
+func (b *LDAPBackend) RunSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Sync(); err != nil {
+			logger.Logger.Error("Ошибка синхронизации LDAP", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/ldapauth"
+	"github.com/LandGAA/authh2/pkg/ldapauth/ldaptest"
+	"testing"
+	"time"
+)
+
+const (
+	ldapAdmins = "cn=admins,ou=groups,dc=example,dc=com"
+	ldapDevs   = "cn=devs,ou=groups,dc=example,dc=com"
+)
+
+type ldapFixture struct {
+	dir        *ldaptest.Directory
+	backend    *LDAPBackend
+	users      *UserUseCase
+	identities repository.IdentityRepository
+}
+
+// newLDAPFixture — LDAP (группа admins → admin, devs → manager), затем локальная проверка пароля.
+func newLDAPFixture(t *testing.T, entries ...ldaptest.User) ldapFixture {
+	t.Helper()
+	dir := ldaptest.Start(t, "cn=admin,dc=example,dc=com", "service-secret", entries...)
+	client, err := ldapauth.New(config.LDAP{
+		URL:            dir.URL,
+		Timeout:        2 * time.Second,
+		Mode:           "search",
+		BindDN:         dir.BindDN,
+		BindPassword:   dir.BindPassword,
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(mail={login})",
+		SyncFilter:     "(mail=*)",
+		EmailAttribute: ldaptest.EmailAttribute,
+		NameAttribute:  ldaptest.NameAttribute,
+		GroupAttribute: ldaptest.GroupAttribute,
+		GroupRoles: []config.GroupRole{
+			{Group: ldapAdmins, Role: "admin"},
+			{Group: ldapDevs, Role: "manager"},
+		},
+		DefaultRole: "user",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	identities := repository.NewIdentityRep(db)
+	backend := NewLDAPBackend(client, &users, &identities, true)
+	uc := NewUserUseCase(&users, backend, NewLocalBackend(&users, &identities)).(*UserUseCase)
+	return ldapFixture{dir: dir, backend: backend, users: uc, identities: &identities}
+}
+
+func (f ldapFixture) login(email string, password string) (entity.User, error) {
+	return f.users.authenticate(context.Background(), email, password)
+}
+
+func TestLDAPDoesNotTakeOverLocalAccounts(t *testing.T) {
+	// записи каталога с email существующих пользователей, в том числе администратора из миграций
+	f := newLDAPFixture(t,
+		ldaptest.User{DN: "uid=intruder,ou=people,dc=example,dc=com", Password: "dir-pass", Mail: "admin@a.com", Name: "Intruder"},
+		ldaptest.User{DN: "uid=local,ou=people,dc=example,dc=com", Password: "dir-pass", Mail: "local@a.com", Name: "Directory", Groups: []string{ldapAdmins}},
+	)
+	local := createGlobalUser(t, f.users, "local@a.com", "manager")
+
+	for _, email := range []string{"admin@a.com", local.Email} {
+		if _, err := f.backend.Authenticate(email, "dir-pass"); !errors.Is(err, ErrorUnknownUser) {
+			t.Fatalf("%s: LDAP вход в локальную учетную запись: %v", email, err)
+		}
+		if _, err := f.login(email, "dir-pass"); !errors.Is(err, ErrorWrongPassword) {
+			t.Fatalf("%s: вход паролем каталога: %v", email, err)
+		}
+	}
+
+	admin, err := f.users.GetUserByID(1)
+	if err != nil || admin.Role != "admin" {
+		t.Fatalf("администратор изменен: %+v, %v", admin, err)
+	}
+	unchanged, err := f.users.GetUserByID(local.ID)
+	if err != nil || unchanged.Role != "manager" || unchanged.Name != local.Name {
+		t.Fatalf("локальный пользователь изменен: %+v, %v", unchanged, err)
+	}
+	for _, id := range []int{1, local.ID} {
+		if linked, _ := f.identities.GetIdentitiesByUser(id); len(linked) != 0 {
+			t.Fatalf("пользователь %d привязан к LDAP: %+v", id, linked)
+		}
+	}
+
+	// локальный пароль продолжает работать
+	user, err := f.login(local.Email, "password")
+	if err != nil || user.ID != local.ID {
+		t.Fatalf("локальный вход: %+v, %v", user, err)
+	}
+}
+
+func TestLDAPProvisionedUserFollowsGroups(t *testing.T) {
+	carol := ldaptest.User{DN: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-pass", Mail: "carol@a.com", Name: "Carol", Groups: []string{ldapDevs}}
+	f := newLDAPFixture(t, carol)
+
+	user, err := f.login(carol.Mail, carol.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "manager" || user.Name != "Carol" {
+		t.Fatalf("созданный пользователь %+v", user)
+	}
+	linked, err := f.identities.GetIdentitiesByUser(user.ID)
+	if err != nil || len(linked) != 1 || linked[0].Subject != carol.DN || !linked[0].Provisioned {
+		t.Fatalf("привязки %+v, %v", linked, err)
+	}
+
+	// у созданного из LDAP пользователя роль и имя следуют за каталогом
+	carol.Groups, carol.Name = []string{ldapAdmins}, "Carol Admin"
+	f.dir.SetUsers(carol)
+	if user, err = f.login(carol.Mail, carol.Password); err != nil || user.Role != "admin" || user.Name != "Carol Admin" {
+		t.Fatalf("после смены групп: %+v, %v", user, err)
+	}
+
+	carol.Groups = nil
+	f.dir.SetUsers(carol)
+	if err := f.backend.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = f.users.GetUserByID(user.ID); err != nil || user.Role != "user" {
+		t.Fatalf("после синхронизации: %+v, %v", user, err)
+	}
+
+	// пользователю из LDAP локальный пароль недоступен
+	if _, err := f.login(carol.Mail, "wrong"); !errors.Is(err, ErrorWrongPassword) {
+		t.Fatalf("неверный пароль: %v", err)
+	}
+}
+
+// Пользователь, привязанный к каталогу, но созданный локально, входит через LDAP,
+// а роль, выданная локально, каталогом не меняется.
+func TestLDAPKeepsRoleOfLinkedLocalAccount(t *testing.T) {
+	dave := ldaptest.User{DN: "uid=dave,ou=people,dc=example,dc=com", Password: "dave-pass", Mail: "dave@a.com", Name: "Dave LDAP"}
+	f := newLDAPFixture(t, dave)
+	local := createGlobalUser(t, f.users, dave.Mail, "manager")
+	if _, err := f.identities.CreateIdentity(entity.UserIdentity{
+		UserID:    local.ID,
+		Connector: ConnectorLDAP,
+		Subject:   dave.DN,
+		Email:     dave.Mail,
+		CreateAt:  time.Now().String(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, groups := range [][]string{nil, {ldapAdmins}} {
+		dave.Groups = groups
+		f.dir.SetUsers(dave)
+		user, err := f.login(dave.Mail, dave.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != local.ID || user.Role != "manager" || user.Name != "Dave LDAP" {
+			t.Fatalf("группы %v: %+v", groups, user)
+		}
+	}
+	if err := f.backend.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := f.users.GetUserByID(local.ID); user.Role != "manager" {
+		t.Fatalf("роль после синхронизации %q", user.Role)
+	}
+}
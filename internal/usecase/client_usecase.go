@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorInvalidClient = fmt.Errorf("Неверный client_id или client_secret")
+	ErrorInvalidScope  = fmt.Errorf("Запрошенный scope не разрешен клиенту")
+
+	ErrorInvalidSubjectToken = fmt.Errorf("Неверный subject_token: нужен действующий access токен пользователя")
+	ErrorInvalidTarget       = fmt.Errorf("Обмен токена для этой аудитории не разрешен")
+)
+
+// TokenTypeAccessToken — тип токена RFC 8693 для subject_token и issued_token_type.
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+type ClientUseCase interface {
+	GetAllServiceAccounts() ([]entity.ServiceAccount, error)
+	CreateServiceAccount(name string, scopes []string) (entity.ServiceAccount, string, error)
+	DeleteServiceAccount(id int) error
+	AuthenticateClient(clientID string, clientSecret string) (entity.ServiceAccount, error)
+	IssueClientToken(clientID string, clientSecret string, scope string) (string, int64, string, error)
+	// ExchangeToken выдает сервису account токен пользователя из subjectToken для вызова
+	// сервиса audience со scope не шире разрешений пользователя (RFC 8693)
+	ExchangeToken(account entity.ServiceAccount, subjectToken string, subjectTokenType string, audience string, scope string) (string, int64, string, error)
+}
+
+type ServiceAccountUseCase struct {
+	repo     repository.ServiceAccountRepository
+	exchange config.TokenExchange
+	groups   config.Groups
+}
+
+func NewClientUseCase(repo repository.ServiceAccountRepository, exchange config.TokenExchange, groups config.Groups) ClientUseCase {
+	return &ServiceAccountUseCase{repo: repo, exchange: exchange, groups: groups}
+}
+
+func (s *ServiceAccountUseCase) GetAllServiceAccounts() ([]entity.ServiceAccount, error) {
+	return s.repo.GetAll()
+}
+
+func (s *ServiceAccountUseCase) CreateServiceAccount(name string, scopes []string) (entity.ServiceAccount, string, error) {
+	clientID, err := randomHex(8)
+	if err != nil {
+		return entity.ServiceAccount{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return entity.ServiceAccount{}, "", err
+	}
+
+	start := time.Now()
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	metrics.ObserveBcrypt("hash", start)
+	if err != nil {
+		logger.Logger.Error("Ошибка при хешировании секрета клиента",
+			zap.Error(err),
+			zap.String("usecase", "CreateServiceAccount"))
+		return entity.ServiceAccount{}, "", err
+	}
+
+	account, err := s.repo.Create(entity.ServiceAccount{
+		Name:         name,
+		ClientID:     "sa_" + clientID,
+		ClientSecret: string(hash),
+		Scopes:       strings.Join(scopes, " "),
+		CreateAt:     time.Now().String(),
+	})
+	if err != nil {
+		return entity.ServiceAccount{}, "", err
+	}
+	return account, secret, nil
+}
+
+func (s *ServiceAccountUseCase) DeleteServiceAccount(id int) error {
+	return s.repo.Delete(id)
+}
+
+func (s *ServiceAccountUseCase) AuthenticateClient(clientID string, clientSecret string) (entity.ServiceAccount, error) {
+	account, err := s.repo.GetByClientID(clientID)
+	if err != nil {
+		return entity.ServiceAccount{}, ErrorInvalidClient
+	}
+
+	start := time.Now()
+	err = bcrypt.CompareHashAndPassword([]byte(account.ClientSecret), []byte(clientSecret))
+	metrics.ObserveBcrypt("compare", start)
+	if err != nil {
+		logger.Logger.Warn("Неверный секрет сервисного аккаунта",
+			zap.String("client_id", clientID))
+		return entity.ServiceAccount{}, ErrorInvalidClient
+	}
+	return account, nil
+}
+
+func (s *ServiceAccountUseCase) IssueClientToken(clientID string, clientSecret string, scope string) (string, int64, string, error) {
+	account, err := s.AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	scopes, err := grantScopes(strings.Fields(account.Scopes), strings.Fields(scope))
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	token, expiresIn, err := jwt.GenerateClientToken(account.ClientID, scopes)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("ошибка генерации access токена: %w", err)
+	}
+	return token, expiresIn, strings.Join(scopes, " "), nil
+}
+
+func (s *ServiceAccountUseCase) ExchangeToken(account entity.ServiceAccount, subjectToken string, subjectTokenType string, audience string, scope string) (string, int64, string, error) {
+	if !jwt.HasScope(account.Scopes, jwt.ScopeTokenExchange) {
+		return "", 0, "", ErrorUnauthorizedClient
+	}
+	if subjectTokenType != TokenTypeAccessToken {
+		return "", 0, "", ErrorInvalidSubjectToken
+	}
+
+	// токен, уже выданный для другого сервиса, может обменять только этот сервис
+	subject, err := jwt.ValidateToken(subjectToken, jwt.AnyAudience)
+	if err != nil || !subject.IsUserAccess() {
+		return "", 0, "", ErrorInvalidSubjectToken
+	}
+	if len(subject.Audience) > 0 && !slices.Contains(subject.Audience, account.ClientID) {
+		return "", 0, "", ErrorInvalidSubjectToken
+	}
+
+	if audience == "" || !s.exchange.AllowsAudience(audience) {
+		return "", 0, "", ErrorInvalidTarget
+	}
+
+	// scope обменянного токена сужается дальше, разрешения ролей — только у исходного
+	available := strings.Fields(subject.Scope)
+	if len(available) == 0 {
+		available = s.groups.PermissionsFor(subject.EffectiveRoles())
+	}
+	scopes, err := grantScopes(available, strings.Fields(scope))
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	expiresAt := time.Now().Add(s.exchange.TTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+	token, expiresIn, err := jwt.GenerateExchangedToken(subject, account.ClientID, audience, scopes, expiresAt)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("ошибка генерации access токена: %w", err)
+	}
+
+	logger.Logger.Info("Токен пользователя обменян для другого сервиса",
+		zap.String("client_id", account.ClientID),
+		zap.Int("user_id", subject.ID),
+		zap.String("audience", audience),
+		zap.Strings("scope", scopes),
+		zap.String("tenant", subject.Tenant))
+	return token, expiresIn, strings.Join(scopes, " "), nil
+}
+
+func grantScopes(allowed []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+
+	set := make(map[string]bool, len(allowed))
+	for _, s := range allowed {
+		set[s] = true
+	}
+	for _, s := range requested {
+		if !set[s] {
+			return nil, ErrorInvalidScope
+		}
+	}
+	return requested, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ошибка генерации случайных байт: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
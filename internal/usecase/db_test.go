@@ -0,0 +1,39 @@
+package usecase
+
+import (
+	"database/sql"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/database"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB создает временную базу SQLite со всеми миграциями сервиса и, как Connect,
+// открывает ее с включенными внешними ключами.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.db")
+	if err := database.Migrate(file, "../../migrations"); err != nil {
+		t.Fatalf("миграции: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", file+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestOrg создает организацию; при isolated ее участники получают собственные учетные записи.
+func newTestOrg(t *testing.T, db *sql.DB, slug string, isolated bool) entity.Organization {
+	t.Helper()
+	orgs := repository.NewOrganizationRep(db)
+	org, err := orgs.CreateOrganization(entity.Organization{Slug: slug, Name: slug, IsolatedEmails: isolated, CreateAt: "2026-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return org
+}
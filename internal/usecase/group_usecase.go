@@ -0,0 +1,249 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorGroupNotFound       = fmt.Errorf("Группа не найдена")
+	ErrorGroupExists         = fmt.Errorf("Группа с таким названием уже существует")
+	ErrorGroupCycle          = fmt.Errorf("Группа не может быть вложена сама в себя или в свою подгруппу")
+	ErrorGroupRole           = fmt.Errorf("Роль не может быть пустой или содержать пробелы")
+	ErrorGroupMemberNotFound = fmt.Errorf("Пользователь не найден или не может состоять в группе")
+)
+
+type GroupUseCase interface {
+	GetGroups() ([]entity.Group, error)
+	GetGroup(id int) (entity.Group, error)
+	CreateGroup(group entity.Group) (entity.Group, error)
+	UpdateGroup(group entity.Group) (entity.Group, error)
+	DeleteGroup(id int) error
+	SetRoles(id int, roles []string) error
+	GetMembers(id int) ([]entity.GroupMember, error)
+	AddMember(id int, userID int) error
+	RemoveMember(id int, userID int) error
+	// Access вычисляет эффективные роли и разрешения пользователя в контексте use case
+	Access(user entity.User) (entity.Access, error)
+	// Claims — роли и группы для access токена; тенант берется из user.Tenant
+	Claims(user entity.User) ([]string, []string)
+	// WithTenant возвращает use case, работающий с группами организации
+	WithTenant(org entity.Organization) GroupUseCase
+}
+
+type GroupUseCaseImpl struct {
+	cfg  config.Groups
+	repo repository.GroupRepository
+	orgs repository.OrganizationRepository
+}
+
+func NewGroupUseCase(cfg config.Groups, repo repository.GroupRepository, orgs repository.OrganizationRepository) GroupUseCase {
+	return &GroupUseCaseImpl{cfg: cfg, repo: repo, orgs: orgs}
+}
+
+func (g *GroupUseCaseImpl) WithTenant(org entity.Organization) GroupUseCase {
+	return &GroupUseCaseImpl{cfg: g.cfg, repo: g.repo.WithTenant(org.ID), orgs: g.orgs}
+}
+
+func (g *GroupUseCaseImpl) GetGroups() ([]entity.Group, error) {
+	return g.repo.GetGroups()
+}
+
+func (g *GroupUseCaseImpl) GetGroup(id int) (entity.Group, error) {
+	group, err := g.repo.GetGroup(id)
+	if err != nil {
+		return entity.Group{}, ErrorGroupNotFound
+	}
+	return group, nil
+}
+
+func (g *GroupUseCaseImpl) CreateGroup(group entity.Group) (entity.Group, error) {
+	if group.ParentID != 0 {
+		if _, err := g.repo.GetGroup(group.ParentID); err != nil {
+			return entity.Group{}, ErrorGroupNotFound
+		}
+	}
+	if err := validRoles(group.Roles); err != nil {
+		return entity.Group{}, err
+	}
+	group.CreateAt = time.Now().String()
+
+	created, err := g.repo.CreateGroup(group)
+	if err != nil {
+		if errors.Is(err, repository.ErrGroupExists) {
+			return entity.Group{}, ErrorGroupExists
+		}
+		return entity.Group{}, err
+	}
+	if len(group.Roles) > 0 {
+		if err := g.repo.SetRoles(created.ID, group.Roles); err != nil {
+			return entity.Group{}, err
+		}
+	}
+	logger.Logger.Info("Создана группа",
+		zap.Int("group_id", created.ID),
+		zap.String("name", created.Name),
+		zap.Int("parent_id", created.ParentID))
+	return g.GetGroup(created.ID)
+}
+
+// UpdateGroup меняет название, описание и родителя группы. Роли меняются через SetRoles.
+func (g *GroupUseCaseImpl) UpdateGroup(group entity.Group) (entity.Group, error) {
+	if _, err := g.repo.GetGroup(group.ID); err != nil {
+		return entity.Group{}, ErrorGroupNotFound
+	}
+	if group.ParentID != 0 {
+		ancestors, err := g.repo.GetAncestors(group.ParentID)
+		if err != nil {
+			return entity.Group{}, err
+		}
+		if len(ancestors) == 0 {
+			return entity.Group{}, ErrorGroupNotFound
+		}
+		if slices.Contains(ancestors, group.ID) {
+			return entity.Group{}, ErrorGroupCycle
+		}
+	}
+
+	if err := g.repo.UpdateGroup(group); err != nil {
+		if errors.Is(err, repository.ErrGroupExists) {
+			return entity.Group{}, ErrorGroupExists
+		}
+		return entity.Group{}, ErrorGroupNotFound
+	}
+	logger.Logger.Info("Изменена группа",
+		zap.Int("group_id", group.ID),
+		zap.String("name", group.Name),
+		zap.Int("parent_id", group.ParentID))
+	return g.GetGroup(group.ID)
+}
+
+func (g *GroupUseCaseImpl) DeleteGroup(id int) error {
+	if err := g.repo.DeleteGroup(id); err != nil {
+		return ErrorGroupNotFound
+	}
+	logger.Logger.Info("Удалена группа", zap.Int("group_id", id))
+	return nil
+}
+
+func (g *GroupUseCaseImpl) SetRoles(id int, roles []string) error {
+	if _, err := g.repo.GetGroup(id); err != nil {
+		return ErrorGroupNotFound
+	}
+	if err := validRoles(roles); err != nil {
+		return err
+	}
+	if err := g.repo.SetRoles(id, roles); err != nil {
+		return err
+	}
+	logger.Logger.Info("Изменены роли группы",
+		zap.Int("group_id", id),
+		zap.Strings("roles", roles))
+	return nil
+}
+
+func (g *GroupUseCaseImpl) GetMembers(id int) ([]entity.GroupMember, error) {
+	if _, err := g.repo.GetGroup(id); err != nil {
+		return nil, ErrorGroupNotFound
+	}
+	return g.repo.GetMembers(id)
+}
+
+func (g *GroupUseCaseImpl) AddMember(id int, userID int) error {
+	if _, err := g.repo.GetGroup(id); err != nil {
+		return ErrorGroupNotFound
+	}
+	if err := g.repo.AddMember(id, userID); err != nil {
+		return ErrorGroupMemberNotFound
+	}
+	logger.Logger.Info("Пользователь добавлен в группу",
+		zap.Int("group_id", id),
+		zap.Int("user_id", userID))
+	return nil
+}
+
+func (g *GroupUseCaseImpl) RemoveMember(id int, userID int) error {
+	if err := g.repo.DeleteMember(id, userID); err != nil {
+		return ErrorGroupMemberNotFound
+	}
+	logger.Logger.Info("Пользователь исключен из группы",
+		zap.Int("group_id", id),
+		zap.Int("user_id", userID))
+	return nil
+}
+
+func (g *GroupUseCaseImpl) Access(user entity.User) (entity.Access, error) {
+	groups, err := g.repo.GetUserGroups(user.ID)
+	if err != nil {
+		return entity.Access{}, err
+	}
+
+	access := entity.Access{
+		UserID: user.ID,
+		Role:   user.Role,
+		Roles:  []string{},
+		Groups: []string{},
+	}
+	if user.Role != "" {
+		access.Roles = append(access.Roles, user.Role)
+	}
+	for _, group := range groups {
+		access.Groups = append(access.Groups, group.Name)
+		for _, role := range group.Roles {
+			if !slices.Contains(access.Roles, role) {
+				access.Roles = append(access.Roles, role)
+			}
+		}
+	}
+	access.Permissions = g.cfg.PermissionsFor(access.Roles)
+	return access, nil
+}
+
+// Claims не прерывает выдачу токена: при ошибке токен выдается только с собственной ролью.
+func (g *GroupUseCaseImpl) Claims(user entity.User) ([]string, []string) {
+	scoped := GroupUseCase(g)
+	if user.Tenant != "" {
+		org, err := g.orgs.GetOrganizationBySlug(user.Tenant)
+		if err != nil {
+			logger.Logger.Error("Не удалось определить организацию для групп токена",
+				zap.Error(err),
+				zap.String("tenant", user.Tenant))
+			return nil, nil
+		}
+		scoped = g.WithTenant(org)
+	}
+
+	access, err := scoped.Access(user)
+	if err != nil {
+		logger.Logger.Error("Не удалось получить группы пользователя для токена",
+			zap.Error(err),
+			zap.Int("user_id", user.ID))
+		return nil, nil
+	}
+
+	var roles, groups []string
+	if len(access.Roles) > 1 || (len(access.Roles) == 1 && access.Roles[0] != user.Role) {
+		roles = access.Roles
+	}
+	if g.cfg.TokenClaim && len(access.Groups) > 0 {
+		groups = access.Groups
+	}
+	return roles, groups
+}
+
+func validRoles(roles []string) error {
+	for _, role := range roles {
+		if role == "" || strings.ContainsAny(role, " \t\n") {
+			return ErrorGroupRole
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/connector"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// ExternalLoginStateTTL — сколько живет state входа через провайдер и cookie с ним.
+const ExternalLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrorConnectorNotFound = fmt.Errorf("Провайдер входа не найден")
+	ErrorExternalState     = fmt.Errorf("Состояние входа не найдено или истекло, начните вход заново")
+	ErrorIdentityNotLinked = fmt.Errorf("Учетная запись провайдера не привязана ни к одному пользователю")
+	ErrorIdentityTaken     = fmt.Errorf("Учетная запись провайдера уже привязана к другому пользователю")
+	ErrorIdentityNotFound  = fmt.Errorf("Внешняя учетная запись не найдена")
+	ErrorIdentityLastLogin = fmt.Errorf("Нельзя отвязать единственный способ входа пользователя, созданного через провайдер")
+)
+
+type IdentityUseCase interface {
+	Connectors() []entity.Connector
+	BeginLogin(ctx context.Context, connectorID string, userID int, session bool) (authURL string, state string, err error)
+	Finish(ctx context.Context, connectorID string, state string, browserState string, code string) (entity.User, entity.ExternalLoginState, error)
+	ExternalLogin(source string, rules config.Provisioning, identity connector.Identity) (entity.User, error)
+	GetIdentities(userID int) ([]entity.UserIdentity, error)
+	Unlink(userID int, id int) error
+	// WithTenant возвращает use case, который ищет, привязывает и создает пользователей
+	// только среди участников организации
+	WithTenant(org entity.Organization) IdentityUseCase
+}
+
+type IdentityUseCaseImpl struct {
+	connectors []connector.Connector
+	repo       repository.IdentityRepository
+	users      UseCase
+	tenant     entity.Organization
+}
+
+func NewIdentityUseCase(connectors []connector.Connector, repo repository.IdentityRepository, users UseCase) IdentityUseCase {
+	return &IdentityUseCaseImpl{connectors: connectors, repo: repo, users: users}
+}
+
+func (i *IdentityUseCaseImpl) WithTenant(org entity.Organization) IdentityUseCase {
+	return &IdentityUseCaseImpl{connectors: i.connectors, repo: i.repo, users: i.users.WithTenant(org), tenant: org}
+}
+
+func (i *IdentityUseCaseImpl) connector(id string) (connector.Connector, error) {
+	for _, c := range i.connectors {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, ErrorConnectorNotFound
+}
+
+func (i *IdentityUseCaseImpl) Connectors() []entity.Connector {
+	list := make([]entity.Connector, 0, len(i.connectors))
+	for _, c := range i.connectors {
+		list = append(list, entity.Connector{ID: c.ID(), Name: c.Name()})
+	}
+	return list
+}
+
+// BeginLogin возвращает адрес провайдера для входа и state. userID != 0 — привязка
+// учетной записи провайдера к уже вошедшему пользователю. state нужно сохранить
+// в браузере (cookie) и передать в Finish: так возврат от провайдера принимается
+// только в браузере, который начал вход.
+func (i *IdentityUseCaseImpl) BeginLogin(ctx context.Context, connectorID string, userID int, session bool) (string, string, error) {
+	c, err := i.connector(connectorID)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := c.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logger.Logger.Error("Ошибка начала входа через провайдер",
+			zap.Error(err),
+			zap.String("connector", connectorID))
+		return "", "", err
+	}
+
+	err = i.repo.SaveState(entity.ExternalLoginState{
+		State:     state,
+		Connector: connectorID,
+		UserID:    userID,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		Session:   session,
+		Tenant:    i.tenant.Slug,
+		ExpiresAt: time.Now().Add(ExternalLoginStateTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Finish завершает вход по коду провайдера. browserState — state из cookie браузера:
+// без совпадения с state из адреса возврата вход не завершается (защита от подстановки
+// чужого кода через CSRF), а сам state не расходуется. Вход, начатый в другой организации
+// (или вне организаций), здесь не завершается.
+func (i *IdentityUseCaseImpl) Finish(ctx context.Context, connectorID string, state string, browserState string, code string) (entity.User, entity.ExternalLoginState, error) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		logger.Logger.Warn("state возврата от провайдера не совпадает с cookie браузера",
+			zap.String("connector", connectorID))
+		return entity.User{}, entity.ExternalLoginState{}, ErrorExternalState
+	}
+	saved, err := i.repo.TakeState(state)
+	if err != nil || saved.Connector != connectorID {
+		return entity.User{}, entity.ExternalLoginState{}, ErrorExternalState
+	}
+	if saved.Tenant != i.tenant.Slug {
+		logger.Logger.Warn("Возврат от провайдера в другую организацию",
+			zap.String("connector", connectorID),
+			zap.String("started", saved.Tenant),
+			zap.String("tenant", i.tenant.Slug))
+		return entity.User{}, entity.ExternalLoginState{}, ErrorExternalState
+	}
+	c, err := i.connector(connectorID)
+	if err != nil {
+		return entity.User{}, saved, err
+	}
+
+	identity, err := c.Exchange(ctx, code, saved.Nonce, saved.Verifier)
+	if err != nil {
+		logger.Logger.Warn("Ошибка входа через провайдер",
+			zap.Error(err),
+			zap.String("connector", connectorID))
+		return entity.User{}, saved, err
+	}
+
+	if saved.UserID != 0 {
+		user, err := i.link(c.ID(), identity, saved.UserID)
+		return user, saved, err
+	}
+	user, err := i.login(c.ID(), c.Provisioning(), identity)
+	return user, saved, err
+}
+
+// ExternalLogin входит по учетной записи, проверенной в другом месте (например, SAML IdP):
+// source — имя источника, под которым хранятся привязки, rules — его правила provisioning.
+func (i *IdentityUseCaseImpl) ExternalLogin(source string, rules config.Provisioning, identity connector.Identity) (entity.User, error) {
+	return i.login(source, rules, identity)
+}
+
+func (i *IdentityUseCaseImpl) link(source string, identity connector.Identity, userID int) (entity.User, error) {
+	existing, err := i.repo.GetIdentity(source, identity.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return entity.User{}, ErrorIdentityTaken
+		}
+		return i.users.GetUserByID(userID)
+	}
+
+	// у пользователя, созданного через провайдер, нет своего пароля: новая привязка
+	// наследует признак, чтобы последнюю из них нельзя было отвязать
+	identities, err := i.repo.GetIdentitiesByUser(userID)
+	if err != nil {
+		return entity.User{}, err
+	}
+	provisioned := false
+	for _, linked := range identities {
+		provisioned = provisioned || linked.Provisioned
+	}
+
+	if _, err := i.createIdentity(source, identity, userID, provisioned); err != nil {
+		return entity.User{}, err
+	}
+	logger.Logger.Info("Привязана внешняя учетная запись",
+		zap.Int("user_id", userID),
+		zap.String("connector", source))
+	return i.users.GetUserByID(userID)
+}
+
+// login находит пользователя по привязанной учетной записи, а если ее нет —
+// применяет правила provisioning источника: привязка по подтвержденному email
+// и создание пользователя.
+func (i *IdentityUseCaseImpl) login(source string, rules config.Provisioning, identity connector.Identity) (entity.User, error) {
+	if existing, err := i.repo.GetIdentity(source, identity.Subject); err == nil {
+		user, err := i.users.GetUserByID(existing.UserID)
+		if err != nil {
+			// привязанный пользователь удален или больше не состоит в организации
+			return entity.User{}, ErrorIdentityNotLinked
+		}
+		return user, nil
+	}
+
+	if !validEmail(identity.Email) || !rules.AllowsEmail(identity.Email) {
+		logger.Logger.Warn("Email внешней учетной записи не подходит для входа",
+			zap.String("connector", source),
+			zap.String("email", identity.Email))
+		return entity.User{}, ErrorIdentityNotLinked
+	}
+
+	user, err := i.users.GetUserByEmail(identity.Email)
+	if err == nil {
+		if !rules.LinkByEmail || !identity.EmailVerified {
+			return entity.User{}, ErrorIdentityNotLinked
+		}
+		if _, err := i.createIdentity(source, identity, user.ID, false); err != nil {
+			return entity.User{}, err
+		}
+		logger.Logger.Info("Внешняя учетная запись привязана по email",
+			zap.Int("user_id", user.ID),
+			zap.String("connector", source))
+		return user, nil
+	}
+
+	if !rules.CreateUsers {
+		return entity.User{}, ErrorIdentityNotLinked
+	}
+	return i.provision(source, rules, identity)
+}
+
+func (i *IdentityUseCaseImpl) provision(source string, rules config.Provisioning, identity connector.Identity) (entity.User, error) {
+	// пароль случайный и нигде не сохраняется: вход только через провайдер
+	password, err := randomHex(32)
+	if err != nil {
+		return entity.User{}, err
+	}
+	name := identity.Name
+	if name == "" {
+		name = identity.Email[:strings.Index(identity.Email, "@")]
+	}
+	role := rules.DefaultRole
+	if role == "" {
+		role = "user"
+	}
+
+	if err := i.users.CreateUser(entity.User{Name: name, Email: identity.Email, Password: password, Role: role}); err != nil {
+		if errors.Is(err, repository.ErrUserExists) {
+			// email занят учетной записью вне организации: к ней не привязываемся
+			return entity.User{}, ErrorIdentityNotLinked
+		}
+		return entity.User{}, err
+	}
+	user, err := i.users.GetUserByEmail(identity.Email)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if _, err := i.createIdentity(source, identity, user.ID, true); err != nil {
+		return entity.User{}, err
+	}
+
+	logger.Logger.Info("Создан пользователь через внешний провайдер",
+		zap.Int("user_id", user.ID),
+		zap.String("connector", source))
+	return user, nil
+}
+
+// validEmail проверяет, что провайдер вернул голый адрес вида user@host: по нему
+// ищется и создается пользователь, а часть до @ становится именем.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+func (i *IdentityUseCaseImpl) createIdentity(source string, identity connector.Identity, userID int, provisioned bool) (entity.UserIdentity, error) {
+	return i.repo.CreateIdentity(entity.UserIdentity{
+		UserID:      userID,
+		Connector:   source,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		Provisioned: provisioned,
+		CreateAt:    time.Now().String(),
+	})
+}
+
+func (i *IdentityUseCaseImpl) GetIdentities(userID int) ([]entity.UserIdentity, error) {
+	return i.repo.GetIdentitiesByUser(userID)
+}
+
+func (i *IdentityUseCaseImpl) Unlink(userID int, id int) error {
+	identities, err := i.repo.GetIdentitiesByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if identity.ID != id {
+			continue
+		}
+		if identity.Provisioned && len(identities) == 1 {
+			return ErrorIdentityLastLogin
+		}
+		if err := i.repo.DeleteIdentity(userID, id); err != nil {
+			return ErrorIdentityNotFound
+		}
+		logger.Logger.Info("Отвязана внешняя учетная запись",
+			zap.Int("user_id", userID),
+			zap.String("connector", identity.Connector))
+		return nil
+	}
+	return ErrorIdentityNotFound
+}
@@ -0,0 +1,418 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/connector"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSource = SAMLSourcePrefix + "acme"
+
+// newTenantIdentities создает use case входа через внешний источник в организации acme.
+func newTenantIdentities(t *testing.T) (IdentityUseCase, UseCase, repository.OrganizationRepository, entity.Organization) {
+	t.Helper()
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	identities := repository.NewIdentityRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	org, err := orgs.CreateOrganization(entity.Organization{Slug: "acme", Name: "Acme", CreateAt: "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := NewUserUseCase(&users)
+	return NewIdentityUseCase(nil, &identities, uc).WithTenant(org), uc, &orgs, org
+}
+
+func createGlobalUser(t *testing.T, users UseCase, email string, role string) entity.User {
+	t.Helper()
+	if err := users.CreateUser(entity.User{Name: "Global", Email: email, Password: "password", Role: role}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestTenantExternalLoginDoesNotLinkOutsiders(t *testing.T) {
+	identities, users, _, _ := newTenantIdentities(t)
+	outsider := createGlobalUser(t, users, "outsider@a.com", "admin")
+
+	rules := config.Provisioning{LinkByEmail: true, CreateUsers: true}
+	_, err := identities.ExternalLogin(testSource, rules, connector.Identity{Subject: "s1", Email: outsider.Email, EmailVerified: true})
+	if !errors.Is(err, ErrorIdentityNotLinked) {
+		t.Fatalf("ожидалась ErrorIdentityNotLinked, получено %v", err)
+	}
+	if linked, _ := identities.GetIdentities(outsider.ID); len(linked) != 0 {
+		t.Fatal("учетная запись вне организации привязана по email")
+	}
+}
+
+func TestTenantExternalLoginLinksMember(t *testing.T) {
+	identities, users, orgs, org := newTenantIdentities(t)
+	member := createGlobalUser(t, users, "member@a.com", "user")
+	if err := orgs.SetMember(org.ID, member.ID, "manager"); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := config.Provisioning{LinkByEmail: true}
+	user, err := identities.ExternalLogin(testSource, rules, connector.Identity{Subject: "s1", Email: member.Email, EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != member.ID || user.Role != "manager" || user.Tenant != "acme" {
+		t.Fatalf("неожиданный пользователь: %+v", user)
+	}
+
+	// участник исключен из организации: привязка больше не дает входа
+	if err := orgs.DeleteMember(org.ID, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = identities.ExternalLogin(testSource, rules, connector.Identity{Subject: "s1", Email: member.Email})
+	if !errors.Is(err, ErrorIdentityNotLinked) {
+		t.Fatalf("ожидалась ErrorIdentityNotLinked, получено %v", err)
+	}
+}
+
+func TestTenantExternalLoginProvisionsMembership(t *testing.T) {
+	identities, users, _, _ := newTenantIdentities(t)
+
+	rules := config.Provisioning{CreateUsers: true, DefaultRole: "admin"}
+	user, err := identities.ExternalLogin(testSource, rules, connector.Identity{Subject: "s1", Email: "new@a.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "admin" || user.Tenant != "acme" {
+		t.Fatalf("роль в организации: %+v", user)
+	}
+	global, err := users.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.Role != "user" {
+		t.Fatalf("глобальная роль созданного пользователя %q, ожидалась user", global.Role)
+	}
+}
+
+// Email провайдера без @ или с отображаемым именем не создает пользователя и не
+// роняет вход.
+func TestExternalLoginRejectsMalformedEmail(t *testing.T) {
+	identities, users, _, _ := newTenantIdentities(t)
+
+	rules := config.Provisioning{CreateUsers: true}
+	for _, email := range []string{"", "user", "user@", "User <user@a.com>", "user@a.com, admin@a.com"} {
+		_, err := identities.ExternalLogin(testSource, rules, connector.Identity{Subject: "s-" + email, Email: email, EmailVerified: true})
+		if !errors.Is(err, ErrorIdentityNotLinked) {
+			t.Fatalf("%q: ожидалась ErrorIdentityNotLinked, получено %v", email, err)
+		}
+	}
+	if _, err := users.GetUserByEmail("user"); err == nil {
+		t.Fatal("создан пользователь с некорректным email")
+	}
+}
+
+const mockClientID = "authh2-test"
+
+// mockOIDC — OIDC провайдер для тестов: discovery, JWKS, авторизация без интерфейса
+// и token endpoint с проверкой PKCE. id_token подписывается RS256.
+type mockOIDC struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	subject string
+	email   string
+	// nonce, если задан, подставляется в id_token вместо запрошенного
+	nonce string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockOIDC(t *testing.T, subject string, email string) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDC{t: t, key: key, subject: subject, email: email, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockOIDC) discovery(w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockOIDC) jwks(w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// authorize имитирует вход пользователя у провайдера и возвращает code и state
+// из адреса возврата.
+func (p *mockOIDC) authorize(authURL string) (code string, state string) {
+	p.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != "S256" {
+		p.t.Fatalf("неверный запрос авторизации: %s", authURL)
+	}
+	code, err = randomHex(16)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.codes[code] = query
+	p.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (p *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	request, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != request.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := request.Get("nonce")
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	idToken := gojwt.NewWithClaims(gojwt.SigningMethodRS256, gojwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            mockClientID,
+		"sub":            p.subject,
+		"email":          p.email,
+		"email_verified": true,
+		"name":           "OIDC User",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		p.t.Error(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func newOIDCIdentities(t *testing.T, p *mockOIDC) (IdentityUseCase, UseCase, *sql.DB) {
+	t.Helper()
+	c, err := connector.New(config.Connector{
+		ID:           "mock",
+		Type:         connector.TypeOIDC,
+		ClientID:     mockClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8081/v1/connectors/mock/callback",
+		Issuer:       p.server.URL,
+		Provisioning: config.Provisioning{CreateUsers: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	identities := repository.NewIdentityRep(db)
+	uc := NewUserUseCase(&users)
+	return NewIdentityUseCase([]connector.Connector{c}, &identities, uc), uc, db
+}
+
+func TestOIDCLoginRequiresBrowserState(t *testing.T) {
+	p := newMockOIDC(t, "oidc-subject", "oidc@a.com")
+	identities, users, _ := newOIDCIdentities(t, p)
+	ctx := context.Background()
+
+	authURL, state, err := identities.BeginLogin(ctx, "mock", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returned := p.authorize(authURL)
+	if returned != state {
+		t.Fatalf("state в адресе провайдера %q, BeginLogin вернул %q", returned, state)
+	}
+
+	// code и state атакующего, подставленные в браузер жертвы: cookie нет или в ней свой state
+	for _, browserState := range []string{"", "victim-state", state[:len(state)-1]} {
+		if _, _, err := identities.Finish(ctx, "mock", state, browserState, code); !errors.Is(err, ErrorExternalState) {
+			t.Fatalf("cookie %q: ожидалась ErrorExternalState, получено %v", browserState, err)
+		}
+	}
+	if _, err := users.GetUserByEmail("oidc@a.com"); err == nil {
+		t.Fatal("пользователь создан без совпадения state")
+	}
+
+	// отклоненные попытки не расходуют state: вход в том же браузере проходит
+	user, saved, err := identities.Finish(ctx, "mock", state, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "oidc@a.com" || saved.UserID != 0 {
+		t.Fatalf("пользователь %+v, состояние %+v", user, saved)
+	}
+	linked, err := identities.GetIdentities(user.ID)
+	if err != nil || len(linked) != 1 || linked[0].Subject != "oidc-subject" || !linked[0].Provisioned {
+		t.Fatalf("привязки %+v, %v", linked, err)
+	}
+
+	// state одноразовый
+	if _, _, err := identities.Finish(ctx, "mock", state, state, code); !errors.Is(err, ErrorExternalState) {
+		t.Fatalf("повтор state: %v", err)
+	}
+}
+
+func TestOIDCLinkRequiresBrowserState(t *testing.T) {
+	p := newMockOIDC(t, "admin-subject", "someone@else.com")
+	identities, _, _ := newOIDCIdentities(t, p)
+	ctx := context.Background()
+
+	authURL, state, err := identities.BeginLogin(ctx, "mock", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := p.authorize(authURL)
+	if _, _, err := identities.Finish(ctx, "mock", state, "", code); !errors.Is(err, ErrorExternalState) {
+		t.Fatalf("привязка без cookie: %v", err)
+	}
+
+	user, saved, err := identities.Finish(ctx, "mock", state, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UserID != 1 || user.ID != 1 {
+		t.Fatalf("привязано к %d, состояние %+v", user.ID, saved)
+	}
+	if linked, _ := identities.GetIdentities(1); len(linked) != 1 || linked[0].Subject != "admin-subject" {
+		t.Fatalf("привязки %+v", linked)
+	}
+}
+
+func TestOIDCLoginRejectsForeignStateAndNonce(t *testing.T) {
+	p := newMockOIDC(t, "oidc-subject", "oidc@a.com")
+	identities, _, _ := newOIDCIdentities(t, p)
+	ctx := context.Background()
+
+	// state другого провайдера
+	authURL, state, err := identities.BeginLogin(ctx, "mock", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := p.authorize(authURL)
+	if _, _, err := identities.Finish(ctx, "other", state, state, code); !errors.Is(err, ErrorExternalState) {
+		t.Fatalf("state другого провайдера: %v", err)
+	}
+
+	// id_token с чужим nonce
+	p.nonce = "replayed-nonce"
+	authURL, state, err = identities.BeginLogin(ctx, "mock", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = p.authorize(authURL)
+	if _, _, err := identities.Finish(ctx, "mock", state, state, code); err == nil {
+		t.Fatal("принят id_token с чужим nonce")
+	}
+}
+
+// Вход, начатый в организации, завершается только в ней, и наоборот: иначе через
+// /t/{slug}/... можно было бы привязать или создать глобальную учетную запись.
+func TestOIDCLoginBoundToTenant(t *testing.T) {
+	p := newMockOIDC(t, "oidc-subject", "oidc@a.com")
+	global, users, db := newOIDCIdentities(t, p)
+	acme := newTestOrg(t, db, "acme", false)
+	tenant := global.WithTenant(acme)
+	beta := global.WithTenant(newTestOrg(t, db, "beta", false))
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		begin, finish IdentityUseCase
+	}{
+		{"из организации в глобальный контекст", tenant, global},
+		{"из глобального контекста в организацию", global, tenant},
+		{"в другую организацию", tenant, beta},
+	}
+	for _, tt := range tests {
+		authURL, state, err := tt.begin.BeginLogin(ctx, "mock", 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := p.authorize(authURL)
+		if _, _, err := tt.finish.Finish(ctx, "mock", state, state, code); !errors.Is(err, ErrorExternalState) {
+			t.Fatalf("%s: ожидалась ErrorExternalState, получено %v", tt.name, err)
+		}
+	}
+	if _, err := users.GetUserByEmail("oidc@a.com"); err == nil {
+		t.Fatal("пользователь создан входом из другого контекста")
+	}
+
+	authURL, state, err := tenant.BeginLogin(ctx, "mock", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := p.authorize(authURL)
+	user, _, err := tenant.Finish(ctx, "mock", state, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Tenant != "acme" {
+		t.Fatalf("пользователь загружен в контексте %q", user.Tenant)
+	}
+	if _, err := users.WithTenant(acme).GetUserByID(user.ID); err != nil {
+		t.Fatalf("пользователь не стал участником организации: %v", err)
+	}
+}
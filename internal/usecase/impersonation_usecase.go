@@ -0,0 +1,90 @@
+package usecase
+
+import (
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"slices"
+)
+
+var (
+	ErrorImpersonateSelf      = fmt.Errorf("Нельзя действовать от имени самого себя")
+	ErrorImpersonateProtected = fmt.Errorf("Нельзя действовать от имени пользователя с защищенной ролью")
+	ErrorImpersonateChain     = fmt.Errorf("Подмененный токен нельзя использовать для новой подмены")
+)
+
+type ImpersonationUseCase interface {
+	// Impersonate выдает actor короткий access токен пользователя target с claim act
+	Impersonate(actor *jwt.Claims, target entity.User, reason string) (string, int64, error)
+	// WithTenant возвращает use case, проверяющий роли target по группам организации
+	WithTenant(org entity.Organization) ImpersonationUseCase
+}
+
+type ImpersonationUseCaseImpl struct {
+	cfg    config.Impersonation
+	groups GroupUseCase
+	audit  AuditUseCase
+}
+
+func NewImpersonationUseCase(cfg config.Impersonation, groups GroupUseCase, audit AuditUseCase) ImpersonationUseCase {
+	return &ImpersonationUseCaseImpl{cfg: cfg, groups: groups, audit: audit}
+}
+
+func (i *ImpersonationUseCaseImpl) WithTenant(org entity.Organization) ImpersonationUseCase {
+	return &ImpersonationUseCaseImpl{cfg: i.cfg, groups: i.groups.WithTenant(org), audit: i.audit}
+}
+
+func (i *ImpersonationUseCaseImpl) Impersonate(actor *jwt.Claims, target entity.User, reason string) (string, int64, error) {
+	if actor.Act != nil {
+		return "", 0, ErrorImpersonateChain
+	}
+	if actor.ID == target.ID {
+		return "", 0, ErrorImpersonateSelf
+	}
+
+	// роли, унаследованные от групп, защищают так же, как собственная; если их не
+	// удалось получить, подмена не выполняется
+	access, err := i.groups.Access(target)
+	if err != nil {
+		logger.Logger.Error("Не удалось получить роли пользователя для подмены",
+			zap.Error(err),
+			zap.Int("actor_id", actor.ID),
+			zap.Int("user_id", target.ID))
+		return "", 0, fmt.Errorf("Ошибка проверки ролей пользователя: %w", err)
+	}
+	for _, role := range access.Roles {
+		if slices.Contains(i.cfg.ProtectedRoles, role) {
+			logger.Logger.Warn("Отказано в подмене пользователя с защищенной ролью",
+				zap.Int("actor_id", actor.ID),
+				zap.Int("user_id", target.ID),
+				zap.String("role", role))
+			return "", 0, ErrorImpersonateProtected
+		}
+	}
+
+	token, expiresIn, err := jwt.GenerateImpersonationToken(target, entity.User{ID: actor.ID, Email: actor.Email}, i.cfg.TTL)
+	if err != nil {
+		return "", 0, fmt.Errorf("Ошибка генерации токена: %w", err)
+	}
+
+	i.audit.Record(entity.AuditEvent{
+		Action:     entity.AuditImpersonationStart,
+		ActorID:    actor.ID,
+		ActorEmail: actor.Email,
+		UserID:     target.ID,
+		UserEmail:  target.Email,
+		Tenant:     target.Tenant,
+		Details:    reason,
+	})
+	logger.Logger.Info("Администратор действует от имени пользователя",
+		zap.Int("actor_id", actor.ID),
+		zap.String("actor_email", actor.Email),
+		zap.Int("user_id", target.ID),
+		zap.String("user_email", target.Email),
+		zap.String("tenant", target.Tenant),
+		zap.String("reason", reason))
+	return token, expiresIn, nil
+}
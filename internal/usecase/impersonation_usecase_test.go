@@ -0,0 +1,70 @@
+package usecase
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"testing"
+	"time"
+)
+
+var testImpersonation = config.Impersonation{TTL: time.Minute, ProtectedRoles: []string{"admin"}}
+
+// Роль admin, полученная через группу организации, защищает от подмены в этой организации.
+func TestImpersonateTenantGroupProtectedRole(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	groupRep := repository.NewGroupRep(db)
+	auditRep := repository.NewAuditRep(db)
+	groups := NewGroupUseCase(config.Groups{}, &groupRep, &orgs)
+	impersonation := NewImpersonationUseCase(testImpersonation, groups, NewAuditUseCase(&auditRep))
+
+	acme := newTestOrg(t, db, "acme", false)
+	uc := NewUserUseCase(&users)
+	target := createGlobalUser(t, uc, "target@a.com", "user")
+	if err := orgs.SetMember(acme.ID, target.ID, "user"); err != nil {
+		t.Fatal(err)
+	}
+	group, err := groups.WithTenant(acme).CreateGroup(entity.Group{Name: "ops", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groups.WithTenant(acme).AddMember(group.ID, target.ID); err != nil {
+		t.Fatal(err)
+	}
+	member, err := uc.WithTenant(acme).GetUserByID(target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actor := &jwt.Claims{ID: 1, Email: "admin@a.com", Role: "admin"}
+	if _, _, err := impersonation.WithTenant(acme).Impersonate(actor, member, "проверка"); !errors.Is(err, ErrorImpersonateProtected) {
+		t.Fatalf("ожидалась ErrorImpersonateProtected, получено %v", err)
+	}
+	// вне организации группа роли не дает
+	if _, _, err := impersonation.Impersonate(actor, target, "проверка"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type failingGroups struct{ GroupUseCase }
+
+func (failingGroups) Access(entity.User) (entity.Access, error) {
+	return entity.Access{}, errors.New("база недоступна")
+}
+
+// Если группы пользователя не удалось получить, подмена не выполняется.
+func TestImpersonateFailsClosed(t *testing.T) {
+	db := newTestDB(t)
+	auditRep := repository.NewAuditRep(db)
+	impersonation := NewImpersonationUseCase(testImpersonation, failingGroups{}, NewAuditUseCase(&auditRep))
+
+	actor := &jwt.Claims{ID: 1, Email: "admin@a.com", Role: "admin"}
+	token, _, err := impersonation.Impersonate(actor, entity.User{ID: 2, Email: "user@a.com", Role: "user"}, "проверка")
+	if err == nil || token != "" {
+		t.Fatal("подмена выполнена без проверки групп")
+	}
+}
@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/mailer"
+	"go.uber.org/zap"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorInviteNotFound      = fmt.Errorf("Приглашение не найдено")
+	ErrorInviteInvalid       = fmt.Errorf("Неверное, просроченное или уже использованное приглашение")
+	ErrorInviteAccountExists = fmt.Errorf("Пользователь с таким email уже зарегистрирован")
+	ErrorInvitePassword      = fmt.Errorf("Для новой учетной записи нужны имя и пароль")
+	ErrorRegistrationClosed  = fmt.Errorf("Регистрация с этим email доступна только по приглашению")
+)
+
+type InviteUseCase interface {
+	GetInvites() ([]entity.Invite, error)
+	// CreateInvite сохраняет приглашение и отправляет ссылку на email приглашенного
+	CreateInvite(invite entity.Invite) (entity.Invite, error)
+	RevokeInvite(id int) error
+	// Accept создает учетную запись по приглашению, а в организации без изолированных
+	// email делает существующую глобальную учетную запись ее участником
+	Accept(accept entity.AcceptInvite) (entity.User, error)
+	// CheckRegistration проверяет, разрешена ли регистрация без приглашения
+	CheckRegistration(email string) error
+	WithTenant(org entity.Organization) InviteUseCase
+}
+
+type InviteUseCaseImpl struct {
+	cfg    config.Registration
+	repo   repository.InviteRepository
+	users  UseCase
+	mailer mailer.Mailer
+	org    entity.Organization
+}
+
+func NewInviteUseCase(cfg config.Registration, repo repository.InviteRepository, users UseCase, m mailer.Mailer) InviteUseCase {
+	return &InviteUseCaseImpl{cfg: cfg, repo: repo, users: users, mailer: m}
+}
+
+func (i *InviteUseCaseImpl) WithTenant(org entity.Organization) InviteUseCase {
+	scoped := *i
+	scoped.repo = i.repo.WithTenant(org.ID)
+	scoped.org = org
+	return &scoped
+}
+
+func (i *InviteUseCaseImpl) GetInvites() ([]entity.Invite, error) {
+	return i.repo.GetInvites()
+}
+
+func (i *InviteUseCaseImpl) CreateInvite(invite entity.Invite) (entity.Invite, error) {
+	invite.Email = strings.TrimSpace(invite.Email)
+	if invite.Role == "" {
+		invite.Role = "user"
+	}
+	if i.org.ID == 0 {
+		if _, err := i.users.GetUserByEmail(invite.Email); err == nil {
+			return entity.Invite{}, ErrorInviteAccountExists
+		}
+	}
+
+	now := time.Now()
+	invite.ExpiresAt = now.Add(i.cfg.InviteTTL).Unix()
+	invite.CreateAt = now.String()
+	created, err := i.repo.CreateInvite(invite)
+	if err != nil {
+		return entity.Invite{}, err
+	}
+
+	token, err := jwt.GenerateInviteToken(created, i.org.Slug)
+	if err != nil {
+		return entity.Invite{}, fmt.Errorf("Ошибка генерации токена приглашения: %w", err)
+	}
+	where := "сервисе"
+	if i.org.ID != 0 {
+		where = "организации " + i.org.Name
+	}
+	body := fmt.Sprintf("Вас пригласили зарегистрироваться в %s.\n\nДля принятия приглашения перейдите по ссылке:\n%s%s\n\nПриглашение действует до %s.",
+		where, i.cfg.InviteURL, url.QueryEscape(token), time.Unix(created.ExpiresAt, 0).Format(time.DateTime))
+	if err := i.mailer.Send(created.Email, "Приглашение", body); err != nil {
+		return entity.Invite{}, err
+	}
+
+	logger.Logger.Info("Отправлено приглашение",
+		zap.Int("invite_id", created.ID),
+		zap.String("email", created.Email),
+		zap.String("role", created.Role),
+		zap.String("tenant", i.org.Slug),
+		zap.Int("invited_by", created.InvitedBy))
+	return created, nil
+}
+
+func (i *InviteUseCaseImpl) RevokeInvite(id int) error {
+	if err := i.repo.RevokeInvite(id); err != nil {
+		return ErrorInviteNotFound
+	}
+	logger.Logger.Info("Приглашение отозвано", zap.Int("invite_id", id))
+	return nil
+}
+
+func (i *InviteUseCaseImpl) Accept(accept entity.AcceptInvite) (entity.User, error) {
+	invite, err := i.pendingInvite(accept.Token)
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	users := i.users
+	if i.org.ID != 0 {
+		users = i.users.WithTenant(i.org)
+	}
+
+	var user entity.User
+	existing, err := i.users.GetUserByEmail(invite.Email)
+	switch {
+	case err == nil && i.org.ID != 0 && !i.org.IsolatedEmails:
+		// приглашение подтверждает владение email: пароль существующей учетной записи не нужен
+		user = entity.User{ID: existing.ID, Role: invite.Role}
+	case err == nil && i.org.ID == 0:
+		return entity.User{}, ErrorInviteAccountExists
+	default:
+		if accept.Name == "" || accept.Password == "" {
+			return entity.User{}, ErrorInvitePassword
+		}
+		user, err = i.users.HashPassword(entity.User{
+			Name:     accept.Name,
+			Email:    invite.Email,
+			Password: accept.Password,
+			Role:     invite.Role,
+		})
+		if err != nil {
+			return entity.User{}, err
+		}
+		user.CreateAt = time.Now().String()
+	}
+
+	// приглашение гасится в одной транзакции с созданием учетной записи или членства:
+	// параллельное принятие того же приглашения ничего не создает
+	_, err = i.repo.Accept(invite.ID, i.org, user)
+	switch {
+	case errors.Is(err, repository.ErrUserExists):
+		return entity.User{}, ErrorInviteAccountExists
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.User{}, ErrorInviteInvalid
+	case err != nil:
+		return entity.User{}, err
+	}
+	user, err = users.GetUserByEmail(invite.Email)
+	if err != nil {
+		return entity.User{}, err
+	}
+	logger.Logger.Info("Приглашение принято",
+		zap.Int("invite_id", invite.ID),
+		zap.Int("user_id", user.ID),
+		zap.String("tenant", i.org.Slug))
+	return user, nil
+}
+
+// pendingInvite проверяет подпись токена, его организацию и то, что приглашение еще действует.
+func (i *InviteUseCaseImpl) pendingInvite(token string) (entity.Invite, error) {
+	claims, err := jwt.ValidateToken(token)
+	if err != nil || claims.TokenUse != jwt.TokenUseInvite || claims.Tenant != i.org.Slug {
+		return entity.Invite{}, ErrorInviteInvalid
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return entity.Invite{}, ErrorInviteInvalid
+	}
+
+	invite, err := i.repo.GetInvite(id)
+	if err != nil || !strings.EqualFold(invite.Email, claims.Email) {
+		return entity.Invite{}, ErrorInviteInvalid
+	}
+	if invite.AcceptedAt != 0 || invite.RevokedAt != 0 || invite.ExpiresAt < time.Now().Unix() {
+		return entity.Invite{}, ErrorInviteInvalid
+	}
+	return invite, nil
+}
+
+func (i *InviteUseCaseImpl) CheckRegistration(email string) error {
+	if !i.cfg.AllowsEmail(email) {
+		logger.Logger.Warn("Регистрация без приглашения отклонена",
+			zap.String("email", email),
+			zap.String("mode", i.cfg.Mode))
+		return ErrorRegistrationClosed
+	}
+	return nil
+}
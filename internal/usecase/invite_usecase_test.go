@@ -0,0 +1,103 @@
+package usecase
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"testing"
+	"time"
+)
+
+// newInvite сохраняет приглашение организации org и возвращает его токен.
+func newInvite(t *testing.T, invites *repository.InviteRep, org entity.Organization, email string, role string) (entity.Invite, string) {
+	t.Helper()
+	invite, err := invites.WithTenant(org.ID).CreateInvite(entity.Invite{
+		Email:     email,
+		Role:      role,
+		InvitedBy: 1,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		CreateAt:  time.Now().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.GenerateInviteToken(invite, org.Slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invite, token
+}
+
+// Приглашение не понижает роль того, кто уже состоит в организации.
+func TestInviteAcceptKeepsMemberRole(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	invites := repository.NewInviteRep(db)
+	uc := NewUserUseCase(&users)
+	acme := newTestOrg(t, db, "acme", false)
+	accept := NewInviteUseCase(config.Registration{}, &invites, uc, &captureMailer{}).WithTenant(acme)
+
+	member := createGlobalUser(t, uc, "member@a.com", "user")
+	if err := orgs.SetMember(acme.ID, member.ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	_, token := newInvite(t, &invites, acme, "member@a.com", "user")
+	user, err := accept.Accept(entity.AcceptInvite{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != member.ID || user.Role != "admin" {
+		t.Fatalf("участник после приглашения: %+v", user)
+	}
+
+	// новый участник получает роль из приглашения
+	outsider := createGlobalUser(t, uc, "outsider@a.com", "user")
+	_, token = newInvite(t, &invites, acme, "outsider@a.com", "manager")
+	user, err = accept.Accept(entity.AcceptInvite{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != outsider.ID || user.Role != "manager" {
+		t.Fatalf("новый участник: %+v", user)
+	}
+}
+
+// Приглашение гасится вместе с созданием учетной записи: принятое приглашение ничего
+// не создает повторно, а неудачное создание оставляет приглашение действующим.
+func TestInviteAcceptIsAtomic(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	invites := repository.NewInviteRep(db)
+	uc := NewUserUseCase(&users)
+	acme := newTestOrg(t, db, "acme", true)
+	accept := NewInviteUseCase(config.Registration{}, &invites, uc, &captureMailer{}).WithTenant(acme)
+
+	invite, token := newInvite(t, &invites, acme, "new@acme.com", "user")
+	if _, err := accept.Accept(entity.AcceptInvite{Token: token, Name: "New", Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+	// параллельный запрос, прошедший проверку приглашения до его принятия
+	_, err := invites.WithTenant(acme.ID).Accept(invite.ID, acme, entity.User{Name: "Twin", Email: "twin@acme.com", Role: "admin"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалась sql.ErrNoRows, получено %v", err)
+	}
+	if _, err := uc.WithTenant(acme).GetUserByEmail("twin@acme.com"); err == nil {
+		t.Fatal("учетная запись создана по принятому приглашению")
+	}
+
+	invite, token = newInvite(t, &invites, acme, "new@acme.com", "user")
+	if _, err := accept.Accept(entity.AcceptInvite{Token: token, Name: "New", Password: "password"}); !errors.Is(err, ErrorInviteAccountExists) {
+		t.Fatalf("ожидалась ErrorInviteAccountExists, получено %v", err)
+	}
+	pending, err := invites.WithTenant(acme.ID).GetInvite(invite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.AcceptedAt != 0 {
+		t.Fatal("приглашение погашено без созданной учетной записи")
+	}
+}
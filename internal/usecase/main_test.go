@@ -0,0 +1,15 @@
+package usecase
+
+import (
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	jwt.SECRET_KEY = []byte("test-secret")
+	os.Exit(m.Run())
+}
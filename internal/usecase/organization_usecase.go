@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorOrganizationNotFound = fmt.Errorf("Организация не найдена")
+	ErrorOrganizationSlug     = fmt.Errorf("slug организации может содержать строчные латинские буквы, цифры и дефис (до 63 символов)")
+	ErrorOrganizationExists   = fmt.Errorf("Организация с таким slug или доменом уже существует")
+	ErrorMemberNotFound       = fmt.Errorf("Пользователь не найден или не состоит в организации")
+)
+
+var organizationSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type OrganizationUseCase interface {
+	GetOrganizations() ([]entity.Organization, error)
+	CreateOrganization(org entity.Organization) (entity.Organization, error)
+	DeleteOrganization(id int) error
+	Resolve(slug string) (entity.Organization, error)
+	ResolveHost(host string) (entity.Organization, error)
+	GetMembers(orgID int) ([]entity.Membership, error)
+	SetMember(orgID int, userID int, role string) error
+	RemoveMember(orgID int, userID int) error
+}
+
+type OrganizationUseCaseImpl struct {
+	cfg  config.Tenancy
+	repo repository.OrganizationRepository
+}
+
+func NewOrganizationUseCase(cfg config.Tenancy, repo repository.OrganizationRepository) OrganizationUseCase {
+	return &OrganizationUseCaseImpl{cfg: cfg, repo: repo}
+}
+
+func (o *OrganizationUseCaseImpl) GetOrganizations() ([]entity.Organization, error) {
+	return o.repo.GetOrganizations()
+}
+
+func (o *OrganizationUseCaseImpl) CreateOrganization(org entity.Organization) (entity.Organization, error) {
+	if !organizationSlug.MatchString(org.Slug) {
+		return entity.Organization{}, ErrorOrganizationSlug
+	}
+	org.Domain = strings.ToLower(org.Domain)
+	org.CreateAt = time.Now().String()
+
+	created, err := o.repo.CreateOrganization(org)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrganizationExists) {
+			return entity.Organization{}, ErrorOrganizationExists
+		}
+		return entity.Organization{}, err
+	}
+	logger.Logger.Info("Создана организация",
+		zap.Int("org_id", created.ID),
+		zap.String("slug", created.Slug))
+	return created, nil
+}
+
+func (o *OrganizationUseCaseImpl) DeleteOrganization(id int) error {
+	if err := o.repo.DeleteOrganization(id); err != nil {
+		return ErrorOrganizationNotFound
+	}
+	logger.Logger.Info("Удалена организация", zap.Int("org_id", id))
+	return nil
+}
+
+func (o *OrganizationUseCaseImpl) Resolve(slug string) (entity.Organization, error) {
+	org, err := o.repo.GetOrganizationBySlug(strings.ToLower(slug))
+	if err != nil {
+		return entity.Organization{}, ErrorOrganizationNotFound
+	}
+	return org, nil
+}
+
+// ResolveHost ищет организацию по ее домену, а затем по поддомену {slug}.{base_domain}.
+// Хост без организации возвращает ErrorOrganizationNotFound.
+func (o *OrganizationUseCaseImpl) ResolveHost(host string) (entity.Organization, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if org, err := o.repo.GetOrganizationByDomain(host); err == nil {
+		return org, nil
+	}
+	if o.cfg.BaseDomain == "" {
+		return entity.Organization{}, ErrorOrganizationNotFound
+	}
+	slug, ok := strings.CutSuffix(host, "."+strings.ToLower(o.cfg.BaseDomain))
+	if !ok || strings.Contains(slug, ".") {
+		return entity.Organization{}, ErrorOrganizationNotFound
+	}
+	return o.Resolve(slug)
+}
+
+func (o *OrganizationUseCaseImpl) GetMembers(orgID int) ([]entity.Membership, error) {
+	if _, err := o.repo.GetOrganization(orgID); err != nil {
+		return nil, ErrorOrganizationNotFound
+	}
+	return o.repo.GetMembers(orgID)
+}
+
+func (o *OrganizationUseCaseImpl) SetMember(orgID int, userID int, role string) error {
+	if _, err := o.repo.GetOrganization(orgID); err != nil {
+		return ErrorOrganizationNotFound
+	}
+	if role == "" {
+		role = "user"
+	}
+	if err := o.repo.SetMember(orgID, userID, role); err != nil {
+		return ErrorMemberNotFound
+	}
+	logger.Logger.Info("Изменено членство в организации",
+		zap.Int("org_id", orgID),
+		zap.Int("user_id", userID),
+		zap.String("role", role))
+	return nil
+}
+
+func (o *OrganizationUseCaseImpl) RemoveMember(orgID int, userID int) error {
+	if err := o.repo.DeleteMember(orgID, userID); err != nil {
+		return ErrorMemberNotFound
+	}
+	logger.Logger.Info("Пользователь исключен из организации",
+		zap.Int("org_id", orgID),
+		zap.Int("user_id", userID))
+	return nil
+}
@@ -0,0 +1,284 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/broker"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const outboxCleanupInterval = time.Minute
+
+// OutboxSink — приемник событий outbox. Relay повторяет всю пачку, пока Publish не
+// вернет nil, поэтому Publish должен быть идемпотентным по IdempotencyKey (или ID) события.
+type OutboxSink interface {
+	Name() string
+	Publish(events []entity.UserEvent) error
+}
+
+type OutboxUseCase interface {
+	// Run доставляет события в приемники и очищает outbox, пока не отменен ctx;
+	// возвращается, когда relay и очистка остановлены
+	Run(ctx context.Context)
+	Stats() ([]entity.OutboxSinkStats, error)
+}
+
+type OutboxUseCaseImpl struct {
+	cfg   config.Outbox
+	repo  repository.OutboxRepository
+	sinks []OutboxSink
+
+	mu    sync.Mutex
+	stats map[string]*entity.OutboxSinkStats
+}
+
+// NewOutboxUseCase проверяет, что имена приемников уникальны: по имени хранится позиция в outbox.
+func NewOutboxUseCase(cfg config.Outbox, repo repository.OutboxRepository, sinks ...OutboxSink) (OutboxUseCase, error) {
+	stats := make(map[string]*entity.OutboxSinkStats, len(sinks))
+	for _, sink := range sinks {
+		if _, ok := stats[sink.Name()]; ok {
+			return nil, fmt.Errorf("приемник outbox %s указан дважды", sink.Name())
+		}
+		stats[sink.Name()] = &entity.OutboxSinkStats{Sink: sink.Name()}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return &OutboxUseCaseImpl{cfg: cfg, repo: repo, sinks: sinks, stats: stats}, nil
+}
+
+func (o *OutboxUseCaseImpl) Run(ctx context.Context) {
+	cursors, err := o.startCursors()
+	if err != nil {
+		logger.Logger.Fatal("Ошибка получения позиций приемников outbox", zap.Error(err))
+	}
+
+	var wg sync.WaitGroup
+	for i, sink := range o.sinks {
+		o.stats[sink.Name()].Cursor = cursors[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.relay(ctx, sink, cursors[i])
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		o.cleanup(ctx)
+	}()
+	wg.Wait()
+}
+
+// wait ждет d и сообщает, что ctx за это время не отменен.
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// startCursors возвращает позиции приемников. Если позиций еще нет ни у одного
+// (первый запуск), все начинают с начала outbox. Приемник, добавленный позже,
+// начинает с последнего события: истории он не получает, как и новый вебхук.
+func (o *OutboxUseCaseImpl) startCursors() ([]int64, error) {
+	cursors := make([]int64, len(o.sinks))
+	started := make([]bool, len(o.sinks))
+	anyStarted := false
+	for i, sink := range o.sinks {
+		cursor, ok, err := o.repo.GetCursor(sink.Name())
+		if err != nil {
+			return nil, err
+		}
+		cursors[i], started[i] = cursor, ok
+		anyStarted = anyStarted || ok
+	}
+	if !anyStarted {
+		return cursors, nil
+	}
+
+	latest, err := o.repo.LatestCursor()
+	if err != nil {
+		return nil, err
+	}
+	for i, sink := range o.sinks {
+		if started[i] {
+			continue
+		}
+		if err := o.repo.SetCursor(sink.Name(), latest); err != nil {
+			return nil, err
+		}
+		cursors[i] = latest
+		logger.Logger.Info("Новый приемник outbox начинает с последнего события",
+			zap.String("sink", sink.Name()),
+			zap.Int64("cursor", latest))
+	}
+	return cursors, nil
+}
+
+// relay доставляет события в приемник по порядку. Позиция сохраняется после
+// успешной доставки пачки: при сбое между ними пачка будет доставлена повторно.
+// Начатая пачка доставляется до конца и после отмены ctx.
+func (o *OutboxUseCaseImpl) relay(ctx context.Context, sink OutboxSink, cursor int64) {
+	for ctx.Err() == nil {
+		events, err := o.repo.GetEventsAfter(cursor, o.cfg.BatchSize)
+		if err == nil && len(events) > 0 {
+			err = sink.Publish(events)
+			if err == nil {
+				next := events[len(events)-1].ID
+				if err = o.repo.SetCursor(sink.Name(), next); err == nil {
+					cursor = next
+				}
+			}
+			o.record(sink.Name(), cursor, len(events), err)
+		}
+
+		switch {
+		case err != nil:
+			logger.Logger.Error("Ошибка доставки событий outbox",
+				zap.Error(err),
+				zap.String("sink", sink.Name()),
+				zap.Int64("cursor", cursor))
+			wait(ctx, o.cfg.RetryInterval)
+		case len(events) < o.cfg.BatchSize:
+			wait(ctx, o.cfg.PollInterval)
+		}
+	}
+}
+
+func (o *OutboxUseCaseImpl) record(sink string, cursor int64, count int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := o.stats[sink]
+	if err != nil {
+		stats.Failures++
+		stats.LastError = err.Error()
+		return
+	}
+	stats.Cursor = cursor
+	stats.Published += int64(count)
+	stats.LastError = ""
+	stats.LastPublishedAt = time.Now().Unix()
+}
+
+// cleanup удаляет события, доставленные во все приемники.
+func (o *OutboxUseCaseImpl) cleanup(ctx context.Context) {
+	for wait(ctx, outboxCleanupInterval) {
+
+		o.mu.Lock()
+		var cursor int64 = math.MaxInt64
+		for _, stats := range o.stats {
+			cursor = min(cursor, stats.Cursor)
+		}
+		o.mu.Unlock()
+		if cursor == math.MaxInt64 || cursor == 0 {
+			continue
+		}
+
+		deleted, err := o.repo.DeleteEventsUpTo(cursor)
+		if err != nil {
+			logger.Logger.Error("Ошибка очистки outbox", zap.Error(err))
+		} else if deleted > 0 {
+			logger.Logger.Debug("Очищен outbox", zap.Int64("count", deleted), zap.Int64("cursor", cursor))
+		}
+	}
+}
+
+func (o *OutboxUseCaseImpl) Stats() ([]entity.OutboxSinkStats, error) {
+	now := time.Now().Unix()
+	result := make([]entity.OutboxSinkStats, 0, len(o.sinks))
+	for _, sink := range o.sinks {
+		o.mu.Lock()
+		stats := *o.stats[sink.Name()]
+		o.mu.Unlock()
+
+		pending, oldest, err := o.repo.Pending(stats.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		stats.Pending = pending
+		if pending > 0 {
+			stats.LagSeconds = float64(max(now-oldest, 0))
+		}
+		result = append(result, stats)
+	}
+	return result, nil
+}
+
+// eventPayload — событие в том виде, в котором его получают вебхуки и брокеры.
+// ID и IdempotencyKey одинаковы во всех повторных доставках.
+type eventPayload struct {
+	ID             int64            `json:"id"`
+	IdempotencyKey string           `json:"idempotency_key"`
+	Type           string           `json:"type"`
+	CreateAt       int64            `json:"create_at"`
+	User           eventPayloadUser `json:"user"`
+}
+
+type eventPayloadUser struct {
+	ID     int    `json:"id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func marshalEvent(event entity.UserEvent) ([]byte, error) {
+	return json.Marshal(eventPayload{
+		ID:             event.ID,
+		IdempotencyKey: event.IdempotencyKey,
+		Type:           event.Type,
+		CreateAt:       event.CreateAt,
+		User: eventPayloadUser{
+			ID:     event.UserID,
+			Email:  event.Email,
+			Name:   event.Name,
+			Role:   event.Role,
+			Tenant: event.Tenant,
+		},
+	})
+}
+
+// brokerSink публикует события outbox в брокер сообщений.
+type brokerSink struct {
+	publisher broker.Publisher
+}
+
+func NewBrokerSink(publisher broker.Publisher) OutboxSink {
+	return &brokerSink{publisher: publisher}
+}
+
+func (b *brokerSink) Name() string {
+	return b.publisher.Name()
+}
+
+func (b *brokerSink) Publish(events []entity.UserEvent) error {
+	messages := make([]broker.Message, 0, len(events))
+	for _, event := range events {
+		data, err := marshalEvent(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, broker.Message{
+			ID:   event.IdempotencyKey,
+			Key:  strconv.Itoa(event.UserID),
+			Type: event.Type,
+			Data: data,
+		})
+	}
+	return b.publisher.Publish(context.Background(), messages)
+}
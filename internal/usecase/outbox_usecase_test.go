@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/broker"
+	"github.com/LandGAA/authh2/pkg/config"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testOutbox = config.Outbox{PollInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond, BatchSize: 2}
+
+// recordingSink запоминает принятые события; первые fail пачек отклоняет.
+type recordingSink struct {
+	name string
+
+	mu       sync.Mutex
+	fail     int
+	rejected [][]int64
+	accepted []int64
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Publish(events []entity.UserEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if s.fail > 0 {
+		s.fail--
+		s.rejected = append(s.rejected, ids)
+		return errors.New("брокер недоступен")
+	}
+	s.accepted = append(s.accepted, ids...)
+	return nil
+}
+
+func (s *recordingSink) delivered() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.accepted)
+}
+
+// createOutboxEvents пишет n событий и возвращает их ID.
+func createOutboxEvents(t *testing.T, outbox *repository.OutboxRep, n int) []int64 {
+	t.Helper()
+	var ids []int64
+	for i := 0; i < n; i++ {
+		if err := outbox.CreateEvent(entity.UserEvent{Type: entity.UserEventUpdated, UserID: 1, Email: "admin@a.com"}); err != nil {
+			t.Fatal(err)
+		}
+		latest, err := outbox.LatestCursor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, latest)
+	}
+	return ids
+}
+
+// runOutbox запускает relay до получения приемниками want событий и останавливает его.
+func runOutbox(t *testing.T, o OutboxUseCase, want []int64, sinks ...*recordingSink) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, sink := range sinks {
+		for len(sink.delivered()) < len(want) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay не остановился после отмены контекста")
+	}
+}
+
+// Relay доставляет события по порядку, повторяет отклоненную пачку целиком и
+// сохраняет позицию только после успешной доставки.
+func TestOutboxRelay(t *testing.T) {
+	outbox := repository.NewOutboxRep(newTestDB(t))
+	events := createOutboxEvents(t, &outbox, 5)
+
+	stable := &recordingSink{name: "stable"}
+	flaky := &recordingSink{name: "flaky", fail: 2}
+	o, err := NewOutboxUseCase(testOutbox, &outbox, stable, flaky)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOutbox(t, o, events, stable, flaky)
+
+	for _, sink := range []*recordingSink{stable, flaky} {
+		got := sink.delivered()
+		if !slices.Equal(got[len(got)-len(events):], events) {
+			t.Fatalf("%s получил %v, ожидалось %v", sink.name, got, events)
+		}
+		cursor, ok, err := outbox.GetCursor(sink.name)
+		if err != nil || !ok || cursor != events[len(events)-1] {
+			t.Fatalf("позиция %s = %d, %v, %v", sink.name, cursor, ok, err)
+		}
+	}
+	// отклоненная пачка повторяется та же, а не следующая
+	if len(flaky.rejected) != 2 || !slices.Equal(flaky.rejected[0], flaky.rejected[1]) {
+		t.Fatalf("отклоненные пачки %v", flaky.rejected)
+	}
+
+	stats, err := o.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stats {
+		if s.Pending != 0 || s.Cursor != events[len(events)-1] {
+			t.Fatalf("статистика %+v", s)
+		}
+		if s.Sink == "flaky" && s.Failures != 2 {
+			t.Fatalf("у flaky %d сбоев", s.Failures)
+		}
+	}
+}
+
+// Перезапущенный приемник продолжает с сохраненной позиции, а добавленный позже
+// начинает с последнего события и истории не получает.
+func TestOutboxStartCursors(t *testing.T) {
+	outbox := repository.NewOutboxRep(newTestDB(t))
+	first := createOutboxEvents(t, &outbox, 3)
+
+	stable := &recordingSink{name: "stable"}
+	o, err := NewOutboxUseCase(testOutbox, &outbox, stable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOutbox(t, o, first, stable)
+
+	second := createOutboxEvents(t, &outbox, 2)
+	restarted := &recordingSink{name: "stable"}
+	added := &recordingSink{name: "added"}
+	o, err = NewOutboxUseCase(testOutbox, &outbox, restarted, added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOutbox(t, o, second, restarted)
+
+	if got := restarted.delivered(); !slices.Equal(got, second) {
+		t.Fatalf("после перезапуска получено %v, ожидалось %v", got, second)
+	}
+	if got := added.delivered(); len(got) != 0 {
+		t.Fatalf("новый приемник получил историю %v", got)
+	}
+	if cursor, _, _ := outbox.GetCursor("added"); cursor != second[len(second)-1] {
+		t.Fatalf("позиция нового приемника %d", cursor)
+	}
+}
+
+func TestOutboxDuplicateSink(t *testing.T) {
+	outbox := repository.NewOutboxRep(newTestDB(t))
+	if _, err := NewOutboxUseCase(testOutbox, &outbox, &recordingSink{name: "a"}, &recordingSink{name: "a"}); err == nil {
+		t.Fatal("приемники с одинаковым именем приняты")
+	}
+}
+
+type capturePublisher struct {
+	broker.Publisher
+	messages []broker.Message
+}
+
+func (c *capturePublisher) Name() string { return "nats" }
+
+func (c *capturePublisher) Publish(_ context.Context, messages []broker.Message) error {
+	c.messages = append(c.messages, messages...)
+	return nil
+}
+
+// В брокер событие уходит с ключом идемпотентности outbox и партицией по пользователю.
+func TestBrokerSink(t *testing.T) {
+	publisher := &capturePublisher{}
+	sink := NewBrokerSink(publisher)
+	err := sink.Publish([]entity.UserEvent{{ID: 3, IdempotencyKey: "key-3", Type: entity.UserEventCreated, UserID: 7, Email: "user@a.com", Tenant: "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sink.Name() != "nats" || len(publisher.messages) != 1 {
+		t.Fatalf("сообщения %+v", publisher.messages)
+	}
+	m := publisher.messages[0]
+	if m.ID != "key-3" || m.Key != "7" || m.Type != entity.UserEventCreated ||
+		!strings.Contains(string(m.Data), `"idempotency_key":"key-3"`) || !strings.Contains(string(m.Data), `"tenant":"acme"`) {
+		t.Fatalf("сообщение %+v: %s", m, m.Data)
+	}
+}
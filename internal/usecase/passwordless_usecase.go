@@ -0,0 +1,195 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/mailer"
+	"go.uber.org/zap"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+const (
+	LoginCodeKindLink = "link"
+	LoginCodeKindCode = "code"
+)
+
+var (
+	ErrorPasswordlessMethod  = fmt.Errorf("Неизвестный способ входа, ожидается link или code")
+	ErrorPasswordlessInvalid = fmt.Errorf("Неверный или просроченный код входа")
+)
+
+type PasswordlessUseCase interface {
+	Start(email string, kind string) error
+	RedeemLink(token string) (entity.User, error)
+	RedeemCode(email string, code string) (entity.User, error)
+	Enabled(userID int) bool
+	SetEnabled(userID int, enabled bool) error
+	// WithTenant возвращает use case, который ищет пользователей среди участников организации
+	WithTenant(org entity.Organization) PasswordlessUseCase
+}
+
+type PasswordlessUseCaseImpl struct {
+	repo   repository.PasswordlessRepository
+	users  repository.Repository
+	mailer mailer.Mailer
+	cfg    config.Passwordless
+}
+
+func NewPasswordlessUseCase(cfg config.Passwordless, repo repository.PasswordlessRepository, users repository.Repository, m mailer.Mailer) PasswordlessUseCase {
+	return &PasswordlessUseCaseImpl{repo: repo, users: users, mailer: m, cfg: cfg}
+}
+
+func (p *PasswordlessUseCaseImpl) WithTenant(org entity.Organization) PasswordlessUseCase {
+	return &PasswordlessUseCaseImpl{repo: p.repo, users: p.users.WithTenant(org), mailer: p.mailer, cfg: p.cfg}
+}
+
+// Start отправляет ссылку или код. Для неизвестного email, пользователей без входа по почте
+// и сверх ThrottleLimit писем за ThrottleWindow ничего не отправляется и ошибка не
+// возвращается, чтобы по ответу нельзя было перебирать адреса.
+func (p *PasswordlessUseCaseImpl) Start(email string, kind string) error {
+	if kind != LoginCodeKindLink && kind != LoginCodeKindCode {
+		return ErrorPasswordlessMethod
+	}
+
+	user, err := p.users.GetByEmail(email)
+	if err != nil || !p.Enabled(user.ID) {
+		logger.Logger.Info("Запрос входа без пароля отклонен",
+			zap.String("email", email))
+		return nil
+	}
+
+	now := time.Now()
+	issued, err := p.repo.CountIssuedSince(user.ID, now.Add(-p.cfg.ThrottleWindow).Unix())
+	if err != nil {
+		return err
+	}
+	if issued >= p.cfg.ThrottleLimit {
+		logger.Logger.Warn("Превышен лимит писем для входа без пароля",
+			zap.Int("user_id", user.ID))
+		return nil
+	}
+
+	var secret, subject, body string
+	switch kind {
+	case LoginCodeKindLink:
+		if secret, err = randomHex(32); err != nil {
+			return err
+		}
+		subject = "Ссылка для входа"
+		body = fmt.Sprintf("Для входа перейдите по ссылке:\n%s%s\n\nСсылка действует %s и работает один раз.",
+			p.cfg.LinkURL, url.QueryEscape(secret), p.cfg.TTL)
+	case LoginCodeKindCode:
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return err
+		}
+		secret = fmt.Sprintf("%06d", n.Int64())
+		subject = "Код для входа"
+		body = fmt.Sprintf("Ваш код для входа: %s\n\nКод действует %s.", secret, p.cfg.TTL)
+	}
+
+	err = p.repo.CreateCode(entity.LoginCode{
+		UserID:    user.ID,
+		Kind:      kind,
+		CodeHash:  hashLoginCode(secret),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(p.cfg.TTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := p.mailer.Send(user.Email, subject, body); err != nil {
+		return err
+	}
+	logger.Logger.Info("Отправлен код входа без пароля",
+		zap.Int("user_id", user.ID),
+		zap.String("kind", kind))
+	return nil
+}
+
+func (p *PasswordlessUseCaseImpl) RedeemLink(token string) (entity.User, error) {
+	code, err := p.repo.GetActiveCodeByHash(LoginCodeKindLink, hashLoginCode(token))
+	if err != nil {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	return p.redeem(code)
+}
+
+// RedeemCode проверяет последний отправленный пользователю код. После MaxAttempts
+// неверных попыток код сгорает.
+func (p *PasswordlessUseCaseImpl) RedeemCode(email string, code string) (entity.User, error) {
+	user, err := p.users.GetByEmail(email)
+	if err != nil {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	stored, err := p.repo.GetActiveCode(user.ID, LoginCodeKindCode)
+	if err != nil {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(hashLoginCode(code))) != 1 {
+		if err := p.repo.IncrementAttempts(stored.ID); err != nil {
+			return entity.User{}, err
+		}
+		if stored.Attempts+1 >= p.cfg.MaxAttempts {
+			logger.Logger.Warn("Код входа сгорел после неверных попыток",
+				zap.Int("user_id", user.ID))
+			_ = p.repo.MarkUsed(stored.ID)
+		}
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	return p.redeem(stored)
+}
+
+// redeem гасит код пользователя, которого Start нашел бы по email в этом контексте.
+// Ссылка, открытая в чужой организации или вне своей, не гасится и остается
+// действительной там, где была запрошена.
+func (p *PasswordlessUseCaseImpl) redeem(code entity.LoginCode) (entity.User, error) {
+	user, err := p.users.GetByID(code.UserID)
+	if err != nil {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	if visible, err := p.users.GetByEmail(user.Email); err != nil || visible.ID != user.ID {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	if !p.Enabled(user.ID) {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	if err := p.repo.MarkUsed(code.ID); err != nil {
+		return entity.User{}, ErrorPasswordlessInvalid
+	}
+	logger.Logger.Info("Вход без пароля",
+		zap.Int("user_id", user.ID),
+		zap.String("kind", code.Kind))
+	return user, nil
+}
+
+func (p *PasswordlessUseCaseImpl) Enabled(userID int) bool {
+	enabled, err := p.repo.GetEnabled(userID)
+	if err != nil {
+		logger.Logger.Error("Ошибка проверки входа без пароля",
+			zap.Error(err),
+			zap.Int("user_id", userID))
+		return false
+	}
+	return enabled
+}
+
+func (p *PasswordlessUseCaseImpl) SetEnabled(userID int, enabled bool) error {
+	return p.repo.SetEnabled(userID, enabled)
+}
+
+func hashLoginCode(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
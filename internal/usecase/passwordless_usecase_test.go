@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// captureMailer запоминает отправленные письма.
+type captureMailer struct{ bodies []string }
+
+func (m *captureMailer) Send(to string, subject string, body string) error {
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+var (
+	loginLink = regexp.MustCompile(`token=([0-9a-f]+)`)
+	loginCode = regexp.MustCompile(`код для входа: (\d{6})`)
+)
+
+// secret возвращает токен ссылки или код из последнего письма.
+func (m *captureMailer) secret(t *testing.T, re *regexp.Regexp) string {
+	t.Helper()
+	if len(m.bodies) == 0 {
+		t.Fatal("письмо не отправлено")
+	}
+	match := re.FindStringSubmatch(m.bodies[len(m.bodies)-1])
+	if match == nil {
+		t.Fatalf("в письме нет кода: %q", m.bodies[len(m.bodies)-1])
+	}
+	return match[1]
+}
+
+var testPasswordless = config.Passwordless{
+	LinkURL:        "https://app.example.com/login?token=",
+	TTL:            time.Minute,
+	MaxAttempts:    3,
+	ThrottleWindow: time.Minute,
+	ThrottleLimit:  2,
+}
+
+func newPasswordless(t *testing.T) (PasswordlessUseCase, *captureMailer, UseCase, *sql.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	codes := repository.NewPasswordlessRep(db)
+	m := &captureMailer{}
+	return NewPasswordlessUseCase(testPasswordless, &codes, &users, m), m, NewUserUseCase(&users), db
+}
+
+// Ответ Start не отличает неизвестный email и превышенный лимит от отправленного письма.
+func TestPasswordlessStartThrottle(t *testing.T) {
+	p, m, users, _ := newPasswordless(t)
+	user := createGlobalUser(t, users, "user@a.com", "user")
+	if err := p.SetEnabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < testPasswordless.ThrottleLimit+2; i++ {
+		if err := p.Start("user@a.com", LoginCodeKindCode); err != nil {
+			t.Fatalf("запрос %d: %v", i+1, err)
+		}
+	}
+	if len(m.bodies) != testPasswordless.ThrottleLimit {
+		t.Fatalf("отправлено %d писем, ожидалось %d", len(m.bodies), testPasswordless.ThrottleLimit)
+	}
+
+	if err := p.Start("unknown@a.com", LoginCodeKindCode); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start("admin@a.com", LoginCodeKindCode); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.bodies) != testPasswordless.ThrottleLimit {
+		t.Fatal("письмо отправлено неизвестному пользователю или без включенного входа по почте")
+	}
+	if err := p.Start("user@a.com", "sms"); !errors.Is(err, ErrorPasswordlessMethod) {
+		t.Fatalf("ожидалась ErrorPasswordlessMethod, получено %v", err)
+	}
+}
+
+// После MaxAttempts неверных попыток код сгорает и верный код уже не подходит.
+func TestPasswordlessCodeMaxAttempts(t *testing.T) {
+	p, m, users, _ := newPasswordless(t)
+	user := createGlobalUser(t, users, "user@a.com", "user")
+	if err := p.SetEnabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start("user@a.com", LoginCodeKindCode); err != nil {
+		t.Fatal(err)
+	}
+	code := m.secret(t, loginCode)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < testPasswordless.MaxAttempts; i++ {
+		if _, err := p.RedeemCode("user@a.com", wrong); !errors.Is(err, ErrorPasswordlessInvalid) {
+			t.Fatalf("попытка %d: %v", i+1, err)
+		}
+	}
+	if _, err := p.RedeemCode("user@a.com", code); !errors.Is(err, ErrorPasswordlessInvalid) {
+		t.Fatalf("сгоревший код принят: %v", err)
+	}
+}
+
+// Ссылка и код одноразовые.
+func TestPasswordlessSingleUse(t *testing.T) {
+	p, m, users, _ := newPasswordless(t)
+	user := createGlobalUser(t, users, "user@a.com", "user")
+	if err := p.SetEnabled(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Start("user@a.com", LoginCodeKindLink); err != nil {
+		t.Fatal(err)
+	}
+	token := m.secret(t, loginLink)
+	if got, err := p.RedeemLink(token); err != nil || got.ID != user.ID {
+		t.Fatalf("вход по ссылке: %+v, %v", got, err)
+	}
+	if _, err := p.RedeemLink(token); !errors.Is(err, ErrorPasswordlessInvalid) {
+		t.Fatalf("ссылка принята повторно: %v", err)
+	}
+
+	if err := p.Start("user@a.com", LoginCodeKindCode); err != nil {
+		t.Fatal(err)
+	}
+	code := m.secret(t, loginCode)
+	if got, err := p.RedeemCode("user@a.com", code); err != nil || got.ID != user.ID {
+		t.Fatalf("вход по коду: %+v, %v", got, err)
+	}
+	if _, err := p.RedeemCode("user@a.com", code); !errors.Is(err, ErrorPasswordlessInvalid) {
+		t.Fatalf("код принят повторно: %v", err)
+	}
+}
+
+// Пользователь организации с изолированными email ищется только в ней, а ссылка,
+// открытая вне организации, не гасится.
+func TestPasswordlessTenant(t *testing.T) {
+	p, m, users, db := newPasswordless(t)
+	acme := newTestOrg(t, db, "acme", true)
+	own := createGlobalUser(t, users.WithTenant(acme), "own@acme.com", "user")
+	if err := p.SetEnabled(own.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Start("own@acme.com", LoginCodeKindLink); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.bodies) != 0 {
+		t.Fatal("пользователь организации найден глобально")
+	}
+	if err := p.WithTenant(acme).Start("own@acme.com", LoginCodeKindLink); err != nil {
+		t.Fatal(err)
+	}
+	token := m.secret(t, loginLink)
+
+	if _, err := p.RedeemLink(token); !errors.Is(err, ErrorPasswordlessInvalid) {
+		t.Fatalf("ссылка организации принята глобально: %v", err)
+	}
+	if got, err := p.WithTenant(acme).RedeemLink(token); err != nil || got.ID != own.ID {
+		t.Fatalf("вход по ссылке в организации: %+v, %v", got, err)
+	}
+}
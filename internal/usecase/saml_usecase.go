@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/connector"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/samlauth"
+	"go.uber.org/zap"
+	"time"
+)
+
+// SAMLSourcePrefix — префикс, под которым хранятся привязки SAML: "saml:" + тенант.
+const SAMLSourcePrefix = "saml:"
+
+var (
+	ErrorSAMLTenantNotFound = fmt.Errorf("SAML IdP для тенанта не настроен")
+	ErrorSAMLResponse       = fmt.Errorf("Ответ SAML IdP не прошел проверку")
+	ErrorSAMLReplay         = fmt.Errorf("SAML утверждение уже использовано")
+)
+
+type SAMLUseCase interface {
+	Metadata(tenant string) ([]byte, error)
+	BeginLogin(ctx context.Context, tenant string, session bool) (string, error)
+	Finish(ctx context.Context, tenant string, relayState string, response []byte) (entity.User, entity.ExternalLoginState, error)
+}
+
+type SAMLUseCaseImpl struct {
+	providers  map[string]*samlauth.Provider
+	states     repository.IdentityRepository
+	assertions repository.SAMLRepository
+	users      repository.Repository
+	identities IdentityUseCase
+	orgs       OrganizationUseCase
+}
+
+// NewSAMLUseCase создает вход через SAML. Тенант IdP — slug организации: пользователи
+// ищутся и создаются только среди ее участников.
+func NewSAMLUseCase(providers []*samlauth.Provider, states repository.IdentityRepository, assertions repository.SAMLRepository,
+	users repository.Repository, identities IdentityUseCase, orgs OrganizationUseCase) SAMLUseCase {
+	byTenant := make(map[string]*samlauth.Provider, len(providers))
+	for _, p := range providers {
+		byTenant[p.Tenant()] = p
+	}
+	return &SAMLUseCaseImpl{providers: byTenant, states: states, assertions: assertions, users: users, identities: identities, orgs: orgs}
+}
+
+func (s *SAMLUseCaseImpl) provider(tenant string) (*samlauth.Provider, error) {
+	p, ok := s.providers[tenant]
+	if !ok {
+		return nil, ErrorSAMLTenantNotFound
+	}
+	return p, nil
+}
+
+func (s *SAMLUseCaseImpl) Metadata(tenant string) ([]byte, error) {
+	p, err := s.provider(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return p.Metadata()
+}
+
+// BeginLogin возвращает адрес IdP с AuthnRequest. ID запроса сохраняется под RelayState,
+// чтобы при возврате принять только ответ на этот запрос.
+func (s *SAMLUseCaseImpl) BeginLogin(ctx context.Context, tenant string, session bool) (string, error) {
+	p, err := s.provider(tenant)
+	if err != nil {
+		return "", err
+	}
+
+	relayState, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	authURL, requestID, err := p.AuthURL(ctx, relayState)
+	if err != nil {
+		logger.Logger.Error("Ошибка начала входа через SAML",
+			zap.Error(err),
+			zap.String("tenant", tenant))
+		return "", err
+	}
+
+	err = s.states.SaveState(entity.ExternalLoginState{
+		State:     relayState,
+		Connector: SAMLSourcePrefix + tenant,
+		Nonce:     requestID,
+		Session:   session,
+		ExpiresAt: time.Now().Add(ExternalLoginStateTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// Finish проверяет ответ IdP и входит пользователем из утверждения. Ответ без известного
+// RelayState считается входом со стороны IdP и завершается cookie-сессией.
+// Пользователь ищется, привязывается и создается только в организации тенанта,
+// роль из утверждения назначается членству в ней.
+func (s *SAMLUseCaseImpl) Finish(ctx context.Context, tenant string, relayState string, response []byte) (entity.User, entity.ExternalLoginState, error) {
+	p, err := s.provider(tenant)
+	if err != nil {
+		return entity.User{}, entity.ExternalLoginState{}, err
+	}
+	org, err := s.orgs.Resolve(tenant)
+	if err != nil {
+		logger.Logger.Error("SAML IdP настроен для несуществующей организации",
+			zap.String("tenant", tenant))
+		return entity.User{}, entity.ExternalLoginState{}, ErrorSAMLTenantNotFound
+	}
+	source := SAMLSourcePrefix + tenant
+
+	saved := entity.ExternalLoginState{Connector: source, Session: true}
+	if relayState != "" {
+		if state, err := s.states.TakeState(relayState); err == nil && state.Connector == source {
+			saved = state
+		}
+	}
+
+	assertion, err := p.ParseResponse(ctx, response, saved.Nonce)
+	if err != nil {
+		logger.Logger.Warn("Ошибка входа через SAML",
+			zap.Error(err),
+			zap.String("tenant", tenant))
+		if errors.Is(err, samlauth.ErrorIdPInitiated) {
+			return entity.User{}, saved, ErrorExternalState
+		}
+		return entity.User{}, saved, ErrorSAMLResponse
+	}
+	if err := s.assertions.UseAssertion(assertion.ID, tenant, assertion.ExpiresAt.Unix()); err != nil {
+		if errors.Is(err, repository.ErrAssertionReplayed) {
+			logger.Logger.Warn("Повторное предъявление SAML утверждения",
+				zap.String("tenant", tenant),
+				zap.String("assertion_id", assertion.ID))
+			return entity.User{}, saved, ErrorSAMLReplay
+		}
+		return entity.User{}, saved, err
+	}
+
+	rules := p.Provisioning()
+	if assertion.Role != "" {
+		rules.DefaultRole = assertion.Role
+	}
+	user, err := s.identities.WithTenant(org).ExternalLogin(source, rules, connector.Identity{
+		Subject: assertion.Subject,
+		Email:   assertion.Email,
+		// IdP тенанта — доверенный источник email его сотрудников
+		EmailVerified: true,
+		Name:          assertion.Name,
+	})
+	if err != nil {
+		return entity.User{}, saved, err
+	}
+
+	if assertion.Role != "" && user.Role != assertion.Role {
+		logger.Logger.Info("Роль пользователя обновлена по SAML",
+			zap.Int("user_id", user.ID),
+			zap.String("tenant", tenant),
+			zap.String("role", assertion.Role))
+		user.Role = assertion.Role
+		if err := s.users.WithTenant(org).UpdateProfile(user); err != nil {
+			return entity.User{}, saved, err
+		}
+	}
+	return user, saved, nil
+}
@@ -0,0 +1,646 @@
+package usecase
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/scim"
+	"go.uber.org/zap"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scimTokenPrefix = "scim_"
+
+// scimTouchInterval — как часто обновляется время последнего использования токена провижининга
+const scimTouchInterval = time.Minute
+
+var (
+	ErrorSCIMNotFound          = fmt.Errorf("Ресурс не найден")
+	ErrorSCIMUserExists        = fmt.Errorf("Пользователь с таким userName уже существует")
+	ErrorSCIMUserName          = fmt.Errorf("userName должен быть email")
+	ErrorSCIMGroupName         = fmt.Errorf("displayName группы не может быть пустым")
+	ErrorSCIMEmailImmutable    = fmt.Errorf("Email глобальной учетной записи нельзя изменить из организации")
+	ErrorSCIMPasswordImmutable = fmt.Errorf("Пароль глобальной учетной записи нельзя изменить из организации")
+	ErrorSCIMTokenNotFound     = fmt.Errorf("Токен провижининга не найден")
+	ErrorSCIMInvalidToken      = fmt.Errorf("Невалидный токен провижининга")
+	ErrorSCIMRole              = fmt.Errorf("Роль не разрешена для назначения через SCIM")
+)
+
+// SCIMUseCase — ресурсы SCIM 2.0 поверх пользователей и групп. userName пользователя — его
+// email, роль — основной элемент roles; externalId и active хранятся отдельно.
+// Участники групп — только пользователи, вложенные группы через SCIM не передаются.
+type SCIMUseCase interface {
+	ListUsers(filter scim.Filter) ([]scim.User, error)
+	GetUser(id int) (scim.User, error)
+	CreateUser(user scim.User) (scim.User, error)
+	ReplaceUser(id int, user scim.User) (scim.User, error)
+	PatchUser(id int, operations []scim.PatchOperation) (scim.User, error)
+	DeleteUser(id int) error
+
+	// ListGroups без withMembers не читает участников (excludedAttributes=members)
+	ListGroups(filter scim.Filter, withMembers bool) ([]scim.Group, error)
+	GetGroup(id int) (scim.Group, error)
+	CreateGroup(group scim.Group) (scim.Group, error)
+	ReplaceGroup(id int, group scim.Group) (scim.Group, error)
+	PatchGroup(id int, operations []scim.PatchOperation) (scim.Group, error)
+	DeleteGroup(id int) error
+
+	GetTokens() ([]entity.SCIMToken, error)
+	// CreateToken возвращает токен целиком; он показывается один раз
+	CreateToken(name string) (entity.SCIMToken, string, error)
+	RevokeToken(id int) error
+	Authenticate(token string) (entity.SCIMToken, error)
+	// IsUserDisabled — проверка для jwt.UserDisabled; при ошибке пользователь считается отключенным
+	IsUserDisabled(userID int, tenant string) bool
+
+	// WithTenant возвращает use case, работающий с пользователями и группами организации
+	WithTenant(org entity.Organization) SCIMUseCase
+}
+
+type SCIMUseCaseImpl struct {
+	cfg    config.SCIM
+	users  UseCase
+	rep    repository.Repository
+	groups GroupUseCase
+	repo   repository.SCIMRepository
+	tenant entity.Organization
+	// touched — когда токен последний раз отмечен использованным, чтобы не писать в базу на каждый запрос
+	touched *sync.Map
+}
+
+func NewSCIMUseCase(cfg config.SCIM, users UseCase, rep repository.Repository, groups GroupUseCase, repo repository.SCIMRepository) SCIMUseCase {
+	return &SCIMUseCaseImpl{cfg: cfg, users: users, rep: rep, groups: groups, repo: repo, touched: &sync.Map{}}
+}
+
+func (s *SCIMUseCaseImpl) WithTenant(org entity.Organization) SCIMUseCase {
+	return &SCIMUseCaseImpl{
+		cfg:     s.cfg,
+		users:   s.users.WithTenant(org),
+		rep:     s.rep.WithTenant(org),
+		groups:  s.groups.WithTenant(org),
+		repo:    s.repo,
+		tenant:  org,
+		touched: s.touched,
+	}
+}
+
+func (s *SCIMUseCaseImpl) ListUsers(filter scim.Filter) ([]scim.User, error) {
+	users, err := s.users.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := s.repo.GetResources(entity.SCIMResourceUser, s.tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []scim.User{}
+	for _, user := range users {
+		resource, ok := resources[user.ID]
+		scimUser := toSCIMUser(user, resource, ok)
+		if matches, err := matchFilter(filter, scimUser); err != nil || !matches {
+			continue
+		}
+		result = append(result, scimUser)
+	}
+	return result, nil
+}
+
+func (s *SCIMUseCaseImpl) GetUser(id int) (scim.User, error) {
+	user, err := s.users.GetUserByID(id)
+	if err != nil {
+		return scim.User{}, ErrorSCIMNotFound
+	}
+	resource, ok, err := s.repo.GetResource(entity.SCIMResourceUser, s.tenant.ID, id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return toSCIMUser(user, resource, ok), nil
+}
+
+func (s *SCIMUseCaseImpl) CreateUser(user scim.User) (scim.User, error) {
+	email, err := scimEmail(user)
+	if err != nil {
+		return scim.User{}, err
+	}
+	if _, err := s.users.GetUserByEmail(email); err == nil {
+		return scim.User{}, ErrorSCIMUserExists
+	}
+
+	// у пользователя из IdP может не быть пароля: он входит через SSO
+	role, err := s.role(user.Roles, "user")
+	if err != nil {
+		return scim.User{}, err
+	}
+	password := user.Password
+	if password == "" {
+		if password, err = randomHex(32); err != nil {
+			return scim.User{}, err
+		}
+	}
+	err = s.users.CreateUser(entity.User{
+		Name:     scimDisplayName(user, email),
+		Email:    email,
+		Password: password,
+		Role:     role,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrUserExists) {
+			return scim.User{}, ErrorSCIMUserExists
+		}
+		return scim.User{}, err
+	}
+	created, err := s.users.GetUserByEmail(email)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	active := user.Active == nil || bool(*user.Active)
+	if err := s.saveResource(entity.SCIMResourceUser, created.ID, user.ExternalID, active); err != nil {
+		return scim.User{}, err
+	}
+	logger.Logger.Info("Пользователь создан через SCIM",
+		zap.Int("user_id", created.ID),
+		zap.String("tenant", s.tenant.Slug))
+	return s.GetUser(created.ID)
+}
+
+// ReplaceUser заменяет атрибуты пользователя. Не переданные name, roles, active и
+// password сохраняют текущие значения. В организации пароль и email меняются только
+// у ее собственных учетных записей: глобальная учетная запись участника общая для всех
+// организаций, и токен провижининга одной из них не может ее перехватить. Имя такой
+// учетной записи IdP организации тоже не меняет, роль в организации — меняет.
+func (s *SCIMUseCaseImpl) ReplaceUser(id int, user scim.User) (scim.User, error) {
+	existing, err := s.users.GetUserByID(id)
+	if err != nil {
+		return scim.User{}, ErrorSCIMNotFound
+	}
+	resource, ok, err := s.repo.GetResource(entity.SCIMResourceUser, s.tenant.ID, id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	email, err := scimEmail(user)
+	if err != nil {
+		return scim.User{}, err
+	}
+	owned, err := s.rep.Owned(id)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	emailChanged := !strings.EqualFold(email, existing.Email)
+	if !owned && (emailChanged || user.Password != "") {
+		logger.Logger.Warn("SCIM: отказ в изменении учетных данных глобальной учетной записи",
+			zap.Int("user_id", id),
+			zap.String("tenant", s.tenant.Slug),
+			zap.Bool("email", emailChanged),
+			zap.Bool("password", user.Password != ""))
+		if emailChanged {
+			return scim.User{}, ErrorSCIMEmailImmutable
+		}
+		return scim.User{}, ErrorSCIMPasswordImmutable
+	}
+
+	if emailChanged {
+		if _, err := s.users.GetUserByEmail(email); err == nil {
+			return scim.User{}, ErrorSCIMUserExists
+		}
+		if err := s.rep.UpdateEmail(id, email); err != nil {
+			if errors.Is(err, repository.ErrUserExists) {
+				return scim.User{}, ErrorSCIMUserExists
+			}
+			return scim.User{}, err
+		}
+	}
+
+	name := existing.Name
+	if owned {
+		name = scimDisplayName(user, existing.Name)
+	}
+	role, err := s.role(user.Roles, existing.Role)
+	if err != nil {
+		return scim.User{}, err
+	}
+	if name != existing.Name || role != existing.Role {
+		if err := s.rep.UpdateProfile(entity.User{ID: id, Name: name, Role: role}); err != nil {
+			return scim.User{}, err
+		}
+	}
+	if user.Password != "" {
+		if err := s.users.UpdatePassword(entity.User{ID: id, Password: user.Password}); err != nil {
+			return scim.User{}, err
+		}
+	}
+
+	active := !ok || resource.Active
+	if user.Active != nil {
+		active = bool(*user.Active)
+	}
+	if err := s.saveResource(entity.SCIMResourceUser, id, user.ExternalID, active); err != nil {
+		return scim.User{}, err
+	}
+	return s.GetUser(id)
+}
+
+func (s *SCIMUseCaseImpl) PatchUser(id int, operations []scim.PatchOperation) (scim.User, error) {
+	current, err := s.GetUser(id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	var patched scim.User
+	if err := patchResource(current, operations, &patched); err != nil {
+		return scim.User{}, err
+	}
+
+	// имя берется из того атрибута, который поменял PATCH: IdP меняют либо displayName,
+	// либо name.givenName и name.familyName
+	if patched.DisplayName == current.DisplayName {
+		patched.DisplayName = ""
+	}
+	if patched.Name != nil && current.Name != nil && patched.Name.Formatted == current.Name.Formatted &&
+		(patched.Name.GivenName != "" || patched.Name.FamilyName != "") {
+		patched.Name.Formatted = ""
+	}
+	return s.ReplaceUser(id, patched)
+}
+
+// DeleteUser в контексте организации удаляет членство, а собственную учетную запись
+// организации — целиком.
+func (s *SCIMUseCaseImpl) DeleteUser(id int) error {
+	if _, err := s.users.GetUserByID(id); err != nil {
+		return ErrorSCIMNotFound
+	}
+	if err := s.users.DeleteUser(id); err != nil {
+		return err
+	}
+	logger.Logger.Info("Пользователь удален через SCIM",
+		zap.Int("user_id", id),
+		zap.String("tenant", s.tenant.Slug))
+	return nil
+}
+
+func (s *SCIMUseCaseImpl) ListGroups(filter scim.Filter, withMembers bool) ([]scim.Group, error) {
+	groups, err := s.groups.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := s.repo.GetResources(entity.SCIMResourceGroup, s.tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []scim.Group{}
+	for _, group := range groups {
+		var members []entity.GroupMember
+		// фильтр может ссылаться на участников, поэтому с ним они читаются всегда
+		if withMembers || filter != nil {
+			if members, err = s.groups.GetMembers(group.ID); err != nil {
+				return nil, err
+			}
+		}
+		resource, ok := resources[group.ID]
+		scimGroup := toSCIMGroup(group, members, resource, ok)
+		if matches, err := matchFilter(filter, scimGroup); err != nil || !matches {
+			continue
+		}
+		if !withMembers {
+			scimGroup.Members = nil
+		}
+		result = append(result, scimGroup)
+	}
+	return result, nil
+}
+
+func (s *SCIMUseCaseImpl) GetGroup(id int) (scim.Group, error) {
+	group, err := s.groups.GetGroup(id)
+	if err != nil {
+		return scim.Group{}, ErrorSCIMNotFound
+	}
+	members, err := s.groups.GetMembers(id)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	resource, ok, err := s.repo.GetResource(entity.SCIMResourceGroup, s.tenant.ID, id)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return toSCIMGroup(group, members, resource, ok), nil
+}
+
+func (s *SCIMUseCaseImpl) CreateGroup(group scim.Group) (scim.Group, error) {
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return scim.Group{}, ErrorSCIMGroupName
+	}
+	created, err := s.groups.CreateGroup(entity.Group{Name: group.DisplayName})
+	if err != nil {
+		return scim.Group{}, err
+	}
+	if err := s.setMembers(created.ID, group.Members); err != nil {
+		return scim.Group{}, err
+	}
+	if err := s.saveResource(entity.SCIMResourceGroup, created.ID, group.ExternalID, true); err != nil {
+		return scim.Group{}, err
+	}
+	return s.GetGroup(created.ID)
+}
+
+func (s *SCIMUseCaseImpl) ReplaceGroup(id int, group scim.Group) (scim.Group, error) {
+	existing, err := s.groups.GetGroup(id)
+	if err != nil {
+		return scim.Group{}, ErrorSCIMNotFound
+	}
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return scim.Group{}, ErrorSCIMGroupName
+	}
+	if group.DisplayName != existing.Name {
+		existing.Name = group.DisplayName
+		if _, err := s.groups.UpdateGroup(existing); err != nil {
+			return scim.Group{}, err
+		}
+	}
+	if err := s.setMembers(id, group.Members); err != nil {
+		return scim.Group{}, err
+	}
+	if err := s.saveResource(entity.SCIMResourceGroup, id, group.ExternalID, true); err != nil {
+		return scim.Group{}, err
+	}
+	return s.GetGroup(id)
+}
+
+func (s *SCIMUseCaseImpl) PatchGroup(id int, operations []scim.PatchOperation) (scim.Group, error) {
+	current, err := s.GetGroup(id)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	var patched scim.Group
+	if err := patchResource(current, operations, &patched); err != nil {
+		return scim.Group{}, err
+	}
+	return s.ReplaceGroup(id, patched)
+}
+
+func (s *SCIMUseCaseImpl) DeleteGroup(id int) error {
+	if err := s.groups.DeleteGroup(id); err != nil {
+		return ErrorSCIMNotFound
+	}
+	return nil
+}
+
+// setMembers приводит участников группы к списку members: недостающие добавляются,
+// лишние исключаются.
+func (s *SCIMUseCaseImpl) setMembers(id int, members []scim.MultiValue) error {
+	wanted := map[int]bool{}
+	for _, member := range members {
+		userID, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return ErrorGroupMemberNotFound
+		}
+		wanted[userID] = true
+	}
+
+	current, err := s.groups.GetMembers(id)
+	if err != nil {
+		return err
+	}
+	for _, member := range current {
+		if wanted[member.UserID] {
+			delete(wanted, member.UserID)
+			continue
+		}
+		if err := s.groups.RemoveMember(id, member.UserID); err != nil {
+			return err
+		}
+	}
+	for userID := range wanted {
+		if err := s.groups.AddMember(id, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SCIMUseCaseImpl) saveResource(resourceType string, id int, externalID string, active bool) error {
+	return s.repo.SaveResource(entity.SCIMResource{
+		Type:       resourceType,
+		ResourceID: id,
+		TenantID:   s.tenant.ID,
+		ExternalID: externalID,
+		Active:     active,
+	})
+}
+
+func (s *SCIMUseCaseImpl) GetTokens() ([]entity.SCIMToken, error) {
+	return s.repo.GetTokens(s.tenant.ID)
+}
+
+func (s *SCIMUseCaseImpl) CreateToken(name string) (entity.SCIMToken, string, error) {
+	prefix, err := randomHex(4)
+	if err != nil {
+		return entity.SCIMToken{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return entity.SCIMToken{}, "", err
+	}
+
+	token, err := s.repo.CreateToken(entity.SCIMToken{
+		TenantID:  s.tenant.ID,
+		Name:      name,
+		Prefix:    prefix,
+		TokenHash: hashAPIKeySecret(secret),
+		CreateAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		return entity.SCIMToken{}, "", err
+	}
+	token.Tenant = s.tenant.Slug
+	logger.Logger.Info("Создан токен провижининга",
+		zap.Int("token_id", token.ID),
+		zap.String("tenant", s.tenant.Slug))
+	return token, scimTokenPrefix + prefix + "_" + secret, nil
+}
+
+func (s *SCIMUseCaseImpl) RevokeToken(id int) error {
+	if err := s.repo.RevokeToken(s.tenant.ID, id); err != nil {
+		return ErrorSCIMTokenNotFound
+	}
+	logger.Logger.Info("Отозван токен провижининга",
+		zap.Int("token_id", id),
+		zap.String("tenant", s.tenant.Slug))
+	return nil
+}
+
+func (s *SCIMUseCaseImpl) Authenticate(token string) (entity.SCIMToken, error) {
+	parts := strings.SplitN(strings.TrimPrefix(token, scimTokenPrefix), "_", 2)
+	if !strings.HasPrefix(token, scimTokenPrefix) || len(parts) != 2 {
+		return entity.SCIMToken{}, ErrorSCIMInvalidToken
+	}
+
+	found, err := s.repo.GetTokenByPrefix(parts[0])
+	if err != nil || found.Revoked {
+		return entity.SCIMToken{}, ErrorSCIMInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(found.TokenHash), []byte(hashAPIKeySecret(parts[1]))) != 1 {
+		return entity.SCIMToken{}, ErrorSCIMInvalidToken
+	}
+
+	now := time.Now()
+	if last, ok := s.touched.Load(found.ID); !ok || now.Sub(last.(time.Time)) > scimTouchInterval {
+		s.touched.Store(found.ID, now)
+		if err := s.repo.TouchToken(found.ID); err != nil {
+			logger.Logger.Warn("Не удалось отметить использование токена провижининга",
+				zap.Error(err),
+				zap.Int("token_id", found.ID))
+		}
+	}
+	return found, nil
+}
+
+func (s *SCIMUseCaseImpl) IsUserDisabled(userID int, tenant string) bool {
+	disabled, err := s.repo.IsUserDisabled(userID, tenant)
+	if err != nil {
+		logger.Logger.Error("Ошибка проверки статуса пользователя",
+			zap.Error(err),
+			zap.Int("user_id", userID))
+		return true
+	}
+	return disabled
+}
+
+func toSCIMUser(user entity.User, resource entity.SCIMResource, ok bool) scim.User {
+	active := scim.Bool(!ok || resource.Active)
+	created := scimTime(user.CreateAt)
+	modified := created
+	if ok {
+		modified = time.Unix(resource.UpdateAt, 0).UTC().Format(time.RFC3339)
+	}
+	return scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          strconv.Itoa(user.ID),
+		ExternalID:  resource.ExternalID,
+		UserName:    user.Email,
+		Name:        &scim.Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Roles:       []scim.MultiValue{{Value: user.Role, Primary: true}},
+		Meta:        &scim.Meta{ResourceType: "User", Created: created, LastModified: modified},
+	}
+}
+
+func toSCIMGroup(group entity.Group, members []entity.GroupMember, resource entity.SCIMResource, ok bool) scim.Group {
+	created := scimTime(group.CreateAt)
+	modified := created
+	if ok {
+		modified = time.Unix(resource.UpdateAt, 0).UTC().Format(time.RFC3339)
+	}
+	scimMembers := make([]scim.MultiValue, 0, len(members))
+	for _, member := range members {
+		scimMembers = append(scimMembers, scim.MultiValue{Value: strconv.Itoa(member.UserID), Display: member.Email})
+	}
+	return scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.Itoa(group.ID),
+		ExternalID:  resource.ExternalID,
+		DisplayName: group.Name,
+		Members:     scimMembers,
+		Meta:        &scim.Meta{ResourceType: "Group", Created: created, LastModified: modified},
+	}
+}
+
+// scimTime переводит create_at в RFC 3339. Драйвер отдает его уже в RFC 3339, если
+// распознал время, иначе — строкой time.Time.String(), как он был записан.
+func scimTime(createAt string) string {
+	parsed, err := time.Parse(time.RFC3339Nano, createAt)
+	if err != nil {
+		value, _, _ := strings.Cut(createAt, " m=")
+		if parsed, err = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value); err != nil {
+			return ""
+		}
+	}
+	return parsed.UTC().Format(time.RFC3339)
+}
+
+// scimEmail — userName в роли email; без "@" он не принимается, так как вход идет по email.
+func scimEmail(user scim.User) (string, error) {
+	email := strings.TrimSpace(user.UserName)
+	if !strings.Contains(email, "@") {
+		return "", ErrorSCIMUserName
+	}
+	return email, nil
+}
+
+// scimDisplayName выбирает имя из displayName, name.formatted или givenName и familyName.
+func scimDisplayName(user scim.User, fallback string) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	if user.Name != nil {
+		if user.Name.Formatted != "" {
+			return user.Name.Formatted
+		}
+		if full := strings.TrimSpace(user.Name.GivenName + " " + user.Name.FamilyName); full != "" {
+			return full
+		}
+	}
+	return fallback
+}
+
+// scimRole — основная роль из roles, иначе первая; без ролей — fallback.
+func scimRole(roles []scim.MultiValue, fallback string) string {
+	for _, role := range roles {
+		if role.Primary && role.Value != "" {
+			return role.Value
+		}
+	}
+	for _, role := range roles {
+		if role.Value != "" {
+			return role.Value
+		}
+	}
+	return fallback
+}
+
+// role выбирает роль из roles. Назначить можно только роль из настройки scim.roles;
+// fallback (текущая роль пользователя) сохраняется, даже если ее нет в списке.
+func (s *SCIMUseCaseImpl) role(roles []scim.MultiValue, fallback string) (string, error) {
+	role := scimRole(roles, fallback)
+	if role != fallback && !slices.Contains(s.cfg.Roles, role) {
+		logger.Logger.Warn("Отклонена роль из SCIM",
+			zap.String("role", role),
+			zap.String("tenant", s.tenant.Slug))
+		return "", ErrorSCIMRole
+	}
+	return role, nil
+}
+
+func matchFilter(filter scim.Filter, resource any) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	values, err := scim.ToMap(resource)
+	if err != nil {
+		return false, err
+	}
+	return filter.Match(values), nil
+}
+
+// patchResource применяет PATCH к текущему представлению ресурса и разбирает результат в patched.
+func patchResource(current any, operations []scim.PatchOperation, patched any) error {
+	values, err := scim.ToMap(current)
+	if err != nil {
+		return err
+	}
+	if err := scim.ApplyPatch(values, operations); err != nil {
+		return err
+	}
+	return scim.FromMap(values, patched)
+}
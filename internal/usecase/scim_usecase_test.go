@@ -0,0 +1,187 @@
+package usecase
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/scim"
+	"strconv"
+	"testing"
+)
+
+func newTestSCIM(t *testing.T, roles ...string) (SCIMUseCase, repository.Repository) {
+	t.Helper()
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	groups := repository.NewGroupRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	rep := repository.NewSCIMRep(db)
+	return NewSCIMUseCase(config.SCIM{Roles: roles}, NewUserUseCase(&users), &users,
+		NewGroupUseCase(config.Groups{}, &groups, &orgs), &rep), &users
+}
+
+func scimRoles(values ...string) []scim.MultiValue {
+	roles := make([]scim.MultiValue, 0, len(values))
+	for _, v := range values {
+		roles = append(roles, scim.MultiValue{Value: v})
+	}
+	return roles
+}
+
+func TestSCIMRoleAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []scim.MultiValue
+		want    string
+		wantErr error
+	}{
+		{"без ролей", nil, "user", nil},
+		{"разрешенная роль", scimRoles("editor"), "editor", nil},
+		{"запрещенная роль", scimRoles("admin"), "", ErrorSCIMRole},
+		{"основная роль запрещена", []scim.MultiValue{{Value: "editor"}, {Value: "admin", Primary: true}}, "", ErrorSCIMRole},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestSCIM(t, "user", "editor")
+			email := "u" + strconv.Itoa(i) + "@a.com"
+
+			created, err := s.CreateUser(scim.User{UserName: email, Roles: tt.roles})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := users.GetByEmail(email); err == nil {
+					t.Fatal("пользователь с запрещенной ролью создан")
+				}
+				return
+			}
+			id, _ := strconv.Atoi(created.ID)
+			stored, err := users.GetByID(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Role != tt.want {
+				t.Fatalf("роль %q, ожидалась %q", stored.Role, tt.want)
+			}
+		})
+	}
+}
+
+func TestSCIMReplaceUserCannotEscalate(t *testing.T) {
+	s, users := newTestSCIM(t, "user")
+	created, err := s.CreateUser(scim.User{UserName: "u@a.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := strconv.Atoi(created.ID)
+
+	_, err = s.PatchUser(id, []scim.PatchOperation{{Op: "replace", Path: "roles", Value: []any{map[string]any{"value": "admin"}}}})
+	if !errors.Is(err, ErrorSCIMRole) {
+		t.Fatalf("ожидалась ErrorSCIMRole, получено %v", err)
+	}
+	if stored, _ := users.GetByID(id); stored.Role != "user" {
+		t.Fatalf("роль изменена на %q", stored.Role)
+	}
+}
+
+func TestSCIMReplaceUserKeepsCurrentRole(t *testing.T) {
+	s, users := newTestSCIM(t, "user")
+	admin, err := users.GetByEmail("admin@a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IdP без roles не снимает и не подтверждает роль, которую назначили не через SCIM
+	if _, err := s.ReplaceUser(admin.ID, scim.User{UserName: admin.Email, DisplayName: "Root"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := users.GetByID(admin.ID); stored.Role != "admin" || stored.Name != "Root" {
+		t.Fatalf("неожиданный пользователь %+v", stored)
+	}
+}
+
+// Токен провижининга организации не может сменить пароль или email глобальной учетной
+// записи, которая состоит в организации: иначе IdP одной организации перехватил бы
+// учетную запись, общую для всех.
+func TestSCIMTenantCannotTakeOverGlobalMember(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	groups := repository.NewGroupRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	rep := repository.NewSCIMRep(db)
+	global := NewSCIMUseCase(config.SCIM{Roles: []string{"user", "editor"}}, NewUserUseCase(&users), &users,
+		NewGroupUseCase(config.Groups{}, &groups, &orgs), &rep)
+	acme := newTestOrg(t, db, "acme", true)
+	s := global.WithTenant(acme)
+
+	admin, err := users.GetByEmail("admin@a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orgs.SetMember(acme.ID, admin.ID, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    scim.User
+		wantErr error
+	}{
+		{"пароль", scim.User{UserName: admin.Email, Password: "taken-over"}, ErrorSCIMPasswordImmutable},
+		{"email", scim.User{UserName: "attacker@evil.com"}, ErrorSCIMEmailImmutable},
+		{"email и пароль", scim.User{UserName: "attacker@evil.com", Password: "taken-over"}, ErrorSCIMEmailImmutable},
+		{"пароль через PATCH", scim.User{}, ErrorSCIMPasswordImmutable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.user.UserName == "" {
+				_, err = s.PatchUser(admin.ID, []scim.PatchOperation{{Op: "replace", Path: "password", Value: "taken-over"}})
+			} else {
+				_, err = s.ReplaceUser(admin.ID, tt.user)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			stored, _ := users.GetByID(admin.ID)
+			if stored.Email != admin.Email || stored.Password != admin.Password {
+				t.Fatalf("глобальная учетная запись изменена: %+v", stored)
+			}
+		})
+	}
+
+	// имя глобальной учетной записи не меняется, роль в организации — меняется
+	if _, err := s.ReplaceUser(admin.ID, scim.User{UserName: admin.Email, DisplayName: "Renamed", Roles: scimRoles("editor")}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := users.GetByID(admin.ID); stored.Name != admin.Name || stored.Role != "admin" {
+		t.Fatalf("глобальная учетная запись изменена: %+v", stored)
+	}
+	if member, _ := users.WithTenant(acme).GetByID(admin.ID); member.Role != "editor" {
+		t.Fatalf("роль в организации %q, ожидалась editor", member.Role)
+	}
+
+	// собственную учетную запись организации с изолированными email IdP меняет полностью
+	created, err := s.CreateUser(scim.User{UserName: "own@acme.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := strconv.Atoi(created.ID)
+	if _, err := s.ReplaceUser(id, scim.User{UserName: "renamed@acme.com", DisplayName: "Own", Password: "new-password"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := users.GetByID(id); stored.Email != "renamed@acme.com" || stored.Name != "Own" {
+		t.Fatalf("собственная учетная запись не изменена: %+v", stored)
+	}
+
+	// без изолированных email организация создает глобальные учетные записи
+	beta := global.WithTenant(newTestOrg(t, db, "beta", false))
+	created, err = beta.CreateUser(scim.User{UserName: "shared@beta.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ = strconv.Atoi(created.ID)
+	if _, err := beta.ReplaceUser(id, scim.User{UserName: "shared@beta.com", Password: "new-password"}); !errors.Is(err, ErrorSCIMPasswordImmutable) {
+		t.Fatalf("смена пароля глобальной учетной записи: %v", err)
+	}
+}
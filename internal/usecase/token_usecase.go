@@ -0,0 +1,321 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiKeyPrefix = "ak_"
+
+var (
+	ErrorUnauthorizedClient = fmt.Errorf("Клиенту не разрешена эта операция")
+	ErrorAPIKeyNotFound     = fmt.Errorf("API ключ не найден")
+	ErrorInvalidRefresh     = fmt.Errorf("Невалидный refresh токен")
+)
+
+type TokenUseCase interface {
+	GetAPIKeys(userID int) ([]entity.APIKey, error)
+	// CreateAPIKey создает ключ пользователя subject; scopes должны входить в разрешения
+	// его ролей (или в scope токена, если токен уже ограничен)
+	CreateAPIKey(subject *jwt.Claims, name string, scopes []string, ttl time.Duration) (entity.APIKey, string, error)
+	RevokeAPIKey(userID int, id int) error
+	Introspect(token string, hint string) entity.TokenIntrospection
+	Revoke(token string, hint string, caller entity.ServiceAccount) error
+	RevokeToken(token string) error
+	// Logout отзывает токены сессии и записывает событие user.logged_out
+	Logout(tokens ...string) error
+	// Refresh отзывает предъявленный refresh токен и выдает новую пару токенов.
+	// Каждый refresh токен обменивается один раз.
+	Refresh(refreshToken string) (entity.User, string, string, int64, error)
+	// IsRevoked — проверка для jwt.RevocationCheck; при ошибке токен считается отозванным
+	IsRevoked(claims *jwt.Claims) bool
+	// WithTenant возвращает use case, обновляющий токены только участников организации
+	WithTenant(org entity.Organization) TokenUseCase
+}
+
+type TokenUseCaseImpl struct {
+	repo   repository.TokenRepository
+	events repository.OutboxRepository
+	groups config.Groups
+	users  UseCase
+	tenant string
+}
+
+func NewTokenUseCase(repo repository.TokenRepository, events repository.OutboxRepository, groups config.Groups, users UseCase) TokenUseCase {
+	return &TokenUseCaseImpl{repo: repo, events: events, groups: groups, users: users}
+}
+
+func (t *TokenUseCaseImpl) WithTenant(org entity.Organization) TokenUseCase {
+	return &TokenUseCaseImpl{repo: t.repo, events: t.events, groups: t.groups, users: t.users.WithTenant(org), tenant: org.Slug}
+}
+
+func (t *TokenUseCaseImpl) GetAPIKeys(userID int) ([]entity.APIKey, error) {
+	return t.repo.GetAPIKeysByUser(userID)
+}
+
+func (t *TokenUseCaseImpl) CreateAPIKey(subject *jwt.Claims, name string, scopes []string, ttl time.Duration) (entity.APIKey, string, error) {
+	// как при обмене токена: ключ не может получить больше, чем есть у создающего токена
+	available := strings.Fields(subject.Scope)
+	if len(available) == 0 {
+		available = t.groups.PermissionsFor(subject.EffectiveRoles())
+	}
+	if len(scopes) > 0 {
+		if _, err := grantScopes(available, scopes); err != nil {
+			logger.Logger.Warn("Запрошен scope API ключа вне разрешений пользователя",
+				zap.Int("user_id", subject.ID),
+				zap.Strings("scopes", scopes))
+			return entity.APIKey{}, "", err
+		}
+	}
+
+	prefix, err := randomHex(4)
+	if err != nil {
+		return entity.APIKey{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return entity.APIKey{}, "", err
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	key, err := t.repo.CreateAPIKey(entity.APIKey{
+		UserID:    subject.ID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKeySecret(secret),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+		CreateAt:  time.Now().String(),
+	})
+	if err != nil {
+		return entity.APIKey{}, "", err
+	}
+	return key, apiKeyPrefix + prefix + "_" + secret, nil
+}
+
+func (t *TokenUseCaseImpl) RevokeAPIKey(userID int, id int) error {
+	keys, err := t.repo.GetAPIKeysByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return t.repo.RevokeAPIKey(id)
+		}
+	}
+	return ErrorAPIKeyNotFound
+}
+
+func (t *TokenUseCaseImpl) Introspect(token string, hint string) entity.TokenIntrospection {
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		key, err := t.lookupAPIKey(token)
+		if err != nil || key.Revoked || (key.ExpiresAt != 0 && key.ExpiresAt < time.Now().Unix()) {
+			return entity.TokenIntrospection{Active: false}
+		}
+		// ключ отключенного пользователя неактивен, как и его токены
+		if jwt.UserDisabled != nil && jwt.UserDisabled(key.UserID, "") {
+			return entity.TokenIntrospection{Active: false}
+		}
+		return entity.TokenIntrospection{
+			Active:    true,
+			Scope:     key.Scopes,
+			TokenType: "api_key",
+			Exp:       key.ExpiresAt,
+			Sub:       strconv.Itoa(key.UserID),
+			Jti:       apiKeyPrefix + key.Prefix,
+		}
+	}
+
+	claims, err := jwt.ValidateToken(token, jwt.AnyAudience)
+	if err != nil {
+		logger.Logger.Debug("Интроспекция невалидного токена",
+			zap.String("hint", hint),
+			zap.Error(err))
+		return entity.TokenIntrospection{Active: false}
+	}
+	return introspectClaims(claims)
+}
+
+func introspectClaims(claims *jwt.Claims) entity.TokenIntrospection {
+	result := entity.TokenIntrospection{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: claims.TokenUse + "_token",
+		Sub:       claims.Subject,
+		Jti:       claims.RegisteredClaims.ID,
+		Role:      claims.Role,
+	}
+	if claims.TokenUse == jwt.TokenUseClient {
+		result.TokenType = "access_token"
+	}
+	result.Aud = claims.Audience
+	for actor, next := claims.Act, &result.Act; actor != nil; actor = actor.Act {
+		*next = &entity.TokenActor{Sub: actor.Sub}
+		next = &(*next).Act
+	}
+	if claims.ExpiresAt != nil {
+		result.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result.Iat = claims.IssuedAt.Unix()
+	}
+	return result
+}
+
+func (t *TokenUseCaseImpl) Revoke(token string, hint string, caller entity.ServiceAccount) error {
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		if !jwt.HasScope(caller.Scopes, jwt.ScopeTokenRevoke) {
+			return ErrorUnauthorizedClient
+		}
+		key, err := t.lookupAPIKey(token)
+		if err != nil {
+			// RFC 7009: неизвестный токен не является ошибкой
+			return nil
+		}
+		return t.repo.RevokeAPIKey(key.ID)
+	}
+
+	claims, err := jwt.ValidateToken(token, jwt.AnyAudience)
+	if err != nil {
+		return nil
+	}
+	if claims.ClientID != caller.ClientID && !jwt.HasScope(caller.Scopes, jwt.ScopeTokenRevoke) {
+		return ErrorUnauthorizedClient
+	}
+
+	logger.Logger.Info("Отзыв токена",
+		zap.String("hint", hint),
+		zap.String("sub", claims.Subject),
+		zap.String("by", caller.ClientID))
+	return t.revokeClaims(claims)
+}
+
+func (t *TokenUseCaseImpl) RevokeToken(token string) error {
+	claims, err := jwt.ValidateToken(token, jwt.AnyAudience)
+	if err != nil {
+		return nil
+	}
+	return t.revokeClaims(claims)
+}
+
+func (t *TokenUseCaseImpl) Logout(tokens ...string) error {
+	var user *jwt.Claims
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		claims, err := jwt.ValidateToken(token, jwt.AnyAudience)
+		if err != nil {
+			continue
+		}
+		if err := t.revokeClaims(claims); err != nil {
+			return err
+		}
+		if user == nil && claims.ID != 0 {
+			user = claims
+		}
+	}
+	if user != nil {
+		t.events.CreateEvent(entity.UserEvent{
+			Type:   entity.UserEventLoggedOut,
+			UserID: user.ID,
+			Email:  user.Email,
+			Role:   user.Role,
+			Tenant: user.Tenant,
+		})
+	}
+	return nil
+}
+
+func (t *TokenUseCaseImpl) revokeClaims(claims *jwt.Claims) error {
+	if claims.RegisteredClaims.ID == "" || claims.ExpiresAt == nil {
+		return fmt.Errorf("Токен не поддерживает отзыв")
+	}
+	return t.repo.RevokeToken(claims.RegisteredClaims.ID, claims.ExpiresAt.Unix())
+}
+
+func (t *TokenUseCaseImpl) Refresh(refreshToken string) (entity.User, string, string, int64, error) {
+	claims, err := jwt.ValidateToken(refreshToken)
+	if err != nil || !claims.IsRefresh() || claims.Tenant != t.tenant || claims.ExpiresAt == nil {
+		return entity.User{}, "", "", 0, ErrorInvalidRefresh
+	}
+	// по ID, а не по email: email мог перейти к другому пользователю
+	user, err := t.users.GetUserByID(claims.ID)
+	if err != nil {
+		return entity.User{}, "", "", 0, ErrorUnknownUser
+	}
+
+	fresh, err := t.repo.ConsumeToken(claims.RegisteredClaims.ID, claims.ExpiresAt.Unix())
+	if err != nil {
+		return entity.User{}, "", "", 0, err
+	}
+	if !fresh {
+		logger.Logger.Warn("Повторное предъявление refresh токена",
+			zap.Int("user_id", user.ID),
+			zap.String("tenant", t.tenant))
+		return entity.User{}, "", "", 0, ErrorInvalidRefresh
+	}
+
+	access, expiresIn, err := jwt.GenerateAccessToken(user)
+	if err != nil {
+		return entity.User{}, "", "", 0, err
+	}
+	refresh, err := jwt.GenerateRefreshToken(user)
+	if err != nil {
+		return entity.User{}, "", "", 0, fmt.Errorf("ошибка генерации refresh токена: %w", err)
+	}
+	return user, access, refresh, expiresIn, nil
+}
+
+func (t *TokenUseCaseImpl) IsRevoked(claims *jwt.Claims) bool {
+	var issuedAt int64
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Unix()
+	}
+	revoked, err := t.repo.IsRevoked(claims.RegisteredClaims.ID, claims.ID, issuedAt)
+	if err != nil {
+		logger.Logger.Error("Ошибка проверки отзыва токена",
+			zap.Error(err),
+			zap.String("jti", claims.RegisteredClaims.ID))
+		return true
+	}
+	return revoked
+}
+
+func (t *TokenUseCaseImpl) lookupAPIKey(token string) (entity.APIKey, error) {
+	parts := strings.SplitN(strings.TrimPrefix(token, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return entity.APIKey{}, ErrorAPIKeyNotFound
+	}
+
+	key, err := t.repo.GetAPIKeyByPrefix(parts[0])
+	if err != nil {
+		return entity.APIKey{}, ErrorAPIKeyNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashAPIKeySecret(parts[1]))) != 1 {
+		return entity.APIKey{}, ErrorAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
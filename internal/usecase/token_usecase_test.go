@@ -0,0 +1,201 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	db := newTestDB(t)
+	tokens := repository.NewTokenRep(db)
+	outbox := repository.NewOutboxRep(db)
+	uc := NewTokenUseCase(&tokens, &outbox, config.Groups{Permissions: map[string][]string{
+		"user":    {"profile:read"},
+		"auditor": {"audit:read"},
+		"admin":   {"users:read", "users:write"},
+	}}, nil)
+
+	// ключи создаются для администратора из миграций, роли берутся из токена
+	user := &jwt.Claims{ID: 1, Role: "user"}
+	tests := []struct {
+		name    string
+		subject *jwt.Claims
+		scopes  []string
+		wantErr error
+	}{
+		{"без scopes", user, nil, nil},
+		{"разрешение роли", user, []string{"profile:read"}, nil},
+		{"чужое разрешение", user, []string{"users:write"}, ErrorInvalidScope},
+		{"часть запроса вне разрешений", user, []string{"profile:read", "users:read"}, ErrorInvalidScope},
+		{"роль из группы", &jwt.Claims{ID: 1, Role: "user", Roles: []string{"user", "auditor"}}, []string{"audit:read"}, nil},
+		{"администратор", &jwt.Claims{ID: 1, Role: "admin"}, []string{"users:read", "users:write"}, nil},
+		{"токен с scope сужает разрешения", &jwt.Claims{ID: 1, Role: "admin", Scope: "users:read"}, []string{"users:write"}, ErrorInvalidScope},
+		{"произвольный scope", user, []string{"*"}, ErrorInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, secret, err := uc.CreateAPIKey(tt.subject, "key", tt.scopes, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if key.UserID != tt.subject.ID {
+				t.Fatalf("ключ создан для пользователя %d", key.UserID)
+			}
+			if info := uc.Introspect(secret, ""); !info.Active || info.Scope != key.Scopes {
+				t.Fatalf("интроспекция ключа: %+v", info)
+			}
+		})
+	}
+}
+
+func TestIntrospectAPIKeyOfDeletedUser(t *testing.T) {
+	db := newTestDB(t)
+	tokens := repository.NewTokenRep(db)
+	outbox := repository.NewOutboxRep(db)
+	uc := NewTokenUseCase(&tokens, &outbox, config.Groups{}, nil)
+
+	newKey := func(email string) (int, string) {
+		t.Helper()
+		var id int
+		err := db.QueryRow(`INSERT INTO users (name, email, password, role, create_at)
+			VALUES ('u', $1, 'x', 'user', '2026-01-01') RETURNING id`, email).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, secret, err := uc.CreateAPIKey(&jwt.Claims{ID: id, Role: "user"}, "key", nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uc.Introspect(secret, "").Active {
+			t.Fatal("ключ неактивен до удаления пользователя")
+		}
+		return id, secret
+	}
+
+	// при включенных внешних ключах ключ удаляется вместе с пользователем
+	id, secret := newKey("cascade@a.com")
+	if _, err := db.Exec(`DELETE FROM users WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE user_id = $1`, id).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("ключей удаленного пользователя: %d (%v)", left, err)
+	}
+	if uc.Introspect(secret, "").Active {
+		t.Fatal("ключ удаленного пользователя активен")
+	}
+
+	// строка ключа, оставшаяся с тех пор, когда внешние ключи не проверялись
+	id, secret = newKey("orphan@a.com")
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{`PRAGMA foreign_keys = OFF`, `DELETE FROM users WHERE id = ` + strconv.Itoa(id), `PRAGMA foreign_keys = ON`} {
+		if _, err := conn.ExecContext(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+	if uc.Introspect(secret, "").Active {
+		t.Fatal("ключ без владельца активен")
+	}
+}
+
+// newRefreshTokens создает use case токенов с проверкой отзыва, подключенной к jwt,
+// как при старте приложения.
+func newRefreshTokens(t *testing.T) (TokenUseCase, UseCase, *sql.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	tokens := repository.NewTokenRep(db)
+	outbox := repository.NewOutboxRep(db)
+	users := repository.NewRep(db)
+	uc := NewUserUseCase(&users)
+	tu := NewTokenUseCase(&tokens, &outbox, config.Groups{}, uc)
+	jwt.RevocationCheck = tu.IsRevoked
+	t.Cleanup(func() { jwt.RevocationCheck = nil })
+	return tu, uc, db
+}
+
+func TestRefreshRotatesOnce(t *testing.T) {
+	tokens, users, db := newRefreshTokens(t)
+	admin, err := users.GetUserByEmail("admin@a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, _, err := jwt.GenerateAccessToken(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := jwt.GenerateRefreshToken(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, _, err := tokens.Refresh(access); !errors.Is(err, ErrorInvalidRefresh) {
+		t.Fatalf("access токен обменян как refresh: %v", err)
+	}
+	acme := newTestOrg(t, db, "acme", false)
+	if _, _, _, _, err := tokens.WithTenant(acme).Refresh(refresh); !errors.Is(err, ErrorInvalidRefresh) {
+		t.Fatalf("глобальный refresh токен обменян в организации: %v", err)
+	}
+
+	user, _, rotated, _, err := tokens.Refresh(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != admin.ID || rotated == refresh {
+		t.Fatalf("неожиданный результат обмена: %+v", user)
+	}
+	if _, _, _, _, err := tokens.Refresh(refresh); !errors.Is(err, ErrorInvalidRefresh) {
+		t.Fatalf("refresh токен обменян повторно: %v", err)
+	}
+	if _, _, _, _, err := tokens.Refresh(rotated); err != nil {
+		t.Fatalf("новый refresh токен не обменивается: %v", err)
+	}
+}
+
+func TestUpdatePasswordRevokesTokens(t *testing.T) {
+	tokens, users, _ := newRefreshTokens(t)
+	admin, err := users.GetUserByEmail("admin@a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// токены с заданным временем выпуска: выданный в ту же секунду, что и смена
+	// пароля, не отзывается
+	issued := func(at time.Time) *jwt.Claims {
+		return &jwt.Claims{ID: admin.ID, RegisteredClaims: gojwt.RegisteredClaims{
+			ID:       "jti-" + strconv.FormatInt(at.UnixNano(), 10),
+			IssuedAt: gojwt.NewNumericDate(at),
+		}}
+	}
+	before := issued(time.Now().Add(-time.Minute))
+	if tokens.IsRevoked(before) {
+		t.Fatal("токен отозван до смены пароля")
+	}
+
+	admin.Password = "new-password"
+	if err := users.UpdatePassword(admin); err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.IsRevoked(before) {
+		t.Fatal("токен, выданный до смены пароля, не отозван")
+	}
+	if tokens.IsRevoked(issued(time.Now().Add(time.Second))) {
+		t.Fatal("отозван токен, выданный после смены пароля")
+	}
+	if tokens.IsRevoked(&jwt.Claims{ID: 2, RegisteredClaims: before.RegisteredClaims}) {
+		t.Fatal("смена пароля отозвала токены другого пользователя")
+	}
+}
@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+var ErrorEventCursorExpired = fmt.Errorf("События после этого курсора уже удалены, перечитайте пользователей и начните с последнего события")
+
+type UserEventUseCase interface {
+	// Events возвращает до limit событий после cursor. Если часть событий после cursor
+	// уже удалена по сроку хранения, возвращает ErrorEventCursorExpired
+	Events(cursor int64, limit int) ([]entity.UserEvent, error)
+	// LatestCursor — курсор, с которого поток отдает только новые события
+	LatestCursor() (int64, error)
+	// RunRetention удаляет устаревшие события раз в час, пока не отменен ctx;
+	// запускается в отдельной горутине
+	RunRetention(ctx context.Context)
+	// OutboxSink пополняет ленту событиями outbox
+	OutboxSink
+}
+
+type UserEventUseCaseImpl struct {
+	cfg  config.Events
+	repo repository.UserEventRepository
+}
+
+func NewUserEventUseCase(cfg config.Events, repo repository.UserEventRepository) UserEventUseCase {
+	return &UserEventUseCaseImpl{cfg: cfg, repo: repo}
+}
+
+func (u *UserEventUseCaseImpl) Events(cursor int64, limit int) ([]entity.UserEvent, error) {
+	events, err := u.repo.GetEventsAfter(cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	if cursor > 0 && (len(events) == 0 || events[0].ID > cursor+1) {
+		oldest, err := u.repo.OldestCursor()
+		if err != nil {
+			return nil, err
+		}
+		if oldest > cursor+1 {
+			return nil, ErrorEventCursorExpired
+		}
+	}
+	return events, nil
+}
+
+func (u *UserEventUseCaseImpl) LatestCursor() (int64, error) {
+	return u.repo.LatestCursor()
+}
+
+func (u *UserEventUseCaseImpl) Name() string {
+	return "stream"
+}
+
+// Publish добавляет события в ленту под новыми ID, сохраняя порядок outbox.
+func (u *UserEventUseCaseImpl) Publish(events []entity.UserEvent) error {
+	return u.repo.AppendEvents(events)
+}
+
+func (u *UserEventUseCaseImpl) RunRetention(ctx context.Context) {
+	if u.cfg.Retention <= 0 {
+		return
+	}
+	for {
+		deleted, err := u.repo.DeleteEventsBefore(time.Now().Add(-u.cfg.Retention).Unix())
+		if err != nil {
+			logger.Logger.Error("Ошибка удаления старых событий пользователей", zap.Error(err))
+		} else if deleted > 0 {
+			logger.Logger.Info("Удалены старые события пользователей", zap.Int64("count", deleted))
+		}
+		if !wait(ctx, time.Hour) {
+			return
+		}
+	}
+}
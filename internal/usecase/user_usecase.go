@@ -1,11 +1,17 @@
 package usecase
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/LandGAA/authh2/internal/entity"
 	"github.com/LandGAA/authh2/internal/repository"
 	"github.com/LandGAA/authh2/pkg/jwt"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"github.com/LandGAA/authh2/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 	"time"
@@ -24,33 +30,77 @@ type UseCase interface {
 	CheckHashPassword(password string, user entity.User) bool
 	ToDTO(users []entity.User) []entity.DTOUser
 	Authenticate(email string, password string) (string, string, int64, error)
+	// WithTenant возвращает use case, видящий только участников организации
+	WithTenant(org entity.Organization) UseCase
+	// WithContext возвращает use case, спаны и запросы которого попадают в трассировку ctx
+	WithContext(ctx context.Context) UseCase
 }
 
 type UserUseCase struct {
-	repo repository.Repository
+	repo     repository.Repository
+	backends []AuthBackend
+	ctx      context.Context
 }
 
-func NewUserUseCase(repo repository.Repository) UseCase {
-	return &UserUseCase{repo: repo}
+// NewUserUseCase создает use case пользователей. backends проверяют пароль по порядку;
+// без них используется только локальная проверка bcrypt.
+func NewUserUseCase(repo repository.Repository, backends ...AuthBackend) UseCase {
+	if len(backends) == 0 {
+		backends = []AuthBackend{NewLocalBackend(repo, nil)}
+	}
+	return &UserUseCase{repo: repo, backends: backends}
+}
+
+func (u *UserUseCase) WithTenant(org entity.Organization) UseCase {
+	backends := make([]AuthBackend, 0, len(u.backends))
+	for _, backend := range u.backends {
+		backends = append(backends, backend.WithTenant(org))
+	}
+	return &UserUseCase{repo: u.repo.WithTenant(org), backends: backends, ctx: u.ctx}
+}
+
+func (u *UserUseCase) WithContext(ctx context.Context) UseCase {
+	return &UserUseCase{repo: u.repo.WithContext(ctx), backends: u.backends, ctx: ctx}
 }
 
-func (u *UserUseCase) GetAllUsers() ([]entity.User, error) {
-	return u.repo.GetAll()
+// startSpan начинает спан метода внутри трассировки запроса и возвращает репозиторий,
+// запросы которого станут его дочерними спанами.
+func (u *UserUseCase) startSpan(method string) (context.Context, repository.Repository, trace.Span) {
+	ctx := u.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.StartChild(ctx, "UserUseCase."+method)
+	return ctx, u.repo.WithContext(ctx), span
 }
 
-func (u *UserUseCase) GetUserByID(id int) (entity.User, error) {
-	return u.repo.GetByID(id)
+func (u *UserUseCase) GetAllUsers() (users []entity.User, err error) {
+	_, repo, span := u.startSpan("GetAllUsers")
+	defer func() { tracing.End(span, err) }()
+	return repo.GetAll()
 }
 
-func (u *UserUseCase) GetUserByEmail(email string) (entity.User, error) {
-	return u.repo.GetByEmail(email)
+func (u *UserUseCase) GetUserByID(id int) (user entity.User, err error) {
+	_, repo, span := u.startSpan("GetUserByID")
+	defer func() { tracing.End(span, err) }()
+	return repo.GetByID(id)
 }
 
-func (u *UserUseCase) DeleteUser(id int) error {
-	return u.repo.Delete(id)
+func (u *UserUseCase) GetUserByEmail(email string) (user entity.User, err error) {
+	_, repo, span := u.startSpan("GetUserByEmail")
+	defer func() { tracing.End(span, err) }()
+	return repo.GetByEmail(email)
 }
 
-func (u *UserUseCase) CreateUser(user entity.User) error {
+func (u *UserUseCase) DeleteUser(id int) (err error) {
+	_, repo, span := u.startSpan("DeleteUser")
+	defer func() { tracing.End(span, err) }()
+	return repo.Delete(id)
+}
+
+func (u *UserUseCase) CreateUser(user entity.User) (err error) {
+	_, repo, span := u.startSpan("CreateUser")
+	defer func() { tracing.End(span, err) }()
 	userWithHashPassword, err := u.HashPassword(user)
 	if err != nil {
 		logger.Logger.Error("Ошибка при хешировании пароля",
@@ -59,19 +109,23 @@ func (u *UserUseCase) CreateUser(user entity.User) error {
 		return err
 	}
 	userWithHashPassword.CreateAt = time.Now().String()
-	return u.repo.Create(userWithHashPassword)
+	return repo.Create(userWithHashPassword)
 }
 
-func (u *UserUseCase) UpdatePassword(user entity.User) error {
+func (u *UserUseCase) UpdatePassword(user entity.User) (err error) {
+	_, repo, span := u.startSpan("UpdatePassword")
+	defer func() { tracing.End(span, err) }()
 	userH, err := u.HashPassword(user)
 	if err != nil {
 		return err
 	}
-	return u.repo.UpdatePassword(userH)
+	return repo.UpdatePassword(userH)
 }
 
 func (u *UserUseCase) HashPassword(user entity.User) (entity.User, error) {
+	start := time.Now()
 	password, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	metrics.ObserveBcrypt("hash", start)
 	if err != nil {
 		return entity.User{}, err
 	}
@@ -80,7 +134,9 @@ func (u *UserUseCase) HashPassword(user entity.User) (entity.User, error) {
 }
 
 func (u *UserUseCase) CheckHashPassword(password string, user entity.User) bool {
+	start := time.Now()
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	metrics.ObserveBcrypt("compare", start)
 	return err == nil
 }
 
@@ -93,19 +149,33 @@ func (u *UserUseCase) ToDTO(users []entity.User) []entity.DTOUser {
 			Email:    user.Email,
 			Role:     user.Role,
 			CreateAt: user.CreateAt,
+			Tenant:   user.Tenant,
 		}
 	}
 	return dtoUsers
 }
 
 func (uc *UserUseCase) Authenticate(email string, password string) (string, string, int64, error) {
-	user, err := uc.repo.GetByEmail(email)
-	if err != nil {
-		return "", "", 0, err
+	ctx, _, span := uc.startSpan("Authenticate")
+	access, refresh, expiresIn, err := uc.issueTokens(ctx, email, password)
+	tracing.End(span, err)
+	switch {
+	case err == nil:
+		metrics.Logins.WithLabelValues(metrics.OutcomeSuccess).Inc()
+	case errors.Is(err, ErrorWrongPassword):
+		metrics.Logins.WithLabelValues(metrics.OutcomeInvalid).Inc()
+	case errors.Is(err, jwt.ErrorUserDisabled):
+		metrics.Logins.WithLabelValues(metrics.OutcomeRejected).Inc()
+	default:
+		metrics.Logins.WithLabelValues(metrics.OutcomeError).Inc()
 	}
+	return access, refresh, expiresIn, err
+}
 
-	if !uc.CheckHashPassword(password, user) {
-		return "", "", 0, ErrorWrongPassword
+func (uc *UserUseCase) issueTokens(ctx context.Context, email string, password string) (string, string, int64, error) {
+	user, err := uc.authenticate(ctx, email, password)
+	if err != nil {
+		return "", "", 0, err
 	}
 
 	accessToken, expiresIn, err := jwt.GenerateAccessToken(user)
@@ -120,3 +190,19 @@ func (uc *UserUseCase) Authenticate(email string, password string) (string, stri
 
 	return accessToken, refreshToken, expiresIn, nil
 }
+
+func (uc *UserUseCase) authenticate(ctx context.Context, email string, password string) (entity.User, error) {
+	for _, backend := range uc.backends {
+		user, err := backend.WithContext(ctx).Authenticate(email, password)
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("auth.backend", backend.Name()))
+			return user, nil
+		}
+		if !errors.Is(err, ErrorWrongPassword) && !errors.Is(err, ErrorUnknownUser) {
+			logger.Logger.Error("Ошибка проверки пароля",
+				zap.Error(err),
+				zap.String("backend", backend.Name()))
+		}
+	}
+	return entity.User{}, ErrorWrongPassword
+}
@@ -0,0 +1,149 @@
+package usecase
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// Глобальная учетная запись, вступившая в организацию, общая для всех организаций:
+// ее пароль и имя не меняются из контекста организации, роль в организации — меняется.
+func TestTenantCannotChangeGlobalAccount(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	global := NewUserUseCase(&users)
+	acme := newTestOrg(t, db, "acme", true)
+	tenant := global.WithTenant(acme)
+
+	admin, err := global.GetUserByEmail("admin@a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orgs.SetMember(acme.ID, admin.ID, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant.CreateUser(entity.User{Name: "Own", Email: "own@acme.com", Password: "old-password", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	own, err := tenant.GetUserByEmail("own@acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := tenant.GetUserByID(admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	member.Password = "taken-over"
+	if err := tenant.UpdatePassword(member); !errors.Is(err, repository.ErrGlobalAccount) {
+		t.Fatalf("смена пароля глобальной учетной записи: %v", err)
+	}
+	stored, _ := users.GetByID(admin.ID)
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("taken-over")) == nil {
+		t.Fatal("пароль глобальной учетной записи изменен из организации")
+	}
+
+	repo := users.WithTenant(acme)
+	if err := repo.UpdateProfile(entity.User{ID: admin.ID, Name: "Renamed", Role: "user"}); !errors.Is(err, repository.ErrGlobalAccount) {
+		t.Fatalf("смена имени глобальной учетной записи: %v", err)
+	}
+	if err := repo.UpdateEmail(admin.ID, "x@acme.com"); !errors.Is(err, repository.ErrGlobalAccount) {
+		t.Fatalf("смена email глобальной учетной записи: %v", err)
+	}
+	if err := repo.UpdateProfile(entity.User{ID: admin.ID, Name: admin.Name, Role: "manager"}); err != nil {
+		t.Fatalf("смена роли в организации: %v", err)
+	}
+	if stored, _ := users.GetByID(admin.ID); stored.Name != admin.Name || stored.Role != "admin" {
+		t.Fatalf("глобальная учетная запись изменена: %+v", stored)
+	}
+	if stored, _ := repo.GetByID(admin.ID); stored.Role != "manager" {
+		t.Fatalf("роль в организации %q, ожидалась manager", stored.Role)
+	}
+
+	// собственная учетная запись организации меняется из ее контекста
+	own.Password = "new-password"
+	if err := tenant.UpdatePassword(own); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateProfile(entity.User{ID: own.ID, Name: "Own Renamed", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = users.GetByID(own.ID)
+	if stored.Name != "Own Renamed" || bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("new-password")) != nil {
+		t.Fatalf("собственная учетная запись не изменена: %+v", stored)
+	}
+}
+
+// Удаление из организации: собственная учетная запись удаляется целиком, у глобальной
+// удаляется только членство.
+func TestTenantDeleteUser(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	orgs := repository.NewOrganizationRep(db)
+	acme := newTestOrg(t, db, "acme", true)
+	tenant := NewUserUseCase(&users).WithTenant(acme)
+
+	if err := orgs.SetMember(acme.ID, 1, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant.CreateUser(entity.User{Name: "Own", Email: "own@acme.com", Password: "password", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	own, err := tenant.GetUserByEmail("own@acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int{own.ID, 1} {
+		if err := tenant.DeleteUser(id); err != nil {
+			t.Fatalf("удаление пользователя %d: %v", id, err)
+		}
+		if _, err := tenant.GetUserByID(id); err == nil {
+			t.Fatalf("пользователь %d остался участником", id)
+		}
+	}
+	if _, err := users.GetByID(own.ID); err == nil {
+		t.Fatal("собственная учетная запись организации не удалена")
+	}
+	if _, err := users.GetByID(1); err != nil {
+		t.Fatalf("глобальная учетная запись удалена: %v", err)
+	}
+	if err := tenant.DeleteUser(1); err == nil {
+		t.Fatal("повторное удаление участника без ошибки")
+	}
+}
+
+// Занятый email распознается по ограничению UNIQUE, а не по тексту ошибки драйвера.
+func TestCreateUserExists(t *testing.T) {
+	db := newTestDB(t)
+	users := repository.NewRep(db)
+	global := NewUserUseCase(&users)
+	acme := newTestOrg(t, db, "acme", true)
+
+	tests := []struct {
+		name   string
+		create func() error
+	}{
+		{"глобально", func() error {
+			return global.CreateUser(entity.User{Name: "Admin", Email: "admin@a.com", Password: "password", Role: "user"})
+		}},
+		{"в организации", func() error {
+			createGlobalUser(t, global.WithTenant(acme), "own@acme.com", "user")
+			return global.WithTenant(acme).CreateUser(entity.User{Name: "Own", Email: "own@acme.com", Password: "password", Role: "user"})
+		}},
+		{"смена email", func() error {
+			user := createGlobalUser(t, global, "user@a.com", "user")
+			return users.UpdateEmail(user.ID, "admin@a.com")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.create(); !errors.Is(err, repository.ErrUserExists) {
+				t.Fatalf("ожидалась ErrUserExists, получено %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+const (
+	webAuthnPurposeRegister = "register"
+	webAuthnPurposeLogin    = "login"
+	webAuthnSessionTTL      = 5 * time.Minute
+)
+
+var (
+	ErrorWebAuthnSession     = fmt.Errorf("Сессия WebAuthn не найдена или истекла")
+	ErrorWebAuthnCredential  = fmt.Errorf("Ключ WebAuthn не найден")
+	ErrorWebAuthnAttestation = fmt.Errorf("Формат аттестации не поддерживается")
+	ErrorWebAuthnCloned      = fmt.Errorf("Счетчик подписей ключа не увеличился, возможно ключ клонирован")
+	ErrorWebAuthnNoPasskeys  = fmt.Errorf("Сначала зарегистрируйте хотя бы один ключ")
+)
+
+type WebAuthnUseCase interface {
+	BeginRegistration(user entity.User) (*protocol.CredentialCreation, string, error)
+	FinishRegistration(user entity.User, sessionID string, name string, response *protocol.ParsedCredentialCreationData) (entity.WebAuthnCredential, error)
+	BeginLogin(user *entity.User) (*protocol.CredentialAssertion, string, error)
+	FinishLogin(sessionID string, response *protocol.ParsedCredentialAssertionData) (entity.User, error)
+	GetCredentials(userID int) ([]entity.WebAuthnCredential, error)
+	DeleteCredential(userID int, id int) error
+	PasskeyRequired(userID int) bool
+	SetPasskeyRequired(userID int, required bool) error
+}
+
+type WebAuthnUseCaseImpl struct {
+	w       *webauthn.WebAuthn
+	repo    repository.WebAuthnRepository
+	users   repository.Repository
+	formats []string
+}
+
+func NewWebAuthnUseCase(cfg config.WebAuthn, repo repository.WebAuthnRepository, users repository.Repository) (WebAuthnUseCase, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:                  cfg.RPID,
+		RPDisplayName:         cfg.RPDisplayName,
+		RPOrigins:             cfg.RPOrigins,
+		AttestationPreference: protocol.ConveyancePreference(cfg.Attestation),
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionTTL},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка конфигурации WebAuthn: %w", err)
+	}
+	return &WebAuthnUseCaseImpl{w: w, repo: repo, users: users, formats: cfg.AttestationFormats}, nil
+}
+
+// webAuthnUser адаптирует entity.User к интерфейсу webauthn.User.
+type webAuthnUser struct {
+	user  entity.User
+	creds []webauthn.Credential
+}
+
+func (u webAuthnUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.user.ID)) }
+func (u webAuthnUser) WebAuthnName() string                       { return u.user.Email }
+func (u webAuthnUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.creds }
+
+func (s *WebAuthnUseCaseImpl) loadUser(user entity.User) (webAuthnUser, error) {
+	stored, err := s.repo.GetCredentialsByUser(user.ID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+
+	creds := make([]webauthn.Credential, 0, len(stored))
+	for _, c := range stored {
+		var cred webauthn.Credential
+		if err := json.Unmarshal([]byte(c.Data), &cred); err != nil {
+			return webAuthnUser{}, fmt.Errorf("ошибка чтения ключа WebAuthn %d: %w", c.ID, err)
+		}
+		creds = append(creds, cred)
+	}
+	return webAuthnUser{user: user, creds: creds}, nil
+}
+
+func (s *WebAuthnUseCaseImpl) BeginRegistration(user entity.User) (*protocol.CredentialCreation, string, error) {
+	wu, err := s.loadUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	exclude := make([]protocol.CredentialDescriptor, 0, len(wu.creds))
+	for _, c := range wu.creds {
+		exclude = append(exclude, c.Descriptor())
+	}
+
+	options, data, err := s.w.BeginRegistration(wu,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred))
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка начала регистрации WebAuthn: %w", err)
+	}
+
+	sessionID, err := s.saveSession(user.ID, webAuthnPurposeRegister, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, sessionID, nil
+}
+
+func (s *WebAuthnUseCaseImpl) FinishRegistration(user entity.User, sessionID string, name string, response *protocol.ParsedCredentialCreationData) (entity.WebAuthnCredential, error) {
+	data, err := s.takeSession(sessionID, webAuthnPurposeRegister, user.ID)
+	if err != nil {
+		return entity.WebAuthnCredential{}, err
+	}
+
+	wu, err := s.loadUser(user)
+	if err != nil {
+		return entity.WebAuthnCredential{}, err
+	}
+
+	cred, err := s.w.CreateCredential(wu, data, response)
+	if err != nil {
+		logger.Logger.Warn("Ошибка проверки регистрации WebAuthn",
+			zap.Error(err),
+			zap.Int("user_id", user.ID))
+		return entity.WebAuthnCredential{}, fmt.Errorf("ошибка проверки регистрации WebAuthn: %w", err)
+	}
+
+	if !s.formatAllowed(response.Response.AttestationObject.Format) {
+		logger.Logger.Warn("Отклонен формат аттестации WebAuthn",
+			zap.String("format", response.Response.AttestationObject.Format),
+			zap.Int("user_id", user.ID))
+		return entity.WebAuthnCredential{}, ErrorWebAuthnAttestation
+	}
+
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return entity.WebAuthnCredential{}, fmt.Errorf("ошибка сохранения ключа WebAuthn: %w", err)
+	}
+
+	stored, err := s.repo.CreateCredential(entity.WebAuthnCredential{
+		UserID:          user.ID,
+		CredentialID:    base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:            name,
+		AttestationType: response.Response.AttestationObject.Format,
+		SignCount:       cred.Authenticator.SignCount,
+		Data:            string(raw),
+		CreateAt:        time.Now().String(),
+	})
+	if err != nil {
+		return entity.WebAuthnCredential{}, err
+	}
+
+	logger.Logger.Info("Зарегистрирован ключ WebAuthn",
+		zap.Int("user_id", user.ID),
+		zap.String("format", stored.AttestationType))
+	return stored, nil
+}
+
+// BeginLogin начинает вход по ключу. Если user == nil, используется discoverable-вход
+// (passkey сам сообщает пользователя).
+func (s *WebAuthnUseCaseImpl) BeginLogin(user *entity.User) (*protocol.CredentialAssertion, string, error) {
+	var (
+		options *protocol.CredentialAssertion
+		data    *webauthn.SessionData
+		userID  int
+		err     error
+	)
+
+	if user == nil {
+		options, data, err = s.w.BeginDiscoverableLogin()
+	} else {
+		var wu webAuthnUser
+		wu, err = s.loadUser(*user)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(wu.creds) == 0 {
+			return nil, "", ErrorWebAuthnCredential
+		}
+		userID = user.ID
+		options, data, err = s.w.BeginLogin(wu)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка начала входа WebAuthn: %w", err)
+	}
+
+	sessionID, err := s.saveSession(userID, webAuthnPurposeLogin, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, sessionID, nil
+}
+
+func (s *WebAuthnUseCaseImpl) FinishLogin(sessionID string, response *protocol.ParsedCredentialAssertionData) (entity.User, error) {
+	session, err := s.repo.TakeSession(sessionID)
+	if err != nil || session.Purpose != webAuthnPurposeLogin {
+		return entity.User{}, ErrorWebAuthnSession
+	}
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil {
+		return entity.User{}, ErrorWebAuthnSession
+	}
+
+	var (
+		wu   webAuthnUser
+		cred *webauthn.Credential
+	)
+	if session.UserID != 0 {
+		user, err := s.users.GetByID(session.UserID)
+		if err != nil {
+			return entity.User{}, err
+		}
+		if wu, err = s.loadUser(user); err != nil {
+			return entity.User{}, err
+		}
+		cred, err = s.w.ValidateLogin(wu, data, response)
+		if err != nil {
+			logger.Logger.Warn("Ошибка проверки входа WebAuthn",
+				zap.Error(err),
+				zap.Int("user_id", user.ID))
+			return entity.User{}, fmt.Errorf("ошибка проверки входа WebAuthn: %w", err)
+		}
+	} else {
+		resolved, c, err := s.w.ValidatePasskeyLogin(s.discoverUser, data, response)
+		if err != nil {
+			logger.Logger.Warn("Ошибка проверки входа по passkey",
+				zap.Error(err))
+			return entity.User{}, fmt.Errorf("ошибка проверки входа WebAuthn: %w", err)
+		}
+		wu, cred = resolved.(webAuthnUser), c
+	}
+
+	if cred.Authenticator.CloneWarning {
+		logger.Logger.Warn("Обнаружен возможный клон ключа WebAuthn",
+			zap.Int("user_id", wu.user.ID),
+			zap.Uint32("sign_count", cred.Authenticator.SignCount))
+		return entity.User{}, ErrorWebAuthnCloned
+	}
+
+	stored, err := s.repo.GetCredentialByCredentialID(base64.RawURLEncoding.EncodeToString(cred.ID))
+	if err != nil || stored.UserID != wu.user.ID {
+		return entity.User{}, ErrorWebAuthnCredential
+	}
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return entity.User{}, fmt.Errorf("ошибка сохранения ключа WebAuthn: %w", err)
+	}
+	stored.SignCount = cred.Authenticator.SignCount
+	stored.Data = string(raw)
+	if err := s.repo.UpdateCredentialUsage(stored); err != nil {
+		return entity.User{}, err
+	}
+
+	return wu.user, nil
+}
+
+func (s *WebAuthnUseCaseImpl) discoverUser(rawID []byte, userHandle []byte) (webauthn.User, error) {
+	id, err := strconv.Atoi(string(userHandle))
+	if err != nil {
+		return nil, ErrorWebAuthnCredential
+	}
+	user, err := s.users.GetByID(id)
+	if err != nil {
+		return nil, ErrorWebAuthnCredential
+	}
+	return s.loadUser(user)
+}
+
+func (s *WebAuthnUseCaseImpl) GetCredentials(userID int) ([]entity.WebAuthnCredential, error) {
+	return s.repo.GetCredentialsByUser(userID)
+}
+
+func (s *WebAuthnUseCaseImpl) DeleteCredential(userID int, id int) error {
+	if err := s.repo.DeleteCredential(userID, id); err != nil {
+		return ErrorWebAuthnCredential
+	}
+
+	creds, err := s.repo.GetCredentialsByUser(userID)
+	if err == nil && len(creds) == 0 {
+		return s.repo.SetPasskeyRequired(userID, false)
+	}
+	return nil
+}
+
+func (s *WebAuthnUseCaseImpl) PasskeyRequired(userID int) bool {
+	required, err := s.repo.GetPasskeyRequired(userID)
+	if err != nil {
+		logger.Logger.Error("Ошибка проверки MFA",
+			zap.Error(err),
+			zap.Int("user_id", userID))
+		return true
+	}
+	return required
+}
+
+func (s *WebAuthnUseCaseImpl) SetPasskeyRequired(userID int, required bool) error {
+	if required {
+		creds, err := s.repo.GetCredentialsByUser(userID)
+		if err != nil {
+			return err
+		}
+		if len(creds) == 0 {
+			return ErrorWebAuthnNoPasskeys
+		}
+	}
+	return s.repo.SetPasskeyRequired(userID, required)
+}
+
+func (s *WebAuthnUseCaseImpl) saveSession(userID int, purpose string, data *webauthn.SessionData) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("ошибка сохранения сессии WebAuthn: %w", err)
+	}
+	id, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo.SaveSession(entity.WebAuthnSession{
+		ID:        id,
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      string(raw),
+		ExpiresAt: time.Now().Add(webAuthnSessionTTL).Unix(),
+	})
+	return id, err
+}
+
+func (s *WebAuthnUseCaseImpl) takeSession(id string, purpose string, userID int) (webauthn.SessionData, error) {
+	session, err := s.repo.TakeSession(id)
+	if err != nil || session.Purpose != purpose || session.UserID != userID {
+		return webauthn.SessionData{}, ErrorWebAuthnSession
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil {
+		return webauthn.SessionData{}, ErrorWebAuthnSession
+	}
+	return data, nil
+}
+
+func (s *WebAuthnUseCaseImpl) formatAllowed(format string) bool {
+	for _, f := range s.formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
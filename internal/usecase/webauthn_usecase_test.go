@@ -0,0 +1,440 @@
+package usecase
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"sync"
+	"testing"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// memWebAuthnRep — хранилище ключей и сессий WebAuthn в памяти.
+type memWebAuthnRep struct {
+	mu       sync.Mutex
+	creds    []entity.WebAuthnCredential
+	sessions map[string]entity.WebAuthnSession
+	required map[int]bool
+}
+
+func newMemWebAuthnRep() *memWebAuthnRep {
+	return &memWebAuthnRep{sessions: map[string]entity.WebAuthnSession{}, required: map[int]bool{}}
+}
+
+func (m *memWebAuthnRep) GetCredentialsByUser(userID int) ([]entity.WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []entity.WebAuthnCredential
+	for _, c := range m.creds {
+		if c.UserID == userID {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (m *memWebAuthnRep) GetCredentialByCredentialID(credentialID string) (entity.WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.creds {
+		if c.CredentialID == credentialID {
+			return c, nil
+		}
+	}
+	return entity.WebAuthnCredential{}, sql.ErrNoRows
+}
+
+func (m *memWebAuthnRep) CreateCredential(cred entity.WebAuthnCredential) (entity.WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cred.ID = len(m.creds) + 1
+	m.creds = append(m.creds, cred)
+	return cred, nil
+}
+
+func (m *memWebAuthnRep) UpdateCredentialUsage(cred entity.WebAuthnCredential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, c := range m.creds {
+		if c.ID == cred.ID {
+			m.creds[i] = cred
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memWebAuthnRep) DeleteCredential(userID int, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, c := range m.creds {
+		if c.ID == id && c.UserID == userID {
+			m.creds = append(m.creds[:i], m.creds[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memWebAuthnRep) SaveSession(session entity.WebAuthnSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *memWebAuthnRep) TakeSession(id string) (entity.WebAuthnSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return entity.WebAuthnSession{}, sql.ErrNoRows
+	}
+	delete(m.sessions, id)
+	return session, nil
+}
+
+func (m *memWebAuthnRep) GetPasskeyRequired(userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.required[userID], nil
+}
+
+func (m *memWebAuthnRep) SetPasskeyRequired(userID int, required bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.required[userID] = required
+	return nil
+}
+
+// memUsers — репозиторий пользователей в памяти; реализует только чтение, остальные
+// методы use case в этих тестах не вызывает.
+type memUsers struct {
+	repository.Repository
+	users []entity.User
+}
+
+func (m *memUsers) GetByID(id int) (entity.User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+func (m *memUsers) GetByEmail(email string) (entity.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+// softAuthenticator — программный аутентификатор с ключом ES256 и аттестацией none.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	userID    []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func clientData(t *testing.T, typ string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// authData собирает authenticatorData: хеш RP ID, флаги и счетчик подписей.
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	var buf bytes.Buffer
+	buf.Write(rpHash[:])
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.signCount)
+	buf.Write(attested)
+	return buf.Bytes()
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) *protocol.ParsedCredentialCreationData {
+	t.Helper()
+	a.userID = options.Response.User.ID.(protocol.URLEncodedBase64)
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	binary.Write(&attested, binary.BigEndian, uint16(len(a.id)))
+	attested.Write(a.id)
+	attested.Write(coseKey)
+
+	// UP | UV | AT
+	attObj, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x45, attested.Bytes()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64(attObj),
+		},
+	})
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(body)
+	if err != nil {
+		t.Fatalf("разбор ответа регистрации: %v", err)
+	}
+	return parsed
+}
+
+func (a *softAuthenticator) assert(t *testing.T, options *protocol.CredentialAssertion) *protocol.ParsedCredentialAssertionData {
+	t.Helper()
+	authData := a.authData(0x05, nil) // UP | UV
+	cd := clientData(t, "webauthn.get", options.Response.Challenge)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(cd),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userID),
+		},
+	})
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(body)
+	if err != nil {
+		t.Fatalf("разбор ответа входа: %v", err)
+	}
+	return parsed
+}
+
+func newTestWebAuthn(t *testing.T, formats ...string) (*WebAuthnUseCaseImpl, *memWebAuthnRep, entity.User) {
+	t.Helper()
+	if len(formats) == 0 {
+		formats = []string{"none", "packed"}
+	}
+	user := entity.User{ID: 7, Name: "Test", Email: "test@a.com", Role: "user"}
+	repo := newMemWebAuthnRep()
+	uc, err := NewWebAuthnUseCase(config.WebAuthn{
+		RPID:               testRPID,
+		RPDisplayName:      "Auth",
+		RPOrigins:          []string{testOrigin},
+		Attestation:        "none",
+		AttestationFormats: formats,
+	}, repo, &memUsers{users: []entity.User{user}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uc.(*WebAuthnUseCaseImpl), repo, user
+}
+
+func register(t *testing.T, uc *WebAuthnUseCaseImpl, user entity.User, a *softAuthenticator) (entity.WebAuthnCredential, error) {
+	t.Helper()
+	options, sessionID, err := uc.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uc.FinishRegistration(user, sessionID, "key", a.create(t, options))
+}
+
+func login(t *testing.T, uc *WebAuthnUseCaseImpl, user *entity.User, a *softAuthenticator) (entity.User, error) {
+	t.Helper()
+	options, sessionID, err := uc.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uc.FinishLogin(sessionID, a.assert(t, options))
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	uc, repo, user := newTestWebAuthn(t)
+	a := newSoftAuthenticator(t)
+
+	cred, err := register(t, uc, user, a)
+	if err != nil {
+		t.Fatalf("регистрация: %v", err)
+	}
+	if cred.UserID != user.ID || cred.CredentialID != b64(a.id) || cred.AttestationType != "none" {
+		t.Fatalf("неожиданный ключ: %+v", cred)
+	}
+	if creds, _ := repo.GetCredentialsByUser(user.ID); len(creds) != 1 {
+		t.Fatalf("ожидался 1 ключ, сохранено %d", len(creds))
+	}
+}
+
+func TestWebAuthnRegistrationRejectsFormat(t *testing.T) {
+	uc, repo, user := newTestWebAuthn(t, "packed")
+
+	if _, err := register(t, uc, user, newSoftAuthenticator(t)); !errors.Is(err, ErrorWebAuthnAttestation) {
+		t.Fatalf("ожидалась ErrorWebAuthnAttestation, получено %v", err)
+	}
+	if creds, _ := repo.GetCredentialsByUser(user.ID); len(creds) != 0 {
+		t.Fatal("ключ с запрещенной аттестацией сохранен")
+	}
+}
+
+func TestWebAuthnRegistrationSessionIsSingleUse(t *testing.T) {
+	uc, _, user := newTestWebAuthn(t)
+	a := newSoftAuthenticator(t)
+
+	options, sessionID, err := uc.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := a.create(t, options)
+	if _, err := uc.FinishRegistration(user, sessionID, "key", response); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.FinishRegistration(user, sessionID, "key", response); !errors.Is(err, ErrorWebAuthnSession) {
+		t.Fatalf("повтор сессии: ожидалась ErrorWebAuthnSession, получено %v", err)
+	}
+}
+
+func TestWebAuthnRegistrationOtherUserSession(t *testing.T) {
+	uc, _, user := newTestWebAuthn(t)
+	a := newSoftAuthenticator(t)
+
+	options, sessionID, err := uc.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := entity.User{ID: 8, Email: "other@a.com"}
+	if _, err := uc.FinishRegistration(other, sessionID, "key", a.create(t, options)); !errors.Is(err, ErrorWebAuthnSession) {
+		t.Fatalf("ожидалась ErrorWebAuthnSession, получено %v", err)
+	}
+}
+
+func TestWebAuthnLoginUpdatesSignCount(t *testing.T) {
+	for _, discoverable := range []bool{false, true} {
+		uc, repo, user := newTestWebAuthn(t)
+		a := newSoftAuthenticator(t)
+		if _, err := register(t, uc, user, a); err != nil {
+			t.Fatal(err)
+		}
+
+		target := &user
+		if discoverable {
+			target = nil
+		}
+		a.signCount = 5
+		got, err := login(t, uc, target, a)
+		if err != nil {
+			t.Fatalf("discoverable=%v: вход: %v", discoverable, err)
+		}
+		if got.ID != user.ID {
+			t.Fatalf("discoverable=%v: вошел пользователь %d", discoverable, got.ID)
+		}
+		stored, _ := repo.GetCredentialByCredentialID(b64(a.id))
+		if stored.SignCount != 5 {
+			t.Fatalf("discoverable=%v: sign_count = %d, ожидался 5", discoverable, stored.SignCount)
+		}
+	}
+}
+
+func TestWebAuthnLoginRejectsClone(t *testing.T) {
+	tests := []struct {
+		name  string
+		count uint32
+	}{
+		{"тот же счетчик", 5},
+		{"счетчик меньше", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, repo, user := newTestWebAuthn(t)
+			a := newSoftAuthenticator(t)
+			if _, err := register(t, uc, user, a); err != nil {
+				t.Fatal(err)
+			}
+			a.signCount = 5
+			if _, err := login(t, uc, &user, a); err != nil {
+				t.Fatal(err)
+			}
+
+			a.signCount = tt.count
+			if _, err := login(t, uc, &user, a); !errors.Is(err, ErrorWebAuthnCloned) {
+				t.Fatalf("ожидалась ErrorWebAuthnCloned, получено %v", err)
+			}
+			stored, _ := repo.GetCredentialByCredentialID(b64(a.id))
+			if stored.SignCount != 5 {
+				t.Fatalf("sign_count изменился на %d после отклоненного входа", stored.SignCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnLoginRejectsWrongKey(t *testing.T) {
+	uc, _, user := newTestWebAuthn(t)
+	a := newSoftAuthenticator(t)
+	if _, err := register(t, uc, user, a); err != nil {
+		t.Fatal(err)
+	}
+
+	forged := newSoftAuthenticator(t)
+	forged.id, forged.userID = a.id, a.userID
+	if _, err := login(t, uc, &user, forged); err == nil {
+		t.Fatal("вход с подписью чужим ключом принят")
+	}
+}
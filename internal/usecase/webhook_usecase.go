@@ -0,0 +1,375 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	webhookSendBatch    = 20
+	webhookDefaultLimit = 100
+	// webhookErrorLimit — сколько байт ответа получателя сохраняется в журнале
+	webhookErrorLimit = 512
+)
+
+var (
+	ErrorWebhookNotFound  = fmt.Errorf("Вебхук не найден")
+	ErrorDeliveryNotFound = fmt.Errorf("Доставка не найдена")
+	ErrorWebhookURL       = fmt.Errorf("URL вебхука должен быть абсолютным http(s) адресом")
+	ErrorWebhookAddress   = fmt.Errorf("Адрес вебхука во внутренней сети запрещен")
+	ErrorWebhookEvent     = fmt.Errorf("Неизвестный тип события")
+	ErrorWebhookSecret    = fmt.Errorf("Секрет вебхука должен быть не короче 16 символов")
+	ErrorDeliveryStatus   = fmt.Errorf("Статус доставки должен быть pending, delivered или dead")
+)
+
+type WebhookUseCase interface {
+	GetWebhooks() ([]entity.Webhook, error)
+	// CreateWebhook создает подписку; пустой secret генерируется. Секрет возвращается только здесь
+	CreateWebhook(rawURL string, events []string, secret string) (entity.Webhook, string, error)
+	UpdateWebhook(id int, rawURL string, events []string, active bool) (entity.Webhook, error)
+	DeleteWebhook(id int) error
+	// GetDeliveries — журнал доставок подписки, новые первыми; пустой status — все
+	GetDeliveries(webhookID int, status string, limit int) ([]entity.WebhookDelivery, error)
+	GetDelivery(webhookID int, id int64) (entity.WebhookDelivery, []entity.WebhookAttempt, error)
+	Redeliver(webhookID int, id int64) error
+	// Run отправляет доставки, время которых наступило, пока не отменен ctx;
+	// запускается в отдельной горутине
+	Run(ctx context.Context)
+	// OutboxSink раскладывает события outbox по подпискам
+	OutboxSink
+}
+
+type WebhookUseCaseImpl struct {
+	cfg    config.Webhooks
+	repo   repository.WebhookRepository
+	client *http.Client
+}
+
+func NewWebhookUseCase(cfg config.Webhooks, repo repository.WebhookRepository) WebhookUseCase {
+	return &WebhookUseCaseImpl{
+		cfg:  cfg,
+		repo: repo,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: webhookTransport(cfg.Timeout, cfg.AllowPrivateNetworks),
+			// перенаправление считается неудачной доставкой: подпись привязана к исходному URL
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (w *WebhookUseCaseImpl) GetWebhooks() ([]entity.Webhook, error) {
+	return w.repo.GetWebhooks()
+}
+
+func (w *WebhookUseCaseImpl) CreateWebhook(rawURL string, events []string, secret string) (entity.Webhook, string, error) {
+	if err := w.validateWebhook(rawURL, events); err != nil {
+		return entity.Webhook{}, "", err
+	}
+	switch {
+	case secret == "":
+		random, err := randomHex(24)
+		if err != nil {
+			return entity.Webhook{}, "", err
+		}
+		secret = webhookSecretPrefix + random
+	case len(secret) < 16:
+		return entity.Webhook{}, "", ErrorWebhookSecret
+	}
+
+	webhook, err := w.repo.CreateWebhook(entity.Webhook{
+		URL:      rawURL,
+		Events:   strings.Join(events, " "),
+		Secret:   secret,
+		Active:   true,
+		CreateAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return entity.Webhook{}, "", err
+	}
+	return webhook, secret, nil
+}
+
+func (w *WebhookUseCaseImpl) UpdateWebhook(id int, rawURL string, events []string, active bool) (entity.Webhook, error) {
+	if err := w.validateWebhook(rawURL, events); err != nil {
+		return entity.Webhook{}, err
+	}
+	webhook, err := w.getWebhook(id)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	webhook.URL = rawURL
+	webhook.Events = strings.Join(events, " ")
+	webhook.Active = active
+	if err := w.repo.UpdateWebhook(webhook); err != nil {
+		return entity.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (w *WebhookUseCaseImpl) DeleteWebhook(id int) error {
+	err := w.repo.DeleteWebhook(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrorWebhookNotFound
+	}
+	return err
+}
+
+func (w *WebhookUseCaseImpl) GetDeliveries(webhookID int, status string, limit int) ([]entity.WebhookDelivery, error) {
+	switch status {
+	case "", entity.WebhookDeliveryPending, entity.WebhookDeliveryDelivered, entity.WebhookDeliveryDead:
+	default:
+		return nil, ErrorDeliveryStatus
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = webhookDefaultLimit
+	}
+	if _, err := w.getWebhook(webhookID); err != nil {
+		return nil, err
+	}
+	return w.repo.GetDeliveries(webhookID, status, limit)
+}
+
+func (w *WebhookUseCaseImpl) GetDelivery(webhookID int, id int64) (entity.WebhookDelivery, []entity.WebhookAttempt, error) {
+	delivery, err := w.repo.GetDelivery(webhookID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.WebhookDelivery{}, nil, ErrorDeliveryNotFound
+	}
+	if err != nil {
+		return entity.WebhookDelivery{}, nil, err
+	}
+	attempts, err := w.repo.GetAttempts(id)
+	if err != nil {
+		return entity.WebhookDelivery{}, nil, err
+	}
+	return delivery, attempts, nil
+}
+
+func (w *WebhookUseCaseImpl) Redeliver(webhookID int, id int64) error {
+	err := w.repo.Redeliver(webhookID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrorDeliveryNotFound
+	}
+	return err
+}
+
+func (w *WebhookUseCaseImpl) getWebhook(id int) (entity.Webhook, error) {
+	webhook, err := w.repo.GetWebhook(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Webhook{}, ErrorWebhookNotFound
+	}
+	return webhook, err
+}
+
+// validateWebhook сразу отклоняет URL с внутренним IP или localhost. Имена, которые
+// разрешаются во внутренние адреса, отклоняются при доставке (webhookTransport).
+func (w *WebhookUseCaseImpl) validateWebhook(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrorWebhookURL
+	}
+	if !w.cfg.AllowPrivateNetworks {
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return ErrorWebhookAddress
+		}
+		if addr, err := netip.ParseAddr(host); err == nil && blockedAddress(addr) {
+			return ErrorWebhookAddress
+		}
+	}
+	for _, event := range events {
+		if !slices.Contains(entity.UserEventTypes, event) {
+			return fmt.Errorf("%w: %s", ErrorWebhookEvent, event)
+		}
+	}
+	return nil
+}
+
+// Run дожидается начатых доставок и после отмены ctx.
+func (w *WebhookUseCaseImpl) Run(ctx context.Context) {
+	for {
+		w.send()
+		if !wait(ctx, w.cfg.PollInterval) {
+			return
+		}
+	}
+}
+
+func (w *WebhookUseCaseImpl) Name() string {
+	return "webhooks"
+}
+
+// Publish ставит события в очередь доставки подпискам. ID доставки по событию — ID
+// события outbox, поэтому повторная публикация той же пачки доставок не дублирует.
+// Подписка получает только события, произошедшие после ее создания.
+func (w *WebhookUseCaseImpl) Publish(events []entity.UserEvent) error {
+	webhooks, err := w.repo.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	var deliveries []entity.WebhookDelivery
+	for _, event := range events {
+		payload, err := marshalEvent(event)
+		if err != nil {
+			return err
+		}
+		for _, webhook := range webhooks {
+			if !webhook.Active || event.CreateAt < webhook.CreateAt || !webhookWants(webhook, event.Type) {
+				continue
+			}
+			deliveries = append(deliveries, entity.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Payload:       string(payload),
+				NextAttemptAt: now,
+				CreateAt:      now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return w.repo.EnqueueDeliveries(deliveries)
+}
+
+func webhookWants(webhook entity.Webhook, eventType string) bool {
+	return webhook.Events == "" || slices.Contains(strings.Fields(webhook.Events), eventType)
+}
+
+// send отправляет доставки, время которых наступило, параллельно в пределах пачки.
+// Результаты записываются по очереди после отправки: SQLite не допускает
+// одновременных пишущих транзакций.
+func (w *WebhookUseCaseImpl) send() {
+	deliveries, err := w.repo.GetDueDeliveries(time.Now().Unix(), webhookSendBatch)
+	if err != nil || len(deliveries) == 0 {
+		return
+	}
+	webhooks, err := w.repo.GetWebhooks()
+	if err != nil {
+		return
+	}
+	byID := make(map[int]entity.Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byID[webhook.ID] = webhook
+	}
+
+	attempts := make([]entity.WebhookAttempt, len(deliveries))
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		webhook, ok := byID[deliveries[i].WebhookID]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts[i] = w.deliver(webhook, &deliveries[i])
+		}()
+	}
+	wg.Wait()
+
+	for i, delivery := range deliveries {
+		if attempts[i].DeliveryID != 0 {
+			_ = w.repo.RecordAttempt(delivery, attempts[i])
+		}
+	}
+}
+
+// deliver делает одну попытку и переводит доставку в следующее состояние.
+func (w *WebhookUseCaseImpl) deliver(webhook entity.Webhook, delivery *entity.WebhookDelivery) entity.WebhookAttempt {
+	start := time.Now()
+	status, err := w.post(webhook, *delivery)
+	attempt := entity.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Status:     status,
+		DurationMs: time.Since(start).Milliseconds(),
+		CreateAt:   time.Now().Unix(),
+	}
+
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = entity.WebhookDeliveryDelivered
+	case delivery.Attempts >= w.cfg.MaxAttempts:
+		delivery.Status = entity.WebhookDeliveryDead
+	default:
+		delivery.NextAttemptAt = time.Now().Add(w.backoff(delivery.Attempts)).Unix()
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		delivery.LastError = err.Error()
+		logger.Logger.Warn("Не удалось доставить вебхук",
+			zap.Error(err),
+			zap.Int("webhook_id", webhook.ID),
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int("attempt", delivery.Attempts),
+			zap.String("status", delivery.Status))
+	}
+	return attempt
+}
+
+// post отправляет подписанное событие. Подпись — HMAC-SHA256 секрета от
+// "<timestamp>.<тело>" в заголовке X-Webhook-Signature: v1=<hex>.
+func (w *WebhookUseCaseImpl) post(webhook entity.Webhook, delivery entity.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "." + delivery.Payload))
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "authh2-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "v1="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorLimit))
+	return resp.StatusCode, fmt.Errorf("получатель ответил %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (w *WebhookUseCaseImpl) backoff(attempts int) time.Duration {
+	delay := w.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < w.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, w.cfg.MaxBackoff)
+}
@@ -0,0 +1,321 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/pkg/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_test_secret_0123456789"
+
+// receiver — получатель вебхуков: проверяет подпись и отвечает статусами из statuses
+// по очереди (последний повторяется).
+type receiver struct {
+	t        *testing.T
+	statuses []int
+	calls    atomic.Int32
+	invalid  atomic.Int32
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := int(r.calls.Add(1)) - 1
+	body, _ := io.ReadAll(req.Body)
+	if !validSignature(req.Header, body, testWebhookSecret) {
+		r.invalid.Add(1)
+	}
+	status := r.statuses[min(n, len(r.statuses)-1)]
+	w.WriteHeader(status)
+	if status >= 300 {
+		io.WriteString(w, "временная ошибка")
+	}
+}
+
+// validSignature проверяет подпись так, как это делает получатель по документации.
+func validSignature(header http.Header, body []byte, secret string) bool {
+	timestamp := header.Get("X-Webhook-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Webhook-Signature")))
+}
+
+func newTestWebhooks(t *testing.T, cfg config.Webhooks) (*WebhookUseCaseImpl, *sql.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	rep := repository.NewWebhookRep(db)
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 3
+	}
+	return NewWebhookUseCase(cfg, &rep).(*WebhookUseCaseImpl), db
+}
+
+// publish ставит в очередь одно событие и возвращает доставку подписке webhookID.
+func publish(t *testing.T, w *WebhookUseCaseImpl, webhookID int) entity.WebhookDelivery {
+	t.Helper()
+	err := w.Publish([]entity.UserEvent{{
+		ID:       1,
+		Type:     entity.UserEventCreated,
+		UserID:   7,
+		Email:    "new@a.com",
+		CreateAt: time.Now().Unix(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := w.GetDeliveries(webhookID, "", 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("доставки: %v, %v", deliveries, err)
+	}
+	return deliveries[0]
+}
+
+func getDelivery(t *testing.T, w *WebhookUseCaseImpl, webhookID int, id int64) (entity.WebhookDelivery, []entity.WebhookAttempt) {
+	t.Helper()
+	delivery, attempts, err := w.GetDelivery(webhookID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery, attempts
+}
+
+// forceDue переносит следующую попытку на текущий момент, не дожидаясь паузы.
+func forceDue(t *testing.T, db *sql.DB, id int64) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id = $2`, time.Now().Unix(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	r := &receiver{t: t, statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	w, _ := newTestWebhooks(t, config.Webhooks{AllowPrivateNetworks: true})
+	webhook, _, err := w.CreateWebhook(server.URL+"/hook", nil, testWebhookSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publish(t, w, webhook.ID)
+	w.send()
+
+	if r.calls.Load() != 1 || r.invalid.Load() != 0 {
+		t.Fatalf("вызовов %d, с неверной подписью %d", r.calls.Load(), r.invalid.Load())
+	}
+	delivery, attempts := getDelivery(t, w, webhook.ID, queued.ID)
+	if delivery.Status != entity.WebhookDeliveryDelivered || delivery.LastStatus != http.StatusOK || len(attempts) != 1 {
+		t.Fatalf("доставка %+v, попыток %d", delivery, len(attempts))
+	}
+
+	// подпись другим секретом получатель не принимает
+	if validSignature(http.Header{
+		"X-Webhook-Timestamp": {strconv.FormatInt(time.Now().Unix(), 10)},
+		"X-Webhook-Signature": {"v1=00"},
+	}, []byte(queued.Payload), testWebhookSecret) {
+		t.Fatal("принята неверная подпись")
+	}
+}
+
+func TestWebhookRetryAndDeadLetter(t *testing.T) {
+	r := &receiver{t: t, statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	w, db := newTestWebhooks(t, config.Webhooks{
+		AllowPrivateNetworks: true,
+		MaxAttempts:          3,
+		InitialBackoff:       time.Minute,
+		MaxBackoff:           time.Hour,
+	})
+	webhook, _, err := w.CreateWebhook(server.URL, nil, testWebhookSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publish(t, w, webhook.ID)
+
+	w.send()
+	delivery, attempts := getDelivery(t, w, webhook.ID, queued.ID)
+	if delivery.Status != entity.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatus != http.StatusInternalServerError {
+		t.Fatalf("после первой неудачи: %+v", delivery)
+	}
+	if wait := delivery.NextAttemptAt - time.Now().Unix(); wait < 59 || wait > 60 {
+		t.Fatalf("следующая попытка через %ds, ожидалось 60s", wait)
+	}
+	if len(attempts) != 1 || !strings.Contains(attempts[0].Error, "временная ошибка") {
+		t.Fatalf("журнал попыток: %+v", attempts)
+	}
+
+	// пауза не истекла: повторной отправки нет
+	w.send()
+	if r.calls.Load() != 1 {
+		t.Fatalf("повтор до истечения паузы, вызовов %d", r.calls.Load())
+	}
+
+	for i := 2; i <= 3; i++ {
+		forceDue(t, db, delivery.ID)
+		w.send()
+		delivery, _ = getDelivery(t, w, webhook.ID, queued.ID)
+	}
+	if delivery.Status != entity.WebhookDeliveryDead || delivery.Attempts != 3 || r.calls.Load() != 3 {
+		t.Fatalf("после %d попыток: %+v", r.calls.Load(), delivery)
+	}
+
+	// dead доставки не повторяются автоматически
+	forceDue(t, db, delivery.ID)
+	w.send()
+	if r.calls.Load() != 3 {
+		t.Fatal("dead доставка отправлена повторно")
+	}
+	if dead, _ := w.GetDeliveries(webhook.ID, entity.WebhookDeliveryDead, 10); len(dead) != 1 {
+		t.Fatalf("dead доставок %d", len(dead))
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	r := &receiver{t: t, statuses: []int{http.StatusServiceUnavailable, http.StatusNoContent}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	w, _ := newTestWebhooks(t, config.Webhooks{AllowPrivateNetworks: true, MaxAttempts: 1, InitialBackoff: time.Minute, MaxBackoff: time.Minute})
+	webhook, _, err := w.CreateWebhook(server.URL, nil, testWebhookSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publish(t, w, webhook.ID)
+	w.send()
+	if delivery, _ := getDelivery(t, w, webhook.ID, queued.ID); delivery.Status != entity.WebhookDeliveryDead {
+		t.Fatalf("ожидалась dead доставка: %+v", delivery)
+	}
+
+	if err := w.Redeliver(webhook.ID, queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	w.send()
+	delivery, attempts := getDelivery(t, w, webhook.ID, queued.ID)
+	if delivery.Status != entity.WebhookDeliveryDelivered || delivery.LastStatus != http.StatusNoContent || len(attempts) != 2 {
+		t.Fatalf("после повторной отправки: %+v, попыток %d", delivery, len(attempts))
+	}
+	if r.invalid.Load() != 0 {
+		t.Fatal("повторная доставка с неверной подписью")
+	}
+
+	if err := w.Redeliver(webhook.ID+1, queued.ID); !errors.Is(err, ErrorDeliveryNotFound) {
+		t.Fatalf("доставка чужой подписки: %v", err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	w := &WebhookUseCaseImpl{cfg: config.Webhooks{InitialBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, d := range want {
+		if got := w.backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %v, ожидалось %v", i+1, got, d)
+		}
+	}
+}
+
+func TestWebhookURLValidation(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://hooks.example.com/auth", nil},
+		{"ftp://hooks.example.com", ErrorWebhookURL},
+		{"/relative", ErrorWebhookURL},
+		{"http://localhost:8080/", ErrorWebhookAddress},
+		{"http://api.localhost/", ErrorWebhookAddress},
+		{"http://127.0.0.1/", ErrorWebhookAddress},
+		{"http://169.254.169.254/latest/meta-data/", ErrorWebhookAddress},
+		{"http://10.1.2.3/", ErrorWebhookAddress},
+		{"http://192.168.0.10/", ErrorWebhookAddress},
+		{"http://[::1]:9000/", ErrorWebhookAddress},
+		{"http://[::ffff:169.254.169.254]/", ErrorWebhookAddress},
+		{"http://[fd00::1]/", ErrorWebhookAddress},
+	}
+	w, _ := newTestWebhooks(t, config.Webhooks{})
+	for _, tt := range tests {
+		if _, _, err := w.CreateWebhook(tt.url, nil, ""); !errors.Is(err, tt.want) {
+			t.Errorf("%s: ошибка %v, ожидалась %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestBlockedAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"127.255.0.1", true},
+		{"10.0.0.1", true},
+		{"172.16.5.4", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1%eth0", true},
+		{"fd12:3456::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := blockedAddress(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+			t.Errorf("blockedAddress(%s) = %v, ожидалось %v", tt.addr, got, tt.blocked)
+		}
+	}
+}
+
+// Подписка, созданная до включения запрета или с именем, которое позже стало указывать
+// на внутренний адрес, отклоняется при подключении, и получатель запроса не видит.
+func TestWebhookDialBlocksPrivateAddress(t *testing.T) {
+	r := &receiver{t: t, statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	w, db := newTestWebhooks(t, config.Webhooks{MaxAttempts: 1})
+	repo := repository.NewWebhookRep(db)
+	for _, target := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		webhook, err := repo.CreateWebhook(entity.Webhook{URL: target, Secret: testWebhookSecret, Active: true, CreateAt: time.Now().Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued := publish(t, w, webhook.ID)
+		w.send()
+
+		delivery, attempts := getDelivery(t, w, webhook.ID, queued.ID)
+		if delivery.Status != entity.WebhookDeliveryDead || len(attempts) != 1 || !strings.Contains(attempts[0].Error, ErrorWebhookAddress.Error()) {
+			t.Fatalf("%s: доставка %+v, попытки %+v", target, delivery, attempts)
+		}
+	}
+	if r.calls.Load() != 0 {
+		t.Fatalf("получатель во внутренней сети вызван %d раз", r.calls.Load())
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX idx_service_accounts_client_id;
+DROP TABLE service_accounts;
\ No newline at end of file
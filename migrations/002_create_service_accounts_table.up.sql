@@ -0,0 +1,11 @@
+CREATE TABLE service_accounts
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    name          TEXT NOT NULL,
+    client_id     TEXT NOT NULL UNIQUE,
+    client_secret TEXT NOT NULL,
+    scopes        TEXT NOT NULL DEFAULT '',
+    create_at     DATE NOT NULL
+);
+
+CREATE INDEX idx_service_accounts_client_id ON service_accounts (client_id);
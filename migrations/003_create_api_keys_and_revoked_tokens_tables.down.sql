@@ -0,0 +1,3 @@
+DROP TABLE revoked_tokens;
+DROP INDEX idx_api_keys_user_id;
+DROP TABLE api_keys;
\ No newline at end of file
@@ -0,0 +1,20 @@
+CREATE TABLE api_keys
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       TEXT    NOT NULL,
+    prefix     TEXT    NOT NULL UNIQUE,
+    key_hash   TEXT    NOT NULL,
+    scopes     TEXT    NOT NULL DEFAULT '',
+    expires_at INTEGER NOT NULL DEFAULT 0,
+    revoked    INTEGER NOT NULL DEFAULT 0,
+    create_at  DATE    NOT NULL
+);
+
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
+
+CREATE TABLE revoked_tokens
+(
+    jti        TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP TABLE user_mfa;
+DROP TABLE webauthn_sessions;
+DROP INDEX idx_webauthn_credentials_user_id;
+DROP TABLE webauthn_credentials;
\ No newline at end of file
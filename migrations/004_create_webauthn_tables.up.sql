@@ -0,0 +1,29 @@
+CREATE TABLE webauthn_credentials
+(
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id          INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    credential_id    TEXT    NOT NULL UNIQUE,
+    name             TEXT    NOT NULL DEFAULT '',
+    attestation_type TEXT    NOT NULL,
+    sign_count       INTEGER NOT NULL DEFAULT 0,
+    data             TEXT    NOT NULL,
+    create_at        DATE    NOT NULL,
+    last_used_at     DATE
+);
+
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+CREATE TABLE webauthn_sessions
+(
+    id         TEXT PRIMARY KEY,
+    user_id    INTEGER NOT NULL DEFAULT 0,
+    purpose    TEXT    NOT NULL,
+    data       TEXT    NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE user_mfa
+(
+    user_id          INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    passkey_required INTEGER NOT NULL DEFAULT 0
+);
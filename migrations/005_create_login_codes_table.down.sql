@@ -0,0 +1,4 @@
+DROP TABLE user_passwordless;
+DROP INDEX idx_login_codes_code_hash;
+DROP INDEX idx_login_codes_user_id;
+DROP TABLE login_codes;
\ No newline at end of file
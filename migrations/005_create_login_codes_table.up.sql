@@ -0,0 +1,20 @@
+CREATE TABLE login_codes
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind       TEXT    NOT NULL,
+    code_hash  TEXT    NOT NULL,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    issued_at  INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_login_codes_user_id ON login_codes (user_id);
+CREATE INDEX idx_login_codes_code_hash ON login_codes (code_hash);
+
+CREATE TABLE user_passwordless
+(
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    enabled INTEGER NOT NULL DEFAULT 0
+);
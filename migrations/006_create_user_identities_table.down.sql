@@ -0,0 +1,3 @@
+DROP TABLE external_login_states;
+DROP INDEX idx_user_identities_user_id;
+DROP TABLE user_identities;
\ No newline at end of file
@@ -0,0 +1,24 @@
+CREATE TABLE user_identities
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    connector   TEXT    NOT NULL,
+    subject     TEXT    NOT NULL,
+    email       TEXT    NOT NULL DEFAULT '',
+    provisioned INTEGER NOT NULL DEFAULT 0,
+    create_at   DATE    NOT NULL,
+    UNIQUE (connector, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE external_login_states
+(
+    state      TEXT PRIMARY KEY,
+    connector  TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL DEFAULT 0,
+    nonce      TEXT    NOT NULL,
+    verifier   TEXT    NOT NULL,
+    session    INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL
+);
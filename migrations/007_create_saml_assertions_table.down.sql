@@ -0,0 +1 @@
+DROP TABLE saml_assertions;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE saml_assertions
+(
+    id         TEXT PRIMARY KEY,
+    tenant     TEXT    NOT NULL,
+    expires_at INTEGER NOT NULL
+);
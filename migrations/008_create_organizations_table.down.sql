@@ -0,0 +1,25 @@
+CREATE TABLE users_old
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    name     TEXT NOT NULL,
+    email    TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    role   TEXT DEFAULT "user",
+    create_at DATE NOT NULL
+);
+
+INSERT INTO users_old (id, name, email, password, role, create_at)
+SELECT id, name, email, password, role, create_at
+FROM users
+WHERE tenant_id = 0;
+
+DROP INDEX idx_users_email;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX idx_users_email ON users (email);
+
+DROP INDEX idx_memberships_user_id;
+DROP TABLE memberships;
+DROP INDEX idx_organizations_domain;
+DROP TABLE organizations;
@@ -0,0 +1,46 @@
+CREATE TABLE organizations
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug            TEXT    NOT NULL UNIQUE,
+    name            TEXT    NOT NULL,
+    domain          TEXT    NOT NULL DEFAULT '',
+    isolated_emails INTEGER NOT NULL DEFAULT 0,
+    create_at       DATE    NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_organizations_domain ON organizations (domain) WHERE domain != '';
+
+CREATE TABLE memberships
+(
+    org_id    INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role      TEXT    NOT NULL DEFAULT 'user',
+    create_at DATE    NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX idx_memberships_user_id ON memberships (user_id);
+
+-- email уникален в пределах tenant_id: 0 — глобальные учетные записи,
+-- иначе учетная запись принадлежит организации с изолированными email
+CREATE TABLE users_new
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    name      TEXT    NOT NULL,
+    email     TEXT    NOT NULL,
+    password  TEXT    NOT NULL,
+    role      TEXT DEFAULT "user",
+    create_at DATE    NOT NULL,
+    tenant_id INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (tenant_id, email)
+);
+
+INSERT INTO users_new (id, name, email, password, role, create_at)
+SELECT id, name, email, password, role, create_at
+FROM users;
+
+DROP INDEX idx_users_email;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX idx_users_email ON users (email);
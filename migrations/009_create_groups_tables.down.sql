@@ -0,0 +1,5 @@
+DROP TABLE group_roles;
+DROP INDEX idx_group_members_user_id;
+DROP TABLE group_members;
+DROP INDEX idx_user_groups_parent_id;
+DROP TABLE user_groups;
@@ -0,0 +1,30 @@
+-- tenant_id: 0 — глобальные группы, иначе группы организации
+CREATE TABLE user_groups
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id   INTEGER NOT NULL DEFAULT 0,
+    name        TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    parent_id   INTEGER NOT NULL DEFAULT 0,
+    create_at   DATE    NOT NULL,
+    UNIQUE (tenant_id, name)
+);
+
+CREATE INDEX idx_user_groups_parent_id ON user_groups (parent_id);
+
+CREATE TABLE group_members
+(
+    group_id  INTEGER NOT NULL REFERENCES user_groups (id) ON DELETE CASCADE,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    create_at DATE    NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user_id ON group_members (user_id);
+
+CREATE TABLE group_roles
+(
+    group_id INTEGER NOT NULL REFERENCES user_groups (id) ON DELETE CASCADE,
+    role     TEXT    NOT NULL,
+    PRIMARY KEY (group_id, role)
+);
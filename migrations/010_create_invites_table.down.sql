@@ -0,0 +1,2 @@
+DROP INDEX idx_invites_tenant_email;
+DROP TABLE invites;
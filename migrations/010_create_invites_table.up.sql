@@ -0,0 +1,15 @@
+-- tenant_id: 0 — приглашение в глобальный контекст, иначе в организацию
+CREATE TABLE invites
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id   INTEGER NOT NULL DEFAULT 0,
+    email       TEXT    NOT NULL,
+    role        TEXT    NOT NULL DEFAULT 'user',
+    invited_by  INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL,
+    accepted_at INTEGER NOT NULL DEFAULT 0,
+    revoked_at  INTEGER NOT NULL DEFAULT 0,
+    create_at   DATE    NOT NULL
+);
+
+CREATE INDEX idx_invites_tenant_email ON invites (tenant_id, email);
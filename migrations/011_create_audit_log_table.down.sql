@@ -0,0 +1,3 @@
+DROP INDEX idx_audit_log_actor_id;
+DROP INDEX idx_audit_log_user_id;
+DROP TABLE audit_log;
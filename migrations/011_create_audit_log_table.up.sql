@@ -0,0 +1,18 @@
+CREATE TABLE audit_log
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    action      TEXT    NOT NULL,
+    actor_id    INTEGER NOT NULL DEFAULT 0,
+    actor_email TEXT    NOT NULL DEFAULT '',
+    user_id     INTEGER NOT NULL DEFAULT 0,
+    user_email  TEXT    NOT NULL DEFAULT '',
+    tenant      TEXT    NOT NULL DEFAULT '',
+    method      TEXT    NOT NULL DEFAULT '',
+    path        TEXT    NOT NULL DEFAULT '',
+    status      INTEGER NOT NULL DEFAULT 0,
+    details     TEXT    NOT NULL DEFAULT '',
+    create_at   DATE    NOT NULL
+);
+
+CREATE INDEX idx_audit_log_user_id ON audit_log (user_id);
+CREATE INDEX idx_audit_log_actor_id ON audit_log (actor_id);
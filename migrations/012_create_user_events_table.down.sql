@@ -0,0 +1,2 @@
+DROP INDEX idx_user_events_create_at;
+DROP TABLE user_events;
@@ -0,0 +1,13 @@
+CREATE TABLE user_events
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    type      TEXT    NOT NULL,
+    user_id   INTEGER NOT NULL,
+    email     TEXT    NOT NULL DEFAULT '',
+    name      TEXT    NOT NULL DEFAULT '',
+    role      TEXT    NOT NULL DEFAULT '',
+    tenant    TEXT    NOT NULL DEFAULT '',
+    create_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_user_events_create_at ON user_events (create_at);
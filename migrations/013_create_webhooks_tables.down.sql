@@ -0,0 +1,6 @@
+DROP TABLE webhook_cursor;
+DROP INDEX idx_webhook_attempts_delivery_id;
+DROP TABLE webhook_attempts;
+DROP INDEX idx_webhook_deliveries_due;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,47 @@
+CREATE TABLE webhooks
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    url       TEXT    NOT NULL,
+    events    TEXT    NOT NULL DEFAULT '',
+    secret    TEXT    NOT NULL,
+    active    INTEGER NOT NULL DEFAULT 1,
+    create_at INTEGER NOT NULL
+);
+
+CREATE TABLE webhook_deliveries
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id      INTEGER NOT NULL,
+    event_id        INTEGER NOT NULL,
+    event_type      TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'pending',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_status     INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT    NOT NULL DEFAULT '',
+    create_at       INTEGER NOT NULL,
+    update_at       INTEGER NOT NULL,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE webhook_attempts
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery_id INTEGER NOT NULL,
+    status      INTEGER NOT NULL DEFAULT 0,
+    error       TEXT    NOT NULL DEFAULT '',
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    create_at   INTEGER NOT NULL
+);
+
+CREATE INDEX idx_webhook_attempts_delivery_id ON webhook_attempts (delivery_id);
+
+-- позиция диспетчера в user_events: события после нее еще не разложены по подпискам
+CREATE TABLE webhook_cursor
+(
+    id     INTEGER PRIMARY KEY CHECK (id = 1),
+    cursor INTEGER NOT NULL
+);
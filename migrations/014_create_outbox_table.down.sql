@@ -0,0 +1,11 @@
+CREATE TABLE webhook_cursor
+(
+    id     INTEGER PRIMARY KEY CHECK (id = 1),
+    cursor INTEGER NOT NULL
+);
+
+DROP INDEX idx_user_events_idempotency_key;
+ALTER TABLE user_events DROP COLUMN idempotency_key;
+
+DROP TABLE outbox_cursors;
+DROP TABLE outbox;
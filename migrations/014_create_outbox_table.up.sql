@@ -0,0 +1,32 @@
+-- outbox пишется в одной транзакции с изменением пользователя. Relay читает его
+-- по порядку и доставляет события в приемники; строки, пройденные всеми
+-- приемниками, удаляются.
+CREATE TABLE outbox
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    idempotency_key TEXT    NOT NULL UNIQUE DEFAULT (lower(hex(randomblob(16)))),
+    type            TEXT    NOT NULL,
+    user_id         INTEGER NOT NULL,
+    email           TEXT    NOT NULL DEFAULT '',
+    name            TEXT    NOT NULL DEFAULT '',
+    role            TEXT    NOT NULL DEFAULT '',
+    tenant          TEXT    NOT NULL DEFAULT '',
+    create_at       INTEGER NOT NULL
+);
+
+-- позиция каждого приемника в outbox
+CREATE TABLE outbox_cursors
+(
+    sink      TEXT PRIMARY KEY,
+    cursor    INTEGER NOT NULL,
+    update_at INTEGER NOT NULL
+);
+
+-- ID событий outbox продолжают нумерацию user_events: по ним доставки вебхуков
+-- отличают новые события от уже поставленных в очередь
+INSERT INTO sqlite_sequence (name, seq) SELECT 'outbox', COALESCE(MAX(id), 0) FROM user_events;
+
+ALTER TABLE user_events ADD COLUMN idempotency_key TEXT;
+CREATE UNIQUE INDEX idx_user_events_idempotency_key ON user_events (idempotency_key);
+
+DROP TABLE webhook_cursor;
@@ -0,0 +1,3 @@
+DROP TABLE scim_tokens;
+DROP INDEX idx_scim_resources_inactive;
+DROP TABLE scim_resources;
@@ -0,0 +1,30 @@
+-- Атрибуты SCIM, которых нет в users и user_groups: externalId из IdP и active.
+-- Отключенный (active = 0) пользователь не получает токенов, выданные отклоняются.
+-- tenant_id = 0 — глобальный контекст; пользователь отключается в той организации,
+-- через которую его отключил IdP, а глобально — везде.
+CREATE TABLE scim_resources
+(
+    type        TEXT    NOT NULL,
+    resource_id INTEGER NOT NULL,
+    tenant_id   INTEGER NOT NULL DEFAULT 0,
+    external_id TEXT    NOT NULL DEFAULT '',
+    active      INTEGER NOT NULL DEFAULT 1,
+    create_at   INTEGER NOT NULL,
+    update_at   INTEGER NOT NULL,
+    PRIMARY KEY (type, tenant_id, resource_id)
+);
+
+CREATE INDEX idx_scim_resources_inactive ON scim_resources (resource_id) WHERE type = 'User' AND active = 0;
+
+-- Токены провижининга для IdP: scim_<prefix>_<secret>, хранится sha256 секрета
+CREATE TABLE scim_tokens
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id    INTEGER NOT NULL DEFAULT 0,
+    name         TEXT    NOT NULL,
+    prefix       TEXT    NOT NULL UNIQUE,
+    token_hash   TEXT    NOT NULL,
+    revoked      INTEGER NOT NULL DEFAULT 0,
+    last_used_at INTEGER NOT NULL DEFAULT 0,
+    create_at    INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+-- удаленные строки не восстанавливаются
+SELECT 1;
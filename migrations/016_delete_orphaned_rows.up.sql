@@ -0,0 +1,13 @@
+-- До включения PRAGMA foreign_keys удаление пользователей, организаций и групп
+-- не срабатывало каскадно: удаляем строки, оставшиеся без родителя.
+DELETE FROM api_keys WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM webauthn_credentials WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM user_mfa WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM login_codes WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM user_passwordless WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM user_identities WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM memberships WHERE user_id NOT IN (SELECT id FROM users)
+                           OR org_id NOT IN (SELECT id FROM organizations);
+DELETE FROM group_members WHERE user_id NOT IN (SELECT id FROM users)
+                             OR group_id NOT IN (SELECT id FROM user_groups);
+DELETE FROM group_roles WHERE group_id NOT IN (SELECT id FROM user_groups);
@@ -0,0 +1 @@
+ALTER TABLE external_login_states DROP COLUMN tenant;
@@ -0,0 +1,2 @@
+-- slug организации, в которой начат вход через провайдер: завершить его можно только в ней
+ALTER TABLE external_login_states ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
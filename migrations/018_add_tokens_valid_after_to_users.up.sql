@@ -0,0 +1,2 @@
+-- токены пользователя, выданные раньше этого момента (unix), отозваны: ставится при смене пароля
+ALTER TABLE users ADD COLUMN tokens_valid_after INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"time"
+)
+
+const (
+	TypeNATS  = "nats"
+	TypeKafka = "kafka"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Message — событие для брокера. ID — ключ идемпотентности: брокер или
+// потребитель отбрасывает по нему повторную публикацию того же события.
+// Key определяет партицию: события одного пользователя идут по порядку.
+type Message struct {
+	ID   string
+	Key  string
+	Type string
+	Data []byte
+}
+
+// Publisher публикует сообщения в брокер. Publish возвращается, только когда
+// брокер подтвердил прием всех сообщений пачки.
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, messages []Message) error
+	Close() error
+}
+
+// New подключается к брокеру по настройкам приемника outbox.
+func New(cfg config.OutboxSink) (Publisher, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("у приемника outbox не задан name")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	switch cfg.Type {
+	case TypeNATS:
+		if cfg.URL == "" || cfg.Subject == "" {
+			return nil, fmt.Errorf("у приемника %s должны быть заданы url и subject", cfg.Name)
+		}
+		return newNATS(cfg)
+	case TypeKafka:
+		if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+			return nil, fmt.Errorf("у приемника %s должны быть заданы brokers и topic", cfg.Name)
+		}
+		return newKafka(cfg), nil
+	default:
+		return nil, fmt.Errorf("неизвестный тип приемника %s: %q", cfg.Name, cfg.Type)
+	}
+}
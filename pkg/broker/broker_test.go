@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"context"
+	"github.com/LandGAA/authh2/pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.OutboxSink
+		err  string
+	}{
+		{"без имени", config.OutboxSink{Type: TypeKafka, Brokers: []string{"localhost:9092"}, Topic: "users"}, "не задан name"},
+		{"неизвестный тип", config.OutboxSink{Name: "mq", Type: "rabbitmq"}, "неизвестный тип"},
+		{"nats без subject", config.OutboxSink{Name: "events", Type: TypeNATS, URL: "nats://localhost:4222"}, "url и subject"},
+		{"nats без url", config.OutboxSink{Name: "events", Type: TypeNATS, Subject: "users"}, "url и subject"},
+		{"kafka без brokers", config.OutboxSink{Name: "events", Type: TypeKafka, Topic: "users"}, "brokers и topic"},
+		{"kafka без topic", config.OutboxSink{Name: "events", Type: TypeKafka, Brokers: []string{"localhost:9092"}}, "brokers и topic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestKafkaDefaults(t *testing.T) {
+	p, err := New(config.OutboxSink{Name: "events", Type: TypeKafka, Brokers: []string{"localhost:9092"}, Topic: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	kafka := p.(*kafkaPublisher)
+	if p.Name() != "events" || kafka.cfg.Timeout != defaultTimeout || kafka.writer.MaxAttempts != 1 {
+		t.Fatalf("настройки Kafka: %+v, MaxAttempts %d", kafka.cfg, kafka.writer.MaxAttempts)
+	}
+}
+
+// Недоступный брокер не мешает запуску, но публикация возвращает ошибку не позже
+// timeout: relay outbox повторит пачку.
+func TestPublishUnavailableBroker(t *testing.T) {
+	sinks := []config.OutboxSink{
+		{Name: "kafka", Type: TypeKafka, Brokers: []string{"127.0.0.1:1"}, Topic: "users", Timeout: 200 * time.Millisecond},
+		{Name: "nats", Type: TypeNATS, URL: "nats://127.0.0.1:1", Subject: "users", Timeout: 200 * time.Millisecond},
+	}
+	for _, cfg := range sinks {
+		t.Run(cfg.Name, func(t *testing.T) {
+			p, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			start := time.Now()
+			err = p.Publish(context.Background(), []Message{{ID: "1", Key: "7", Type: "user.created", Data: []byte(`{}`)}})
+			if err == nil {
+				t.Fatal("публикация в недоступный брокер прошла")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("публикация заняла %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaPublisher пишет в topic с подтверждением всех реплик. ID события
+// передается потребителям в заголовке Idempotency-Key.
+type kafkaPublisher struct {
+	cfg    config.OutboxSink
+	writer *kafka.Writer
+}
+
+func newKafka(cfg config.OutboxSink) *kafkaPublisher {
+	return &kafkaPublisher{
+		cfg: cfg,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			WriteTimeout: cfg.Timeout,
+			ReadTimeout:  cfg.Timeout,
+			// повторы делает relay outbox целой пачкой
+			MaxAttempts: 1,
+		},
+	}
+}
+
+func (k *kafkaPublisher) Name() string {
+	return k.cfg.Name
+}
+
+func (k *kafkaPublisher) Publish(ctx context.Context, messages []Message) error {
+	ctx, cancel := context.WithTimeout(ctx, k.cfg.Timeout)
+	defer cancel()
+
+	batch := make([]kafka.Message, 0, len(messages))
+	for _, m := range messages {
+		batch = append(batch, kafka.Message{
+			Key:   []byte(m.Key),
+			Value: m.Data,
+			Headers: []kafka.Header{
+				{Key: "Idempotency-Key", Value: []byte(m.ID)},
+				{Key: "Event-Type", Value: []byte(m.Type)},
+			},
+		})
+	}
+	if err := k.writer.WriteMessages(ctx, batch...); err != nil {
+		return fmt.Errorf("ошибка публикации в Kafka %s: %w", k.cfg.Topic, err)
+	}
+	return nil
+}
+
+func (k *kafkaPublisher) Close() error {
+	return k.writer.Close()
+}
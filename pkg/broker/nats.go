@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/nats-io/nats.go"
+)
+
+// natsPublisher публикует в subject. С JetStream каждое сообщение ждет
+// подтверждения, а повторы в окне дубликатов стрима отбрасываются по Nats-Msg-Id.
+// Без JetStream доставка подтверждается только до сервера NATS (flush).
+type natsPublisher struct {
+	cfg  config.OutboxSink
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func newNATS(cfg config.OutboxSink) (*natsPublisher, error) {
+	conn, err := nats.Connect(cfg.URL,
+		nats.Name("authh2-outbox"),
+		nats.Timeout(cfg.Timeout),
+		// недоступный при запуске сервер не мешает старту: события подождут в outbox
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к NATS %s: %w", cfg.Name, err)
+	}
+	p := &natsPublisher{cfg: cfg, conn: conn}
+	if cfg.JetStream {
+		p.js, err = conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ошибка подключения к JetStream %s: %w", cfg.Name, err)
+		}
+	}
+	return p, nil
+}
+
+func (n *natsPublisher) Name() string {
+	return n.cfg.Name
+}
+
+func (n *natsPublisher) Publish(ctx context.Context, messages []Message) error {
+	ctx, cancel := context.WithTimeout(ctx, n.cfg.Timeout)
+	defer cancel()
+
+	for _, m := range messages {
+		msg := nats.NewMsg(n.cfg.Subject)
+		msg.Header.Set(nats.MsgIdHdr, m.ID)
+		msg.Header.Set("Event-Type", m.Type)
+		msg.Data = m.Data
+
+		if n.js != nil {
+			if _, err := n.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+				return fmt.Errorf("ошибка публикации в JetStream %s: %w", n.cfg.Subject, err)
+			}
+			continue
+		}
+		if err := n.conn.PublishMsg(msg); err != nil {
+			return fmt.Errorf("ошибка публикации в NATS %s: %w", n.cfg.Subject, err)
+		}
+	}
+	if n.js == nil {
+		if err := n.conn.FlushWithContext(ctx); err != nil {
+			return fmt.Errorf("ошибка публикации в NATS %s: %w", n.cfg.Subject, err)
+		}
+	}
+	return nil
+}
+
+func (n *natsPublisher) Close() error {
+	return n.conn.Drain()
+}
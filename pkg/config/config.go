@@ -0,0 +1,699 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+var Cfg Config
+
+type Config struct {
+	Tenancy       Tenancy       `yaml:"tenancy"`
+	Groups        Groups        `yaml:"groups"`
+	Registration  Registration  `yaml:"registration"`
+	Impersonation Impersonation `yaml:"impersonation"`
+	TokenExchange TokenExchange `yaml:"token_exchange"`
+	Session       Session       `yaml:"session"`
+	WebAuthn      WebAuthn      `yaml:"webauthn"`
+	Passwordless  Passwordless  `yaml:"passwordless"`
+	Mailer        Mailer        `yaml:"mailer"`
+	Connectors    []Connector   `yaml:"connectors"`
+	LDAP          LDAP          `yaml:"ldap"`
+	SAML          SAML          `yaml:"saml"`
+	ForwardAuth   ForwardAuth   `yaml:"forward_auth"`
+	ExtAuthz      ExtAuthz      `yaml:"ext_authz"`
+	GRPC          GRPC          `yaml:"grpc"`
+	Events        Events        `yaml:"events"`
+	Webhooks      Webhooks      `yaml:"webhooks"`
+	Outbox        Outbox        `yaml:"outbox"`
+	SCIM          SCIM          `yaml:"scim"`
+	Metrics       Metrics       `yaml:"metrics"`
+	Tracing       Tracing       `yaml:"tracing"`
+	Health        Health        `yaml:"health"`
+}
+
+// Tenancy — организации (тенанты) на одном развертывании. Тенант запроса определяется
+// резолверами по порядку: path (/t/{slug}/...), header (заголовок Header) и host
+// (домен организации или поддомен {slug}.{base_domain}).
+type Tenancy struct {
+	Enabled    bool     `yaml:"enabled"`
+	Resolvers  []string `yaml:"resolvers"`
+	Header     string   `yaml:"header"`
+	BaseDomain string   `yaml:"base_domain"`
+	// Required — отклонять запросы, для которых тенант не определен
+	Required bool `yaml:"required"`
+	// Issuer — шаблон iss токенов организации, {tenant} заменяется на slug
+	Issuer string `yaml:"issuer"`
+}
+
+// IssuerFor возвращает iss для токенов тенанта; для глобальных токенов — пустую строку.
+func (t Tenancy) IssuerFor(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return strings.ReplaceAll(t.Issuer, "{tenant}", tenant)
+}
+
+// Groups — группы пользователей. Роли, назначенные группе, получают все ее участники,
+// включая участников подгрупп.
+type Groups struct {
+	// TokenClaim — добавлять в access токен claim groups с группами пользователя
+	TokenClaim bool `yaml:"token_claim"`
+	// Permissions — разрешения каждой роли; эффективные разрешения пользователя —
+	// объединение разрешений всех его ролей
+	Permissions map[string][]string `yaml:"permissions"`
+}
+
+// PermissionsFor возвращает объединение разрешений ролей в порядке сортировки.
+func (g Groups) PermissionsFor(roles []string) []string {
+	permissions := []string{}
+	for _, role := range roles {
+		for _, permission := range g.Permissions[role] {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	slices.Sort(permissions)
+	return permissions
+}
+
+const (
+	RegistrationOpen    = "open"
+	RegistrationDomains = "domains"
+	RegistrationInvite  = "invite"
+)
+
+// Registration — самостоятельная регистрация через /register и приглашения.
+// Mode: open — регистрация открыта всем, domains — только для email из AllowedDomains,
+// invite — только по приглашениям.
+type Registration struct {
+	Mode           string   `yaml:"mode"`
+	AllowedDomains []string `yaml:"allowed_domains"`
+	// InviteURL — адрес страницы фронтенда, к которому дописывается токен приглашения
+	InviteURL string        `yaml:"invite_url"`
+	InviteTTL time.Duration `yaml:"invite_ttl"`
+}
+
+// AllowsEmail сообщает, можно ли зарегистрироваться с этим email без приглашения.
+func (r Registration) AllowsEmail(email string) bool {
+	switch r.Mode {
+	case RegistrationOpen, "":
+		return true
+	case RegistrationDomains:
+		at := strings.LastIndex(email, "@")
+		if at == -1 {
+			return false
+		}
+		domain := strings.ToLower(email[at+1:])
+		for _, allowed := range r.AllowedDomains {
+			if strings.ToLower(allowed) == domain {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Impersonation — вход администратора от имени пользователя (для поддержки). Токен
+// несет claim act с настоящим пользователем и не позволяет менять пароль и факторы входа.
+type Impersonation struct {
+	Enabled bool `yaml:"enabled"`
+	// Roles — роли, которым разрешено действовать от имени других пользователей
+	Roles []string `yaml:"roles"`
+	// ProtectedRoles — от имени пользователей с этими ролями (включая роли групп) действовать нельзя
+	ProtectedRoles []string      `yaml:"protected_roles"`
+	TTL            time.Duration `yaml:"ttl"`
+}
+
+// TokenExchange — обмен токена пользователя на токен для другого сервиса (RFC 8693).
+// Выданный токен ограничен аудиторией и scope и несет claim act с клиентом, запросившим обмен.
+type TokenExchange struct {
+	// TTL — максимальный срок жизни токена; он не переживает исходный токен
+	TTL time.Duration `yaml:"ttl"`
+	// Audiences — сервисы, для которых можно получить токен; пустой список — любые
+	Audiences []string `yaml:"audiences"`
+}
+
+// AllowsAudience сообщает, можно ли выдать токен для сервиса audience.
+func (t TokenExchange) AllowsAudience(audience string) bool {
+	return len(t.Audiences) == 0 || slices.Contains(t.Audiences, audience)
+}
+
+// Session — настройки cookie-сессий для браузерного фронтенда.
+type Session struct {
+	AccessCookie  string `yaml:"access_cookie"`
+	RefreshCookie string `yaml:"refresh_cookie"`
+	CSRFCookie    string `yaml:"csrf_cookie"`
+	CSRFHeader    string `yaml:"csrf_header"`
+	// StateCookie — cookie со state входа через внешний провайдер, действует до возврата от него
+	StateCookie string `yaml:"state_cookie"`
+	Domain      string `yaml:"domain"`
+	Secure      bool   `yaml:"secure"`
+	SameSite    string `yaml:"same_site"`
+	// PostLoginURL — куда перенаправить браузер после входа через внешний провайдер
+	PostLoginURL string `yaml:"post_login_url"`
+}
+
+type WebAuthn struct {
+	RPID               string   `yaml:"rp_id"`
+	RPDisplayName      string   `yaml:"rp_display_name"`
+	RPOrigins          []string `yaml:"rp_origins"`
+	Attestation        string   `yaml:"attestation"`
+	AttestationFormats []string `yaml:"attestation_formats"`
+}
+
+// Passwordless — вход по одноразовой ссылке или 6-значному коду из письма.
+type Passwordless struct {
+	// LinkURL — адрес страницы фронтенда, к которому дописывается токен ссылки
+	LinkURL        string        `yaml:"link_url"`
+	TTL            time.Duration `yaml:"ttl"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	ThrottleWindow time.Duration `yaml:"throttle_window"`
+	ThrottleLimit  int           `yaml:"throttle_limit"`
+}
+
+// Mailer — отправка писем. Driver: log (письма пишутся в лог) или smtp.
+type Mailer struct {
+	Driver   string `yaml:"driver"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// Connector — внешний провайдер входа. Type: oidc (discovery по issuer) или oauth2
+// (auth_url, token_url и userinfo_url задаются явно, поля профиля — через *_field).
+type Connector struct {
+	ID           string   `yaml:"id"`
+	Type         string   `yaml:"type"`
+	Name         string   `yaml:"name"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+
+	Issuer string `yaml:"issuer"`
+
+	AuthURL      string `yaml:"auth_url"`
+	TokenURL     string `yaml:"token_url"`
+	UserInfoURL  string `yaml:"userinfo_url"`
+	SubjectField string `yaml:"subject_field"`
+	EmailField   string `yaml:"email_field"`
+	NameField    string `yaml:"name_field"`
+
+	Provisioning Provisioning `yaml:"provisioning"`
+}
+
+// Provisioning — что делать при первом входе через провайдер, если личность еще не привязана.
+type Provisioning struct {
+	// CreateUsers — создавать пользователя (just-in-time)
+	CreateUsers bool `yaml:"create_users"`
+	// LinkByEmail — привязать к существующему пользователю с тем же подтвержденным email
+	LinkByEmail    bool     `yaml:"link_by_email"`
+	AllowedDomains []string `yaml:"allowed_domains"`
+	DefaultRole    string   `yaml:"default_role"`
+}
+
+// AllowsEmail сообщает, разрешен ли автоматический вход для email по списку доменов.
+func (p Provisioning) AllowsEmail(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	for _, domain := range p.AllowedDomains {
+		if strings.EqualFold(email[at+1:], domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// LDAP — проверка паролей сотрудников в LDAP / Active Directory.
+// Mode: search — найти пользователя служебной учетной записью и войти его DN,
+// bind — войти сразу по user_dn_template. В шаблонах {login} заменяется на email.
+type LDAP struct {
+	Enabled            bool          `yaml:"enabled"`
+	URL                string        `yaml:"url"`
+	StartTLS           bool          `yaml:"start_tls"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	CAFile             string        `yaml:"ca_file"`
+	Timeout            time.Duration `yaml:"timeout"`
+
+	Mode           string `yaml:"mode"`
+	BindDN         string `yaml:"bind_dn"`
+	BindPassword   string `yaml:"bind_password"`
+	UserDNTemplate string `yaml:"user_dn_template"`
+	BaseDN         string `yaml:"base_dn"`
+	UserFilter     string `yaml:"user_filter"`
+	SyncFilter     string `yaml:"sync_filter"`
+
+	EmailAttribute string `yaml:"email_attribute"`
+	NameAttribute  string `yaml:"name_attribute"`
+	GroupAttribute string `yaml:"group_attribute"`
+
+	// GroupRoles проверяются по порядку, роль берется из первой группы пользователя
+	GroupRoles   []GroupRole   `yaml:"group_roles"`
+	DefaultRole  string        `yaml:"default_role"`
+	CreateUsers  bool          `yaml:"create_users"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+type GroupRole struct {
+	Group string `yaml:"group"`
+	Role  string `yaml:"role"`
+}
+
+// SAML — вход через корпоративные SAML 2.0 IdP. У каждого тенанта свой IdP;
+// entity ID сервиса — {base_url}/v1/saml/{tenant}/metadata, ACS — {base_url}/v1/saml/{tenant}/acs.
+type SAML struct {
+	// BaseURL — внешний адрес сервиса, из него строятся адреса metadata и ACS
+	BaseURL string `yaml:"base_url"`
+	// CertFile и KeyFile — ключ подписи AuthnRequest (RSA). Без них при запуске
+	// создается временный самоподписанный ключ
+	CertFile string    `yaml:"cert_file"`
+	KeyFile  string    `yaml:"key_file"`
+	IdPs     []SAMLIdP `yaml:"idps"`
+}
+
+type SAMLIdP struct {
+	Tenant       string `yaml:"tenant"`
+	MetadataURL  string `yaml:"metadata_url"`
+	MetadataFile string `yaml:"metadata_file"`
+	SignRequests bool   `yaml:"sign_requests"`
+	// AllowIdPInitiated — принимать ответы без AuthnRequest (вход из портала IdP)
+	AllowIdPInitiated bool `yaml:"allow_idp_initiated"`
+
+	// Пустой email_attribute — email берется из NameID
+	EmailAttribute string `yaml:"email_attribute"`
+	NameAttribute  string `yaml:"name_attribute"`
+	RoleAttribute  string `yaml:"role_attribute"`
+	// RoleMapping проверяется по порядку, роль берется из первого совпавшего значения
+	// role_attribute; если совпадений нет — provisioning.default_role
+	RoleMapping  []SAMLRoleMapping `yaml:"role_mapping"`
+	Provisioning Provisioning      `yaml:"provisioning"`
+}
+
+type SAMLRoleMapping struct {
+	Value string `yaml:"value"`
+	Role  string `yaml:"role"`
+}
+
+const (
+	ProxyTraefik = "traefik"
+	ProxyNginx   = "nginx"
+)
+
+// ForwardAuth — проверка запросов для обратного прокси. Proxy определяет единственный
+// источник исходного адреса, которому доверяет сервис: traefik — X-Forwarded-Method,
+// X-Forwarded-Proto, X-Forwarded-Host и X-Forwarded-Uri; nginx — X-Original-URL и
+// X-Original-Method. Заголовки другого прокси игнорируются: прокси их не перезаписывает,
+// и клиент мог бы подставить в них любой путь.
+type ForwardAuth struct {
+	Proxy      string       `yaml:"proxy"`
+	LoginURL   string       `yaml:"login_url"`
+	CookieName string       `yaml:"cookie_name"`
+	Rules      []AccessRule `yaml:"rules"`
+}
+
+type ExtAuthz struct {
+	CookieName string       `yaml:"cookie_name"`
+	Rules      []AccessRule `yaml:"rules"`
+}
+
+// GRPC — gRPC сервер (UserService и Envoy ext_authz).
+type GRPC struct {
+	Addr string  `yaml:"addr"`
+	TLS  GRPCTLS `yaml:"tls"`
+	// Reflection — включить grpc.reflection для grpcurl и подобных инструментов
+	Reflection     bool          `yaml:"reflection"`
+	MaxRecvMsgSize int           `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize int           `yaml:"max_send_msg_size"`
+	Keepalive      GRPCKeepalive `yaml:"keepalive"`
+}
+
+// GRPCTLS — без CertFile сервер работает без TLS. ClientCAFile включает mTLS.
+type GRPCTLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile — CA, которым подписаны клиентские сертификаты
+	ClientCAFile string `yaml:"client_ca_file"`
+	// RequireClientCert — не принимать соединения без клиентского сертификата
+	RequireClientCert bool `yaml:"require_client_cert"`
+	// ClientScopes — scope клиентов, аутентифицированных сертификатом, по CommonName.
+	// Такие клиенты вызывают методы без токена сервисного аккаунта
+	ClientScopes map[string][]string `yaml:"client_scopes"`
+}
+
+type GRPCKeepalive struct {
+	// Time — через сколько простоя сервер пингует клиента, Timeout — сколько ждет ответа
+	Time    time.Duration `yaml:"time"`
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConnectionIdle — закрывать соединения без вызовов дольше этого срока (0 — не закрывать)
+	MaxConnectionIdle time.Duration `yaml:"max_connection_idle"`
+	// MinTime — как часто клиенту разрешено пинговать сервер
+	MinTime             time.Duration `yaml:"min_time"`
+	PermitWithoutStream bool          `yaml:"permit_without_stream"`
+}
+
+// Events — лента изменений пользователей (gRPC WatchUserEvents).
+type Events struct {
+	// PollInterval — как часто открытый поток проверяет новые события
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Retention — сколько хранить события; потребитель, отставший сильнее, получает OUT_OF_RANGE
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Webhooks — доставка событий пользователей подписчикам по HTTP.
+type Webhooks struct {
+	// PollInterval — как часто проверяются новые события и доставки, время повтора которых наступило
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Timeout — сколько ждать ответа получателя
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts — после стольких неудачных попыток доставка переходит в dead
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialBackoff удваивается после каждой неудачи, но не больше MaxBackoff
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// AllowPrivateNetworks разрешает доставку на loopback, link-local и частные адреса.
+	// По умолчанию выключено: URL вебхука задает клиент, и без запрета сервис можно
+	// заставить обращаться к внутренним адресам (в том числе к метаданным облака)
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+// Outbox — события, записанные в одной транзакции с изменениями пользователей, и
+// relay, который доставляет их в приемники: вебхуки, ленту gRPC и брокеры из Sinks.
+type Outbox struct {
+	// PollInterval — как часто relay проверяет новые события
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+	// RetryInterval — пауза перед повтором пачки, которую приемник не принял
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	Sinks         []OutboxSink  `yaml:"sinks"`
+}
+
+// OutboxSink — брокер сообщений. Для nats используются URL, Subject и JetStream,
+// для kafka — Brokers и Topic.
+type OutboxSink struct {
+	Name      string        `yaml:"name"`
+	Type      string        `yaml:"type"`
+	URL       string        `yaml:"url"`
+	Subject   string        `yaml:"subject"`
+	JetStream bool          `yaml:"jetstream"`
+	Brokers   []string      `yaml:"brokers"`
+	Topic     string        `yaml:"topic"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// SCIM — провижининг пользователей и групп из IdP по SCIM 2.0 (/scim/v2 и /t/:tenant/scim/v2).
+type SCIM struct {
+	Enabled bool `yaml:"enabled"`
+	// BaseURL — внешний адрес сервиса для meta.location; пустой — берется из запроса
+	BaseURL string `yaml:"base_url"`
+	// MaxResults — наибольший count в запросе списка
+	MaxResults int `yaml:"max_results"`
+	// Roles — роли, которые IdP может назначить через roles; остальные значения отклоняются
+	Roles []string `yaml:"roles"`
+}
+
+// Metrics — метрики Prometheus на HTTP порту сервиса.
+type Metrics struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// Tracing — трассировка OpenTelemetry HTTP и gRPC запросов, use case'ов и запросов к SQLite.
+type Tracing struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter — otlp (OTLP/gRPC на Endpoint), stdout или file (JSON-строки в File)
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+	// Insecure — подключаться к коллектору без TLS
+	Insecure bool   `yaml:"insecure"`
+	File     string `yaml:"file"`
+	// SampleRatio — доля новых трассировок, которые записываются; для входящих запросов
+	// с traceparent действует решение вызывающего
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+}
+
+// Health — проверки /healthz и /readyz и плавная остановка по SIGTERM.
+type Health struct {
+	// CheckTimeout — общий дедлайн проверок одного запроса /readyz
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// DrainDelay — сколько после сигнала остановки /readyz отвечает 503 до закрытия портов,
+	// чтобы балансировщик успел вывести экземпляр
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ShutdownTimeout — сколько ждать завершения текущих HTTP запросов и gRPC вызовов
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// AccessRule — правило доступа для forward-auth и Envoy ext_authz.
+// Пустые Host, Methods и PathPrefix подходят под любой запрос. PathPrefix сравнивается
+// по границе сегмента: /admin подходит под /admin и /admin/users, но не под /administrator.
+type AccessRule struct {
+	Host           string   `yaml:"host"`
+	PathPrefix     string   `yaml:"path_prefix"`
+	Methods        []string `yaml:"methods"`
+	Roles          []string `yaml:"roles"`
+	AllowAnonymous bool     `yaml:"allow_anonymous"`
+	Deny           bool     `yaml:"deny"`
+}
+
+func Init() {
+	path := os.Getenv("CONFIG_PATH")
+	if path == "" {
+		path = "config.yaml"
+	}
+
+	Cfg = defaults()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Logger.Info("Файл конфигурации не найден, используются значения по умолчанию",
+			zap.String("path", path))
+		return
+	}
+	if err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Ошибка чтения файла конфигурации %s: %v", path, err))
+	}
+
+	if err := yaml.Unmarshal(data, &Cfg); err != nil {
+		logger.Logger.Fatal(fmt.Sprintf("Ошибка разбора файла конфигурации %s: %v", path, err))
+	}
+	logger.Logger.Info("Конфигурация загружена", zap.String("path", path))
+}
+
+func defaults() Config {
+	return Config{
+		Tenancy: Tenancy{
+			Resolvers: []string{"path", "header", "host"},
+			Header:    "X-Tenant",
+			Issuer:    "http://localhost:8081/t/{tenant}",
+		},
+		Session: Session{
+			AccessCookie:  "access_token",
+			RefreshCookie: "refresh_token",
+			CSRFCookie:    "csrf_token",
+			CSRFHeader:    "X-CSRF-Token",
+			StateCookie:   "oauth_state",
+			Secure:        true,
+			SameSite:      "lax",
+		},
+		WebAuthn: WebAuthn{
+			RPID:               "localhost",
+			RPDisplayName:      "Auth",
+			RPOrigins:          []string{"http://localhost:3000"},
+			Attestation:        "none",
+			AttestationFormats: []string{"none", "packed"},
+		},
+		Passwordless: Passwordless{
+			LinkURL:        "http://localhost:3000/login/passwordless?token=",
+			TTL:            10 * time.Minute,
+			MaxAttempts:    5,
+			ThrottleWindow: 15 * time.Minute,
+			ThrottleLimit:  3,
+		},
+		Registration: Registration{
+			Mode:      RegistrationOpen,
+			InviteURL: "http://localhost:3000/invite?token=",
+			InviteTTL: 7 * 24 * time.Hour,
+		},
+		Impersonation: Impersonation{
+			Roles:          []string{"admin"},
+			ProtectedRoles: []string{"admin"},
+			TTL:            15 * time.Minute,
+		},
+		TokenExchange: TokenExchange{
+			TTL: 5 * time.Minute,
+		},
+		Mailer: Mailer{
+			Driver: "log",
+			Port:   587,
+			From:   "no-reply@localhost",
+		},
+		LDAP: LDAP{
+			Timeout:        10 * time.Second,
+			Mode:           "search",
+			UserFilter:     "(mail={login})",
+			SyncFilter:     "(mail=*)",
+			EmailAttribute: "mail",
+			NameAttribute:  "cn",
+			GroupAttribute: "memberOf",
+			DefaultRole:    "user",
+		},
+		SAML: SAML{
+			BaseURL: "http://localhost:8081",
+		},
+		ForwardAuth: ForwardAuth{
+			Proxy:      ProxyTraefik,
+			CookieName: "access_token",
+		},
+		ExtAuthz: ExtAuthz{
+			CookieName: "access_token",
+		},
+		GRPC: GRPC{
+			Addr:           ":50051",
+			MaxRecvMsgSize: 4 << 20,
+			MaxSendMsgSize: 4 << 20,
+			Keepalive: GRPCKeepalive{
+				Time:                2 * time.Minute,
+				Timeout:             20 * time.Second,
+				MinTime:             30 * time.Second,
+				PermitWithoutStream: true,
+			},
+		},
+		Events: Events{
+			PollInterval: time.Second,
+			Retention:    30 * 24 * time.Hour,
+		},
+		Webhooks: Webhooks{
+			PollInterval:   time.Second,
+			Timeout:        10 * time.Second,
+			MaxAttempts:    10,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     6 * time.Hour,
+		},
+		Outbox: Outbox{
+			PollInterval:  500 * time.Millisecond,
+			BatchSize:     100,
+			RetryInterval: 5 * time.Second,
+		},
+		SCIM: SCIM{
+			MaxResults: 200,
+			Roles:      []string{"user"},
+		},
+		Metrics: Metrics{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing: Tracing{
+			Exporter:    "otlp",
+			Endpoint:    "localhost:4317",
+			File:        "traces.json",
+			SampleRatio: 1,
+			ServiceName: "authh2",
+		},
+		Health: Health{
+			CheckTimeout:    2 * time.Second,
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+	}
+}
+
+// MatchRule возвращает первое правило, подходящее под хост, метод и путь запроса.
+func MatchRule(rules []AccessRule, host string, method string, path string) (AccessRule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(host, method, path) {
+			return rule, true
+		}
+	}
+	return AccessRule{}, false
+}
+
+func (r AccessRule) Matches(host string, method string, path string) bool {
+	if !matchPath(r.PathPrefix, path) || !matchHost(r.Host, host) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRole сообщает, разрешен ли доступ пользователю с любой из данных ролей.
+func (r AccessRule) AllowsRole(roles ...string) bool {
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, allowed := range r.Roles {
+		for _, role := range roles {
+			if allowed == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPath(prefix string, path string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// CleanPath приводит путь запроса к виду, по которому проверяются правила доступа:
+// отбрасывает query и fragment, декодирует %XX и схлопывает повторные и "." сегменты.
+// Пути с сегментами "..", закодированными слешами (%2F, %5C), обратными слешами
+// и нулевыми байтами отклоняются: прокси и приложение могут понять их иначе, чем правила.
+func CleanPath(raw string) (string, bool) {
+	if i := strings.IndexAny(raw, "?#"); i != -1 {
+		raw = raw[:i]
+	}
+	lower := strings.ToLower(raw)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(raw)
+	if err != nil || strings.ContainsAny(decoded, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	return path.Clean("/" + decoded), true
+}
+
+func matchHost(pattern string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	switch {
+	case pattern == "" || pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return len(host) > len(pattern)-1 && strings.EqualFold(host[len(host)-len(pattern)+1:], pattern[1:])
+	default:
+		return strings.EqualFold(pattern, host)
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import "testing"
+
+func TestMatchRule(t *testing.T) {
+	rules := []AccessRule{
+		{Host: "*.internal.example.com", Deny: true},
+		{PathPrefix: "/admin", Roles: []string{"admin"}},
+		{Host: "api.example.com", PathPrefix: "/public/", Methods: []string{"GET", "HEAD"}, AllowAnonymous: true},
+		{Host: "api.example.com", PathPrefix: "/reports", Methods: []string{"POST"}, Roles: []string{"manager", "admin"}},
+		{PathPrefix: "/"},
+	}
+	tests := []struct {
+		name   string
+		host   string
+		method string
+		path   string
+		want   int
+	}{
+		{"поддомен под wildcard", "billing.internal.example.com", "GET", "/", 0},
+		{"wildcard без учета регистра", "Billing.INTERNAL.example.com", "GET", "/", 0},
+		{"wildcard с портом", "billing.internal.example.com:8443", "GET", "/", 0},
+		{"wildcard не покрывает сам домен", "internal.example.com", "GET", "/", 4},
+		{"wildcard не покрывает похожий домен", "evilinternal.example.com", "GET", "/", 4},
+		{"префикс пути на любом хосте", "app.example.com", "DELETE", "/admin/users/1", 1},
+		{"префикс пути по границе сегмента", "app.example.com", "GET", "/administrator", 4},
+		{"префикс пути совпадает с путем", "app.example.com", "GET", "/admin", 1},
+		{"префикс со слешем не покрывает путь без него", "api.example.com", "GET", "/public", 4},
+		{"публичный путь", "api.example.com", "GET", "/public/logo.png", 2},
+		{"хост без учета регистра", "API.example.com:443", "head", "/public/logo.png", 2},
+		{"метод не из списка", "api.example.com", "POST", "/public/upload", 4},
+		{"другой хост", "www.example.com", "GET", "/public/logo.png", 4},
+		{"метод из списка", "api.example.com", "POST", "/reports/q3", 3},
+		{"путь чувствителен к регистру", "api.example.com", "GET", "/Admin", 4},
+		{"первое подходящее правило", "app.internal.example.com", "GET", "/admin", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := MatchRule(rules, tt.host, tt.method, tt.path)
+			if !ok {
+				t.Fatal("ни одно правило не подошло")
+			}
+			for i := range rules {
+				if rule.Host == rules[i].Host && rule.PathPrefix == rules[i].PathPrefix {
+					if i != tt.want {
+						t.Fatalf("подошло правило %d, ожидалось %d", i, tt.want)
+					}
+					return
+				}
+			}
+		})
+	}
+
+	if _, ok := MatchRule(rules[:4], "www.example.com", "GET", "/"); ok {
+		t.Fatal("правило подошло к запросу вне всех правил")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"/", "/", true},
+		{"", "/", true},
+		{"/admin/users", "/admin/users", true},
+		{"/admin/users/", "/admin/users", true},
+		{"//admin", "/admin", true},
+		{"/./admin/./users", "/admin/users", true},
+		{"/health?next=/admin", "/health", true},
+		{"/health#x", "/health", true},
+		{"/%61dmin", "/admin", true},
+		{"/public/a%20b", "/public/a b", true},
+		{"admin", "/admin", true},
+		{"/health/../admin", "", false},
+		{"/..", "", false},
+		{"/health/%2e%2e/admin", "", false},
+		{"/health/%2E%2E/admin", "", false},
+		{"/health%2f..%2fadmin", "", false},
+		{"/health%2Fadmin", "", false},
+		{"/health%5c..%5cadmin", "", false},
+		{"/health\\..\\admin", "", false},
+		{"/admin%00", "", false},
+		{"/bad%zz", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := CleanPath(tt.raw)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, %v, ожидалось %q, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAllowsRole(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		roles   []string
+		want    bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"user"}, true},
+		{[]string{"admin"}, []string{"user"}, false},
+		{[]string{"admin"}, []string{"user", "admin"}, true},
+		{[]string{"admin", "manager"}, []string{"manager"}, true},
+		{[]string{"admin"}, nil, false},
+		{[]string{"admin"}, []string{"Admin"}, false},
+	}
+	for _, tt := range tests {
+		if got := (AccessRule{Roles: tt.allowed}).AllowsRole(tt.roles...); got != tt.want {
+			t.Errorf("AllowsRole(%v) при roles %v = %v", tt.roles, tt.allowed, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"os"
+	"strings"
+)
+
+const (
+	TypeOIDC   = "oidc"
+	TypeOAuth2 = "oauth2"
+)
+
+// Identity — профиль пользователя у внешнего провайдера.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Connector — внешний провайдер входа по authorization code flow.
+// state, nonce и verifier (PKCE) генерирует и хранит вызывающая сторона.
+type Connector interface {
+	ID() string
+	Name() string
+	Provisioning() config.Provisioning
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+	Exchange(ctx context.Context, code string, nonce string, verifier string) (Identity, error)
+}
+
+// New создает коннектор по настройкам. Пустой client_secret берется из
+// переменной окружения CONNECTOR_<ID>_CLIENT_SECRET.
+func New(cfg config.Connector) (Connector, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("у коннектора не задан id")
+	}
+	if cfg.ClientSecret == "" {
+		env := "CONNECTOR_" + strings.ToUpper(strings.ReplaceAll(cfg.ID, "-", "_")) + "_CLIENT_SECRET"
+		cfg.ClientSecret = os.Getenv(env)
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.ID
+	}
+
+	switch cfg.Type {
+	case TypeOIDC:
+		if cfg.Issuer == "" {
+			return nil, fmt.Errorf("у коннектора %s не задан issuer", cfg.ID)
+		}
+		return newOIDC(cfg), nil
+	case TypeOAuth2:
+		if cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "" {
+			return nil, fmt.Errorf("у коннектора %s должны быть заданы auth_url, token_url и userinfo_url", cfg.ID)
+		}
+		return newOAuth2(cfg), nil
+	default:
+		return nil, fmt.Errorf("неизвестный тип коннектора %s: %q", cfg.ID, cfg.Type)
+	}
+}
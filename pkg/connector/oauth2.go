@@ -0,0 +1,100 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"golang.org/x/oauth2"
+	"net/http"
+)
+
+// oauth2Connector — провайдер без OIDC: профиль берется из userinfo_url,
+// поля subject, email и name задаются в настройках. Email такого провайдера
+// считается неподтвержденным, если в профиле нет email_verified = true.
+type oauth2Connector struct {
+	cfg    config.Connector
+	config *oauth2.Config
+}
+
+func newOAuth2(cfg config.Connector) *oauth2Connector {
+	if cfg.SubjectField == "" {
+		cfg.SubjectField = "id"
+	}
+	if cfg.EmailField == "" {
+		cfg.EmailField = "email"
+	}
+	if cfg.NameField == "" {
+		cfg.NameField = "name"
+	}
+	return &oauth2Connector{
+		cfg: cfg,
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     oauth2.Endpoint{AuthURL: cfg.AuthURL, TokenURL: cfg.TokenURL},
+			Scopes:       cfg.Scopes,
+		},
+	}
+}
+
+func (c *oauth2Connector) ID() string                        { return c.cfg.ID }
+func (c *oauth2Connector) Name() string                      { return c.cfg.Name }
+func (c *oauth2Connector) Provisioning() config.Provisioning { return c.cfg.Provisioning }
+
+func (c *oauth2Connector) AuthCodeURL(_ context.Context, state string, _ string, verifier string) (string, error) {
+	return c.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (c *oauth2Connector) Exchange(ctx context.Context, code string, _ string, verifier string) (Identity, error) {
+	token, err := c.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("ошибка обмена кода у провайдера %s: %w", c.cfg.ID, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.UserInfoURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.config.Client(ctx, token).Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("ошибка запроса профиля у провайдера %s: %w", c.cfg.ID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("провайдер %s вернул статус %d на запрос профиля", c.cfg.ID, resp.StatusCode)
+	}
+
+	var profile map[string]any
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&profile); err != nil {
+		return Identity{}, fmt.Errorf("ошибка чтения профиля провайдера %s: %w", c.cfg.ID, err)
+	}
+
+	identity := Identity{
+		Subject: field(profile, c.cfg.SubjectField),
+		Email:   field(profile, c.cfg.EmailField),
+		Name:    field(profile, c.cfg.NameField),
+	}
+	identity.EmailVerified, _ = profile["email_verified"].(bool)
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("в профиле провайдера %s нет поля %s", c.cfg.ID, c.cfg.SubjectField)
+	}
+	return identity, nil
+}
+
+// field достает строковое значение поля профиля. Числовые id (например, у GitHub)
+// приводятся к строке.
+func field(profile map[string]any, name string) string {
+	switch v := profile[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,102 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"sync"
+)
+
+// oidcConnector находит эндпоинты провайдера через discovery при первом обращении,
+// чтобы недоступный провайдер не мешал запуску сервиса.
+type oidcConnector struct {
+	cfg config.Connector
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func newOIDC(cfg config.Connector) *oidcConnector {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &oidcConnector{cfg: cfg}
+}
+
+func (c *oidcConnector) ID() string                        { return c.cfg.ID }
+func (c *oidcConnector) Name() string                      { return c.cfg.Name }
+func (c *oidcConnector) Provisioning() config.Provisioning { return c.cfg.Provisioning }
+
+func (c *oidcConnector) discover(ctx context.Context) (*oidc.Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка discovery OIDC провайдера %s: %w", c.cfg.ID, err)
+	}
+	c.provider = provider
+	return provider, nil
+}
+
+func (c *oidcConnector) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		RedirectURL:  c.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.cfg.Scopes,
+	}
+}
+
+func (c *oidcConnector) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (c *oidcConnector) Exchange(ctx context.Context, code string, nonce string, verifier string) (Identity, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	token, err := c.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("ошибка обмена кода у провайдера %s: %w", c.cfg.ID, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("провайдер %s не вернул id_token", c.cfg.ID)
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("невалидный id_token от провайдера %s: %w", c.cfg.ID, err)
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, fmt.Errorf("nonce в id_token не совпадает")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("ошибка чтения id_token: %w", err)
+	}
+	return Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"github.com/LandGAA/authh2/pkg/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// openInstrumented открывает базу через зарегистрированный драйвер driverName так, что
+// каждый запрос попадает в метрики.
+func openInstrumented(driverName string, dsn string) (*sql.DB, error) {
+	// sql.Open не подключается к базе: он нужен только чтобы получить драйвер по имени
+	plain, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := plain.Driver()
+	plain.Close()
+	return sql.OpenDB(&connector{dsn: dsn, driver: drv}), nil
+}
+
+// connector открывает соединения драйвера SQLite, обернутые instrumentedConn.
+type connector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// instrumentedConn замеряет ExecContext и QueryContext — ими database/sql выполняет
+// все запросы репозиториев. Остальные методы передаются соединению драйвера, который
+// их реализует (modernc.org/sqlite).
+type instrumentedConn struct {
+	driver.Conn
+	// txCtx — контекст BeginTx открытой транзакции. tx.Exec и tx.QueryRow передают
+	// драйверу context.Background, поэтому спаны запросов транзакции берут родителя отсюда
+	txCtx context.Context
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	span := c.startSpan(ctx, query)
+	start := time.Now()
+	result, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	metrics.ObserveQuery(query, time.Since(start), err)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	span := c.startSpan(ctx, query)
+	start := time.Now()
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	metrics.ObserveQuery(query, time.Since(start), err)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// startSpan начинает спан запроса внутри трассировки вызывающего. Параметры запроса
+// в спан не попадают.
+func (c *instrumentedConn) startSpan(ctx context.Context, query string) trace.Span {
+	if !trace.SpanContextFromContext(ctx).IsValid() && c.txCtx != nil {
+		ctx = c.txCtx
+	}
+	operation := metrics.QueryOperation(query)
+	_, span := tracing.StartChild(ctx, "sqlite "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperationName(operation), semconv.DBQueryText(query)))
+	return span
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.txCtx = ctx
+	return &instrumentedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+// instrumentedTx сбрасывает контекст транзакции соединения при ее завершении.
+type instrumentedTx struct {
+	driver.Tx
+	conn *instrumentedConn
+}
+
+func (t *instrumentedTx) Commit() error {
+	t.conn.txCtx = nil
+	return t.Tx.Commit()
+}
+
+func (t *instrumentedTx) Rollback() error {
+	t.conn.txCtx = nil
+	return t.Tx.Rollback()
+}
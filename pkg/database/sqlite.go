@@ -1,23 +1,40 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"github.com/LandGAA/authh2/pkg/jwt"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 	"log"
 	_ "modernc.org/sqlite"
+	"os"
 )
 
+// migrationsDir — каталог миграций относительно рабочего каталога сервиса.
+const migrationsDir = "migrations"
+
+const dbFile = "../database.db"
+
 func Connect() *sql.DB {
 	logger.Logger.Debug("SQLite подключается...")
 	jwt.Init()
 
-	db, err := sql.Open("sqlite", "../database.db")
+	if err := Migrate(dbFile, migrationsDir); err != nil {
+		log.Fatalf("ошибка выполнения миграции: %v\n", err)
+	}
+
+	// фоновые задачи (вебхуки, синхронизация LDAP) пишут одновременно с запросами:
+	// busy_timeout заставляет ждать освобождения блокировки вместо SQLITE_BUSY.
+	// foreign_keys включается на каждом соединении пула: без него ON DELETE CASCADE не работает
+	db, err := openInstrumented("sqlite", dbFile+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
 	if err != nil {
 		logger.Logger.Fatal("Ошибка подключения к базе данных!",
 			zap.Error(err),
@@ -32,42 +49,97 @@ func Connect() *sql.DB {
 		db.Close()
 	}
 	logger.Logger.Info("Успешное подключение к SQLite!")
+	metrics.RegisterDB(db)
+	return db
+}
+
+// Migrate применяет миграции из dir к базе file через отдельное соединение с выключенными
+// внешними ключами. Миграции пересоздают таблицы (DROP и RENAME внутри транзакции, где
+// PRAGMA foreign_keys не действует): при включенных ключах DROP TABLE users каскадно
+// удалил бы API ключи, passkeys, участия в организациях и другие зависимые строки.
+func Migrate(file string, dir string) error {
+	db, err := sql.Open("sqlite", file+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(0)")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
 	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
 	if err != nil {
-		log.Fatalf("Ошибка создания драйвера миграции: %v\n", err)
+		return fmt.Errorf("ошибка создания драйвера миграции: %w", err)
 	}
 
 	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
+		"file://"+dir,
 		"sqlite3", driver,
 	)
 	if err != nil {
-		log.Fatalf("ошибка создания миграции: %v\n", err)
+		return fmt.Errorf("ошибка создания миграции: %w", err)
 	}
 
 	version, dirty, err := m.Version()
 	if err != nil && err != migrate.ErrNilVersion {
-		log.Fatalf("ошибка получения версии: %v\n", err)
+		return fmt.Errorf("ошибка получения версии: %w", err)
 	}
 
 	if dirty {
 		log.Println("Обнаружена грязная база данных")
 		err = m.Force(int(version))
 		if err != nil {
-			log.Fatalf("не удалось исправить грязную версию: %v\n", err)
+			return fmt.Errorf("не удалось исправить грязную версию: %w", err)
 		}
 	}
 
 	if err := m.Up(); err != nil {
-		if err == migrate.ErrNoChange {
-			log.Println("нет новых миграций для выполнения")
-		} else {
-			log.Fatalf("ошибка выполнения миграции: %v\n", err)
+		if err != migrate.ErrNoChange {
+			return err
 		}
+		log.Println("нет новых миграций для выполнения")
 	} else {
 		log.Println("миграция выполнена успешно")
 	}
 
-	return db
+	// строки, оставшиеся без пользователя, пока ключи не проверялись, удаляет миграция 016;
+	// остальные нарушения видны в логе
+	var violations int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err == nil && violations > 0 {
+		logger.Logger.Warn("В базе есть строки, нарушающие внешние ключи",
+			zap.Int("count", violations))
+	}
+	return nil
+}
+
+// MigrationState — версия схемы базы и последняя версия в каталоге миграций.
+type MigrationState struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"`
+}
+
+// CheckMigrations сравнивает версию схемы с последней миграцией из каталога. Ошибка —
+// если миграция прервана (dirty) или база отстает от кода.
+func CheckMigrations(ctx context.Context, db *sql.DB) (MigrationState, error) {
+	var state MigrationState
+	entries, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return state, fmt.Errorf("Ошибка чтения каталога миграций: %w", err)
+	}
+	for _, entry := range entries {
+		if m, err := source.Parse(entry.Name()); err == nil && m.Version > state.Latest {
+			state.Latest = m.Version
+		}
+	}
+
+	query := "SELECT version, dirty FROM " + sqlite3.DefaultMigrationsTable + " LIMIT 1"
+	err = db.QueryRowContext(ctx, query).Scan(&state.Version, &state.Dirty)
+	if err != nil {
+		return state, fmt.Errorf("Ошибка получения версии схемы: %w", err)
+	}
+	switch {
+	case state.Dirty:
+		return state, fmt.Errorf("Миграция %d не завершена", state.Version)
+	case state.Version < state.Latest:
+		return state, fmt.Errorf("Схема базы версии %d отстает от миграций (%d)", state.Version, state.Latest)
+	}
+	return state, nil
 }
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+const testMigrations = "../../migrations"
+
+// Пересоздание users в миграции 008 не должно каскадно удалить зависимые строки,
+// а после миграций удаление пользователя — должно.
+func TestMigrateKeepsRowsAndEnablesCascade(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+
+	// база со схемой до организаций: users еще без tenant_id
+	old, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := sqlite3.WithInstance(old, &sqlite3.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+testMigrations, "sqlite3", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(7); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`INSERT INTO users (id, name, email, password, role, create_at) VALUES (2, 'u', 'user@a.com', 'x', 'user', '2026-01-01')`,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, create_at) VALUES (2, 'key', 'p1', 'h', '', 0, '2026-01-01')`,
+		`INSERT INTO user_mfa (user_id, passkey_required) VALUES (2, 1)`,
+		// ключ пользователя, удаленного без каскада
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, create_at) VALUES (99, 'orphan', 'p2', 'h', '', 0, '2026-01-01')`,
+	} {
+		if _, err := old.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	old.Close()
+
+	if err := Migrate(file, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", file+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	count := func(query string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(`SELECT COUNT(*) FROM api_keys WHERE user_id = 2`); n != 1 {
+		t.Fatalf("после миграций у пользователя %d ключей, ожидался 1", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM user_mfa WHERE user_id = 2`); n != 1 {
+		t.Fatalf("после миграций настройки MFA пользователя удалены")
+	}
+	if n := count(`SELECT COUNT(*) FROM api_keys WHERE user_id = 99`); n != 0 {
+		t.Fatalf("ключ без владельца не удален миграцией")
+	}
+	if n := count(`SELECT COUNT(*) FROM pragma_foreign_key_check`); n != 0 {
+		t.Fatalf("после миграций %d нарушений внешних ключей", n)
+	}
+
+	if _, err := db.Exec(`DELETE FROM users WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(`SELECT COUNT(*) FROM api_keys WHERE user_id = 2`) + count(`SELECT COUNT(*) FROM user_mfa WHERE user_id = 2`); n != 0 {
+		t.Fatalf("после удаления пользователя осталось %d зависимых строк", n)
+	}
+
+	// повторный запуск на актуальной базе ничего не меняет
+	if err := Migrate(file, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+}
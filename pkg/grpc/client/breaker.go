@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+type BreakerConfig struct {
+	// FailureThreshold — подряд идущих сбоев до размыкания, по умолчанию 5; отрицательное отключает
+	FailureThreshold int
+	// OpenTimeout — сколько вызовы отклоняются сразу, прежде чем пропустить пробный, по умолчанию 10s
+	OpenTimeout time.Duration
+}
+
+func (b BreakerConfig) withDefaults() BreakerConfig {
+	if b.FailureThreshold == 0 {
+		b.FailureThreshold = 5
+	}
+	if b.OpenTimeout == 0 {
+		b.OpenTimeout = 10 * time.Second
+	}
+	return b
+}
+
+// ErrCircuitOpen возвращается без обращения к серверу, пока breaker разомкнут.
+var ErrCircuitOpen = status.Error(codes.Unavailable, "Сервис авторизации недоступен (circuit breaker разомкнут)")
+
+// breaker размыкается после FailureThreshold сбоев подряд. Через OpenTimeout он
+// пропускает один пробный вызов: успех замыкает его, сбой — снова размыкает.
+type breaker struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	return &breaker{cfg: cfg}
+}
+
+func (b *breaker) interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if b.cfg.FailureThreshold < 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if !b.allow() {
+			return ErrCircuitOpen
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.record(err)
+		return err
+	}
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.cfg.FailureThreshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cfg.OpenTimeout {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isFailure(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.cfg.FailureThreshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// isFailure считает сбоем только недоступность сервера: отказ в доступе
+// или неверный токен означают, что сервер работает.
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// fakeServer отвечает кодами из очереди; пустая очередь — успех.
+type fakeServer struct {
+	codes []codes.Code
+	calls int
+}
+
+func (f *fakeServer) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	f.calls++
+	if len(f.codes) == 0 {
+		return nil
+	}
+	code := f.codes[0]
+	f.codes = f.codes[1:]
+	return status.Error(code, "")
+}
+
+func call(b *breaker, server *fakeServer) error {
+	return b.interceptor()(context.Background(), "/proto.UserService/CheckToken", nil, nil, nil, server.invoke)
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{status.Error(codes.Internal, ""), true},
+		{errors.New("обрыв соединения"), true},
+		{status.Error(codes.Unauthenticated, ""), false},
+		{status.Error(codes.PermissionDenied, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{status.Error(codes.Canceled, ""), false},
+	}
+	for _, tt := range tests {
+		if got := isFailure(tt.err); got != tt.want {
+			t.Errorf("isFailure(%v) = %v, ожидалось %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newBreaker(BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour})
+	server := &fakeServer{codes: []codes.Code{
+		codes.Unavailable, codes.Unavailable, codes.OK,
+		codes.Unavailable, codes.Unauthenticated, codes.Unavailable, codes.Unavailable,
+		codes.Unavailable,
+	}}
+
+	// успех и ответы работающего сервера сбрасывают счетчик
+	for i := 0; i < 7; i++ {
+		call(b, server)
+	}
+	if err := call(b, server); status.Code(err) != codes.Unavailable || server.calls != 8 {
+		t.Fatalf("breaker разомкнулся раньше порога: %v, вызовов %d", err, server.calls)
+	}
+
+	if err := call(b, server); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("ожидалась ErrCircuitOpen, получено %v", err)
+	}
+	if server.calls != 8 {
+		t.Fatalf("разомкнутый breaker обратился к серверу, вызовов %d", server.calls)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		probe    codes.Code
+		reopened bool
+		// calls — обращений к серверу: сбой, пробный вызов и два следующих, если breaker замкнут
+		calls int
+	}{
+		{"успешный пробный вызов замыкает", codes.OK, false, 4},
+		{"ответ работающего сервера замыкает", codes.NotFound, false, 4},
+		{"сбой пробного вызова снова размыкает", codes.Unavailable, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+			server := &fakeServer{codes: []codes.Code{codes.Unavailable, tt.probe}}
+			call(b, server)
+			if err := call(b, server); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("breaker не разомкнулся: %v", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+			if err := call(b, server); status.Code(err) != tt.probe {
+				t.Fatalf("пробный вызов: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				err := call(b, server)
+				if errors.Is(err, ErrCircuitOpen) != tt.reopened {
+					t.Fatalf("после пробного вызова: %v", err)
+				}
+			}
+			if server.calls != tt.calls {
+				t.Fatalf("вызовов сервера %d", server.calls)
+			}
+		})
+	}
+}
+
+// Пока идет пробный вызов, остальные отклоняются сразу.
+func TestBreakerSingleProbe(t *testing.T) {
+	b := newBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	b.record(status.Error(codes.Unavailable, ""))
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("пробный вызов не пропущен")
+	}
+	if b.allow() {
+		t.Fatal("пропущен второй вызов во время пробного")
+	}
+	b.record(nil)
+	if !b.allow() || !b.allow() {
+		t.Fatal("breaker не замкнулся после успешного пробного вызова")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(BreakerConfig{FailureThreshold: -1}.withDefaults())
+	server := &fakeServer{codes: []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable}}
+	for i := 0; i < 4; i++ {
+		if errors.Is(call(b, server), ErrCircuitOpen) {
+			t.Fatal("отключенный breaker разомкнулся")
+		}
+	}
+	if server.calls != 4 {
+		t.Fatalf("вызовов %d", server.calls)
+	}
+}
+
+func TestBreakerDefaults(t *testing.T) {
+	cfg := BreakerConfig{}.withDefaults()
+	if cfg.FailureThreshold != 5 || cfg.OpenTimeout != 10*time.Second {
+		t.Fatalf("значения по умолчанию %+v", cfg)
+	}
+	cfg = BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Second}.withDefaults()
+	if cfg.FailureThreshold != 2 || cfg.OpenTimeout != time.Second {
+		t.Fatalf("заданные значения изменены %+v", cfg)
+	}
+}
@@ -2,36 +2,234 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"os"
+	"strconv"
 	"time"
 )
 
+// Config — подключение к gRPC серверу сервиса авторизации. Нулевые значения
+// заменяются значениями по умолчанию.
+type Config struct {
+	// Addr — адрес сервера, по умолчанию localhost:50051
+	Addr string
+	// Insecure — подключаться без TLS
+	Insecure bool
+	// CAFile — CA сертификата сервера; без него используются системные корневые сертификаты
+	CAFile     string
+	ServerName string
+	// CertFile и KeyFile — клиентский сертификат для mTLS
+	CertFile string
+	KeyFile  string
+
+	// CallTimeout — дедлайн одной попытки вызова, по умолчанию 3s
+	CallTimeout time.Duration
+	Retry       RetryConfig
+	Breaker     BreakerConfig
+
+	// CacheSize — сколько результатов CheckToken хранить (по умолчанию 10000, отрицательное — без кеша)
+	CacheSize int
+	// CacheTTL — сколько доверять закешированному результату, если токен живет дольше.
+	// Ограничивает задержку, с которой клиент узнает об отзыве токена; по умолчанию 30s
+	CacheTTL time.Duration
+
+	// JWKSURL (/.well-known/jwks.json сервиса авторизации) включает локальную проверку
+	// подписи токенов без вызова сервера. Локальная проверка не видит отзыва токенов и
+	// отключения пользователей до конца срока access токена и работает, только если
+	// сервер подписывает токены RS256 (задан JWT_PRIVATE_KEY_FILE): токены HS256
+	// проверяются через CheckToken
+	JWKSURL string
+	// JWKSRefresh — как часто перечитывать JWKS, по умолчанию 10m
+	JWKSRefresh time.Duration
+
+	// Audience — идентификатор сервиса для токенов, выданных обменом (aud)
+	Audience string
+}
+
 type Client struct {
 	Conn pd.UserServiceClient
+	conn *grpc.ClientConn
+
+	cfg     Config
+	cache   *tokenCache
+	breaker *breaker
+	jwks    *jwksVerifier
 }
 
-func NewClient() (Client, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// User — пользователь из проверенного токена.
+type User struct {
+	ID        int
+	Email     string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// NewClient не устанавливает соединение сразу: оно открывается при первом вызове.
+// creds может быть nil, если клиент аутентифицируется сертификатом.
+func NewClient(cfg Config, creds *ClientCredentials) (*Client, error) {
+	cfg = cfg.withDefaults()
+
+	transport, err := cfg.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		cfg:     cfg,
+		breaker: newBreaker(cfg.Breaker),
+	}
+	if cfg.CacheSize > 0 {
+		c.cache = newTokenCache(cfg.CacheSize)
+	}
+	if cfg.JWKSURL != "" {
+		c.jwks = newJWKSVerifier(cfg.JWKSURL, cfg.JWKSRefresh)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transport),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Minute,
+			Timeout:             20 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		// спан на каждую попытку и заголовок traceparent из ctx вызова; трассировщик и
+		// пропагатор берутся из глобальных настроек OpenTelemetry приложения
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		// breaker снаружи retry: серия повторов одного вызова считается одной ошибкой
+		grpc.WithChainUnaryInterceptor(
+			c.breaker.interceptor(),
+			retryInterceptor(cfg.Retry, cfg.CallTimeout),
+		),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
 
-	conn, err := grpc.DialContext(
-		ctx,
-		"localhost:50051",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+	conn, err := grpc.NewClient(cfg.Addr, opts...)
 	if err != nil {
 		logger.Logger.Error("Ошибка подключения клиента к gRPC серверу",
 			zap.Error(err))
-		return Client{}, fmt.Errorf("ошибка подключения к gRPC серверу: %v", err)
+		return nil, fmt.Errorf("ошибка подключения к gRPC серверу: %v", err)
+	}
+
+	c.Conn = pd.NewUserServiceClient(conn)
+	c.conn = conn
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// CheckToken проверяет access токен пользователя: сначала по кешу, затем локально
+// по JWKS (если настроен), иначе вызовом CheckToken на сервере.
+// Невалидный токен — ошибка с кодом Unauthenticated.
+func (c *Client) CheckToken(ctx context.Context, token string) (User, error) {
+	if token == "" {
+		return User{}, status.Error(codes.Unauthenticated, "Не передан токен")
+	}
+	if c.cache != nil {
+		if user, ok := c.cache.get(token); ok {
+			return user, nil
+		}
+	}
+
+	user, err := c.checkToken(ctx, token)
+	if err != nil {
+		return User{}, err
+	}
+
+	if c.cache != nil {
+		expires := time.Now().Add(c.cfg.CacheTTL)
+		if !user.ExpiresAt.IsZero() && user.ExpiresAt.Before(expires) {
+			expires = user.ExpiresAt
+		}
+		c.cache.put(token, user, expires)
+	}
+	return user, nil
+}
+
+func (c *Client) checkToken(ctx context.Context, token string) (User, error) {
+	if c.jwks != nil {
+		user, err := c.jwks.verify(ctx, token, c.cfg.Audience)
+		if err != errUnsupportedAlg {
+			return user, err
+		}
+	}
+
+	resp, err := c.Conn.CheckToken(ctx, &pd.TokenRequest{Access: token, Audience: c.cfg.Audience})
+	if err != nil {
+		return User{}, err
+	}
+	id, err := strconv.Atoi(resp.Id)
+	if err != nil {
+		return User{}, status.Errorf(codes.Internal, "Неверный ID пользователя в ответе: %q", resp.Id)
+	}
+	user := User{ID: id, Email: resp.Email, Role: resp.Role}
+	if resp.ExpiresAt != 0 {
+		user.ExpiresAt = time.Unix(resp.ExpiresAt, 0)
 	}
+	return user, nil
+}
 
-	return Client{
-		Conn: pd.NewUserServiceClient(conn),
-	}, nil
+func (cfg Config) withDefaults() Config {
+	if cfg.Addr == "" {
+		cfg.Addr = "localhost:50051"
+	}
+	if cfg.CallTimeout == 0 {
+		cfg.CallTimeout = 3 * time.Second
+	}
+	if cfg.CacheSize == 0 {
+		cfg.CacheSize = 10000
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = 30 * time.Second
+	}
+	if cfg.JWKSRefresh == 0 {
+		cfg.JWKSRefresh = 10 * time.Minute
+	}
+	cfg.Retry = cfg.Retry.withDefaults()
+	cfg.Breaker = cfg.Breaker.withDefaults()
+	return cfg
+}
+
+func (cfg Config) transportCredentials() (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения CA сервера: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в %s нет сертификатов CA", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка загрузки клиентского сертификата: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
 }
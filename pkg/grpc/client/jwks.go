@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	authjwt "github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// jwksMinRefresh — не чаще этого токен с неизвестным kid заставляет перечитать JWKS.
+const jwksMinRefresh = 30 * time.Second
+
+// asymmetricAlgs — алгоритмы, которые можно проверить открытым ключом из JWKS.
+var asymmetricAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// errUnsupportedAlg — токен нельзя проверить локально, нужен вызов сервера.
+var errUnsupportedAlg = errors.New("алгоритм подписи не поддерживается JWKS")
+
+type jwksVerifier struct {
+	url     string
+	refresh time.Duration
+	http    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWKSVerifier(url string, refresh time.Duration) *jwksVerifier {
+	return &jwksVerifier{url: url, refresh: refresh, http: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// verify проверяет подпись, срок и аудиторию токена. Как и сервер, при заданной
+// audience принимает только токены, выданные для нее, а без нее — только токены без aud.
+func (v *jwksVerifier) verify(ctx context.Context, token string, audience string) (User, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(token, &authjwt.Claims{})
+	if err != nil {
+		return User{}, status.Error(codes.Unauthenticated, "Невалидный токен")
+	}
+	if !slices.Contains(asymmetricAlgs, unverified.Method.Alg()) {
+		return User{}, errUnsupportedAlg
+	}
+
+	claims := &authjwt.Claims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}, jwt.WithValidMethods(asymmetricAlgs), jwt.WithExpirationRequired())
+	if err != nil {
+		return User{}, status.Errorf(codes.Unauthenticated, "Невалидный токен: %v", err)
+	}
+	if !claims.IsUserAccess() {
+		return User{}, status.Error(codes.Unauthenticated, "Токен не является access токеном пользователя")
+	}
+	if audience == "" && len(claims.Audience) > 0 || audience != "" && !slices.Contains(claims.Audience, audience) {
+		return User{}, status.Error(codes.Unauthenticated, "Токен выдан для другой аудитории")
+	}
+
+	return User{
+		ID:        claims.ID,
+		Email:     claims.Email,
+		Role:      claims.Role,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (v *jwksVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stale := time.Since(v.fetchedAt) > v.refresh
+	if _, ok := v.keys[kid]; (!ok && time.Since(v.fetchedAt) > jwksMinRefresh) || stale {
+		keys, err := v.fetch(ctx)
+		if err != nil && v.keys == nil {
+			return nil, err
+		}
+		// при ошибке обновления продолжаем работать с прежними ключами
+		if err == nil {
+			v.keys = keys
+		}
+		v.fetchedAt = time.Now()
+	}
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("ключ %q не найден в JWKS", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *jwksVerifier) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ошибка загрузки JWKS: статус %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("ошибка разбора JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("неизвестная кривая %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %s", k.Kty)
+}
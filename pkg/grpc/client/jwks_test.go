@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/LandGAA/authh2/internal/entity"
+	authjwt "github.com/LandGAA/authh2/pkg/jwt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJWKSVerifier(t *testing.T) {
+	authjwt.SECRET_KEY = []byte("test-secret")
+	hs256, _, err := authjwt.GenerateAccessToken(entity.User{ID: 7, Email: "user@a.com", Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authjwt.SetSigningKey(key)
+	defer authjwt.SetSigningKey(nil)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(authjwt.JWKS())
+	}))
+	defer server.Close()
+	v := newJWKSVerifier(server.URL, time.Minute)
+
+	access, _, err := authjwt.GenerateAccessToken(entity.User{ID: 7, Email: "user@a.com", Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := v.verify(context.Background(), access, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 7 || user.Email != "user@a.com" || user.Role != "user" {
+		t.Fatalf("пользователь %+v", user)
+	}
+	if _, err := v.verify(context.Background(), access, "ledger"); err == nil {
+		t.Fatal("токен без aud принят сервисом с аудиторией")
+	}
+	refresh, _ := authjwt.GenerateRefreshToken(entity.User{ID: 7, Email: "user@a.com"})
+	if _, err := v.verify(context.Background(), refresh, ""); err == nil {
+		t.Fatal("refresh токен принят")
+	}
+	// HS256 проверяет только сервер
+	if _, err := v.verify(context.Background(), hs256, ""); err != errUnsupportedAlg {
+		t.Fatalf("ожидалась errUnsupportedAlg, получено %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("JWKS загружен %d раз", fetches)
+	}
+
+	// токен чужого ключа с неизвестным kid не перечитывает JWKS чаще jwksMinRefresh
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	authjwt.SetSigningKey(other)
+	foreign, _, _ := authjwt.GenerateAccessToken(entity.User{ID: 7, Email: "user@a.com", Role: "user"})
+	if _, err := v.verify(context.Background(), foreign, ""); err == nil {
+		t.Fatal("токен с неизвестным kid принят")
+	}
+	if fetches != 1 {
+		t.Fatalf("JWKS загружен %d раз", fetches)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type userKey struct{}
+
+// WithUser кладет пользователя в контекст; его достает UserFromContext.
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+	return user, ok
+}
+
+// GinMiddleware проверяет токен из заголовка Authorization: Bearer и кладет
+// пользователя в контекст gin ("user", "id", "email", "role") и в контекст запроса.
+func (c *Client) GinMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Не передан токен"})
+			return
+		}
+
+		user, err := c.CheckToken(ctx.Request.Context(), token)
+		if err != nil {
+			if status.Code(err) == codes.Unauthenticated {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Невалидный токен"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Сервис авторизации недоступен"})
+			return
+		}
+
+		ctx.Set("user", user)
+		ctx.Set("id", user.ID)
+		ctx.Set("email", user.Email)
+		ctx.Set("role", user.Role)
+		ctx.Request = ctx.Request.WithContext(WithUser(ctx.Request.Context(), user))
+		ctx.Next()
+	}
+}
+
+// UnaryServerInterceptor проверяет токен пользователя из метаданных authorization
+// входящего gRPC вызова. Методы из skip вызываются без токена.
+func (c *Client) UnaryServerInterceptor(skip ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if slices.Contains(skip, info.FullMethod) {
+			return handler(ctx, req)
+		}
+		user, err := c.userFromMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(WithUser(ctx, user), req)
+	}
+}
+
+func (c *Client) StreamServerInterceptor(skip ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if slices.Contains(skip, info.FullMethod) {
+			return handler(srv, ss)
+		}
+		user, err := c.userFromMetadata(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &userStream{ServerStream: ss, ctx: WithUser(ss.Context(), user)})
+	}
+}
+
+func (c *Client) userFromMetadata(ctx context.Context) (User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return User{}, status.Error(codes.Unauthenticated, "Не передан токен")
+	}
+	user, err := c.CheckToken(ctx, strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil && status.Code(err) != codes.Unauthenticated {
+		return User{}, status.Error(codes.Unavailable, "Сервис авторизации недоступен")
+	}
+	return user, err
+}
+
+type userStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *userStream) Context() context.Context {
+	return s.ctx
+}
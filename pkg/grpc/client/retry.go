@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand/v2"
+	"time"
+)
+
+type RetryConfig struct {
+	// MaxAttempts — попыток вместе с первой, по умолчанию 3; 1 отключает повторы
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = 100 * time.Millisecond
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = 2 * time.Second
+	}
+	return r
+}
+
+// idempotentMethods можно повторять после временной ошибки: они ничего не меняют.
+// Остальные не повторяются: Unavailable приходит и тогда, когда сервер уже выполнил
+// вызов, а соединение оборвалось до ответа. Запросы, не отправленные вовсе, gRPC
+// повторяет сам (transparent retry).
+var idempotentMethods = map[string]bool{
+	"/proto.UserService/CheckToken":     true,
+	"/proto.UserService/GetUserByID":    true,
+	"/proto.UserService/GetUserByEmail": true,
+	"/proto.UserService/ListUsers":      true,
+	"/proto.UserService/BatchGetUsers":  true,
+}
+
+// retryInterceptor ограничивает каждую попытку дедлайном callTimeout и повторяет
+// временные ошибки с экспоненциальной задержкой и случайным разбросом.
+func retryInterceptor(cfg RetryConfig, callTimeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		backoff := cfg.InitialBackoff
+		for attempt := 1; ; attempt++ {
+			callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+			err := invoker(callCtx, method, req, reply, cc, opts...)
+			cancel()
+
+			if err == nil || attempt >= cfg.MaxAttempts || !retryable(method, err) || ctx.Err() != nil {
+				return err
+			}
+
+			delay := backoff/2 + rand.N(backoff/2+1)
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-time.After(delay):
+			}
+			backoff = min(backoff*2, cfg.MaxBackoff)
+		}
+	}
+}
+
+func retryable(method string, err error) bool {
+	if !idempotentMethods[method] {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		method string
+		code   codes.Code
+		want   bool
+	}{
+		{"/proto.UserService/CheckToken", codes.Unavailable, true},
+		{"/proto.UserService/GetUserByID", codes.DeadlineExceeded, true},
+		{"/proto.UserService/ListUsers", codes.ResourceExhausted, true},
+		{"/proto.UserService/BatchGetUsers", codes.Aborted, true},
+		{"/proto.UserService/CheckToken", codes.Unauthenticated, false},
+		{"/proto.UserService/GetUserByEmail", codes.NotFound, false},
+		{"/proto.UserService/Register", codes.Unavailable, false},
+		{"/proto.UserService/Login", codes.Unavailable, false},
+		{"/proto.UserService/Refresh", codes.Unavailable, false},
+		{"/proto.UserService/DeleteUser", codes.DeadlineExceeded, false},
+		{"/proto.UserService/UpdatePassword", codes.Unavailable, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.method, status.Error(tt.code, "")); got != tt.want {
+			t.Errorf("retryable(%s, %v) = %v, ожидалось %v", tt.method, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	tests := []struct {
+		name     string
+		method   string
+		errs     []codes.Code
+		attempts int
+		want     codes.Code
+	}{
+		{"успех с первой попытки", "/proto.UserService/CheckToken", []codes.Code{codes.OK}, 1, codes.OK},
+		{"успех после сбоев", "/proto.UserService/CheckToken", []codes.Code{codes.Unavailable, codes.Unavailable, codes.OK}, 3, codes.OK},
+		{"попытки исчерпаны", "/proto.UserService/GetUserByID", []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable, codes.OK}, 3, codes.Unavailable},
+		{"неповторяемая ошибка", "/proto.UserService/CheckToken", []codes.Code{codes.Unauthenticated, codes.OK}, 1, codes.Unauthenticated},
+		{"изменяющий метод не повторяется", "/proto.UserService/Register", []codes.Code{codes.Unavailable, codes.OK}, 1, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("попытка без дедлайна")
+				}
+				code := tt.errs[attempts]
+				attempts++
+				if code == codes.OK {
+					return nil
+				}
+				return status.Error(code, "")
+			}
+			err := retryInterceptor(cfg, time.Second)(context.Background(), tt.method, nil, nil, nil, invoker)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("код %v, ожидался %v", got, tt.want)
+			}
+			if attempts != tt.attempts {
+				t.Fatalf("попыток %d, ожидалось %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
@@ -2,42 +2,177 @@ package methods
 
 import (
 	"context"
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
 	"github.com/LandGAA/authh2/internal/usecase"
 	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
 	"github.com/LandGAA/authh2/pkg/jwt"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"strconv"
+	"time"
 )
 
+// UserServiceServer работает с глобальными пользователями: организации в gRPC API не выбираются.
 type UserServiceServer struct {
 	pd.UnimplementedUserServiceServer
 	UU usecase.UseCase
+	TU usecase.TokenUseCase
+	WU usecase.WebAuthnUseCase
+	IU usecase.InviteUseCase
+	EU usecase.UserEventUseCase
+	// PollInterval — как часто WatchUserEvents проверяет новые события
+	PollInterval time.Duration
+}
+
+// users возвращает use case пользователей, привязанный к трассировке вызова.
+func (s *UserServiceServer) users(ctx context.Context) usecase.UseCase {
+	return s.UU.WithContext(ctx)
 }
 
 func (s *UserServiceServer) CheckToken(ctx context.Context, req *pd.TokenRequest) (*pd.UserResponse, error) {
 	logger.Logger.Info("Получен запрос на обновление токена от Forum")
 
-	claims, err := jwt.ValidateToken(req.Access)
+	// "*" отключает проверку aud и оставлен для интроспекции внутри сервиса: клиент
+	// проверяет только токены без aud или выданные для него
+	if req.Audience == jwt.AnyAudience {
+		return nil, status.Error(codes.InvalidArgument, "Аудитория * недоступна клиентам")
+	}
+	var audience []string
+	if req.Audience != "" {
+		audience = append(audience, req.Audience)
+	}
+	claims, err := jwt.ValidateToken(req.Access, audience...)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !claims.IsUserAccess() {
+		return nil, status.Error(codes.Unauthenticated, "Токен не является access токеном пользователя")
 	}
 
-	return &pd.UserResponse{
+	resp := &pd.UserResponse{
 		Id:    strconv.Itoa(claims.ID),
 		Role:  claims.Role,
 		Email: claims.Email,
-	}, nil
+	}
+	if claims.ExpiresAt != nil {
+		resp.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	return resp, nil
 }
 
 func (s *UserServiceServer) GetUserByID(ctx context.Context, req *pd.IDRequest) (*pd.UserResponse, error) {
-	user, err := s.UU.GetUserByID(int(req.Id))
+	user, err := s.users(ctx).GetUserByID(int(req.Id))
+	if err != nil {
+		return nil, statusError("GetUserByID", err)
+	}
+	return userResponse(user), nil
+}
+
+func (s *UserServiceServer) Register(ctx context.Context, req *pd.RegisterRequest) (resp *pd.UserResponse, err error) {
+	defer func() { metrics.Registrations.WithLabelValues(metrics.GRPCOutcome(status.Code(err))).Inc() }()
+	if req.Name == "" || req.Email == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "Нужны имя, email и пароль")
+	}
+	if err := s.IU.CheckRegistration(req.Email); err != nil {
+		return nil, statusError("Register", err)
+	}
+	if _, err := s.users(ctx).GetUserByEmail(req.Email); err == nil {
+		return nil, status.Error(codes.AlreadyExists, "Пользователь с таким email уже зарегистрирован")
+	}
+
+	err = s.users(ctx).CreateUser(entity.User{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+		Role:     "user",
+	})
+	if err != nil {
+		return nil, statusError("Register", err)
+	}
+	user, err := s.users(ctx).GetUserByEmail(req.Email)
+	if err != nil {
+		return nil, statusError("Register", err)
+	}
+	logger.Logger.Info("Пользователь зарегистрирован через gRPC",
+		zap.Int("user_id", user.ID))
+	return userResponse(user), nil
+}
+
+// Login не выдает токены пользователям с обязательным passkey: второй фактор
+// проходится только через HTTP API.
+func (s *UserServiceServer) Login(ctx context.Context, req *pd.LoginRequest) (*pd.TokenResponse, error) {
+	if req.Email == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "Нужны email и пароль")
+	}
+
+	access, refresh, expiresIn, err := s.users(ctx).Authenticate(req.Email, req.Password)
+	// неизвестный email и неверный пароль неразличимы, чтобы по ответу нельзя было
+	// перебирать зарегистрированные адреса
+	if errors.Is(err, usecase.ErrorUnknownUser) || errors.Is(err, usecase.ErrorWrongPassword) {
+		return nil, status.Error(codes.Unauthenticated, "Неверный email или пароль")
+	}
+	if err != nil {
+		return nil, statusError("Login", err)
+	}
+	user, err := s.users(ctx).GetUserByEmail(req.Email)
 	if err != nil {
-		return nil, err
+		return nil, statusError("Login", err)
+	}
+	if s.WU.PasskeyRequired(user.ID) {
+		return nil, status.Error(codes.FailedPrecondition, "Требуется второй фактор, войдите через HTTP API")
+	}
+
+	return &pd.TokenResponse{
+		Access:    access,
+		Refresh:   refresh,
+		ExpiresIn: expiresIn,
+		UserId:    int64(user.ID),
+		Role:      user.Role,
+	}, nil
+}
+
+// Refresh выдает новую пару токенов и отзывает переданный refresh токен.
+func (s *UserServiceServer) Refresh(ctx context.Context, req *pd.RefreshRequest) (resp *pd.TokenResponse, err error) {
+	defer func() { metrics.Refreshes.WithLabelValues(metrics.GRPCOutcome(status.Code(err))).Inc() }()
+	user, access, refresh, expiresIn, err := s.TU.Refresh(req.Refresh)
+	switch {
+	case errors.Is(err, usecase.ErrorInvalidRefresh):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, usecase.ErrorUnknownUser):
+		return nil, status.Error(codes.Unauthenticated, "Пользователь не найден")
+	case err != nil:
+		return nil, statusError("Refresh", err)
+	}
+
+	return &pd.TokenResponse{
+		Access:    access,
+		Refresh:   refresh,
+		ExpiresIn: expiresIn,
+		UserId:    int64(user.ID),
+		Role:      user.Role,
+	}, nil
+}
+
+func (s *UserServiceServer) Logout(ctx context.Context, req *pd.LogoutRequest) (*emptypb.Empty, error) {
+	if req.Access == "" && req.Refresh == "" {
+		return nil, status.Error(codes.InvalidArgument, "Не переданы токены")
 	}
+	if err := s.TU.Logout(req.Access, req.Refresh); err != nil {
+		return nil, statusError("Logout", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func userResponse(user entity.User) *pd.UserResponse {
 	return &pd.UserResponse{
 		Id:    strconv.Itoa(user.ID),
 		Role:  user.Role,
 		Email: user.Email,
 		Name:  user.Name,
-	}, nil
+	}
 }
@@ -0,0 +1,85 @@
+package methods
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/internal/usecase"
+	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestCheckTokenAudience(t *testing.T) {
+	user := &jwt.Claims{ID: 2, Email: "user@a.com", Role: "user"}
+	plain, _, err := jwt.GenerateAccessToken(entity.User{ID: 2, Email: "user@a.com", Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forum, _, err := jwt.GenerateExchangedToken(user, "gateway", "forum", nil, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		want     codes.Code
+	}{
+		{"токен без aud без аудитории", plain, "", codes.OK},
+		{"токен сервиса его аудиторией", forum, "forum", codes.OK},
+		{"токен сервиса без аудитории", forum, "", codes.Unauthenticated},
+		{"токен сервиса чужой аудиторией", forum, "billing", codes.Unauthenticated},
+		{"любая аудитория", forum, jwt.AnyAudience, codes.InvalidArgument},
+		{"любая аудитория для токена без aud", plain, jwt.AnyAudience, codes.InvalidArgument},
+	}
+	s := &UserServiceServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CheckToken(context.Background(), &pd.TokenRequest{Access: tt.token, Audience: tt.audience})
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("код %v, ожидался %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+// loginUsers отвечает на Authenticate заданной ошибкой.
+type loginUsers struct {
+	usecase.UseCase
+	err error
+}
+
+func (l loginUsers) WithContext(context.Context) usecase.UseCase { return l }
+
+func (l loginUsers) Authenticate(string, string) (string, string, int64, error) {
+	return "", "", 0, l.err
+}
+
+// Неизвестный email и неверный пароль дают одинаковый ответ.
+func TestLoginDoesNotRevealUnknownEmail(t *testing.T) {
+	var messages []string
+	for _, err := range []error{usecase.ErrorUnknownUser, usecase.ErrorWrongPassword} {
+		s := &UserServiceServer{UU: loginUsers{err: err}}
+		_, err := s.Login(context.Background(), &pd.LoginRequest{Email: "user@a.com", Password: "password"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("код %v, ожидался Unauthenticated", status.Code(err))
+		}
+		messages = append(messages, status.Convert(err).Message())
+	}
+	if messages[0] != messages[1] {
+		t.Fatalf("ответы различаются: %q и %q", messages[0], messages[1])
+	}
+}
+
+func TestStatusErrorUserExists(t *testing.T) {
+	err := statusError("Register", fmt.Errorf("создание: %w", repository.ErrUserExists))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("код %v, ожидался AlreadyExists", status.Code(err))
+	}
+}
@@ -0,0 +1,34 @@
+package methods
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LandGAA/authh2/internal/repository"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError переводит ошибку use case в статус gRPC. Неизвестные ошибки логируются
+// и возвращаются как Internal без подробностей.
+func statusError(method string, err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, usecase.ErrorUnknownUser):
+		return status.Error(codes.NotFound, "Пользователь не найден")
+	case errors.Is(err, usecase.ErrorWrongPassword):
+		return status.Error(codes.Unauthenticated, "Неверный email или пароль")
+	case errors.Is(err, usecase.ErrorEventCursorExpired):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, usecase.ErrorRegistrationClosed), errors.Is(err, repository.ErrGlobalAccount):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, repository.ErrUserExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	logger.Logger.Error("Ошибка gRPC метода",
+		zap.String("method", method),
+		zap.Error(err))
+	return status.Error(codes.Internal, "Внутренняя ошибка сервера")
+}
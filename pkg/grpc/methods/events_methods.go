@@ -0,0 +1,79 @@
+package methods
+
+import (
+	"github.com/LandGAA/authh2/internal/entity"
+	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"slices"
+	"time"
+)
+
+const eventsBatchSize = 100
+
+// WatchUserEvents отдает события пользователей после after_cursor и дальше держит поток
+// открытым, опрашивая таблицу событий. Если события после cursor уже удалены по сроку
+// хранения, поток завершается с OUT_OF_RANGE: потребитель должен перечитать пользователей
+// и переподключиться с from_latest.
+func (s *UserServiceServer) WatchUserEvents(req *pd.WatchUserEventsRequest, stream grpc.ServerStreamingServer[pd.UserEvent]) error {
+	for _, t := range req.Types {
+		if !slices.Contains(entity.UserEventTypes, t) {
+			return status.Errorf(codes.InvalidArgument, "Неизвестный тип события %q", t)
+		}
+	}
+	if req.AfterCursor < 0 {
+		return status.Error(codes.InvalidArgument, "after_cursor не может быть отрицательным")
+	}
+
+	cursor := req.AfterCursor
+	if req.FromLatest {
+		latest, err := s.EU.LatestCursor()
+		if err != nil {
+			return statusError("WatchUserEvents", err)
+		}
+		cursor = latest
+	}
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		events, err := s.EU.Events(cursor, eventsBatchSize)
+		if err != nil {
+			return statusError("WatchUserEvents", err)
+		}
+		for _, e := range events {
+			cursor = e.ID
+			if len(req.Types) > 0 && !slices.Contains(req.Types, e.Type) {
+				continue
+			}
+			if err := stream.Send(userEventResponse(e)); err != nil {
+				return err
+			}
+		}
+		// полная пачка — возможно, есть еще события, читаем сразу
+		if len(events) == eventsBatchSize {
+			continue
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func userEventResponse(e entity.UserEvent) *pd.UserEvent {
+	return &pd.UserEvent{
+		Cursor:         e.ID,
+		Type:           e.Type,
+		UserId:         int64(e.UserID),
+		Email:          e.Email,
+		Name:           e.Name,
+		Role:           e.Role,
+		Tenant:         e.Tenant,
+		CreateAt:       e.CreateAt,
+		IdempotencyKey: e.IdempotencyKey,
+	}
+}
@@ -0,0 +1,150 @@
+package methods
+
+import (
+	"context"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// identityHeaders — заголовки с данными пользователя, которые сервис передает за прокси.
+// Клиент не должен прислать их сам: на каждом разрешенном запросе они либо
+// перезаписываются, либо удаляются.
+var identityHeaders = []string{"x-user-id", "x-user-email", "x-user-role"}
+
+// AuthorizationServer реализует envoy.service.auth.v3.Authorization (ext_authz).
+type AuthorizationServer struct {
+	authv3.UnimplementedAuthorizationServer
+	UU  usecase.UseCase
+	Cfg config.ExtAuthz
+}
+
+// Check проверяет запрос так же, как forward-auth: путь нормализуется config.CleanPath,
+// роли берутся из токена (у токена организации это роли в организации), а на путях
+// с allow_anonymous отсутствующий или невалидный токен не мешает анонимному доступу.
+func (s *AuthorizationServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	host, method := httpReq.GetHost(), httpReq.GetMethod()
+	path, ok := config.CleanPath(httpReq.GetPath())
+	if !ok {
+		logger.Logger.Warn("ext_authz: некорректный путь запроса",
+			zap.String("host", host),
+			zap.String("path", httpReq.GetPath()))
+		return denied(code.Code_INVALID_ARGUMENT, http.StatusBadRequest, "Некорректный путь запроса"), nil
+	}
+
+	rule, matched := config.MatchRule(s.Cfg.Rules, host, method, path)
+	if matched && rule.Deny {
+		return denied(code.Code_PERMISSION_DENIED, http.StatusForbidden, "Доступ запрещен"), nil
+	}
+
+	claims, user, message := s.authenticate(ctx, httpReq.GetHeaders())
+	if claims == nil {
+		if matched && rule.AllowAnonymous {
+			return allowed(nil), nil
+		}
+		return denied(code.Code_UNAUTHENTICATED, http.StatusUnauthorized, message), nil
+	}
+
+	if matched && !rule.AllowsRole(claims.EffectiveRoles()...) {
+		logger.Logger.Warn("ext_authz: недостаточно прав",
+			zap.String("host", host),
+			zap.String("method", method),
+			zap.String("path", path),
+			zap.String("email", user.Email),
+			zap.String("tenant", claims.Tenant),
+			zap.String("role", claims.Role))
+		return denied(code.Code_PERMISSION_DENIED, http.StatusForbidden, "Недостаточно прав"), nil
+	}
+
+	return allowed(map[string]string{
+		"x-user-id":    strconv.Itoa(user.ID),
+		"x-user-email": user.Email,
+		"x-user-role":  claims.Role,
+	}), nil
+}
+
+// authenticate проверяет токен запроса и наличие пользователя. Без пользователя
+// возвращает nil и сообщение для ответа 401.
+func (s *AuthorizationServer) authenticate(ctx context.Context, headers map[string]string) (*jwt.Claims, entity.User, string) {
+	token := requestToken(headers, s.Cfg.CookieName)
+	if token == "" {
+		return nil, entity.User{}, "Не аутентифицирован"
+	}
+	claims, err := jwt.ValidateToken(token)
+	if err != nil || !claims.IsUserAccess() {
+		logger.Logger.Warn("ext_authz: невалидный токен", zap.Error(err))
+		return nil, entity.User{}, "Невалидный токен"
+	}
+	user, err := s.UU.WithContext(ctx).GetUserByID(claims.ID)
+	if err != nil {
+		logger.Logger.Warn("ext_authz: пользователь токена не найден",
+			zap.Int("id", claims.ID),
+			zap.Error(err))
+		return nil, entity.User{}, "Пользователь не найден"
+	}
+	return claims, user, ""
+}
+
+func requestToken(headers map[string]string, cookieName string) string {
+	if token := strings.TrimPrefix(headers["authorization"], "Bearer "); token != "" {
+		return token
+	}
+	if cookieName == "" || headers["cookie"] == "" {
+		return ""
+	}
+
+	req := http.Request{Header: http.Header{"Cookie": {headers["cookie"]}}}
+	if cookie, err := req.Cookie(cookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// allowed разрешает запрос и передает headers за прокси, заменяя значения клиента.
+// Заголовки пользователя, которых нет в headers, удаляются: Envoy применяет
+// headers_to_remove после установки заголовков, поэтому выставленные сюда не попадают.
+func allowed(headers map[string]string) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{}
+	for _, key := range identityHeaders {
+		value, set := headers[key]
+		if !set {
+			ok.HeadersToRemove = append(ok.HeadersToRemove, key)
+			continue
+		}
+		ok.Headers = append(ok.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: key, Value: value},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(code.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+func denied(grpcCode code.Code, httpCode int, message string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(grpcCode), Message: message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode(httpCode)},
+				Headers: []*corev3.HeaderValueOption{{
+					Header: &corev3.HeaderValue{Key: "content-type", Value: "application/json"},
+				}},
+				Body: `{"error":"` + message + `"}`,
+			},
+		},
+	}
+}
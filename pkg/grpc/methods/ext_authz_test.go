@@ -0,0 +1,222 @@
+package methods
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	jwt.SECRET_KEY = []byte("test-secret")
+	os.Exit(m.Run())
+}
+
+// stubUsers отдает пользователей из памяти; остальные методы в Check не вызываются.
+type stubUsers struct {
+	usecase.UseCase
+	users map[int]entity.User
+}
+
+func (s stubUsers) WithContext(context.Context) usecase.UseCase { return s }
+
+func (s stubUsers) GetUserByID(id int) (entity.User, error) {
+	if user, ok := s.users[id]; ok {
+		return user, nil
+	}
+	return entity.User{}, fmt.Errorf("пользователь %d не найден", id)
+}
+
+func newAuthorizationServer() *AuthorizationServer {
+	return &AuthorizationServer{
+		UU: stubUsers{users: map[int]entity.User{
+			1: {ID: 1, Email: "admin@a.com", Role: "admin"},
+			2: {ID: 2, Email: "user@a.com", Role: "user"},
+		}},
+		Cfg: config.ExtAuthz{
+			CookieName: "access_token",
+			Rules: []config.AccessRule{
+				{Host: "internal.example.com", Deny: true},
+				{PathPrefix: "/public", AllowAnonymous: true},
+				{PathPrefix: "/admin", Roles: []string{"admin"}},
+				{Host: "*.example.com", PathPrefix: "/api", Methods: []string{"GET"}},
+				{Host: "*.example.com", PathPrefix: "/api", Roles: []string{"admin"}},
+			},
+		},
+	}
+}
+
+func checkRequest(host, method, path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Host: host, Method: method, Path: path, Headers: headers,
+		}},
+	}}
+}
+
+func bearer(t *testing.T, user entity.User) map[string]string {
+	t.Helper()
+	token, _, err := jwt.GenerateAccessToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"authorization": "Bearer " + token}
+}
+
+func TestCheckRules(t *testing.T) {
+	admin := entity.User{ID: 1, Email: "admin@a.com", Role: "admin"}
+	user := entity.User{ID: 2, Email: "user@a.com", Role: "user"}
+	userToken := bearer(t, user)
+	refresh, _ := jwt.GenerateRefreshToken(user)
+
+	tests := []struct {
+		name    string
+		host    string
+		method  string
+		path    string
+		headers map[string]string
+		want    code.Code
+	}{
+		{"запрещенный хост", "internal.example.com:8080", "GET", "/public", nil, code.Code_PERMISSION_DENIED},
+		{"анонимный доступ", "app.local", "GET", "/public/index.html", nil, code.Code_OK},
+		{"анонимный доступ с query", "app.local", "GET", "/public?x=/admin", nil, code.Code_OK},
+		{"без токена", "app.local", "GET", "/private", nil, code.Code_UNAUTHENTICATED},
+		{"без правила с токеном", "app.local", "GET", "/private", userToken, code.Code_OK},
+		{"роль не подходит", "app.local", "GET", "/admin/users", userToken, code.Code_PERMISSION_DENIED},
+		{"роль подходит", "app.local", "GET", "/admin/users", bearer(t, admin), code.Code_OK},
+		{"метод разрешен всем", "api.example.com", "get", "/api/items", userToken, code.Code_OK},
+		{"другой метод — следующее правило", "api.example.com", "POST", "/api/items", userToken, code.Code_PERMISSION_DENIED},
+		{"хост не совпал с маской", "example.com", "POST", "/api/items", userToken, code.Code_OK},
+		{"токен из cookie", "app.local", "GET", "/admin", map[string]string{"cookie": "a=b; access_token=" + userToken["authorization"][7:]}, code.Code_PERMISSION_DENIED},
+		{"refresh токен", "app.local", "GET", "/private", map[string]string{"authorization": "Bearer " + refresh}, code.Code_UNAUTHENTICATED},
+		{"невалидный токен", "app.local", "GET", "/private", map[string]string{"authorization": "Bearer x.y.z"}, code.Code_UNAUTHENTICATED},
+		{"невалидный токен на анонимном пути", "app.local", "GET", "/public/index.html", map[string]string{"authorization": "Bearer x.y.z"}, code.Code_OK},
+		{"удаленный пользователь на анонимном пути", "app.local", "GET", "/public", bearer(t, entity.User{ID: 9, Email: "gone@a.com", Role: "admin"}), code.Code_OK},
+		{"удаленный пользователь", "app.local", "GET", "/private", bearer(t, entity.User{ID: 9, Email: "gone@a.com", Role: "admin"}), code.Code_UNAUTHENTICATED},
+		{"выход из анонимного пути через ..", "app.local", "GET", "/public/../admin", nil, code.Code_INVALID_ARGUMENT},
+		{"закодированный ..", "app.local", "GET", "/public/%2e%2e/admin", nil, code.Code_INVALID_ARGUMENT},
+		{"закодированный слеш", "app.local", "GET", "/public%2F..%2Fadmin", nil, code.Code_INVALID_ARGUMENT},
+		{"префикс по границе сегмента", "app.local", "GET", "/publicity", nil, code.Code_UNAUTHENTICATED},
+		{"путь /administrator не под /admin", "app.local", "GET", "/administrator", userToken, code.Code_OK},
+		{"двойной слеш нормализуется", "app.local", "GET", "//admin/users", userToken, code.Code_PERMISSION_DENIED},
+		{"закодированная буква нормализуется", "app.local", "GET", "/%61dmin", userToken, code.Code_PERMISSION_DENIED},
+		{"глобальный админ с токеном организации", "app.local", "GET", "/admin", bearer(t, entity.User{ID: 1, Email: "admin@a.com", Role: "user", Tenant: "acme"}), code.Code_PERMISSION_DENIED},
+		{"админ организации", "app.local", "GET", "/admin", bearer(t, entity.User{ID: 2, Email: "user@a.com", Role: "admin", Tenant: "acme"}), code.Code_OK},
+	}
+	s := newAuthorizationServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Check(context.Background(), checkRequest(tt.host, tt.method, tt.path, tt.headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := code.Code(resp.GetStatus().GetCode()); got != tt.want {
+				t.Fatalf("код %v, ожидался %v (%s)", got, tt.want, resp.GetStatus().GetMessage())
+			}
+		})
+	}
+}
+
+// spoofed — заголовки пользователя, которые клиент пытается передать за прокси сам.
+var spoofed = map[string]string{"x-user-id": "1", "x-user-email": "admin@a.com", "x-user-role": "admin"}
+
+func TestCheckAnonymousRemovesIdentityHeaders(t *testing.T) {
+	resp, err := newAuthorizationServer().Check(context.Background(), checkRequest("app.local", "GET", "/public", spoofed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("запрос не разрешен: %v", resp.GetStatus())
+	}
+	for _, header := range identityHeaders {
+		if !slices.Contains(ok.GetHeadersToRemove(), header) {
+			t.Errorf("%s не удаляется", header)
+		}
+	}
+	if len(ok.GetHeaders()) != 0 {
+		t.Errorf("анонимному запросу выставлены заголовки: %v", ok.GetHeaders())
+	}
+}
+
+func TestCheckInvalidTokenOnAnonymousPathRemovesIdentityHeaders(t *testing.T) {
+	headers := map[string]string{"authorization": "Bearer x.y.z"}
+	for k, v := range spoofed {
+		headers[k] = v
+	}
+	resp, err := newAuthorizationServer().Check(context.Background(), checkRequest("app.local", "GET", "/public", headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("запрос не разрешен: %v", resp.GetStatus())
+	}
+	if len(ok.GetHeaders()) != 0 || len(ok.GetHeadersToRemove()) != len(identityHeaders) {
+		t.Fatalf("заголовки пользователя не удалены: %v, %v", ok.GetHeaders(), ok.GetHeadersToRemove())
+	}
+}
+
+// Роль в заголовке — роль из токена: у токена организации это роль в организации.
+func TestCheckTenantRoleHeader(t *testing.T) {
+	headers := bearer(t, entity.User{ID: 2, Email: "user@a.com", Role: "admin", Tenant: "acme"})
+	resp, err := newAuthorizationServer().Check(context.Background(), checkRequest("app.local", "GET", "/private", headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("запрос не разрешен: %v", resp.GetStatus())
+	}
+	for _, h := range ok.GetHeaders() {
+		if h.GetHeader().GetKey() == "x-user-role" && h.GetHeader().GetValue() != "admin" {
+			t.Fatalf("x-user-role = %q, ожидалась роль в организации", h.GetHeader().GetValue())
+		}
+	}
+}
+
+func TestCheckAuthenticatedOverwritesIdentityHeaders(t *testing.T) {
+	headers := bearer(t, entity.User{ID: 2, Email: "user@a.com", Role: "user"})
+	for k, v := range spoofed {
+		headers[k] = v
+	}
+	resp, err := newAuthorizationServer().Check(context.Background(), checkRequest("app.local", "GET", "/private", headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("запрос не разрешен: %v", resp.GetStatus())
+	}
+
+	want := map[string]string{"x-user-id": "2", "x-user-email": "user@a.com", "x-user-role": "user"}
+	got := map[string]string{}
+	for _, h := range ok.GetHeaders() {
+		if h.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			t.Errorf("%s дописывается к значению клиента", h.GetHeader().GetKey())
+		}
+		got[h.GetHeader().GetKey()] = h.GetHeader().GetValue()
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, ожидалось %q", k, got[k], v)
+		}
+	}
+	// Envoy удаляет headers_to_remove после установки: выставленные заголовки не удаляются
+	for _, header := range ok.GetHeadersToRemove() {
+		if _, set := want[header]; set {
+			t.Errorf("%s одновременно выставляется и удаляется", header)
+		}
+	}
+}
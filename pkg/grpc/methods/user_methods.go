@@ -0,0 +1,117 @@
+package methods
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	maxBatchSize    = 100
+)
+
+func (s *UserServiceServer) GetUserByEmail(ctx context.Context, req *pd.EmailRequest) (*pd.UserResponse, error) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "Не передан email")
+	}
+	user, err := s.users(ctx).GetUserByEmail(req.Email)
+	if err != nil {
+		return nil, statusError("GetUserByEmail", err)
+	}
+	return userResponse(user), nil
+}
+
+// ListUsers отдает пользователей страницами; next_page_token пуст на последней странице.
+func (s *UserServiceServer) ListUsers(ctx context.Context, req *pd.ListUsersRequest) (*pd.ListUsersResponse, error) {
+	size := int(req.PageSize)
+	switch {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "page_size не может быть отрицательным")
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+	offset := 0
+	if req.PageToken != "" {
+		var err error
+		offset, err = strconv.Atoi(req.PageToken)
+		if err != nil || offset < 0 {
+			return nil, status.Error(codes.InvalidArgument, "Неверный page_token")
+		}
+	}
+
+	users, err := s.users(ctx).GetAllUsers()
+	if err != nil {
+		return nil, statusError("ListUsers", err)
+	}
+
+	resp := &pd.ListUsersResponse{Users: []*pd.UserResponse{}}
+	end := min(offset+size, len(users))
+	for i := offset; i < end; i++ {
+		resp.Users = append(resp.Users, userResponse(users[i]))
+	}
+	if end < len(users) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+func (s *UserServiceServer) BatchGetUsers(ctx context.Context, req *pd.BatchGetUsersRequest) (*pd.BatchGetUsersResponse, error) {
+	if len(req.Ids) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "Не больше %d ID за запрос", maxBatchSize)
+	}
+
+	resp := &pd.BatchGetUsersResponse{Users: []*pd.UserResponse{}}
+	for _, id := range req.Ids {
+		user, err := s.users(ctx).GetUserByID(int(id))
+		if errors.Is(err, sql.ErrNoRows) {
+			resp.MissingIds = append(resp.MissingIds, id)
+			continue
+		}
+		if err != nil {
+			return nil, statusError("BatchGetUsers", err)
+		}
+		resp.Users = append(resp.Users, userResponse(user))
+	}
+	return resp, nil
+}
+
+func (s *UserServiceServer) UpdatePassword(ctx context.Context, req *pd.UpdatePasswordRequest) (*emptypb.Empty, error) {
+	if req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "Не передан пароль")
+	}
+	user, err := s.users(ctx).GetUserByID(int(req.Id))
+	if err != nil {
+		return nil, statusError("UpdatePassword", err)
+	}
+
+	user.Password = req.Password
+	if err := s.users(ctx).UpdatePassword(user); err != nil {
+		return nil, statusError("UpdatePassword", err)
+	}
+	logger.Logger.Info("Пароль изменен через gRPC",
+		zap.Int("user_id", user.ID))
+	return &emptypb.Empty{}, nil
+}
+
+func (s *UserServiceServer) DeleteUser(ctx context.Context, req *pd.IDRequest) (*emptypb.Empty, error) {
+	if _, err := s.users(ctx).GetUserByID(int(req.Id)); err != nil {
+		return nil, statusError("DeleteUser", err)
+	}
+	if err := s.users(ctx).DeleteUser(int(req.Id)); err != nil {
+		return nil, statusError("DeleteUser", err)
+	}
+	logger.Logger.Info("Пользователь удален через gRPC",
+		zap.Int64("user_id", req.Id))
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// methodScopes — scope, которые должен иметь сервисный аккаунт для вызова метода.
+var methodScopes = map[string]string{
+	"/proto.UserService/CheckToken":      jwt.ScopeTokenCheck,
+	"/proto.UserService/GetUserByID":     jwt.ScopeUsersRead,
+	"/proto.UserService/GetUserByEmail":  jwt.ScopeUsersRead,
+	"/proto.UserService/ListUsers":       jwt.ScopeUsersRead,
+	"/proto.UserService/BatchGetUsers":   jwt.ScopeUsersRead,
+	"/proto.UserService/Register":        jwt.ScopeUsersLogin,
+	"/proto.UserService/Login":           jwt.ScopeUsersLogin,
+	"/proto.UserService/Refresh":         jwt.ScopeUsersLogin,
+	"/proto.UserService/Logout":          jwt.ScopeUsersLogin,
+	"/proto.UserService/UpdatePassword":  jwt.ScopeUsersWrite,
+	"/proto.UserService/DeleteUser":      jwt.ScopeUsersWrite,
+	"/proto.UserService/WatchUserEvents": jwt.ScopeUsersRead,
+}
+
+// publicMethods вызываются без токена сервисного аккаунта: Envoy ext_authz
+// передает токен пользователя внутри запроса, проверки здоровья и reflection открыты.
+var publicMethods = map[string]bool{
+	"/envoy.service.auth.v3.Authorization/Check":                     true,
+	"/grpc.health.v1.Health/Check":                                   true,
+	"/grpc.health.v1.Health/Watch":                                   true,
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      true,
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": true,
+}
+
+// AuthInterceptor пропускает вызов, если у клиента есть scope метода. Клиент
+// аутентифицируется токеном сервисного аккаунта или, при mTLS, сертификатом
+// с CommonName из certScopes.
+func AuthInterceptor(certScopes map[string][]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, info.FullMethod, certScopes); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamAuthInterceptor(certScopes map[string][]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), info.FullMethod, certScopes); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func authorize(ctx context.Context, method string, certScopes map[string][]string) error {
+	if publicMethods[method] {
+		return nil
+	}
+
+	scope, ok := methodScopes[method]
+	if !ok {
+		return status.Error(codes.PermissionDenied, "Метод недоступен")
+	}
+
+	claims, err := callerClaims(ctx, certScopes)
+	if err != nil {
+		logger.Logger.Warn("Неаутентифицированный gRPC вызов",
+			zap.String("method", method),
+			zap.String("request_id", RequestID(ctx)),
+			zap.Error(err))
+		return err
+	}
+
+	if !claims.HasScope(scope) {
+		logger.Logger.Warn("Недостаточно scope для gRPC вызова",
+			zap.String("method", method),
+			zap.String("client_id", claims.ClientID),
+			zap.String("scope", claims.Scope))
+		return status.Errorf(codes.PermissionDenied, "Требуется scope %s", scope)
+	}
+	return nil
+}
+
+// callerClaims предпочитает токен из метаданных: сертификат используется, только если токена нет.
+func callerClaims(ctx context.Context, certScopes map[string][]string) (*jwt.Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("authorization")) == 0 {
+		if cn := clientCertName(ctx); cn != "" {
+			scopes, ok := certScopes[cn]
+			if !ok {
+				return nil, status.Errorf(codes.Unauthenticated, "Сертификату %s не назначены scope", cn)
+			}
+			return &jwt.Claims{ClientID: "cert:" + cn, Scope: strings.Join(scopes, " ")}, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "Не передан токен сервисного аккаунта")
+	}
+
+	token := strings.TrimPrefix(md.Get("authorization")[0], "Bearer ")
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Невалидный токен сервисного аккаунта")
+	}
+	if claims.ClientID == "" {
+		return nil, status.Error(codes.Unauthenticated, "Токен не принадлежит сервисному аккаунту")
+	}
+	return claims, nil
+}
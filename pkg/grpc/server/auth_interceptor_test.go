@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/jwt"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	jwt.SECRET_KEY = []byte("test-secret")
+	os.Exit(m.Run())
+}
+
+// withClientCert — контекст вызова по mTLS с проверенным сертификатом cn.
+func withClientCert(cn string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+}
+
+func withToken(t *testing.T, scopes ...string) context.Context {
+	t.Helper()
+	token, _, err := jwt.GenerateClientToken("billing", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestAuthorize(t *testing.T) {
+	certScopes := map[string][]string{"forum": {jwt.ScopeTokenCheck, jwt.ScopeUsersRead}}
+	user, _, err := jwt.GenerateAccessToken(entity.User{ID: 2, Email: "user@a.com", Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// сертификат без проверенной цепочки не аутентифицирует
+	unverified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "forum"}}}},
+	}})
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{
+		{"публичный метод без токена", context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+		{"ext_authz без токена", context.Background(), "/envoy.service.auth.v3.Authorization/Check", codes.OK},
+		{"неизвестный метод", withToken(t, jwt.ScopeUsersRead), "/proto.UserService/Unknown", codes.PermissionDenied},
+		{"неизвестный метод по сертификату", withClientCert("forum"), "/proto.AdminService/Drop", codes.PermissionDenied},
+		{"без токена и сертификата", context.Background(), "/proto.UserService/CheckToken", codes.Unauthenticated},
+		{"сертификат со scope", withClientCert("forum"), "/proto.UserService/CheckToken", codes.OK},
+		{"сертификат без нужного scope", withClientCert("forum"), "/proto.UserService/DeleteUser", codes.PermissionDenied},
+		{"сертификат без scope в конфиге", withClientCert("unknown"), "/proto.UserService/CheckToken", codes.Unauthenticated},
+		{"непроверенный сертификат", unverified, "/proto.UserService/CheckToken", codes.Unauthenticated},
+		{"токен со scope", withToken(t, jwt.ScopeUsersWrite), "/proto.UserService/DeleteUser", codes.OK},
+		{"токен без scope", withToken(t, jwt.ScopeUsersRead), "/proto.UserService/DeleteUser", codes.PermissionDenied},
+		{"токен пользователя", metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+user)),
+			"/proto.UserService/GetUserByID", codes.Unauthenticated},
+		{"мусор вместо токена", metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer x")),
+			"/proto.UserService/GetUserByID", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorize(tt.ctx, tt.method, certScopes)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("код %v, ожидался %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+// Токен в метаданных важнее сертификата: с невалидным токеном сертификат не помогает.
+func TestAuthorizeTokenOverCertificate(t *testing.T) {
+	ctx := metadata.NewIncomingContext(withClientCert("forum"), metadata.Pairs("authorization", "Bearer x"))
+	err := authorize(ctx, "/proto.UserService/CheckToken", map[string][]string{"forum": {jwt.ScopeTokenCheck}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("код %v, ожидался Unauthenticated", status.Code(err))
+	}
+}
@@ -1,30 +1,180 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/LandGAA/authh2/internal/usecase"
+	"github.com/LandGAA/authh2/pkg/config"
 	pd "github.com/LandGAA/authh2/pkg/grpc/generate"
 	"github.com/LandGAA/authh2/pkg/grpc/methods"
 	"github.com/LandGAA/authh2/pkg/logger"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"sync"
+	"time"
 )
 
-func Run(useCase usecase.UseCase) {
-	ls, err := net.Listen("tcp", ":50051")
+// running — запущенный сервер и его сервис здоровья; пустой до Run и после Stop.
+var (
+	mu           sync.Mutex
+	running      *grpc.Server
+	healthServer *health.Server
+)
+
+func Run(useCase usecase.UseCase, tokenUseCase usecase.TokenUseCase, webAuthnUseCase usecase.WebAuthnUseCase, inviteUseCase usecase.InviteUseCase, userEventUseCase usecase.UserEventUseCase) {
+	cfg := config.Cfg.GRPC
+	ls, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		logger.Logger.Fatal("Ошибка запуска gRPC сервера!",
 			zap.Error(err))
 	}
 
-	grpcServer := grpc.NewServer()
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		logger.Logger.Fatal("Ошибка настройки gRPC сервера!",
+			zap.Error(err))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
 	pd.RegisterUserServiceServer(grpcServer, &methods.UserServiceServer{
 		UU: useCase,
+		TU: tokenUseCase,
+		WU: webAuthnUseCase,
+		IU: inviteUseCase,
+		EU: userEventUseCase,
+
+		PollInterval: pollInterval(config.Cfg.Events.PollInterval),
+	})
+	authv3.RegisterAuthorizationServer(grpcServer, &methods.AuthorizationServer{
+		UU:  useCase,
+		Cfg: config.Cfg.ExtAuthz,
 	})
 
-	logger.Logger.Info("gRPC сервер запущен!")
-	if err := grpcServer.Serve(ls); err != nil {
+	hs := health.NewServer()
+	hs.SetServingStatus(pd.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(authv3.Authorization_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, hs)
+	if cfg.Reflection {
+		reflection.Register(grpcServer)
+	}
+
+	logger.Logger.Info("gRPC сервер запущен!",
+		zap.String("addr", cfg.Addr),
+		zap.Bool("tls", cfg.TLS.CertFile != ""),
+		zap.Bool("mtls", cfg.TLS.ClientCAFile != ""))
+	mu.Lock()
+	running, healthServer = grpcServer, hs
+	mu.Unlock()
+	// ErrServerStopped — Stop вызван раньше, чем сервер начал принимать соединения
+	if err := grpcServer.Serve(ls); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 		logger.Logger.Fatal("Ошибка запуска gRPC сервера!",
 			zap.Error(err))
 	}
 }
+
+// Status возвращает ошибку, если gRPC сервер не принимает вызовы.
+func Status() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if running == nil {
+		return fmt.Errorf("gRPC сервер не запущен")
+	}
+	return nil
+}
+
+// Drain переводит все сервисы в NOT_SERVING в grpc.health.v1, не закрывая соединений:
+// клиенты с проверкой здоровья перестают выбирать этот экземпляр.
+func Drain() {
+	mu.Lock()
+	defer mu.Unlock()
+	if healthServer != nil {
+		healthServer.Shutdown()
+	}
+}
+
+// Stop дожидается завершения текущих вызовов, а по истечении ctx закрывает соединения
+// принудительно: потоки WatchUserEvents сами не завершаются.
+func Stop(ctx context.Context) {
+	mu.Lock()
+	srv := running
+	running = nil
+	mu.Unlock()
+	if srv == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// serverOptions собирает TLS, ограничения и цепочки интерцепторов: recovery первым,
+// чтобы перехватывать панику остальных, auth последним, чтобы отказы тоже логировались.
+func serverOptions(cfg config.GRPC) ([]grpc.ServerOption, error) {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.MaxSendMsgSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:              cfg.Keepalive.Time,
+			Timeout:           cfg.Keepalive.Timeout,
+			MaxConnectionIdle: cfg.Keepalive.MaxConnectionIdle,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.Keepalive.MinTime,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream,
+		}),
+		grpc.ChainUnaryInterceptor(
+			RecoveryInterceptor(),
+			RequestIDInterceptor(),
+			LoggingInterceptor(),
+			MetricsInterceptor(),
+			AuthInterceptor(cfg.TLS.ClientScopes),
+		),
+		grpc.ChainStreamInterceptor(
+			StreamRecoveryInterceptor(),
+			StreamRequestIDInterceptor(),
+			StreamLoggingInterceptor(),
+			StreamMetricsInterceptor(),
+			StreamAuthInterceptor(cfg.TLS.ClientScopes),
+		),
+	}
+
+	if config.Cfg.Tracing.Enabled {
+		// спан вызова продолжает трассировку из метаданных traceparent; проверки здоровья не трассируются
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))))
+	}
+
+	creds, err := transportCredentials(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	} else {
+		logger.Logger.Warn("gRPC сервер работает без TLS")
+	}
+	return opts, nil
+}
+
+func pollInterval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Second
+	}
+	return d
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type panicStream struct {
+	grpc.ServerStream
+}
+
+func (panicStream) Context() context.Context { return context.Background() }
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.UserService/GetUserByID"}
+	_, err := RecoveryInterceptor()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		panic("nil map")
+	})
+	if status.Code(err) != codes.Internal || status.Convert(err).Message() != "Внутренняя ошибка сервера" {
+		t.Fatalf("ошибка после паники: %v", err)
+	}
+
+	// без паники ответ обработчика не меняется
+	resp, err := RecoveryInterceptor()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", status.Error(codes.NotFound, "нет")
+	})
+	if resp != "ok" || status.Code(err) != codes.NotFound {
+		t.Fatalf("ответ %v, ошибка %v", resp, err)
+	}
+
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/proto.UserService/WatchUserEvents"}
+	err = StreamRecoveryInterceptor()(nil, panicStream{}, streamInfo, func(interface{}, grpc.ServerStream) error {
+		panic("closed channel")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("ошибка потока после паники: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Package health — проверки готовности сервиса (/readyz) и режим вывода из балансировки
+// при остановке.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// Check проверяет одну зависимость. details попадают в ответ /readyz и при ошибке.
+type Check func(ctx context.Context) (details map[string]any, err error)
+
+// Result — результат одной проверки.
+type Result struct {
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS float64        `json:"duration_ms"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Report — ответ /readyz.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker выполняет зарегистрированные проверки. После Drain сервис не готов
+// независимо от результатов проверок.
+type Checker struct {
+	timeout  time.Duration
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// New создает Checker; timeout — общий дедлайн всех проверок одного запроса.
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Register добавляет проверку. Вызывается при запуске, до обработки запросов.
+func (c *Checker) Register(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain переводит сервис в режим остановки: /readyz отвечает 503, чтобы балансировщик
+// перестал направлять запросы, пока текущие завершаются.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Ready выполняет проверки параллельно. Статус отчета ok, только если все проверки
+// прошли и сервис не останавливается.
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, nc := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, nc.check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+	for i, nc := range c.checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// run выполняет проверку; зависшая проверка считается проваленной по дедлайну ctx.
+func run(ctx context.Context, check Check) Result {
+	type outcome struct {
+		details map[string]any
+		err     error
+	}
+	start := time.Now()
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := check(ctx)
+		done <- outcome{details: details, err: err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = ctx.Err()
+	}
+	result := Result{
+		Status:     StatusOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		Details:    o.details,
+	}
+	if o.err != nil {
+		result.Status = StatusFail
+		result.Error = o.err.Error()
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) (map[string]any, error) {
+	return map[string]any{"version": 18}, nil
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		status string
+		failed []string
+	}{
+		{"без проверок", nil, StatusOK, nil},
+		{"все прошли", map[string]Check{"database": ok, "migrations": ok}, StatusOK, nil},
+		{"одна провалена", map[string]Check{
+			"database": ok,
+			"grpc": func(context.Context) (map[string]any, error) {
+				return map[string]any{"addr": ":50051"}, errors.New("сервер не запущен")
+			},
+		}, StatusFail, []string{"grpc"}},
+		{"зависшая проверка", map[string]Check{
+			"database": ok,
+			"broker": func(ctx context.Context) (map[string]any, error) {
+				// не смотрит на ctx: Ready не должен ее ждать
+				time.Sleep(300 * time.Millisecond)
+				return nil, nil
+			},
+		}, StatusFail, []string{"broker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				c.Register(name, check)
+			}
+
+			start := time.Now()
+			report := c.Ready(context.Background())
+			if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+				t.Fatalf("Ready занял %s", elapsed)
+			}
+			if report.Status != tt.status || len(report.Checks) != len(tt.checks) {
+				t.Fatalf("отчет %+v", report)
+			}
+			for _, name := range tt.failed {
+				if result := report.Checks[name]; result.Status != StatusFail || result.Error == "" {
+					t.Fatalf("проверка %s: %+v", name, result)
+				}
+			}
+		})
+	}
+}
+
+// Детали проверки попадают в отчет и при ошибке.
+func TestReadyDetails(t *testing.T) {
+	c := New(time.Second)
+	c.Register("grpc", func(context.Context) (map[string]any, error) {
+		return map[string]any{"addr": ":50051"}, errors.New("сервер не запущен")
+	})
+	result := c.Ready(context.Background()).Checks["grpc"]
+	if result.Details["addr"] != ":50051" || result.Error != "сервер не запущен" {
+		t.Fatalf("результат %+v", result)
+	}
+}
+
+// После Drain сервис не готов, даже если все проверки прошли.
+func TestDrain(t *testing.T) {
+	c := New(time.Second)
+	c.Register("database", ok)
+	if c.Draining() {
+		t.Fatal("Draining до Drain")
+	}
+	c.Drain()
+	report := c.Ready(context.Background())
+	if !c.Draining() || report.Status != StatusDraining || report.Checks["database"].Status != StatusOK {
+		t.Fatalf("отчет после Drain %+v", report)
+	}
+}
@@ -1,24 +1,81 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/config"
 	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/LandGAA/authh2/pkg/metrics"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var SECRET_KEY []byte
 
+// RevocationCheck проверяет, отозван ли токен: по jti или вместе со всеми токенами
+// пользователя, выданными до смены пароля. Устанавливается при старте приложения.
+var RevocationCheck func(claims *Claims) bool
+
+// GroupClaims возвращает роли, унаследованные пользователем от групп, и названия его групп
+// (пустые, если claim groups выключен). Устанавливается при старте приложения.
+var GroupClaims func(user entity.User) (roles []string, groups []string)
+
+// UserDisabled сообщает, отключен ли пользователь через SCIM глобально или в организации
+// tenant. Устанавливается при старте приложения.
+var UserDisabled func(userID int, tenant string) bool
+
+var ErrorUserDisabled = fmt.Errorf("Учетная запись отключена")
+
+const (
+	ScopeTokenCheck      = "token:check"
+	ScopeUsersRead       = "users:read"
+	ScopeUsersWrite      = "users:write"
+	ScopeUsersLogin      = "users:login"
+	ScopeTokenIntrospect = "token:introspect"
+	ScopeTokenRevoke     = "token:revoke"
+	ScopeTokenExchange   = "token:exchange"
+	ScopeSCIMProvision   = "scim:provision"
+)
+
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+	TokenUseClient  = "client"
+	TokenUseMFA     = "mfa"
+	TokenUseInvite  = "invite"
+)
+
 type Claims struct {
-	Email string `json:"email"`
-	ID    int    `json:"id"`
-	Role  string `json:"role"`
+	Email    string `json:"email,omitempty"`
+	ID       int    `json:"id,omitempty"`
+	Role     string `json:"role,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	TokenUse string `json:"token_use,omitempty"`
+	// Tenant — slug организации; пустой у глобальных токенов
+	Tenant string `json:"tenant,omitempty"`
+	// Roles — все роли пользователя, если группы добавили к Role другие роли
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Act — настоящий пользователь, действующий от имени sub (RFC 8693)
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+type Actor struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email,omitempty"`
+	// Act — предыдущий участник цепочки делегирования
+	Act *Actor `json:"act,omitempty"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
@@ -51,22 +108,48 @@ func Init() {
 		logger.Logger.Fatal("Переменная окружения JWT_SECRET_KEY не установлена")
 	}
 	SECRET_KEY = []byte(secret)
+
+	// JWT_PRIVATE_KEY_FILE включает подпись RS256 и JWKS для локальной проверки токенов
+	if file := os.Getenv("JWT_PRIVATE_KEY_FILE"); file != "" {
+		if err := LoadSigningKey(file); err != nil {
+			logger.Logger.Fatal(fmt.Sprintf("Ошибка загрузки ключа подписи: %v", err))
+		}
+		logger.Logger.Info(fmt.Sprintf("Токены подписываются RS256, kid %s", signingKeyID))
+	}
+}
+
+// registeredClaims заполняет стандартные поля. У токенов организации свой iss.
+func registeredClaims(subject string, tenant string, expirationTime time.Time) jwt.RegisteredClaims {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return jwt.RegisteredClaims{
+		ID:        hex.EncodeToString(id),
+		Issuer:    config.Cfg.Tenancy.IssuerFor(tenant),
+		Subject:   subject,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(expirationTime),
+	}
 }
 
 func GenerateAccessToken(user entity.User) (string, int64, error) {
+	if UserDisabled != nil && UserDisabled(user.ID, user.Tenant) {
+		return "", 0, ErrorUserDisabled
+	}
 	expirationTime := time.Now().Add(15 * time.Minute)
 
 	claim := &Claims{
-		Email: user.Email,
-		ID:    user.ID,
-		Role:  user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+		Email:            user.Email,
+		ID:               user.ID,
+		Role:             user.Role,
+		TokenUse:         TokenUseAccess,
+		Tenant:           user.Tenant,
+		RegisteredClaims: registeredClaims(strconv.Itoa(user.ID), user.Tenant, expirationTime),
+	}
+	if GroupClaims != nil {
+		claim.Roles, claim.Groups = GroupClaims(user)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	tokenString, err := token.SignedString(SECRET_KEY)
+	tokenString, err := signClaims(claim)
 	return tokenString, expirationTime.Unix(), err
 }
 
@@ -74,31 +157,236 @@ func GenerateRefreshToken(user entity.User) (string, error) {
 	expirationTime := time.Now().Add(7 * 24 * time.Hour)
 
 	claim := &Claims{
-		Email: user.Email,
-		ID:    user.ID,
-		Role:  user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+		Email:            user.Email,
+		ID:               user.ID,
+		Role:             user.Role,
+		TokenUse:         TokenUseRefresh,
+		Tenant:           user.Tenant,
+		RegisteredClaims: registeredClaims(strconv.Itoa(user.ID), user.Tenant, expirationTime),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	return token.SignedString(SECRET_KEY)
+	return signClaims(claim)
 }
 
-func ValidateToken(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return SECRET_KEY, nil
+// GenerateImpersonationToken выдает access токен пользователя user для actor. Refresh
+// токен к нему не выдается: по истечении ttl нужно запросить подмену заново.
+func GenerateImpersonationToken(user entity.User, actor entity.User, ttl time.Duration) (string, int64, error) {
+	expirationTime := time.Now().Add(ttl)
+
+	claim := &Claims{
+		Email:            user.Email,
+		ID:               user.ID,
+		Role:             user.Role,
+		TokenUse:         TokenUseAccess,
+		Tenant:           user.Tenant,
+		Act:              &Actor{Sub: strconv.Itoa(actor.ID), Email: actor.Email},
+		RegisteredClaims: registeredClaims(strconv.Itoa(user.ID), user.Tenant, expirationTime),
+	}
+	if GroupClaims != nil {
+		claim.Roles, claim.Groups = GroupClaims(user)
+	}
+
+	tokenString, err := signClaims(claim)
+	return tokenString, expirationTime.Unix(), err
+}
+
+// GenerateExchangedToken выдает по RFC 8693 токен пользователя из subject для сервиса audience:
+// вместо ролей в нем только scopes, а act дополняется клиентом, запросившим обмен.
+func GenerateExchangedToken(subject *Claims, clientID string, audience string, scopes []string, expirationTime time.Time) (string, int64, error) {
+	claim := &Claims{
+		Email:            subject.Email,
+		ID:               subject.ID,
+		Scope:            strings.Join(scopes, " "),
+		TokenUse:         TokenUseAccess,
+		Tenant:           subject.Tenant,
+		Act:              &Actor{Sub: clientID, Act: subject.Act},
+		RegisteredClaims: registeredClaims(subject.Subject, subject.Tenant, expirationTime),
+	}
+	claim.Audience = jwt.ClaimStrings{audience}
+
+	tokenString, err := signClaims(claim)
+	return tokenString, expirationTime.Unix(), err
+}
+
+// ActorID возвращает ID настоящего пользователя подмененного токена (0 у обычных токенов).
+func (c *Claims) ActorID() int {
+	if c.Act == nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(c.Act.Sub)
+	return id
+}
+
+// GenerateMFAToken выдает короткоживущий токен, подтверждающий первый фактор.
+// Обменять его на access токен можно только после второго фактора.
+func GenerateMFAToken(user entity.User) (string, error) {
+	expirationTime := time.Now().Add(5 * time.Minute)
+
+	claim := &Claims{
+		Email:            user.Email,
+		ID:               user.ID,
+		TokenUse:         TokenUseMFA,
+		Tenant:           user.Tenant,
+		RegisteredClaims: registeredClaims(strconv.Itoa(user.ID), user.Tenant, expirationTime),
+	}
+
+	return signClaims(claim)
+}
+
+// GenerateInviteToken подписывает приглашение: sub — ID приглашения. Одноразовость
+// обеспечивается записью приглашения в базе.
+func GenerateInviteToken(invite entity.Invite, tenant string) (string, error) {
+	claim := &Claims{
+		Email:            invite.Email,
+		TokenUse:         TokenUseInvite,
+		Tenant:           tenant,
+		RegisteredClaims: registeredClaims(strconv.Itoa(invite.ID), tenant, time.Unix(invite.ExpiresAt, 0)),
+	}
+
+	return signClaims(claim)
+}
+
+func GenerateClientToken(clientID string, scopes []string) (string, int64, error) {
+	expirationTime := time.Now().Add(15 * time.Minute)
+
+	claim := &Claims{
+		ClientID:         clientID,
+		Scope:            strings.Join(scopes, " "),
+		TokenUse:         TokenUseClient,
+		RegisteredClaims: registeredClaims(clientID, "", expirationTime),
+	}
+
+	tokenString, err := signClaims(claim)
+	return tokenString, expirationTime.Unix(), err
+}
+
+// CheckSigningKey проверяет, что ключ подписи загружен: подписывает короткоживущий токен
+// и проверяет его подпись. Возвращает алгоритм подписи.
+func CheckSigningKey() (string, error) {
+	if len(SECRET_KEY) == 0 {
+		return "", fmt.Errorf("Ключ подписи не загружен")
+	}
+	alg := jwt.SigningMethodHS256.Alg()
+	if signingKey != nil {
+		alg = jwt.SigningMethodRS256.Alg()
+	}
+	tokenString, err := signClaims(jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
 	})
+	if err != nil {
+		return alg, fmt.Errorf("Ошибка подписи токена: %w", err)
+	}
+	_, err = jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, verificationKey)
+	if err != nil {
+		return alg, fmt.Errorf("Ошибка проверки подписи: %w", err)
+	}
+	return alg, nil
+}
+
+// IsRefresh сообщает, что токен — refresh токен пользователя. Refresh токены не выдаются
+// при входе от чужого имени, поэтому токен с act им не считается.
+func (c *Claims) IsRefresh() bool {
+	return c.ID != 0 && c.TokenUse == TokenUseRefresh && c.Act == nil
+}
+
+// IsUserAccess сообщает, что токен — access токен пользователя (не refresh, не MFA и не сервисный).
+func (c *Claims) IsUserAccess() bool {
+	return c.ID != 0 && (c.TokenUse == TokenUseAccess || c.TokenUse == "")
+}
+
+// EffectiveRoles возвращает все роли пользователя, включая унаследованные от групп.
+func (c *Claims) EffectiveRoles() []string {
+	if len(c.Roles) > 0 {
+		return c.Roles
+	}
+	if c.Role == "" {
+		return nil
+	}
+	return []string{c.Role}
+}
+
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
 
+func (c *Claims) HasScope(scope string) bool {
+	return HasScope(c.Scope, scope)
+}
+
+func HasScope(scopes string, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyAudience отключает проверку aud: нужен интроспекции и отзыву, работающим с токенами любых сервисов.
+const AnyAudience = "*"
+
+// ValidateToken проверяет подпись, срок, издателя и отзыв токена. audience — кто проверяет
+// токен: токен с aud принимается, только если среди audience есть одно из его значений,
+// а без audience принимаются только токены без aud (выданные самому сервису авторизации).
+func ValidateToken(tokenString string, audience ...string) (*Claims, error) {
+	claims, result, err := validateToken(tokenString, audience)
+	metrics.TokenValidations.WithLabelValues(result).Inc()
+	return claims, err
+}
+
+// validateToken возвращает, кроме ошибки, результат проверки для метрик.
+func validateToken(tokenString string, audience []string) (*Claims, string, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
+
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, "expired", err
+	}
 	if err != nil {
-		return nil, err
+		return nil, "invalid", err
 	}
 
 	if !token.Valid {
-		return nil, fmt.Errorf("Невалидный токен")
+		return nil, "invalid", fmt.Errorf("Невалидный токен")
 	}
 
-	return claims, nil
+	if claims.Issuer != config.Cfg.Tenancy.IssuerFor(claims.Tenant) {
+		return nil, "wrong_issuer", fmt.Errorf("Токен выдан другим издателем")
+	}
+
+	if !audienceAllowed(claims.Audience, audience) {
+		return nil, "wrong_audience", fmt.Errorf("Токен выдан для другой аудитории")
+	}
+
+	if RevocationCheck != nil && RevocationCheck(claims) {
+		return nil, "revoked", fmt.Errorf("Токен отозван")
+	}
+
+	if claims.ID != 0 && UserDisabled != nil && UserDisabled(claims.ID, claims.Tenant) {
+		return nil, "disabled", ErrorUserDisabled
+	}
+
+	return claims, "valid", nil
+}
+
+func audienceAllowed(tokenAudience []string, expected []string) bool {
+	if len(tokenAudience) == 0 {
+		return len(expected) == 0 || expected[0] == AnyAudience
+	}
+	for _, want := range expected {
+		if want == AnyAudience {
+			return true
+		}
+		for _, aud := range tokenAudience {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,283 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/internal/entity"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop()
+	SECRET_KEY = []byte("test-secret")
+	config.Cfg.Tenancy.Issuer = "https://{tenant}.auth.example.com"
+	os.Exit(m.Run())
+}
+
+var testUser = entity.User{ID: 7, Email: "user@a.com", Role: "manager"}
+
+func sign(t *testing.T, claims *Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(SECRET_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// userClaims — claims пользователя с корректными iss и сроком.
+func userClaims(tokenUse string, tenant string) *Claims {
+	return &Claims{
+		ID:               testUser.ID,
+		Email:            testUser.Email,
+		Role:             testUser.Role,
+		TokenUse:         tokenUse,
+		Tenant:           tenant,
+		RegisteredClaims: registeredClaims("7", tenant, time.Now().Add(time.Minute)),
+	}
+}
+
+func TestTokenUse(t *testing.T) {
+	access, _, _ := GenerateAccessToken(testUser)
+	refresh, _ := GenerateRefreshToken(testUser)
+	impersonated, _, _ := GenerateImpersonationToken(testUser, entity.User{ID: 1, Email: "admin@a.com"}, time.Minute)
+	mfa, _ := GenerateMFAToken(testUser)
+	invite, _ := GenerateInviteToken(entity.Invite{ID: 3, Email: "new@a.com", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "")
+	client, _, _ := GenerateClientToken("billing", []string{ScopeUsersRead})
+
+	legacy := userClaims("", "")
+	refreshWithAct := userClaims(TokenUseRefresh, "")
+	refreshWithAct.Act = &Actor{Sub: "1"}
+	accessWithoutID := userClaims(TokenUseAccess, "")
+	accessWithoutID.ID = 0
+
+	tests := []struct {
+		name       string
+		token      string
+		userAccess bool
+		refresh    bool
+	}{
+		{"access", access, true, false},
+		{"refresh", refresh, false, true},
+		{"подмена пользователя", impersonated, true, false},
+		{"mfa", mfa, false, false},
+		{"приглашение", invite, false, false},
+		{"клиент", client, false, false},
+		{"старый токен без token_use", sign(t, legacy), true, false},
+		{"refresh с act", sign(t, refreshWithAct), false, false},
+		{"access без пользователя", sign(t, accessWithoutID), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ValidateToken(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.IsUserAccess() != tt.userAccess || claims.IsRefresh() != tt.refresh {
+				t.Fatalf("IsUserAccess = %v, IsRefresh = %v (token_use %q)", claims.IsUserAccess(), claims.IsRefresh(), claims.TokenUse)
+			}
+		})
+	}
+}
+
+func TestGeneratedClaims(t *testing.T) {
+	impersonated, _, err := GenerateImpersonationToken(testUser, entity.User{ID: 1, Email: "admin@a.com"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken(impersonated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ActorID() != 1 || claims.Act.Email != "admin@a.com" || claims.Subject != "7" {
+		t.Fatalf("claims подмены %+v", claims)
+	}
+
+	subject := userClaims(TokenUseAccess, "acme")
+	subject.Act = &Actor{Sub: "gateway"}
+	exchanged, _, err := GenerateExchangedToken(subject, "billing", "ledger", []string{ScopeUsersRead}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = ValidateToken(exchanged, "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// роли не переносятся, act дополняется цепочкой делегирования
+	if claims.Role != "" || claims.Scope != ScopeUsersRead || claims.Tenant != "acme" ||
+		claims.Act.Sub != "billing" || claims.Act.Act.Sub != "gateway" || claims.Issuer != "https://acme.auth.example.com" {
+		t.Fatalf("claims обмена %+v, act %+v", claims, claims.Act)
+	}
+
+	client, _, _ := GenerateClientToken("billing", []string{ScopeUsersRead, ScopeTokenCheck})
+	claims, err = ValidateToken(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Tenant != "" || claims.ClientID != "billing" || !claims.HasScope(ScopeTokenCheck) || claims.HasScope(ScopeUsersWrite) {
+		t.Fatalf("claims клиента %+v", claims)
+	}
+}
+
+func TestAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience []string
+		expected []string
+		ok       bool
+	}{
+		{"без aud, без аудитории", nil, nil, true},
+		{"без aud, проверяет сервис", nil, []string{"ledger"}, false},
+		{"без aud, любая аудитория", nil, []string{AnyAudience}, true},
+		{"aud сервиса, без аудитории", []string{"ledger"}, nil, false},
+		{"aud сервиса, тот же сервис", []string{"ledger"}, []string{"ledger"}, true},
+		{"aud сервиса, другой сервис", []string{"ledger"}, []string{"billing"}, false},
+		{"aud сервиса, один из ожидаемых", []string{"ledger"}, []string{"billing", "ledger"}, true},
+		{"несколько aud", []string{"billing", "ledger"}, []string{"ledger"}, true},
+		{"aud сервиса, любая аудитория", []string{"ledger"}, []string{AnyAudience}, true},
+		{"aud * не подходит сервису", []string{AnyAudience}, []string{"ledger"}, false},
+		{"aud * без аудитории", []string{AnyAudience}, nil, false},
+		{"регистр важен", []string{"Ledger"}, []string{"ledger"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := userClaims(TokenUseAccess, "")
+			claims.Audience = tt.audience
+			_, err := ValidateToken(sign(t, claims), tt.expected...)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ошибка %v, ожидалось ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRejects(t *testing.T) {
+	defer func() { RevocationCheck, UserDisabled = nil, nil }()
+
+	expired := userClaims(TokenUseAccess, "")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	globalIssuerInTenant := userClaims(TokenUseAccess, "acme")
+	globalIssuerInTenant.Issuer = ""
+	otherTenantIssuer := userClaims(TokenUseAccess, "acme")
+	otherTenantIssuer.Issuer = config.Cfg.Tenancy.IssuerFor("globex")
+	tenantIssuerGlobal := userClaims(TokenUseAccess, "")
+	tenantIssuerGlobal.Issuer = config.Cfg.Tenancy.IssuerFor("acme")
+
+	foreign, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, userClaims(TokenUseAccess, "")).SignedString([]byte("other-secret"))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, userClaims(TokenUseAccess, "")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	valid := sign(t, userClaims(TokenUseAccess, ""))
+	parts := strings.Split(valid, ".")
+	admin := sign(t, &Claims{ID: 7, Role: "admin"})
+	tampered := parts[0] + "." + strings.Split(admin, ".")[1] + "." + parts[2]
+
+	revoked := userClaims(TokenUseAccess, "")
+	revoked.RegisteredClaims.ID = "revoked-jti"
+	RevocationCheck = func(claims *Claims) bool { return claims.RegisteredClaims.ID == "revoked-jti" }
+
+	disabled := userClaims(TokenUseAccess, "acme")
+	disabled.ID = 9
+	UserDisabled = func(userID int, tenant string) bool { return userID == 9 && tenant == "acme" }
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"истек", sign(t, expired), jwt.ErrTokenExpired},
+		{"чужой ключ", foreign, jwt.ErrTokenSignatureInvalid},
+		{"alg none", none, jwt.ErrTokenUnverifiable},
+		{"подмененный payload", tampered, jwt.ErrTokenSignatureInvalid},
+		{"мусор", "not.a.token", jwt.ErrTokenMalformed},
+		{"глобальный iss у токена организации", sign(t, globalIssuerInTenant), nil},
+		{"iss другой организации", sign(t, otherTenantIssuer), nil},
+		{"iss организации у глобального токена", sign(t, tenantIssuerGlobal), nil},
+		{"отозван", sign(t, revoked), nil},
+		{"отключен в организации", sign(t, disabled), ErrorUserDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ValidateToken(tt.token)
+			if err == nil || claims != nil {
+				t.Fatalf("токен принят: %+v", claims)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := ValidateToken(valid); err != nil {
+		t.Fatalf("корректный токен отклонен: %v", err)
+	}
+	// пользователь отключен только в организации acme
+	disabled.Tenant, disabled.Issuer = "", ""
+	if _, err := ValidateToken(sign(t, disabled)); err != nil {
+		t.Fatalf("глобальный токен отключенного в организации: %v", err)
+	}
+}
+
+func TestRolesAndScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		role  string
+		roles []string
+		want  []string
+	}{
+		{"только роль", "user", nil, []string{"user"}},
+		{"роли групп", "user", []string{"user", "auditor"}, []string{"user", "auditor"}},
+		{"без ролей", "", nil, nil},
+	}
+	for _, tt := range tests {
+		claims := &Claims{Role: tt.role, Roles: tt.roles}
+		if got := claims.EffectiveRoles(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: EffectiveRoles = %v, ожидалось %v", tt.name, got, tt.want)
+		}
+		for _, role := range tt.want {
+			if !claims.HasRole(role) {
+				t.Errorf("%s: нет роли %s", tt.name, role)
+			}
+		}
+		if claims.HasRole("admin") {
+			t.Errorf("%s: лишняя роль admin", tt.name)
+		}
+	}
+
+	scopes := []struct {
+		scopes string
+		scope  string
+		want   bool
+	}{
+		{"users:read users:write", "users:write", true},
+		{"users:read  users:write", "users:read", true},
+		{"users:read", "users", false},
+		{"users:readwrite", "users:read", false},
+		{"", "users:read", false},
+		{"users:read", "", false},
+	}
+	for _, tt := range scopes {
+		if got := HasScope(tt.scopes, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q, %q) = %v", tt.scopes, tt.scope, got)
+		}
+	}
+}
+
+func TestCheckSigningKey(t *testing.T) {
+	if alg, err := CheckSigningKey(); err != nil || alg != "HS256" {
+		t.Fatalf("CheckSigningKey = %s, %v", alg, err)
+	}
+	key := SECRET_KEY
+	defer func() { SECRET_KEY = key }()
+	SECRET_KEY = nil
+	if _, err := CheckSigningKey(); err == nil {
+		t.Fatal("пустой ключ принят")
+	}
+}
@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+)
+
+// signingKey — ключ RS256 из JWT_PRIVATE_KEY_FILE. Если он загружен, токены подписываются
+// им, а сервисы могут проверять их сами по открытому ключу из JWKS. Без него токены
+// подписываются HS256 с SECRET_KEY и проверяются только сервисом авторизации.
+var (
+	signingKey   *rsa.PrivateKey
+	signingKeyID string
+)
+
+// JSONWebKey — открытый ключ подписи в формате JWK (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JSONWebKeySet — ответ /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// LoadSigningKey читает закрытый ключ RSA (PKCS#1 или PKCS#8) в PEM.
+func LoadSigningKey(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ключа подписи: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("ключ подписи %s не в формате PEM", file)
+	}
+
+	var key any
+	key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка разбора ключа подписи: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("ключ подписи должен быть RSA")
+	}
+	if rsaKey.N.BitLen() < 2048 {
+		return fmt.Errorf("ключ подписи RSA должен быть не короче 2048 бит")
+	}
+	SetSigningKey(rsaKey)
+	return nil
+}
+
+// SetSigningKey включает подпись RS256 ключом key; nil возвращает HS256.
+// kid — отпечаток открытого ключа, поэтому меняется вместе с ключом.
+func SetSigningKey(key *rsa.PrivateKey) {
+	signingKey, signingKeyID = key, ""
+	if key == nil {
+		return
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	signingKeyID = base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// signClaims подписывает claims ключом RS256, если он загружен, иначе HS256.
+func signClaims(claims jwt.Claims) (string, error) {
+	if signingKey != nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = signingKeyID
+		return token.SignedString(signingKey)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(SECRET_KEY)
+}
+
+// verificationKey выбирает ключ проверки по алгоритму токена. HS256 принимается и
+// после перехода на RS256, чтобы выданные раньше токены доработали до конца срока.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method {
+	case jwt.SigningMethodHS256:
+		return SECRET_KEY, nil
+	case jwt.SigningMethodRS256:
+		if kid, _ := token.Header["kid"].(string); signingKey == nil || kid != signingKeyID {
+			return nil, fmt.Errorf("Неизвестный ключ подписи")
+		}
+		return &signingKey.PublicKey, nil
+	}
+	return nil, fmt.Errorf("Неподдерживаемый алгоритм подписи %s", token.Method.Alg())
+}
+
+// JWKS возвращает открытые ключи подписи; пустой набор, если токены подписываются HS256.
+func JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if signingKey == nil {
+		return set
+	}
+	set.Keys = append(set.Keys, JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: signingKeyID,
+		N:   base64.RawURLEncoding.EncodeToString(signingKey.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signingKey.PublicKey.E)).Bytes()),
+	})
+	return set
+}
@@ -0,0 +1,86 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+)
+
+func TestSigningKey(t *testing.T) {
+	hs256 := sign(t, userClaims(TokenUseAccess, ""))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKey(key)
+	defer SetSigningKey(nil)
+
+	access, _, err := GenerateAccessToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(access, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Method != jwt.SigningMethodRS256 || token.Header["kid"] != signingKeyID {
+		t.Fatalf("alg %s, kid %v", token.Method.Alg(), token.Header["kid"])
+	}
+	if _, err := ValidateToken(access); err != nil {
+		t.Fatal(err)
+	}
+	// токены HS256, выданные до перехода на RS256, дорабатывают до конца срока
+	if _, err := ValidateToken(hs256); err != nil {
+		t.Fatalf("токен HS256 отклонен: %v", err)
+	}
+	if alg, err := CheckSigningKey(); err != nil || alg != "RS256" {
+		t.Fatalf("CheckSigningKey = %s, %v", alg, err)
+	}
+
+	set := JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kid != signingKeyID || set.Keys[0].Alg != "RS256" || set.Keys[0].E != "AQAB" {
+		t.Fatalf("JWKS %+v", set)
+	}
+
+	unknownKid := jwt.NewWithClaims(jwt.SigningMethodRS256, userClaims(TokenUseAccess, ""))
+	unknownKid.Header["kid"] = "other"
+	withoutKid, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, userClaims(TokenUseAccess, "")).SignedString(key)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	foreign := jwt.NewWithClaims(jwt.SigningMethodRS256, userClaims(TokenUseAccess, ""))
+	foreign.Header["kid"] = signingKeyID
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"неизвестный kid", mustSign(t, unknownKid, key)},
+		{"без kid", withoutKid},
+		{"чужой ключ с нашим kid", mustSign(t, foreign, other)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, err := ValidateToken(tt.token); err == nil {
+				t.Fatalf("токен принят: %+v", claims)
+			}
+		})
+	}
+
+	SetSigningKey(nil)
+	if len(JWKS().Keys) != 0 {
+		t.Fatal("JWKS без ключа RS256 не пуст")
+	}
+	if _, err := ValidateToken(access); err == nil {
+		t.Fatal("токен RS256 принят без ключа")
+	}
+}
+
+func mustSign(t *testing.T, token *jwt.Token, key *rsa.PrivateKey) string {
+	t.Helper()
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
@@ -0,0 +1,205 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	ErrorInvalidCredentials = errors.New("Неверный логин или пароль LDAP")
+	ErrorUserNotFound       = errors.New("Пользователь не найден в LDAP")
+)
+
+// Entry — учетная запись пользователя в каталоге.
+type Entry struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+type Client struct {
+	cfg       config.LDAP
+	tlsConfig *tls.Config
+}
+
+// New проверяет настройки LDAP. Пустой bind_password берется из LDAP_BIND_PASSWORD.
+func New(cfg config.LDAP) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("не задан url LDAP")
+	}
+	if cfg.Mode != "search" && cfg.Mode != "bind" {
+		return nil, fmt.Errorf("неизвестный режим LDAP %q, ожидается search или bind", cfg.Mode)
+	}
+	if cfg.Mode == "bind" && !strings.Contains(cfg.UserDNTemplate, "{login}") {
+		return nil, fmt.Errorf("в режиме bind user_dn_template должен содержать {login}")
+	}
+	if cfg.BindPassword == "" {
+		cfg.BindPassword = os.Getenv("LDAP_BIND_PASSWORD")
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return nil, fmt.Errorf("некоректный url LDAP %q", cfg.URL)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения ca_file LDAP: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в ca_file LDAP нет сертификатов")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &Client{cfg: cfg, tlsConfig: tlsConfig}, nil
+}
+
+func (c *Client) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(c.cfg.URL,
+		ldap.DialWithTLSConfig(c.tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: c.cfg.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к LDAP: %w", err)
+	}
+	conn.SetTimeout(c.cfg.Timeout)
+
+	if c.cfg.StartTLS && !strings.HasPrefix(c.cfg.URL, "ldaps://") {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ошибка StartTLS LDAP: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate проверяет пароль пользователя и возвращает его запись в каталоге.
+func (c *Client) Authenticate(login string, password string) (Entry, error) {
+	// пустой пароль LDAP принимает как анонимный вход
+	if password == "" {
+		return Entry{}, ErrorInvalidCredentials
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer conn.Close()
+
+	var userDN string
+	if c.cfg.Mode == "bind" {
+		userDN = strings.ReplaceAll(c.cfg.UserDNTemplate, "{login}", ldap.EscapeDN(login))
+	} else {
+		if err := c.serviceBind(conn); err != nil {
+			return Entry{}, err
+		}
+		entries, err := c.search(conn, c.cfg.BaseDN, ldap.ScopeWholeSubtree, c.filter(login), false)
+		if err != nil {
+			return Entry{}, err
+		}
+		if len(entries) != 1 {
+			return Entry{}, ErrorUserNotFound
+		}
+		userDN = entries[0].DN
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Entry{}, ErrorInvalidCredentials
+		}
+		return Entry{}, fmt.Errorf("ошибка входа в LDAP: %w", err)
+	}
+
+	// атрибуты читаются уже от имени пользователя
+	entries, err := c.search(conn, userDN, ldap.ScopeBaseObject, "(objectClass=*)", false)
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(entries) != 1 {
+		return Entry{}, ErrorUserNotFound
+	}
+	return entries[0], nil
+}
+
+// Search возвращает всех пользователей под sync_filter для синхронизации.
+func (c *Client) Search() ([]Entry, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := c.serviceBind(conn); err != nil {
+		return nil, err
+	}
+	return c.search(conn, c.cfg.BaseDN, ldap.ScopeWholeSubtree, c.cfg.SyncFilter, true)
+}
+
+func (c *Client) serviceBind(conn *ldap.Conn) error {
+	if c.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(c.cfg.BindDN, c.cfg.BindPassword); err != nil {
+		return fmt.Errorf("ошибка входа служебной учетной записи LDAP: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) filter(login string) string {
+	return strings.ReplaceAll(c.cfg.UserFilter, "{login}", ldap.EscapeFilter(login))
+}
+
+// search ищет записи каталога. paged включает постраничный поиск для выборок
+// больше лимита сервера (у Active Directory — 1000 записей).
+func (c *Client) search(conn *ldap.Conn, base string, scope int, filter string, paged bool) ([]Entry, error) {
+	req := ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 0, int(c.cfg.Timeout.Seconds()), false,
+		filter, []string{c.cfg.EmailAttribute, c.cfg.NameAttribute, c.cfg.GroupAttribute}, nil)
+	var (
+		res *ldap.SearchResult
+		err error
+	)
+	if paged {
+		res, err = conn.SearchWithPaging(req, 500)
+	} else {
+		res, err = conn.Search(req)
+	}
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrorUserNotFound
+		}
+		return nil, fmt.Errorf("ошибка поиска в LDAP: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		entries = append(entries, Entry{
+			DN:     e.DN,
+			Email:  e.GetAttributeValue(c.cfg.EmailAttribute),
+			Name:   e.GetAttributeValue(c.cfg.NameAttribute),
+			Groups: e.GetAttributeValues(c.cfg.GroupAttribute),
+		})
+	}
+	return entries, nil
+}
+
+// Role возвращает роль по первой подходящей группе из group_roles.
+func (c *Client) Role(entry Entry) string {
+	for _, mapping := range c.cfg.GroupRoles {
+		for _, group := range entry.Groups {
+			if strings.EqualFold(group, mapping.Group) {
+				return mapping.Role
+			}
+		}
+	}
+	return c.cfg.DefaultRole
+}
@@ -0,0 +1,252 @@
+package ldapauth
+
+import (
+	"errors"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/ldapauth/ldaptest"
+	"github.com/go-ldap/ldap/v3"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testBindDN       = "cn=admin,dc=example,dc=com"
+	testBindPassword = "service-secret"
+	testBaseDN       = "ou=people,dc=example,dc=com"
+	adminsGroup      = "cn=admins,ou=groups,dc=example,dc=com"
+	devsGroup        = "cn=devs,ou=groups,dc=example,dc=com"
+)
+
+var (
+	alice = ldaptest.User{
+		DN:       "uid=alice,ou=people,dc=example,dc=com",
+		Password: "alice-pass",
+		Mail:     "alice@example.com",
+		Name:     "Alice",
+		Groups:   []string{devsGroup, adminsGroup},
+	}
+	bob = ldaptest.User{
+		DN:       "uid=bob@example.com,ou=people,dc=example,dc=com",
+		Password: "bob-pass",
+		Mail:     "bob@example.com",
+		Name:     "Bob",
+	}
+)
+
+func testConfig(d *ldaptest.Directory, mode string) config.LDAP {
+	return config.LDAP{
+		URL:            d.URL,
+		Timeout:        2 * time.Second,
+		Mode:           mode,
+		BindDN:         testBindDN,
+		BindPassword:   testBindPassword,
+		UserDNTemplate: "uid={login},ou=people,dc=example,dc=com",
+		BaseDN:         testBaseDN,
+		UserFilter:     "(mail={login})",
+		SyncFilter:     "(mail=*)",
+		EmailAttribute: ldaptest.EmailAttribute,
+		NameAttribute:  ldaptest.NameAttribute,
+		GroupAttribute: ldaptest.GroupAttribute,
+		DefaultRole:    "user",
+	}
+}
+
+func newTestClient(t *testing.T, cfg config.LDAP) *Client {
+	t.Helper()
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAuthenticateSearchMode(t *testing.T) {
+	d := ldaptest.Start(t, testBindDN, testBindPassword, alice, bob)
+	c := newTestClient(t, testConfig(d, "search"))
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     error
+	}{
+		{"верный пароль", alice.Mail, alice.Password, nil},
+		{"неверный пароль", alice.Mail, "wrong", ErrorInvalidCredentials},
+		{"пустой пароль", alice.Mail, "", ErrorInvalidCredentials},
+		{"чужой пароль", alice.Mail, bob.Password, ErrorInvalidCredentials},
+		{"нет в каталоге", "carol@example.com", "carol-pass", ErrorUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := c.Authenticate(tt.login, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if entry.DN != alice.DN || entry.Email != alice.Mail || entry.Name != alice.Name || !slices.Equal(entry.Groups, alice.Groups) {
+				t.Fatalf("запись %+v", entry)
+			}
+		})
+	}
+
+	// служебная учетная запись входит перед поиском, пустой пароль до каталога не доходит
+	binds := d.Binds()
+	if len(binds) == 0 || binds[0] != testBindDN {
+		t.Fatalf("bind: %v", binds)
+	}
+	// по два bind на каждую проверку пароля, один на поиск несуществующего, ни одного на пустой пароль
+	if n := len(binds); n != 7 {
+		t.Fatalf("попыток bind %d, ожидалось 7: %v", n, binds)
+	}
+}
+
+func TestAuthenticateServiceBindFailure(t *testing.T) {
+	d := ldaptest.Start(t, testBindDN, testBindPassword, alice)
+	cfg := testConfig(d, "search")
+	cfg.BindPassword = "wrong"
+	c := newTestClient(t, cfg)
+
+	_, err := c.Authenticate(alice.Mail, alice.Password)
+	if err == nil || errors.Is(err, ErrorInvalidCredentials) || errors.Is(err, ErrorUserNotFound) {
+		t.Fatalf("ошибка служебной учетной записи: %v", err)
+	}
+}
+
+// Логин подставляется в фильтр экранированным: иначе "*" нашел бы единственную запись
+// каталога и пароль ее владельца открыл бы вход.
+func TestAuthenticateFilterEscaping(t *testing.T) {
+	d := ldaptest.Start(t, testBindDN, testBindPassword, alice)
+	c := newTestClient(t, testConfig(d, "search"))
+
+	logins := []string{
+		"*",
+		"alice*",
+		"*)(mail=*",
+		alice.Mail + ")(|(mail=*",
+		`alice\2a`,
+		"alice@example.com\x00",
+	}
+	for _, login := range logins {
+		if _, err := c.Authenticate(login, alice.Password); !errors.Is(err, ErrorUserNotFound) {
+			t.Errorf("логин %q: ошибка %v, ожидалась ErrorUserNotFound", login, err)
+		}
+	}
+
+	filters := d.Filters()
+	if len(filters) != len(logins) {
+		t.Fatalf("фильтры: %v", filters)
+	}
+	for i, login := range logins {
+		if want := "(mail=" + ldap.EscapeFilter(login) + ")"; filters[i] != want {
+			t.Errorf("логин %q: фильтр %q, ожидался %q", login, filters[i], want)
+		}
+	}
+}
+
+func TestAuthenticateBindMode(t *testing.T) {
+	d := ldaptest.Start(t, testBindDN, testBindPassword, bob)
+	c := newTestClient(t, testConfig(d, "bind"))
+
+	entry, err := c.Authenticate(bob.Mail, bob.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != bob.DN || entry.Email != bob.Mail {
+		t.Fatalf("запись %+v", entry)
+	}
+	if _, err := c.Authenticate(bob.Mail, "wrong"); !errors.Is(err, ErrorInvalidCredentials) {
+		t.Fatalf("неверный пароль: %v", err)
+	}
+
+	// логин с разделителями DN не выходит за пределы шаблона
+	injected := "x,cn=admin,dc=example,dc=com"
+	if _, err := c.Authenticate(injected, testBindPassword); !errors.Is(err, ErrorInvalidCredentials) {
+		t.Fatalf("подстановка в DN: %v", err)
+	}
+	binds := d.Binds()
+	want := "uid=" + ldap.EscapeDN(injected) + ",ou=people,dc=example,dc=com"
+	if binds[len(binds)-1] != want {
+		t.Fatalf("bind DN %q, ожидался %q", binds[len(binds)-1], want)
+	}
+	// в режиме bind служебная учетная запись не используется
+	if slices.Contains(binds, testBindDN) {
+		t.Fatalf("bind служебной учетной записью: %v", binds)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := ldaptest.Start(t, testBindDN, testBindPassword, alice, bob)
+	c := newTestClient(t, testConfig(d, "search"))
+
+	entries, err := c.Search()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Email != alice.Mail || entries[1].Email != bob.Mail {
+		t.Fatalf("записи %+v", entries)
+	}
+	if filters := d.Filters(); len(filters) != 1 || filters[0] != "(mail=*)" {
+		t.Fatalf("фильтры %v", filters)
+	}
+}
+
+func TestRole(t *testing.T) {
+	cfg := config.LDAP{
+		URL:            "ldap://localhost:389",
+		Mode:           "search",
+		DefaultRole:    "user",
+		GroupAttribute: ldaptest.GroupAttribute,
+		GroupRoles: []config.GroupRole{
+			{Group: adminsGroup, Role: "admin"},
+			{Group: devsGroup, Role: "manager"},
+		},
+	}
+	c := newTestClient(t, cfg)
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{"без групп", nil, "user"},
+		{"группа без роли", []string{"cn=others,ou=groups,dc=example,dc=com"}, "user"},
+		{"одна группа", []string{devsGroup}, "manager"},
+		{"порядок group_roles важнее порядка групп", []string{devsGroup, adminsGroup}, "admin"},
+		{"без учета регистра", []string{"CN=Admins,OU=Groups,DC=example,DC=com"}, "admin"},
+		{"частичное совпадение не считается", []string{"cn=admins,ou=groups"}, "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Role(Entry{Groups: tt.groups}); got != tt.want {
+				t.Fatalf("роль %q, ожидалась %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.LDAP
+		ok   bool
+	}{
+		{"search", config.LDAP{URL: "ldap://localhost", Mode: "search"}, true},
+		{"ldaps", config.LDAP{URL: "ldaps://localhost:636", Mode: "search"}, true},
+		{"bind с шаблоном", config.LDAP{URL: "ldap://localhost", Mode: "bind", UserDNTemplate: "uid={login},dc=example"}, true},
+		{"без url", config.LDAP{Mode: "search"}, false},
+		{"неизвестный режим", config.LDAP{URL: "ldap://localhost", Mode: "anonymous"}, false},
+		{"bind без {login}", config.LDAP{URL: "ldap://localhost", Mode: "bind", UserDNTemplate: "uid=admin"}, false},
+		{"не ldap схема", config.LDAP{URL: "http://localhost", Mode: "search"}, false},
+		{"нет ca_file", config.LDAP{URL: "ldaps://localhost", Mode: "search", CAFile: "/nonexistent/ca.pem"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); (err == nil) != tt.ok {
+				t.Fatalf("ошибка %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Package ldaptest — LDAP-каталог в памяти для тестов: простой bind, поиск по DN
+// и по равенству mail, запросы записываются для проверок.
+package ldaptest
+
+import (
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jimlambrt/gldap"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	EmailAttribute = "mail"
+	NameAttribute  = "cn"
+	GroupAttribute = "memberOf"
+)
+
+// User — запись пользователя в каталоге.
+type User struct {
+	DN       string
+	Password string
+	Mail     string
+	Name     string
+	Groups   []string
+}
+
+// Directory — запущенный каталог. Служебная учетная запись BindDN входит паролем BindPassword.
+type Directory struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+
+	mu      sync.Mutex
+	users   []User
+	binds   []string
+	filters []string
+}
+
+// Start запускает каталог на свободном порту localhost и останавливает его по завершении теста.
+func Start(t testing.TB, bindDN string, bindPassword string, users ...User) *Directory {
+	t.Helper()
+	d := &Directory{BindDN: bindDN, BindPassword: bindPassword, users: users}
+
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Bind(d.bind)
+	mux.Search(d.search)
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Router(mux)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	go server.Run(addr)
+	t.Cleanup(func() { server.Stop() })
+
+	for deadline := time.Now().Add(5 * time.Second); !server.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatal("каталог LDAP не запустился")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.URL = "ldap://" + addr
+	return d
+}
+
+// SetUsers заменяет записи каталога.
+func (d *Directory) SetUsers(users ...User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users = users
+}
+
+// Binds возвращает DN всех попыток bind по порядку.
+func (d *Directory) Binds() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.binds...)
+}
+
+// Filters возвращает фильтры поисков по поддереву в том виде, в каком их получил сервер.
+func (d *Directory) Filters() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.filters...)
+}
+
+func (d *Directory) bind(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+	defer w.Write(resp)
+
+	m, err := r.GetSimpleBindMessage()
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.binds = append(d.binds, m.UserName)
+
+	password := string(m.Password)
+	if password == "" {
+		return
+	}
+	if strings.EqualFold(m.UserName, d.BindDN) && password == d.BindPassword {
+		resp.SetResultCode(gldap.ResultSuccess)
+		return
+	}
+	for _, u := range d.users {
+		if strings.EqualFold(m.UserName, u.DN) && password == u.Password {
+			resp.SetResultCode(gldap.ResultSuccess)
+			return
+		}
+	}
+}
+
+// search отвечает на поиск по DN (ScopeBaseObject) и по фильтрам (mail=*) и (mail=<значение>).
+// Сравнение фильтра строгое: подстановка в фильтр без экранирования ничего не найдет.
+func (d *Directory) search(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+	defer w.Write(resp)
+
+	m, err := r.GetSearchMessage()
+	if err != nil {
+		resp.SetResultCode(gldap.ResultProtocolError)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if m.Scope == gldap.BaseObject {
+		for _, u := range d.users {
+			if strings.EqualFold(m.BaseDN, u.DN) {
+				w.Write(entry(r, u))
+				return
+			}
+		}
+		resp.SetResultCode(gldap.ResultNoSuchObject)
+		return
+	}
+
+	d.filters = append(d.filters, m.Filter)
+	for _, u := range d.users {
+		if m.Filter == "("+EmailAttribute+"=*)" || m.Filter == fmt.Sprintf("(%s=%s)", EmailAttribute, ldap.EscapeFilter(u.Mail)) {
+			w.Write(entry(r, u))
+		}
+	}
+}
+
+func entry(r *gldap.Request, u User) *gldap.SearchResponseEntry {
+	attributes := map[string][]string{
+		EmailAttribute: {u.Mail},
+		NameAttribute:  {u.Name},
+	}
+	if len(u.Groups) > 0 {
+		attributes[GroupAttribute] = u.Groups
+	}
+	return r.NewSearchResponseEntry(u.DN, gldap.WithAttributes(attributes))
+}
@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/LandGAA/authh2/pkg/logger"
+	"go.uber.org/zap"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// New создает отправщика писем по настройкам. Неизвестный driver считается log.
+func New(cfg config.Mailer) Mailer {
+	if cfg.Driver != "smtp" {
+		logger.Logger.Warn("Письма не отправляются, а пишутся в лог (mailer.driver = log)")
+		return LogMailer{}
+	}
+	if cfg.Password == "" {
+		cfg.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// LogMailer пишет письма в лог. Только для разработки: в логе оказываются коды входа.
+type LogMailer struct{}
+
+func (LogMailer) Send(to string, subject string, body string) error {
+	logger.Logger.Info("Письмо",
+		zap.String("to", to),
+		zap.String("subject", subject),
+		zap.String("body", body))
+	return nil
+}
+
+type SMTPMailer struct {
+	cfg config.Mailer
+}
+
+// Send отправляет письмо через SMTP. STARTTLS включается автоматически, если сервер его поддерживает.
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("Некоректный адрес получателя")
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	msg := "From: " + m.cfg.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" + body
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	if err := smtp.SendMail(addr, auth, m.cfg.From, []string{to}, []byte(msg)); err != nil {
+		logger.Logger.Error("Ошибка отправки письма",
+			zap.Error(err),
+			zap.String("to", to))
+		return fmt.Errorf("Ошибка отправки письма: %w", err)
+	}
+	return nil
+}
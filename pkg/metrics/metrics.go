@@ -0,0 +1,189 @@
+// Package metrics — метрики Prometheus сервиса: HTTP и gRPC запросы, запросы к SQLite
+// и исходы входа, регистрации, refresh и проверки токенов. Отдаются через Handler.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const namespace = "authh2"
+
+// Исходы операций для меток outcome.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeInvalid — неверные данные: пароль, токен, тело запроса
+	OutcomeInvalid = "invalid"
+	// OutcomeRejected — данные верны, но операция запрещена: регистрация закрыта,
+	// учетная запись отключена, нужен второй фактор
+	OutcomeRejected = "rejected"
+	OutcomeError    = "error"
+)
+
+// Registry — реестр метрик сервиса; кроме них в нем метрики рантайма Go и процесса.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP запросы по маршруту gin, методу и коду ответа.",
+	}, []string{"method", "route", "status"})
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Длительность HTTP запросов по маршруту gin.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	grpcRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC вызовы по методу и коду ответа.",
+	}, []string{"method", "code"})
+	grpcDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Длительность gRPC вызовов; у потоков — время жизни потока.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	dbQueries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_queries_total",
+		Help:      "Запросы к SQLite по типу (select, insert, update, delete, other) и результату.",
+	}, []string{"operation", "result"})
+	dbDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Длительность запросов к SQLite; у SELECT — до получения курсора.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	}, []string{"operation"})
+
+	// Logins — входы по паролю по исходу
+	Logins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Входы по паролю по исходу.",
+	}, []string{"outcome"})
+	// Registrations — самостоятельные регистрации через HTTP и gRPC
+	Registrations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Регистрации через HTTP и gRPC по исходу.",
+	}, []string{"outcome"})
+	// Refreshes — обновления пары токенов по refresh токену (HTTP, cookie-сессии, gRPC)
+	Refreshes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Обновления токенов по refresh токену по исходу.",
+	}, []string{"outcome"})
+	// TokenValidations — проверки JWT по результату (valid, expired, revoked, ...)
+	TokenValidations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_validations_total",
+		Help:      "Проверки JWT по результату.",
+	}, []string{"result"})
+
+	bcryptDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bcrypt_duration_seconds",
+		Help:      "Длительность bcrypt: hash — хеширование, compare — проверка.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler отдает метрики в формате Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDB добавляет статистику пула соединений (sqlite_* метрики из database/sql).
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "sqlite"))
+}
+
+// ObserveHTTP учитывает HTTP запрос. route — шаблон маршрута gin, а не путь,
+// чтобы ID в пути не раздували число рядов.
+func ObserveHTTP(method string, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveRPC учитывает gRPC вызов; подходит для server.ObserveRPC.
+func ObserveRPC(method string, code codes.Code, duration time.Duration) {
+	grpcRequests.WithLabelValues(method, code.String()).Inc()
+	grpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// ObserveQuery учитывает запрос к базе. Тип определяется по первому слову запроса.
+func ObserveQuery(query string, duration time.Duration, err error) {
+	operation := QueryOperation(query)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	dbQueries.WithLabelValues(operation, result).Inc()
+	dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// QueryOperation возвращает тип запроса: select, insert, update, delete или other.
+func QueryOperation(query string) string {
+	word, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	switch operation := strings.ToLower(strings.TrimSpace(word)); operation {
+	case "select", "insert", "update", "delete":
+		return operation
+	case "with":
+		// WITH RECURSIVE в репозиториях — всегда чтение
+		return "select"
+	}
+	return "other"
+}
+
+// ObserveBcrypt учитывает операцию bcrypt, начатую в start.
+func ObserveBcrypt(operation string, start time.Time) {
+	bcryptDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// HTTPOutcome переводит код ответа в исход операции.
+func HTTPOutcome(status int) string {
+	switch {
+	case status < 300:
+		return OutcomeSuccess
+	case status == http.StatusBadRequest || status == http.StatusUnauthorized || status == http.StatusNotFound:
+		return OutcomeInvalid
+	case status == http.StatusForbidden || status == http.StatusConflict:
+		return OutcomeRejected
+	}
+	return OutcomeError
+}
+
+// GRPCOutcome переводит код gRPC в исход операции.
+func GRPCOutcome(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return OutcomeSuccess
+	case codes.InvalidArgument, codes.Unauthenticated, codes.NotFound:
+		return OutcomeInvalid
+	case codes.PermissionDenied, codes.FailedPrecondition, codes.AlreadyExists:
+		return OutcomeRejected
+	}
+	return OutcomeError
+}
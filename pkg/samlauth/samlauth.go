@@ -0,0 +1,321 @@
+package samlauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+	"io"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataRefresh — как часто перечитывать метаданные IdP, чтобы подхватить смену его ключа.
+const metadataRefresh = time.Hour
+
+var ErrorIdPInitiated = errors.New("Вход, начатый на стороне IdP, запрещен для этого тенанта")
+
+// Assertion — проверенное утверждение IdP о пользователе.
+type Assertion struct {
+	ID        string
+	ExpiresAt time.Time
+	Subject   string
+	Email     string
+	Name      string
+	// Role — роль по role_mapping, пустая если role_attribute не задан
+	Role string
+}
+
+// Provider — сервис-провайдер SAML для одного тенанта. Метаданные IdP загружаются
+// при первом входе, чтобы недоступный IdP не мешал запуску сервиса.
+type Provider struct {
+	cfg config.SAMLIdP
+
+	mu       sync.Mutex
+	sp       saml.ServiceProvider
+	loadedAt time.Time
+}
+
+// LoadKeyPair читает ключ подписи из cert_file и key_file. Если они не заданы,
+// создается временный самоподписанный ключ: после перезапуска метаданные меняются.
+func LoadKeyPair(cfg config.SAML) (*rsa.PrivateKey, *x509.Certificate, bool, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		key, cert, err := generateKeyPair()
+		return key, cert, true, err
+	}
+
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("ошибка чтения ключа SAML: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("ключ SAML должен быть RSA")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("ошибка разбора сертификата SAML: %w", err)
+	}
+	return key, cert, false, nil
+}
+
+func generateKeyPair() (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "authh2 SAML SP"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return key, cert, err
+}
+
+func New(base config.SAML, cfg config.SAMLIdP, key *rsa.PrivateKey, cert *x509.Certificate) (*Provider, error) {
+	if cfg.Tenant == "" {
+		return nil, fmt.Errorf("у SAML IdP не задан tenant")
+	}
+	if cfg.MetadataURL == "" && cfg.MetadataFile == "" {
+		return nil, fmt.Errorf("у SAML IdP %s должен быть задан metadata_url или metadata_file", cfg.Tenant)
+	}
+
+	prefix := strings.TrimRight(base.BaseURL, "/") + "/v1/saml/" + url.PathEscape(cfg.Tenant)
+	metadataURL, err := url.Parse(prefix + "/metadata")
+	if err != nil {
+		return nil, fmt.Errorf("некоректный base_url SAML %q", base.BaseURL)
+	}
+	acsURL, _ := url.Parse(prefix + "/acs")
+
+	sp := saml.ServiceProvider{
+		EntityID:    metadataURL.String(),
+		Key:         key,
+		Certificate: cert,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		// пустой NameIDPolicy: формат NameID выбирает IdP
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+	if cfg.SignRequests {
+		sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+	return &Provider{cfg: cfg, sp: sp}, nil
+}
+
+func (p *Provider) Tenant() string                    { return p.cfg.Tenant }
+func (p *Provider) Provisioning() config.Provisioning { return p.cfg.Provisioning }
+
+// Metadata возвращает метаданные сервис-провайдера для импорта в IdP.
+func (p *Provider) Metadata() ([]byte, error) {
+	p.mu.Lock()
+	sp := p.sp
+	p.mu.Unlock()
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// serviceProvider возвращает копию сервис-провайдера с загруженными метаданными IdP.
+// Если обновить метаданные не удалось, используются прежние.
+func (p *Provider) serviceProvider(ctx context.Context) (saml.ServiceProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sp.IDPMetadata != nil && time.Since(p.loadedAt) < metadataRefresh {
+		return p.sp, nil
+	}
+
+	data, err := p.readMetadata(ctx)
+	var metadata *saml.EntityDescriptor
+	if err == nil {
+		metadata, err = parseMetadata(data)
+	}
+	if err != nil {
+		if p.sp.IDPMetadata != nil {
+			return p.sp, nil
+		}
+		return saml.ServiceProvider{}, fmt.Errorf("ошибка загрузки метаданных SAML IdP %s: %w", p.cfg.Tenant, err)
+	}
+
+	p.sp.IDPMetadata = metadata
+	p.loadedAt = time.Now()
+	return p.sp, nil
+}
+
+func (p *Provider) readMetadata(ctx context.Context) ([]byte, error) {
+	if p.cfg.MetadataFile != "" {
+		return os.ReadFile(p.cfg.MetadataFile)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.MetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.sp.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IdP ответил %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseMetadata разбирает EntityDescriptor IdP; метаданные федерации
+// (EntitiesDescriptor) тоже подходят — берется первый IdP из них.
+func parseMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil {
+		return &entity, nil
+	}
+
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, err
+	}
+	for i, e := range entities.EntityDescriptors {
+		if len(e.IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, fmt.Errorf("в метаданных нет IdP")
+}
+
+// AuthURL создает AuthnRequest (HTTP-Redirect binding) и возвращает адрес IdP и ID запроса,
+// который нужно сохранить для проверки InResponseTo.
+func (p *Provider) AuthURL(ctx context.Context, relayState string) (string, string, error) {
+	sp, err := p.serviceProvider(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	location := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if location == "" {
+		return "", "", fmt.Errorf("SAML IdP %s не поддерживает HTTP-Redirect binding", p.cfg.Tenant)
+	}
+
+	req, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка создания AuthnRequest: %w", err)
+	}
+	u, err := req.Redirect(relayState, &sp)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка подписи AuthnRequest: %w", err)
+	}
+	return u.String(), req.ID, nil
+}
+
+// ParseResponse проверяет подпись, получателя, аудиторию и сроки ответа IdP.
+// Пустой requestID — вход, начатый на стороне IdP.
+func (p *Provider) ParseResponse(ctx context.Context, response []byte, requestID string) (Assertion, error) {
+	sp, err := p.serviceProvider(ctx)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	var requestIDs []string
+	if requestID == "" {
+		if !p.cfg.AllowIdPInitiated {
+			return Assertion{}, ErrorIdPInitiated
+		}
+		sp.AllowIDPInitiated = true
+	} else {
+		requestIDs = []string{requestID}
+	}
+
+	assertion, err := sp.ParseXMLResponse(response, requestIDs, sp.AcsURL)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return Assertion{}, fmt.Errorf("ответ SAML IdP %s не прошел проверку: %w", p.cfg.Tenant, err)
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return Assertion{}, fmt.Errorf("в ответе SAML IdP %s нет NameID", p.cfg.Tenant)
+	}
+
+	result := Assertion{
+		ID:        assertion.ID,
+		ExpiresAt: time.Now().Add(saml.MaxIssueDelay),
+		Subject:   assertion.Subject.NameID.Value,
+		Name:      p.attribute(assertion, p.cfg.NameAttribute),
+	}
+	if assertion.Conditions != nil && !assertion.Conditions.NotOnOrAfter.IsZero() {
+		result.ExpiresAt = assertion.Conditions.NotOnOrAfter.Add(saml.MaxClockSkew)
+	}
+	if p.cfg.EmailAttribute == "" {
+		result.Email = result.Subject
+	} else {
+		result.Email = p.attribute(assertion, p.cfg.EmailAttribute)
+	}
+	// по email пользователь привязывается и создается: утверждение без корректного
+	// адреса отклоняется до того, как его ID будет израсходован
+	if addr, err := mail.ParseAddress(result.Email); err != nil || addr.Address != result.Email {
+		return Assertion{}, fmt.Errorf("в ответе SAML IdP %s нет корректного email: %q", p.cfg.Tenant, result.Email)
+	}
+	if p.cfg.RoleAttribute != "" {
+		result.Role = p.role(p.attributeValues(assertion, p.cfg.RoleAttribute))
+	}
+	return result, nil
+}
+
+func (p *Provider) attributeValues(assertion *saml.Assertion, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, value := range attr.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+	return values
+}
+
+func (p *Provider) attribute(assertion *saml.Assertion, name string) string {
+	if values := p.attributeValues(assertion, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (p *Provider) role(values []string) string {
+	for _, mapping := range p.cfg.RoleMapping {
+		for _, value := range values {
+			if strings.EqualFold(value, mapping.Value) {
+				return mapping.Role
+			}
+		}
+	}
+	return p.cfg.Provisioning.DefaultRole
+}
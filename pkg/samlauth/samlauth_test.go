@@ -0,0 +1,163 @@
+package samlauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"github.com/LandGAA/authh2/pkg/config"
+	"github.com/crewjam/saml"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testIdP — IdP для тестов: подписывает ответы так же, как настоящий, а утверждение
+// перед подписью можно испортить в modify.
+type testIdP struct {
+	t   *testing.T
+	idp *saml.IdentityProvider
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, cert, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	return &testIdP{t: t, idp: &saml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}}
+}
+
+// provider создает сервис-провайдер тенанта acme, доверяющий метаданным idp.
+func (i *testIdP) provider(cfg config.SAMLIdP) *Provider {
+	i.t.Helper()
+	data, err := xml.Marshal(i.idp.Metadata())
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	cfg.Tenant = "acme"
+	cfg.MetadataFile = filepath.Join(i.t.TempDir(), "idp.xml")
+	if err := os.WriteFile(cfg.MetadataFile, data, 0600); err != nil {
+		i.t.Fatal(err)
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+
+	key, cert, err := generateKeyPair()
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	p, err := New(config.SAML{BaseURL: "https://auth.example.com"}, cfg, key, cert)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return p
+}
+
+// response возвращает подписанный ответ IdP на запрос requestID (пустой — вход,
+// начатый на стороне IdP).
+func (i *testIdP) response(p *Provider, requestID string, session saml.Session, modify func(*saml.Assertion)) []byte {
+	i.t.Helper()
+	metadata := p.sp.Metadata()
+	req := &saml.IdpAuthnRequest{
+		IDP:                     i.idp,
+		HTTPRequest:             httptest.NewRequest("POST", "/sso", nil),
+		Now:                     saml.TimeNow(),
+		Request:                 saml.AuthnRequest{ID: requestID},
+		ServiceProviderMetadata: metadata,
+		SPSSODescriptor:         &metadata.SPSSODescriptors[0],
+	}
+	for _, endpoint := range metadata.SPSSODescriptors[0].AssertionConsumerServices {
+		if endpoint.Binding == saml.HTTPPostBinding {
+			req.ACSEndpoint = &endpoint
+			break
+		}
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, &session); err != nil {
+		i.t.Fatal(err)
+	}
+	if modify != nil {
+		modify(req.Assertion)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(form.SAMLResponse)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return data
+}
+
+var testSession = saml.Session{ID: "s1", NameID: "user@a.com", UserEmail: "user@a.com", UserCommonName: "User"}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider(config.SAMLIdP{NameAttribute: "cn"})
+
+	assertion, err := p.ParseResponse(context.Background(), idp.response(p, "id-request", testSession, nil), "id-request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Subject != "user@a.com" || assertion.Email != "user@a.com" || assertion.Name != "User" || assertion.ID == "" {
+		t.Fatalf("неожиданное утверждение: %+v", assertion)
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider(config.SAMLIdP{})
+	impostor := newTestIdP(t)
+
+	noEmail := testSession
+	noEmail.UserEmail = ""
+	namedEmail := testSession
+	namedEmail.UserEmail = "Admin <admin@a.com>"
+
+	tests := []struct {
+		name      string
+		response  []byte
+		requestID string
+	}{
+		{"подпись чужим ключом", impostor.response(p, "id-request", testSession, nil), "id-request"},
+		{"чужая аудитория", idp.response(p, "id-request", testSession, func(a *saml.Assertion) {
+			a.Conditions.AudienceRestrictions[0].Audience.Value = "https://other.example.com/metadata"
+		}), "id-request"},
+		{"ответ на другой запрос", idp.response(p, "id-other", testSession, nil), "id-request"},
+		{"без email", idp.response(p, "id-request", noEmail, nil), "id-request"},
+		{"email с отображаемым именем", idp.response(p, "id-request", namedEmail, nil), "id-request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.ParseResponse(context.Background(), tt.response, tt.requestID); err == nil {
+				t.Fatal("ответ принят")
+			}
+		})
+	}
+}
+
+func TestParseResponseIdPInitiated(t *testing.T) {
+	idp := newTestIdP(t)
+
+	p := idp.provider(config.SAMLIdP{})
+	_, err := p.ParseResponse(context.Background(), idp.response(p, "", testSession, nil), "")
+	if !errors.Is(err, ErrorIdPInitiated) {
+		t.Fatalf("ожидалась ErrorIdPInitiated, получено %v", err)
+	}
+
+	p = idp.provider(config.SAMLIdP{AllowIdPInitiated: true})
+	if _, err := p.ParseResponse(context.Background(), idp.response(p, "", testSession, nil), ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package scim
+
+// Документы обнаружения (RFC 7643, разделы 5–7). baseURL — адрес /scim/v2 без
+// завершающего слеша, из него строятся meta.location.
+
+// ServiceProviderConfig описывает поддерживаемые возможности: PATCH и фильтры есть,
+// bulk, сортировки и ETag нет.
+func ServiceProviderConfig(baseURL string, maxResults int) map[string]any {
+	return map[string]any{
+		"schemas":          []string{SchemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]any{"supported": true},
+		"bulk":             map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]any{"supported": true, "maxResults": maxResults},
+		"changePassword":   map[string]any{"supported": true},
+		"sort":             map[string]any{"supported": false},
+		"etag":             map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Токен провижининга или access токен сервисного аккаунта со scope scim:provision",
+			"primary":     true,
+		}},
+		"meta": Meta{ResourceType: "ServiceProviderConfig", Location: baseURL + "/ServiceProviderConfig"},
+	}
+}
+
+func ResourceTypes(baseURL string) []any {
+	return []any{
+		resourceType(baseURL, "User", "/Users", SchemaUser),
+		resourceType(baseURL, "Group", "/Groups", SchemaGroup),
+	}
+}
+
+func resourceType(baseURL string, name string, endpoint string, schema string) map[string]any {
+	return map[string]any{
+		"schemas":  []string{SchemaResourceType},
+		"id":       name,
+		"name":     name,
+		"endpoint": endpoint,
+		"schema":   schema,
+		"meta":     Meta{ResourceType: "ResourceType", Location: baseURL + "/ResourceTypes/" + name},
+	}
+}
+
+// Schemas описывает атрибуты, которые сервис хранит; остальные атрибуты ресурса игнорируются.
+func Schemas(baseURL string) []any {
+	multiValue := []map[string]any{
+		attribute("value", "string", false, "readWrite"),
+		attribute("display", "string", false, "readOnly"),
+		attribute("type", "string", false, "readWrite"),
+		attribute("primary", "boolean", false, "readWrite"),
+	}
+	name := attribute("name", "complex", false, "readWrite")
+	name["subAttributes"] = []map[string]any{
+		attribute("formatted", "string", false, "readWrite"),
+		attribute("givenName", "string", false, "readWrite"),
+		attribute("familyName", "string", false, "readWrite"),
+	}
+	emails := attribute("emails", "complex", false, "readWrite")
+	emails["multiValued"] = true
+	emails["subAttributes"] = multiValue
+	roles := attribute("roles", "complex", false, "readWrite")
+	roles["multiValued"] = true
+	roles["subAttributes"] = multiValue
+	userName := attribute("userName", "string", true, "readWrite")
+	userName["uniqueness"] = "server"
+	password := attribute("password", "string", false, "writeOnly")
+	password["returned"] = "never"
+
+	members := attribute("members", "complex", false, "readWrite")
+	members["multiValued"] = true
+	members["subAttributes"] = []map[string]any{
+		attribute("value", "string", true, "immutable"),
+		attribute("display", "string", false, "readOnly"),
+		attribute("$ref", "reference", false, "immutable"),
+	}
+
+	return []any{
+		schema(baseURL, SchemaUser, "User", []map[string]any{
+			userName,
+			name,
+			attribute("displayName", "string", false, "readWrite"),
+			emails,
+			attribute("active", "boolean", false, "readWrite"),
+			password,
+			roles,
+			attribute("externalId", "string", false, "readWrite"),
+		}),
+		schema(baseURL, SchemaGroup, "Group", []map[string]any{
+			attribute("displayName", "string", true, "readWrite"),
+			members,
+			attribute("externalId", "string", false, "readWrite"),
+		}),
+	}
+}
+
+func schema(baseURL string, id string, name string, attributes []map[string]any) map[string]any {
+	return map[string]any{
+		"schemas":    []string{SchemaSchema},
+		"id":         id,
+		"name":       name,
+		"attributes": attributes,
+		"meta":       Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + id},
+	}
+}
+
+func attribute(name string, kind string, required bool, mutability string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"type":        kind,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+}
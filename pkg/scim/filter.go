@@ -0,0 +1,428 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Filter — разобранное выражение параметра filter (RFC 7644, раздел 3.4.2.2).
+// Атрибуты и строки сравниваются без учета регистра.
+type Filter interface {
+	Match(resource map[string]any) bool
+}
+
+// ParseFilter разбирает выражение вида
+// userName eq "bjensen" and (emails[type eq "work"] pr or not (active eq false)).
+func ParseFilter(expression string) (Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, badRequest(ErrorInvalidFilter, "Лишний фрагмент фильтра: %s", p.peek().text)
+	}
+	return filter, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+	tokenDot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenClose, ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokenOpenBracket, "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokenCloseBracket, "]"})
+			i++
+		case c == '.' && len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenCloseBracket:
+			// податрибут после фильтра значений: emails[type eq "work"].value
+			tokens = append(tokens, token{tokenDot, "."})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, badRequest(ErrorInvalidFilter, "Незакрытая строка в фильтре")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+				return nil, badRequest(ErrorInvalidFilter, "Некорректная строка в фильтре: %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{tokenString, value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{tokenWord, s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		if p.peek().kind != tokenOpen {
+			return nil, badRequest(ErrorInvalidFilter, "После not ожидается выражение в скобках")
+		}
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner}, nil
+	}
+	if p.peek().kind == tokenOpen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenClose {
+			return nil, badRequest(ErrorInvalidFilter, "Не хватает закрывающей скобки")
+		}
+		return inner, nil
+	}
+	return p.parseAttribute()
+}
+
+// parseAttribute разбирает attrPath op value, attrPath pr и attr[фильтр].
+func (p *parser) parseAttribute() (Filter, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, badRequest(ErrorInvalidFilter, "Ожидается имя атрибута")
+	}
+	path := parseAttrPath(t.text)
+
+	if p.peek().kind == tokenOpenBracket {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenCloseBracket {
+			return nil, badRequest(ErrorInvalidFilter, "Не хватает закрывающей квадратной скобки")
+		}
+		return valueFilter{attr: path.attr, filter: inner}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenWord {
+		return nil, badRequest(ErrorInvalidFilter, "Ожидается оператор после %s", t.text)
+	}
+	operator := strings.ToLower(op.text)
+	switch operator {
+	case "pr":
+		return presentFilter{path}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, badRequest(ErrorInvalidFilter, "Неизвестный оператор %s", op.text)
+	}
+
+	value := p.next()
+	switch {
+	case value.kind == tokenString:
+		return compareFilter{path, operator, value.text}, nil
+	case value.kind == tokenWord:
+		literal, err := parseLiteral(value.text)
+		if err != nil {
+			return nil, err
+		}
+		return compareFilter{path, operator, literal}, nil
+	}
+	return nil, badRequest(ErrorInvalidFilter, "Ожидается значение после %s %s", t.text, op.text)
+}
+
+func parseLiteral(text string) (any, error) {
+	switch strings.ToLower(text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, badRequest(ErrorInvalidFilter, "Некорректное значение %s: строки берутся в кавычки", text)
+	}
+	return number, nil
+}
+
+// attrPath — атрибут и, возможно, податрибут. Префикс схемы (urn:...:User:) отбрасывается.
+type attrPath struct {
+	attr string
+	sub  string
+}
+
+func parseAttrPath(text string) attrPath {
+	if i := strings.LastIndex(text, ":"); i >= 0 {
+		text = text[i+1:]
+	}
+	attr, sub, _ := strings.Cut(text, ".")
+	return attrPath{attr: attr, sub: sub}
+}
+
+// lookup ищет атрибут без учета регистра.
+func lookup(resource map[string]any, name string) (string, any, bool) {
+	if value, ok := resource[name]; ok {
+		return name, value, true
+	}
+	for key, value := range resource {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// values возвращает значения атрибута: у многозначного — каждого элемента
+// (податрибута sub или value, если sub не указан).
+func (a attrPath) values(resource map[string]any) []any {
+	_, value, ok := lookup(resource, a.attr)
+	if !ok || value == nil {
+		return nil
+	}
+	items, multi := value.([]any)
+	if !multi {
+		items = []any{value}
+	}
+
+	var result []any
+	for _, item := range items {
+		complex, isComplex := item.(map[string]any)
+		switch {
+		case a.sub != "" && isComplex:
+			if _, v, ok := lookup(complex, a.sub); ok && v != nil {
+				result = append(result, v)
+			}
+		case a.sub == "" && isComplex && multi:
+			if _, v, ok := lookup(complex, "value"); ok && v != nil {
+				result = append(result, v)
+			}
+		case a.sub == "":
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(r map[string]any) bool { return f.left.Match(r) || f.right.Match(r) }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(r map[string]any) bool { return f.left.Match(r) && f.right.Match(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) Match(r map[string]any) bool { return !f.inner.Match(r) }
+
+type presentFilter struct{ path attrPath }
+
+func (f presentFilter) Match(r map[string]any) bool {
+	for _, v := range f.path.values(r) {
+		if s, ok := v.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// valueFilter — attr[фильтр]: подходит, если фильтру соответствует хотя бы один элемент attr.
+type valueFilter struct {
+	attr   string
+	filter Filter
+}
+
+func (f valueFilter) Match(r map[string]any) bool {
+	return len(f.matching(r)) > 0
+}
+
+func (f valueFilter) matching(r map[string]any) []int {
+	_, value, _ := lookup(r, f.attr)
+	items, _ := value.([]any)
+	var indexes []int
+	for i, item := range items {
+		if complex, ok := item.(map[string]any); ok && f.filter.Match(complex) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+type compareFilter struct {
+	path  attrPath
+	op    string
+	value any
+}
+
+func (f compareFilter) Match(r map[string]any) bool {
+	values := f.path.values(r)
+	if f.value == nil {
+		// eq null — атрибут не задан
+		return (f.op == "eq") == (len(values) == 0)
+	}
+	if f.op == "ne" {
+		return !compareFilter{f.path, "eq", f.value}.Match(r)
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(actual any, op string, expected any) bool {
+	switch want := expected.(type) {
+	case string:
+		got, ok := actual.(string)
+		if b, isBool := actual.(bool); isBool {
+			// primary eq "True" — логические значения IdP присылают и строкой
+			got, ok = strconv.FormatBool(b), true
+		}
+		if !ok {
+			return false
+		}
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch op {
+		case "eq":
+			return got == want
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	case float64:
+		got, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return got == want
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	case bool:
+		got, ok := actual.(bool)
+		return ok && op == "eq" && got == want
+	}
+	return false
+}
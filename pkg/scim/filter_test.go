@@ -0,0 +1,164 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func resource(t *testing.T, data string) map[string]any {
+	t.Helper()
+	var r map[string]any
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+const testUser = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"id": "42",
+	"userName": "bjensen",
+	"displayName": "Barbara Jensen",
+	"nickName": "\"Babs\"",
+	"externalId": "",
+	"active": true,
+	"loginCount": 3,
+	"name": {"givenName": "Barbara", "familyName": "Jensen"},
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@home.org", "type": "home"}
+	],
+	"meta": {"lastModified": "2024-05-01T10:00:00Z"}
+}`
+
+func TestFilterMatch(t *testing.T) {
+	user := resource(t, testUser)
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "bjensen"`, true},
+		{`userName eq "jdoe"`, false},
+		{`USERNAME EQ "BJensen"`, true},
+		{`userName ne "bjensen"`, false},
+		{`userName ne "jdoe"`, true},
+		{`userName co "jen"`, true},
+		{`userName sw "bj"`, true},
+		{`userName sw "jen"`, false},
+		{`userName ew "sen"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, true},
+		{`nickName eq "\"babs\""`, true},
+
+		{`name.familyName eq "Jensen"`, true},
+		{`name.familyName eq "Smith"`, false},
+		{`emails eq "babs@home.org"`, true},
+		{`emails co "@example.com"`, true},
+		{`emails.type eq "home"`, true},
+		{`emails.type eq "other"`, false},
+		{`emails[type eq "work" and value co "example"]`, true},
+		{`emails[type eq "work" and value co "home"]`, false},
+		{`emails[primary eq true]`, true},
+		{`emails[primary eq "True"]`, true},
+		{`emails[not (type eq "work")]`, true},
+
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`not (active eq false)`, true},
+		{`active eq "true"`, true},
+
+		{`displayName pr`, true},
+		{`title pr`, false},
+		{`externalId pr`, false},
+		{`emails pr`, true},
+		{`title eq null`, true},
+		{`userName eq null`, false},
+		{`userName ne null`, true},
+
+		{`loginCount eq 3`, true},
+		{`loginCount gt 2`, true},
+		{`loginCount ge 3`, true},
+		{`loginCount lt 3`, false},
+		{`loginCount le 2.5`, false},
+		{`loginCount eq "3"`, false},
+		{`userName gt 1`, false},
+		{`meta.lastModified gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2024-01-01T00:00:00Z"`, false},
+
+		{`userName eq "jdoe" or displayName sw "Barbara"`, true},
+		{`userName eq "bjensen" and active eq false`, false},
+		// and связывает сильнее or
+		{`userName eq "jdoe" and displayName pr or active eq true`, true},
+		{`userName eq "jdoe" and (displayName pr or active eq true)`, false},
+		{`active eq true or userName eq "jdoe" and title pr`, true},
+		{`(active eq true or userName eq "jdoe") and title pr`, false},
+		{`not (userName eq "jdoe" or not (active eq true))`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.Match(user); got != tt.want {
+				t.Fatalf("Match = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterGroupMembers(t *testing.T) {
+	group := resource(t, `{"displayName": "Admins", "members": [{"value": "1", "display": "admin"}, {"value": "7"}]}`)
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`members eq "7"`, true},
+		{`members[value eq "1"]`, true},
+		{`members[value eq "2"]`, false},
+		{`members.display eq "admin"`, true},
+		{`displayName eq "admins" and members[value eq "7"]`, true},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.filter, err)
+		}
+		if got := filter.Match(group); got != tt.want {
+			t.Errorf("%s: Match = %v, ожидалось %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "bjensen"`,
+		`userName eq bjensen`,
+		`userName eq "bjensen`,
+		`userName eq "bad \x escape"`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen")`,
+		`emails[type eq "work"`,
+		`not userName eq "bjensen"`,
+		`userName eq "bjensen" extra`,
+		`userName eq "bjensen" and`,
+		`or userName eq "bjensen"`,
+		`"userName" eq "bjensen"`,
+	}
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			_, err := ParseFilter(expression)
+			var scimErr *Error
+			if !errors.As(err, &scimErr) {
+				t.Fatalf("ожидалась ошибка SCIM, получено %v", err)
+			}
+			if scimErr.Status != http.StatusBadRequest || scimErr.Type != ErrorInvalidFilter {
+				t.Fatalf("ошибка %+v", scimErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package scim
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// patchPath — путь операции PATCH: attr, attr.sub, attr[фильтр] или attr[фильтр].sub.
+type patchPath struct {
+	attrPath
+	filter *valueFilter
+}
+
+func parsePatchPath(path string) (patchPath, error) {
+	tokens, err := tokenize(path)
+	if err != nil || len(tokens) == 0 || tokens[0].kind != tokenWord {
+		return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный путь %q", path)
+	}
+	result := patchPath{attrPath: parseAttrPath(tokens[0].text)}
+	if len(tokens) == 1 {
+		return result, nil
+	}
+
+	p := &parser{tokens: tokens, pos: 1}
+	if p.next().kind != tokenOpenBracket || result.sub != "" {
+		return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный путь %q", path)
+	}
+	inner, err := p.parseOr()
+	if err != nil {
+		return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный фильтр в пути %q: %v", path, err)
+	}
+	if p.next().kind != tokenCloseBracket {
+		return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный путь %q", path)
+	}
+	result.filter = &valueFilter{attr: result.attr, filter: inner}
+	if p.peek().kind == tokenDot {
+		p.next()
+		sub := p.next()
+		if sub.kind != tokenWord {
+			return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный путь %q", path)
+		}
+		result.sub = sub.text
+	}
+	if !p.done() {
+		return patchPath{}, badRequest(ErrorInvalidPath, "Некорректный путь %q", path)
+	}
+	return result, nil
+}
+
+// ApplyPatch применяет операции add, replace и remove (RFC 7644, раздел 3.5.2)
+// к ресурсу в виде map. Ресурс меняется на месте.
+func ApplyPatch(resource map[string]any, operations []PatchOperation) error {
+	for _, op := range operations {
+		var err error
+		switch strings.ToLower(op.Op) {
+		case "add":
+			err = applyAdd(resource, op)
+		case "replace":
+			err = applyReplace(resource, op)
+		case "remove":
+			err = applyRemove(resource, op)
+		default:
+			err = badRequest(ErrorInvalidSyntax, "Неизвестная операция PATCH %q", op.Op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyAdd(resource map[string]any, op PatchOperation) error {
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]any)
+		if !ok {
+			return badRequest(ErrorInvalidValue, "Операция add без path требует объект в value")
+		}
+		for name, value := range values {
+			add(resource, parseAttrPath(name), value)
+		}
+		return nil
+	}
+
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+	if path.filter != nil {
+		return setFiltered(resource, path, op.Value, false)
+	}
+	add(resource, path.attrPath, op.Value)
+	return nil
+}
+
+// add дописывает значения к многозначному атрибуту, дополняет комплексный
+// и заменяет простой.
+func add(resource map[string]any, path attrPath, value any) {
+	key, current, _ := lookup(resource, path.attr)
+	if key == "" {
+		key = path.attr
+	}
+	if path.sub != "" {
+		complex, _ := current.(map[string]any)
+		if complex == nil {
+			complex = map[string]any{}
+		}
+		setAttr(complex, path.sub, value)
+		resource[key] = complex
+		return
+	}
+
+	switch existing := current.(type) {
+	case []any:
+		added, ok := value.([]any)
+		if !ok {
+			added = []any{value}
+		}
+		for _, item := range added {
+			if !containsValue(existing, item) {
+				existing = append(existing, item)
+			}
+		}
+		resource[key] = existing
+	case map[string]any:
+		if values, ok := value.(map[string]any); ok {
+			for name, v := range values {
+				setAttr(existing, name, v)
+			}
+			return
+		}
+		resource[key] = value
+	default:
+		resource[key] = value
+	}
+}
+
+func applyReplace(resource map[string]any, op PatchOperation) error {
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]any)
+		if !ok {
+			return badRequest(ErrorInvalidValue, "Операция replace без path требует объект в value")
+		}
+		for name, value := range values {
+			path := parseAttrPath(name)
+			if path.sub != "" {
+				add(resource, path, value)
+				continue
+			}
+			setAttr(resource, path.attr, value)
+		}
+		return nil
+	}
+
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+	if path.filter != nil {
+		return setFiltered(resource, path, op.Value, true)
+	}
+	if path.sub != "" {
+		add(resource, path.attrPath, op.Value)
+		return nil
+	}
+	setAttr(resource, path.attr, op.Value)
+	return nil
+}
+
+// setFiltered меняет элементы attr, подходящие под фильтр пути: целиком или
+// податрибут sub. Если ни один не подошел, а фильтр — простое равенство
+// (emails[type eq "work"].value), элемент создается: так IdP задают атрибут впервые.
+func setFiltered(resource map[string]any, path patchPath, value any, replace bool) error {
+	key, current, _ := lookup(resource, path.attr)
+	items, _ := current.([]any)
+	matching := path.filter.matching(resource)
+
+	if len(matching) == 0 {
+		eq, ok := path.filter.filter.(compareFilter)
+		if !ok || eq.op != "eq" || eq.path.sub != "" || path.sub == "" {
+			return NewError(http.StatusBadRequest, ErrorNoTarget, "Ни одно значение не подходит под фильтр пути")
+		}
+		if key == "" {
+			key = path.attr
+		}
+		resource[key] = append(items, map[string]any{eq.path.attr: eq.value, path.sub: value})
+		return nil
+	}
+
+	for _, i := range matching {
+		switch {
+		case path.sub != "":
+			setAttr(items[i].(map[string]any), path.sub, value)
+		case replace:
+			items[i] = value
+		default:
+			if values, ok := value.(map[string]any); ok {
+				for name, v := range values {
+					setAttr(items[i].(map[string]any), name, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func applyRemove(resource map[string]any, op PatchOperation) error {
+	if op.Path == "" {
+		return NewError(http.StatusBadRequest, ErrorNoTarget, "Операция remove требует path")
+	}
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+	key, current, found := lookup(resource, path.attr)
+	if !found {
+		return nil
+	}
+
+	switch {
+	case path.filter != nil:
+		items, _ := current.([]any)
+		matching := path.filter.matching(resource)
+		if path.sub != "" {
+			for _, i := range matching {
+				deleteAttr(items[i].(map[string]any), path.sub)
+			}
+			return nil
+		}
+		resource[key] = removeIndexes(items, matching)
+	case path.sub != "":
+		if complex, ok := current.(map[string]any); ok {
+			deleteAttr(complex, path.sub)
+		}
+	case op.Value != nil:
+		// remove members со списком value в теле — удаляются только перечисленные
+		items, _ := current.([]any)
+		removed, ok := op.Value.([]any)
+		if !ok {
+			removed = []any{op.Value}
+		}
+		var indexes []int
+		for i, item := range items {
+			if containsValue(removed, item) {
+				indexes = append(indexes, i)
+			}
+		}
+		resource[key] = removeIndexes(items, indexes)
+	default:
+		delete(resource, key)
+	}
+	return nil
+}
+
+func setAttr(resource map[string]any, name string, value any) {
+	if key, _, ok := lookup(resource, name); ok {
+		name = key
+	}
+	resource[name] = value
+}
+
+func deleteAttr(resource map[string]any, name string) {
+	if key, _, ok := lookup(resource, name); ok {
+		delete(resource, key)
+	}
+}
+
+func removeIndexes(items []any, indexes []int) []any {
+	result := make([]any, 0, len(items))
+	for i, item := range items {
+		if !slices.Contains(indexes, i) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// containsValue сравнивает элементы многозначных атрибутов по value, если оно есть.
+func containsValue(items []any, item any) bool {
+	for _, existing := range items {
+		if sameValue(existing, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(a any, b any) bool {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if aok && bok {
+		_, av, _ := lookup(am, "value")
+		_, bv, _ := lookup(bm, "value")
+		return av != nil && reflect.DeepEqual(av, bv)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,257 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func operations(t *testing.T, data string) []PatchOperation {
+	t.Helper()
+	var ops []PatchOperation
+	if err := json.Unmarshal([]byte(data), &ops); err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+const patchUser = `{
+	"userName": "bjensen",
+	"displayName": "Barbara Jensen",
+	"active": true,
+	"name": {"givenName": "Barbara", "familyName": "Jensen"},
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@home.org", "type": "home"}
+	]
+}`
+
+const patchGroup = `{
+	"displayName": "Admins",
+	"members": [{"value": "1"}, {"value": "2"}, {"value": "3"}]
+}`
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		ops      string
+		want     string
+	}{
+		{
+			"replace простого атрибута",
+			`{"displayName": "Barbara Jensen"}`,
+			`[{"op": "replace", "path": "displayName", "value": "Babs"}]`,
+			`{"displayName": "Babs"}`,
+		},
+		{
+			"путь без учета регистра сохраняет имя атрибута",
+			`{"displayName": "Barbara Jensen"}`,
+			`[{"op": "Replace", "path": "DISPLAYNAME", "value": "Babs"}]`,
+			`{"displayName": "Babs"}`,
+		},
+		{
+			"путь с префиксом схемы",
+			`{"active": true}`,
+			`[{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:active", "value": false}]`,
+			`{"active": false}`,
+		},
+		{
+			"replace без path",
+			`{"active": true, "name": {"givenName": "Barbara", "familyName": "Jensen"}}`,
+			`[{"op": "replace", "value": {"ACTIVE": false, "name.givenName": "Babs"}}]`,
+			`{"active": false, "name": {"givenName": "Babs", "familyName": "Jensen"}}`,
+		},
+		{
+			"replace комплексного атрибута целиком",
+			`{"name": {"givenName": "Barbara", "familyName": "Jensen"}}`,
+			`[{"op": "replace", "path": "name", "value": {"givenName": "Babs"}}]`,
+			`{"name": {"givenName": "Babs"}}`,
+		},
+		{
+			"replace податрибута",
+			`{"name": {"givenName": "Barbara", "familyName": "Jensen"}}`,
+			`[{"op": "replace", "path": "name.familyName", "value": "Smith"}]`,
+			`{"name": {"givenName": "Barbara", "familyName": "Smith"}}`,
+		},
+		{
+			"add нового атрибута",
+			`{"userName": "bjensen"}`,
+			`[{"op": "add", "path": "title", "value": "Tour Guide"}]`,
+			`{"userName": "bjensen", "title": "Tour Guide"}`,
+		},
+		{
+			"add податрибута к отсутствующему атрибуту",
+			`{}`,
+			`[{"op": "add", "path": "name.middleName", "value": "Jane"}]`,
+			`{"name": {"middleName": "Jane"}}`,
+		},
+		{
+			"add без path дополняет комплексный атрибут",
+			`{"name": {"givenName": "Barbara"}}`,
+			`[{"op": "add", "value": {"name": {"familyName": "Jensen"}, "title": "Guide"}}]`,
+			`{"name": {"givenName": "Barbara", "familyName": "Jensen"}, "title": "Guide"}`,
+		},
+		{
+			"add к многозначному без дубликатов",
+			patchGroup,
+			`[{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "4", "display": "new"}]}]`,
+			`{"displayName": "Admins", "members": [{"value": "1"}, {"value": "2"}, {"value": "3"}, {"value": "4", "display": "new"}]}`,
+		},
+		{
+			"add одного значения к многозначному",
+			`{"roles": ["a"]}`,
+			`[{"op": "add", "path": "roles", "value": "b"}, {"op": "add", "path": "roles", "value": "a"}]`,
+			`{"roles": ["a", "b"]}`,
+		},
+		{
+			"replace податрибута по фильтру",
+			patchUser,
+			`[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "barbara@example.com"}]`,
+			`{"userName": "bjensen", "displayName": "Barbara Jensen", "active": true,
+			  "name": {"givenName": "Barbara", "familyName": "Jensen"},
+			  "emails": [{"value": "barbara@example.com", "type": "work", "primary": true}, {"value": "babs@home.org", "type": "home"}]}`,
+		},
+		{
+			"replace по фильтру создает элемент при равенстве",
+			`{"emails": [{"value": "bjensen@example.com", "type": "work"}]}`,
+			`[{"op": "replace", "path": "emails[type eq \"home\"].value", "value": "babs@home.org"}]`,
+			`{"emails": [{"value": "bjensen@example.com", "type": "work"}, {"type": "home", "value": "babs@home.org"}]}`,
+		},
+		{
+			"add по фильтру создает многозначный атрибут",
+			`{}`,
+			`[{"op": "add", "path": "phoneNumbers[type eq \"mobile\"].value", "value": "+7 900"}]`,
+			`{"phoneNumbers": [{"type": "mobile", "value": "+7 900"}]}`,
+		},
+		{
+			"replace элемента по фильтру целиком",
+			`{"emails": [{"value": "a@x.com", "type": "work", "primary": true}, {"value": "b@x.com", "type": "home"}]}`,
+			`[{"op": "replace", "path": "emails[type eq \"work\"]", "value": {"value": "c@x.com", "type": "work"}}]`,
+			`{"emails": [{"value": "c@x.com", "type": "work"}, {"value": "b@x.com", "type": "home"}]}`,
+		},
+		{
+			"add по фильтру дополняет элемент",
+			`{"emails": [{"value": "a@x.com", "type": "work"}]}`,
+			`[{"op": "add", "path": "emails[value eq \"a@x.com\"]", "value": {"primary": true}}]`,
+			`{"emails": [{"value": "a@x.com", "type": "work", "primary": true}]}`,
+		},
+		{
+			"remove атрибута",
+			`{"userName": "bjensen", "title": "Guide"}`,
+			`[{"op": "remove", "path": "Title"}]`,
+			`{"userName": "bjensen"}`,
+		},
+		{
+			"remove отсутствующего атрибута",
+			`{"userName": "bjensen"}`,
+			`[{"op": "remove", "path": "title"}]`,
+			`{"userName": "bjensen"}`,
+		},
+		{
+			"remove податрибута",
+			`{"name": {"givenName": "Barbara", "familyName": "Jensen"}}`,
+			`[{"op": "remove", "path": "name.givenName"}]`,
+			`{"name": {"familyName": "Jensen"}}`,
+		},
+		{
+			"remove элементов по фильтру",
+			patchGroup,
+			`[{"op": "remove", "path": "members[value eq \"2\" or value eq \"3\"]"}]`,
+			`{"displayName": "Admins", "members": [{"value": "1"}]}`,
+		},
+		{
+			"remove податрибута элементов по фильтру",
+			`{"emails": [{"value": "a@x.com", "type": "work", "primary": true}, {"value": "b@x.com", "primary": true}]}`,
+			`[{"op": "remove", "path": "emails[type eq \"work\"].primary"}]`,
+			`{"emails": [{"value": "a@x.com", "type": "work"}, {"value": "b@x.com", "primary": true}]}`,
+		},
+		{
+			"remove перечисленных в value (Azure AD)",
+			patchGroup,
+			`[{"op": "remove", "path": "members", "value": [{"value": "1"}, {"value": "3"}, {"value": "9"}]}]`,
+			`{"displayName": "Admins", "members": [{"value": "2"}]}`,
+		},
+		{
+			"remove по фильтру без совпадений",
+			patchGroup,
+			`[{"op": "remove", "path": "members[value eq \"9\"]"}]`,
+			patchGroup,
+		},
+		{
+			"операции применяются по порядку",
+			`{"active": true}`,
+			`[{"op": "replace", "path": "active", "value": false}, {"op": "remove", "path": "active"}, {"op": "add", "path": "active", "value": true}]`,
+			`{"active": true}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resource(t, tt.resource)
+			if err := ApplyPatch(r, operations(t, tt.ops)); err != nil {
+				t.Fatal(err)
+			}
+			if want := resource(t, tt.want); !reflect.DeepEqual(r, want) {
+				got, _ := json.Marshal(r)
+				t.Fatalf("результат %s", got)
+			}
+		})
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      string
+		status   int
+		scimType string
+	}{
+		{"неизвестная операция", `[{"op": "move", "path": "userName"}]`, http.StatusBadRequest, ErrorInvalidSyntax},
+		{"add без path не объект", `[{"op": "add", "value": "x"}]`, http.StatusBadRequest, ErrorInvalidValue},
+		{"replace без path не объект", `[{"op": "replace", "value": ["x"]}]`, http.StatusBadRequest, ErrorInvalidValue},
+		{"remove без path", `[{"op": "remove"}]`, http.StatusBadRequest, ErrorNoTarget},
+		{"незакрытый фильтр пути", `[{"op": "remove", "path": "emails[type eq \"work\""}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"некорректный фильтр пути", `[{"op": "remove", "path": "emails[type xx \"work\"]"}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"фильтр после податрибута", `[{"op": "remove", "path": "name.givenName[value eq \"x\"]"}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"лишнее после фильтра", `[{"op": "remove", "path": "emails[type eq \"work\"] value"}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"точка без податрибута", `[{"op": "replace", "path": "emails[type eq \"work\"].", "value": "x"}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"путь не атрибут", `[{"op": "replace", "path": "\"userName\"", "value": "x"}]`, http.StatusBadRequest, ErrorInvalidPath},
+		{"replace по фильтру без совпадений", `[{"op": "replace", "path": "emails[type co \"x\"].value", "value": "x"}]`, http.StatusBadRequest, ErrorNoTarget},
+		{"replace элемента без совпадений", `[{"op": "replace", "path": "emails[type eq \"other\"]", "value": {"value": "x"}}]`, http.StatusBadRequest, ErrorNoTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resource(t, patchUser)
+			err := ApplyPatch(r, operations(t, tt.ops))
+			var scimErr *Error
+			if !errors.As(err, &scimErr) {
+				t.Fatalf("ожидалась ошибка SCIM, получено %v", err)
+			}
+			if scimErr.Status != tt.status || scimErr.Type != tt.scimType {
+				t.Fatalf("ошибка %+v, ожидался %d %s", scimErr, tt.status, tt.scimType)
+			}
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	resources := []any{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		startIndex, count int
+		want              []any
+	}{
+		{1, 2, []any{"a", "b"}},
+		{4, 10, []any{"d", "e"}},
+		{0, 1, []any{"a"}},
+		{6, 1, []any{}},
+		{2, 0, []any{}},
+	}
+	for _, tt := range tests {
+		page := Page(resources, tt.startIndex, tt.count)
+		if !reflect.DeepEqual(page.Resources, tt.want) || page.TotalResults != 5 || page.ItemsPerPage != len(tt.want) {
+			t.Errorf("Page(%d, %d) = %+v", tt.startIndex, tt.count, page)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package tracing — трассировка OpenTelemetry: провайдер с экспортом по OTLP, в stdout
+// или в файл и распространение контекста W3C Trace Context между HTTP и gRPC.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/LandGAA/authh2/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// ScopeName — имя инструментирования для спанов сервиса.
+const ScopeName = "github.com/LandGAA/authh2"
+
+// provider — провайдер сервиса; nil, пока трассировка выключена. Start берет трассировщик
+// из глобального провайдера, поэтому до Init спаны ничего не записывают.
+var provider *sdktrace.TracerProvider
+
+// closer закрывает файл экспорта после Shutdown.
+var closer io.Closer
+
+// Init настраивает глобальный провайдер трассировки и пропагатор W3C (traceparent и baggage).
+// Пропагатор ставится и при выключенной трассировке, чтобы сервис передавал
+// контекст вызывающих дальше.
+func Init(cfg config.Tracing) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return nil
+	}
+
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+		// решение вызывающего сервиса сохраняется, SampleRatio действует на новые трассировки
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+func newExporter(cfg config.Tracing) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		// соединение с коллектором устанавливается в фоне, недоступный коллектор не мешает запуску
+		return otlptracegrpc.New(context.Background(), opts...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка открытия файла трассировки %s: %w", cfg.File, err)
+		}
+		closer = f
+		return stdouttrace.New(stdouttrace.WithWriter(f))
+	}
+	return nil, fmt.Errorf("Неизвестный экспортер трассировки %q", cfg.Exporter)
+}
+
+// Shutdown отправляет накопленные спаны и останавливает экспорт.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	err := provider.Shutdown(ctx)
+	if closer != nil {
+		closer.Close()
+	}
+	return err
+}
+
+// Start начинает спан сервиса. Без родительского спана в ctx начинается новая трассировка.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(ScopeName).Start(ctx, name, opts...)
+}
+
+// StartChild начинает спан, только если ctx уже в трассировке. Для кода, который вызывается
+// и из запросов, и из фоновых циклов: опрос outbox или очистка событий не порождают
+// трассировку на каждой итерации.
+func StartChild(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return Start(ctx, name, opts...)
+}
+
+// End завершает спан, отмечая ошибку, если она есть; удобно вызывать через defer
+// с именованной ошибкой: defer func() { tracing.End(span, err) }().
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}